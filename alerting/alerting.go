@@ -0,0 +1,495 @@
+// Package alerting evaluates the alerting rules defined in the alerting rules file,
+// and notifies webhooks of the alerts that fire and resolve
+package alerting
+
+import (
+	"bytes"
+	"context"
+	"flag"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+
+	"github.com/grafana/globalconf"
+	"github.com/grafana/metrictank/api/models"
+	"github.com/grafana/metrictank/cluster"
+	"github.com/grafana/metrictank/conf"
+	"github.com/grafana/metrictank/expr"
+	"github.com/grafana/metrictank/stats"
+	"github.com/prometheus/prometheus/pkg/labels"
+	"github.com/prometheus/prometheus/promql"
+	log "github.com/sirupsen/logrus"
+)
+
+var (
+	rulesFile      string
+	webhooksStr    string
+	webhookTimeout time.Duration
+	resendInterval time.Duration
+
+	rules    []*rule
+	notifier *webhookNotifier
+
+	// metric alerting.evaluations is the number of evaluations of alerting rules
+	evaluations = stats.NewCounter32("alerting.evaluations")
+
+	// metric alerting.evaluation_failures is the number of evaluations of alerting rules that failed
+	evaluationFailures = stats.NewCounter32("alerting.evaluation_failures")
+
+	// metric alerting.evaluation_duration is how long it takes to evaluate an alerting rule
+	evaluationDuration = stats.NewLatencyHistogram15s32("alerting.evaluation_duration")
+
+	// metric alerting.alerts.pending is the number of alerts of which the condition is satisfied, but not yet for long enough to fire
+	alertsPending = stats.NewGauge32("alerting.alerts.pending")
+
+	// metric alerting.alerts.firing is the number of firing alerts
+	alertsFiring = stats.NewGauge32("alerting.alerts.firing")
+)
+
+func ConfigSetup() {
+	alertingCfg := flag.NewFlagSet("alerting", flag.ExitOnError)
+	alertingCfg.StringVar(&rulesFile, "rules-file", "", "path to the file defining the alerting rules. empty disables alerting")
+	alertingCfg.StringVar(&webhooksStr, "webhooks", "", "comma separated list of urls to post the firing and resolved alerts to, in the format of the alertmanager api. e.g. http://alertmanager:9093/api/v1/alerts")
+	alertingCfg.DurationVar(&webhookTimeout, "webhook-timeout", 10*time.Second, "timeout of the requests to the webhooks")
+	alertingCfg.DurationVar(&resendInterval, "resend-interval", time.Minute, "how often to resend alerts that are still firing to the webhooks")
+	globalconf.Register("alerting", alertingCfg, flag.ExitOnError)
+}
+
+func ConfigProcess() {
+	if rulesFile == "" {
+		return
+	}
+	confRules, err := conf.ReadAlertingRules(rulesFile)
+	if err != nil {
+		log.Fatalf("alerting: can't read alerting rules file %q: %s", rulesFile, err.Error())
+	}
+	for _, r := range confRules {
+		rule, err := newRule(r)
+		if err != nil {
+			log.Fatalf("alerting: [%s]: %s", r.Name, err.Error())
+		}
+		rules = append(rules, rule)
+	}
+
+	var urls []string
+	for _, url := range strings.Split(webhooksStr, ",") {
+		url = strings.TrimSpace(url)
+		if url == "" {
+			continue
+		}
+		if !strings.HasPrefix(url, "http://") && !strings.HasPrefix(url, "https://") {
+			log.Fatalf("alerting: invalid webhook %q: must be a http or https url", url)
+		}
+		urls = append(urls, url)
+	}
+	if len(urls) == 0 {
+		log.Warn("alerting: no webhooks configured. alerts will only be visible through the /alerting-rules endpoint")
+	}
+	notifier = newWebhookNotifier(urls, webhookTimeout)
+}
+
+// Executor executes graphite and promql expressions, like render and query requests do
+type Executor interface {
+	ExecutePlan(ctx context.Context, orgId uint32, plan expr.Plan) ([]models.Series, error)
+	PromQLInstantQuery(ctx context.Context, orgId uint32, query string, ts time.Time) (promql.Value, error)
+}
+
+// Start starts evaluating the alerting rules, using executor, and notifying the webhooks of their alerts.
+// every rule is evaluated at the end of every interval, while this node is active.
+func Start(executor Executor) {
+	for _, r := range rules {
+		go r.run(executor, notifier)
+	}
+}
+
+// Status returns the status of all alerting rules
+func Status() []models.AlertingRuleStatus {
+	statuses := make([]models.AlertingRuleStatus, 0, len(rules))
+	for _, r := range rules {
+		statuses = append(statuses, r.status())
+	}
+	return statuses
+}
+
+// active returns whether this node should evaluate the alerting rules.
+// only the ready primary node that consumes partition 0 does, so that the webhooks
+// don't get notified of every alert by multiple nodes.
+func active() bool {
+	if !cluster.Manager.IsPrimary() || !cluster.Manager.IsReady() {
+		return false
+	}
+	for _, p := range cluster.Manager.GetPartitions() {
+		if p == 0 {
+			return true
+		}
+	}
+	return false
+}
+
+type alertState string
+
+const (
+	statePending alertState = "pending"
+	stateFiring  alertState = "firing"
+)
+
+type alert struct {
+	labels      map[string]string
+	annotations map[string]string
+	state       alertState
+	value       float64
+	activeAt    time.Time
+	lastSent    time.Time
+}
+
+// sample is a value returned by the expression of a rule, along with the labels of its series
+type sample struct {
+	labels map[string]string
+	value  float64
+}
+
+type rule struct {
+	conf.AlertingRule
+	annotations map[string]*template.Template
+
+	sync.Mutex
+	alerts         map[string]*alert // keyed by the fingerprint of their labels
+	lastEvaluation time.Time
+	lastDuration   time.Duration
+	lastError      error
+}
+
+// newRule validates the expression and the annotation templates of the given rule
+func newRule(r conf.AlertingRule) (*rule, error) {
+	switch r.Type {
+	case conf.AlertingRuleGraphite:
+		if _, err := expr.ParseMany([]string{r.Expr}); err != nil {
+			return nil, fmt.Errorf("failed to parse expr %q: %s", r.Expr, err.Error())
+		}
+	case conf.AlertingRulePromQL:
+		if _, err := promql.ParseExpr(r.Expr); err != nil {
+			return nil, fmt.Errorf("failed to parse expr %q: %s", r.Expr, err.Error())
+		}
+	default:
+		return nil, fmt.Errorf("invalid type %q", r.Type)
+	}
+	rule := &rule{
+		AlertingRule: r,
+		annotations:  make(map[string]*template.Template),
+		alerts:       make(map[string]*alert),
+	}
+	for name, text := range r.Annotations {
+		tmpl, err := template.New(name).Option("missingkey=zero").Parse(text)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse annotation %q: %s", name, err.Error())
+		}
+		rule.annotations[name] = tmpl
+	}
+	return rule, nil
+}
+
+func (r *rule) status() models.AlertingRuleStatus {
+	status := models.AlertingRuleStatus{
+		Name:      r.Name,
+		Expr:      r.Expr,
+		Type:      r.Type,
+		OrgId:     r.OrgId,
+		Interval:  r.Interval,
+		For:       r.For,
+		Condition: r.Condition.String(),
+		Active:    active(),
+		Alerts:    []models.Alert{},
+	}
+	r.Lock()
+	status.LastEvaluation = r.lastEvaluation
+	status.LastDuration = r.lastDuration
+	if r.lastError != nil {
+		status.LastError = r.lastError.Error()
+	}
+	for _, a := range r.alerts {
+		status.Alerts = append(status.Alerts, models.Alert{
+			Labels:      a.labels,
+			Annotations: a.annotations,
+			State:       string(a.state),
+			Value:       strconv.FormatFloat(a.value, 'f', -1, 64),
+			ActiveAt:    a.activeAt,
+		})
+	}
+	r.Unlock()
+	sort.Slice(status.Alerts, func(i, j int) bool {
+		return fingerprint(status.Alerts[i].Labels) < fingerprint(status.Alerts[j].Labels)
+	})
+	return status
+}
+
+// run evaluates the rule at the end of every interval
+func (r *rule) run(executor Executor, notifier *webhookNotifier) {
+	for {
+		now := uint32(time.Now().Unix())
+		ts := now/r.Interval*r.Interval + r.Interval
+		time.Sleep(time.Until(time.Unix(int64(ts), 0)))
+		// only one node evaluates the rules. it must be ready: a node that is not ready
+		// may not have a complete view of the data yet, which could make alerts resolve that are still firing
+		if !active() {
+			r.reset()
+			continue
+		}
+		notifier.notify(r.Name, r.evaluate(executor, time.Unix(int64(ts), 0)))
+	}
+}
+
+// evaluate evaluates the rule at the given time, updates the state of its alerts,
+// and returns the alerts that the webhooks should be notified of.
+// if the evaluation fails, the state of the alerts is left as is.
+func (r *rule) evaluate(executor Executor, now time.Time) []webhookAlert {
+	pre := time.Now()
+	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(r.Interval)*time.Second)
+	samples, err := r.query(ctx, executor, now)
+	cancel()
+	duration := time.Since(pre)
+
+	evaluations.Inc()
+	evaluationDuration.Value(duration)
+	if err != nil {
+		evaluationFailures.Inc()
+		log.Errorf("alerting: [%s]: evaluation failed: %s", r.Name, err.Error())
+	}
+
+	r.Lock()
+	defer r.Unlock()
+	r.lastEvaluation = pre
+	r.lastDuration = duration
+	r.lastError = err
+	if err != nil {
+		return nil
+	}
+	return r.update(samples, now)
+}
+
+// reset drops the alerts of the rule, without notifying the webhooks.
+// it is called when the node stops evaluating the rule, as the node that takes over is in charge of them.
+func (r *rule) reset() {
+	r.Lock()
+	defer r.Unlock()
+	for fp, a := range r.alerts {
+		if a.state == statePending {
+			alertsPending.Dec()
+		} else {
+			alertsFiring.Dec()
+		}
+		delete(r.alerts, fp)
+	}
+}
+
+// update transitions the alerts of the rule based on the given samples, evaluated at the given time,
+// and returns the alerts that the webhooks should be notified of: those that started firing,
+// those that are due to be resent, and those that resolved. the caller must hold the lock.
+func (r *rule) update(samples []sample, now time.Time) []webhookAlert {
+	var toSend []webhookAlert
+	forDuration := time.Duration(r.For) * time.Second
+	// alertmanager resolves alerts that it doesn't hear about by their endsAt,
+	// so give the ones that are still firing some slack in case a few notifications get lost
+	endsAt := now.Add(4 * maxDuration(time.Duration(r.Interval)*time.Second, resendInterval))
+
+	matching := make(map[string]struct{})
+	for _, s := range samples {
+		if !r.Condition.Matches(s.value) {
+			continue
+		}
+		lbls := r.labels(s.labels)
+		fp := fingerprint(lbls)
+		if _, ok := matching[fp]; ok {
+			continue
+		}
+		matching[fp] = struct{}{}
+
+		a, ok := r.alerts[fp]
+		if !ok {
+			a = &alert{
+				labels:   lbls,
+				state:    statePending,
+				activeAt: now,
+			}
+			r.alerts[fp] = a
+			alertsPending.Inc()
+		}
+		a.value = s.value
+		a.annotations = r.expandAnnotations(lbls, s.value)
+
+		if a.state == statePending && now.Sub(a.activeAt) >= forDuration {
+			a.state = stateFiring
+			alertsPending.Dec()
+			alertsFiring.Inc()
+		}
+		if a.state == stateFiring && (a.lastSent.IsZero() || now.Sub(a.lastSent) >= resendInterval) {
+			a.lastSent = now
+			toSend = append(toSend, a.webhookAlert(endsAt))
+		}
+	}
+
+	for fp, a := range r.alerts {
+		if _, ok := matching[fp]; ok {
+			continue
+		}
+		delete(r.alerts, fp)
+		if a.state == statePending {
+			alertsPending.Dec()
+			continue
+		}
+		alertsFiring.Dec()
+		toSend = append(toSend, a.webhookAlert(now))
+	}
+	return toSend
+}
+
+// query evaluates the expression of the rule at the given time, and returns the resulting values
+func (r *rule) query(ctx context.Context, executor Executor, now time.Time) ([]sample, error) {
+	var samples []sample
+	var err error
+	if r.Type == conf.AlertingRulePromQL {
+		samples, err = r.queryPromQL(ctx, executor, now)
+	} else {
+		samples, err = r.queryGraphite(ctx, executor, now)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if ctx.Err() != nil {
+		return nil, ctx.Err()
+	}
+	return samples, nil
+}
+
+// queryGraphite evaluates the graphite expression over the window ending at now.
+// the value of every resulting series is its last non-null value within the window.
+func (r *rule) queryGraphite(ctx context.Context, executor Executor, now time.Time) ([]sample, error) {
+	exprs, err := expr.ParseMany([]string{r.Expr})
+	if err != nil {
+		return nil, err
+	}
+	to := uint32(now.Unix()) + 1
+	from := to - r.Window
+	plan, err := expr.NewPlan(exprs, from, to, 0, true, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer plan.Clean()
+	out, err := executor.ExecutePlan(ctx, r.OrgId, plan)
+	if err != nil {
+		return nil, err
+	}
+
+	var samples []sample
+	for _, s := range out {
+		for i := len(s.Datapoints) - 1; i >= 0; i-- {
+			p := s.Datapoints[i]
+			if p.Ts < from || p.Ts >= to || math.IsNaN(p.Val) {
+				continue
+			}
+			// copy the tags, as the series don't outlive the plan
+			lbls := make(map[string]string, len(s.Tags))
+			for k, v := range s.Tags {
+				lbls[k] = v
+			}
+			samples = append(samples, sample{lbls, p.Val})
+			break
+		}
+	}
+	return samples, nil
+}
+
+// queryPromQL evaluates the promql expression as an instant query at now
+func (r *rule) queryPromQL(ctx context.Context, executor Executor, now time.Time) ([]sample, error) {
+	val, err := executor.PromQLInstantQuery(ctx, r.OrgId, r.Expr, now)
+	if err != nil {
+		return nil, err
+	}
+	switch v := val.(type) {
+	case promql.Vector:
+		samples := make([]sample, 0, len(v))
+		for _, s := range v {
+			lbls := make(map[string]string, len(s.Metric))
+			for _, l := range s.Metric {
+				if l.Name != labels.MetricName {
+					lbls[l.Name] = l.Value
+				}
+			}
+			samples = append(samples, sample{lbls, s.V})
+		}
+		return samples, nil
+	case promql.Scalar:
+		return []sample{{nil, v.V}}, nil
+	}
+	return nil, fmt.Errorf("expression must evaluate to an instant vector or a scalar, got %s", val.Type())
+}
+
+// labels returns the labels of the alert for a series with the given labels:
+// those of the series, overridden by those of the rule, and the alertname
+func (r *rule) labels(series map[string]string) map[string]string {
+	lbls := make(map[string]string, len(series)+len(r.Labels)+1)
+	for k, v := range series {
+		lbls[k] = v
+	}
+	for k, v := range r.Labels {
+		lbls[k] = v
+	}
+	lbls["alertname"] = r.Name
+	return lbls
+}
+
+// expandAnnotations executes the annotation templates with the labels and value of an alert.
+// annotations that fail to execute are set to the error, so that it doesn't go unnoticed.
+func (r *rule) expandAnnotations(lbls map[string]string, value float64) map[string]string {
+	data := struct {
+		Labels map[string]string
+		Value  float64
+	}{lbls, value}
+	annotations := make(map[string]string, len(r.annotations))
+	for name, tmpl := range r.annotations {
+		var buf bytes.Buffer
+		if err := tmpl.Execute(&buf, data); err != nil {
+			annotations[name] = fmt.Sprintf("error expanding annotation: %s", err.Error())
+			continue
+		}
+		annotations[name] = buf.String()
+	}
+	return annotations
+}
+
+func (a *alert) webhookAlert(endsAt time.Time) webhookAlert {
+	return webhookAlert{
+		Labels:      a.labels,
+		Annotations: a.annotations,
+		StartsAt:    a.activeAt,
+		EndsAt:      endsAt,
+	}
+}
+
+// fingerprint returns a string that uniquely identifies the given set of labels
+func fingerprint(lbls map[string]string) string {
+	keys := make([]string, 0, len(lbls))
+	for k := range lbls {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	var buf bytes.Buffer
+	for _, k := range keys {
+		buf.WriteString(k)
+		buf.WriteByte(0xfe)
+		buf.WriteString(lbls[k])
+		buf.WriteByte(0xff)
+	}
+	return buf.String()
+}
+
+func maxDuration(a, b time.Duration) time.Duration {
+	if a > b {
+		return a
+	}
+	return b
+}
@@ -0,0 +1,282 @@
+package alerting
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/grafana/metrictank/api/models"
+	"github.com/grafana/metrictank/cluster"
+	"github.com/grafana/metrictank/conf"
+	"github.com/grafana/metrictank/expr"
+	"github.com/grafana/metrictank/schema"
+	"github.com/prometheus/prometheus/pkg/labels"
+	"github.com/prometheus/prometheus/promql"
+)
+
+func init() {
+	cluster.Init("default", "test", time.Now(), "http", 6060)
+}
+
+type mockExecutor struct {
+	out []models.Series
+	val promql.Value
+	err error
+}
+
+func (e *mockExecutor) ExecutePlan(ctx context.Context, orgId uint32, plan expr.Plan) ([]models.Series, error) {
+	return e.out, e.err
+}
+
+func (e *mockExecutor) PromQLInstantQuery(ctx context.Context, orgId uint32, query string, ts time.Time) (promql.Value, error) {
+	return e.val, e.err
+}
+
+func newTestRule(t *testing.T, typ, query string) *rule {
+	r, err := newRule(conf.AlertingRule{
+		Name:        "high-errors",
+		Expr:        query,
+		Type:        typ,
+		OrgId:       2,
+		Interval:    60,
+		Window:      60,
+		For:         120,
+		Condition:   conf.Condition{Op: ">", Threshold: 10},
+		Labels:      map[string]string{"severity": "page"},
+		Annotations: map[string]string{"summary": "{{ .Labels.dc }} has {{ .Value }} errors"},
+	})
+	if err != nil {
+		t.Fatalf("expected no error creating the rule, got %s", err)
+	}
+	return r
+}
+
+func errorSeries(dc string, ts uint32, val float64) models.Series {
+	return models.Series{
+		Target:   "errors;dc=" + dc,
+		Tags:     map[string]string{"name": "errors", "dc": dc},
+		Interval: 10,
+		Datapoints: []schema.Point{
+			{Val: 1, Ts: ts - 20},
+			{Val: val, Ts: ts - 10},
+			{Val: math.NaN(), Ts: ts},
+		},
+	}
+}
+
+func TestEvaluateTransitions(t *testing.T) {
+	resendInterval = 5 * time.Minute
+	r := newTestRule(t, conf.AlertingRuleGraphite, "sumSeries(errors.*.count)")
+	executor := &mockExecutor{}
+	t0 := time.Unix(6000, 0)
+	at := func(offset int) time.Time {
+		return t0.Add(time.Duration(offset) * time.Second)
+	}
+
+	// the condition is satisfied for us, but not for eu: us becomes pending
+	executor.out = []models.Series{errorSeries("us", 6000, 20), errorSeries("eu", 6000, 5)}
+	if sent := r.evaluate(executor, at(0)); len(sent) != 0 {
+		t.Fatalf("expected no alerts to be sent while pending, got %v", sent)
+	}
+	status := r.status()
+	if len(status.Alerts) != 1 || status.Alerts[0].State != "pending" || status.Alerts[0].Value != "20" {
+		t.Fatalf("expected 1 pending alert with value 20, got %v", status.Alerts)
+	}
+
+	// not yet satisfied for long enough
+	executor.out = []models.Series{errorSeries("us", 6060, 30)}
+	if sent := r.evaluate(executor, at(60)); len(sent) != 0 {
+		t.Fatalf("expected no alerts to be sent while pending, got %v", sent)
+	}
+
+	// satisfied for the for duration: us fires
+	executor.out = []models.Series{errorSeries("us", 6120, 40)}
+	sent := r.evaluate(executor, at(120))
+	exp := []webhookAlert{
+		{
+			Labels:      map[string]string{"alertname": "high-errors", "name": "errors", "dc": "us", "severity": "page"},
+			Annotations: map[string]string{"summary": "us has 40 errors"},
+			StartsAt:    at(0),
+			EndsAt:      at(120 + 4*300),
+		},
+	}
+	if !reflect.DeepEqual(sent, exp) {
+		t.Fatalf("expected firing alert %v, got %v", exp, sent)
+	}
+
+	// still firing, but not due to be resent yet
+	executor.out = []models.Series{errorSeries("us", 6180, 50)}
+	if sent := r.evaluate(executor, at(180)); len(sent) != 0 {
+		t.Fatalf("expected no alerts to be resent before the resend interval, got %v", sent)
+	}
+
+	// failed evaluations leave the alerts as they are
+	executor.err = errors.New("boom")
+	if sent := r.evaluate(executor, at(240)); len(sent) != 0 {
+		t.Fatalf("expected no alerts to be sent on failure, got %v", sent)
+	}
+	status = r.status()
+	if status.LastError != "boom" || len(status.Alerts) != 1 || status.Alerts[0].State != "firing" {
+		t.Fatalf("expected the error to be reported and the alert to keep firing, got %v", status)
+	}
+	executor.err = nil
+
+	// due to be resent
+	executor.out = []models.Series{errorSeries("us", 6420, 50)}
+	sent = r.evaluate(executor, at(420))
+	if len(sent) != 1 || sent[0].EndsAt != at(420+4*300) || sent[0].StartsAt != at(0) {
+		t.Fatalf("expected the firing alert to be resent, got %v", sent)
+	}
+
+	// no longer satisfied: us resolves
+	executor.out = []models.Series{errorSeries("us", 6480, 5)}
+	sent = r.evaluate(executor, at(480))
+	if len(sent) != 1 || sent[0].EndsAt != at(480) || sent[0].Labels["dc"] != "us" {
+		t.Fatalf("expected the alert to be resolved, got %v", sent)
+	}
+	if status := r.status(); len(status.Alerts) != 0 {
+		t.Fatalf("expected no more alerts, got %v", status.Alerts)
+	}
+}
+
+func TestEvaluatePendingCleared(t *testing.T) {
+	r := newTestRule(t, conf.AlertingRuleGraphite, "sumSeries(errors.*.count)")
+	executor := &mockExecutor{out: []models.Series{errorSeries("us", 6000, 20)}}
+	r.evaluate(executor, time.Unix(6000, 0))
+
+	// pending alerts that are no longer satisfied are dropped without notification
+	executor.out = nil
+	if sent := r.evaluate(executor, time.Unix(6060, 0)); len(sent) != 0 {
+		t.Fatalf("expected no alerts to be sent, got %v", sent)
+	}
+	if status := r.status(); len(status.Alerts) != 0 {
+		t.Fatalf("expected no more alerts, got %v", status.Alerts)
+	}
+}
+
+func TestNewRuleInvalidType(t *testing.T) {
+	_, err := newRule(conf.AlertingRule{Name: "foo", Expr: "foo", Type: "influxql"})
+	if err == nil {
+		t.Fatalf("expected an error for an unknown type")
+	}
+}
+
+func TestActive(t *testing.T) {
+	cluster.Manager.SetPrimary(true)
+	cluster.Manager.SetPriority(0)
+	cluster.Manager.SetReady()
+	defer cluster.Manager.SetPartitions(nil)
+
+	cluster.Manager.SetPartitions([]int32{1, 2})
+	if active() {
+		t.Fatalf("expected a primary without partition 0 not to evaluate the rules")
+	}
+	cluster.Manager.SetPartitions([]int32{0, 1})
+	if !active() {
+		t.Fatalf("expected the ready primary of partition 0 to evaluate the rules")
+	}
+	cluster.Manager.SetPrimary(false)
+	defer cluster.Manager.SetPrimary(true)
+	if active() {
+		t.Fatalf("expected a secondary not to evaluate the rules")
+	}
+}
+
+func TestReset(t *testing.T) {
+	r := newTestRule(t, conf.AlertingRuleGraphite, "sumSeries(errors.*.count)")
+	executor := &mockExecutor{out: []models.Series{errorSeries("us", 6000, 20)}}
+	r.evaluate(executor, time.Unix(6000, 0))
+
+	// a node that stops evaluating the rule drops its alerts
+	r.reset()
+	if status := r.status(); len(status.Alerts) != 0 {
+		t.Fatalf("expected no more alerts, got %v", status.Alerts)
+	}
+}
+
+func TestQueryPromQL(t *testing.T) {
+	r := newTestRule(t, conf.AlertingRulePromQL, "sum(errors) by (dc)")
+	executor := &mockExecutor{
+		val: promql.Vector{
+			{
+				Point:  promql.Point{T: 6000000, V: 42},
+				Metric: labels.FromStrings(labels.MetricName, "errors", "dc", "us"),
+			},
+		},
+	}
+	samples, err := r.query(context.Background(), executor, time.Unix(6000, 0))
+	if err != nil {
+		t.Fatalf("expected no error, got %s", err)
+	}
+	exp := []sample{{map[string]string{"dc": "us"}, 42}}
+	if !reflect.DeepEqual(samples, exp) {
+		t.Fatalf("expected samples %v, got %v", exp, samples)
+	}
+
+	executor.val = promql.Scalar{T: 6000000, V: 3}
+	samples, err = r.query(context.Background(), executor, time.Unix(6000, 0))
+	if err != nil || len(samples) != 1 || samples[0].value != 3 || len(samples[0].labels) != 0 {
+		t.Fatalf("expected a single sample without labels, got %v, %v", samples, err)
+	}
+
+	executor.val = promql.String{T: 6000000, V: "foo"}
+	if _, err := r.query(context.Background(), executor, time.Unix(6000, 0)); err == nil {
+		t.Fatalf("expected an error for a string result")
+	}
+}
+
+func TestNotify(t *testing.T) {
+	var received [][]map[string]interface{}
+	var contentType string
+	webhook := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		contentType = req.Header.Get("Content-Type")
+		body, _ := ioutil.ReadAll(req.Body)
+		var alerts []map[string]interface{}
+		if err := json.Unmarshal(body, &alerts); err != nil {
+			t.Errorf("expected a json array of alerts, got %q: %s", body, err)
+		}
+		received = append(received, alerts)
+	}))
+	defer webhook.Close()
+	failing := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer failing.Close()
+
+	n := newWebhookNotifier([]string{failing.URL, webhook.URL}, time.Second)
+	n.notify("high-errors", []webhookAlert{
+		{
+			Labels:      map[string]string{"alertname": "high-errors"},
+			Annotations: map[string]string{"summary": "too many errors"},
+			StartsAt:    time.Unix(6000, 0).UTC(),
+			EndsAt:      time.Unix(7200, 0).UTC(),
+		},
+	})
+
+	if len(received) != 1 || len(received[0]) != 1 {
+		t.Fatalf("expected the webhook to receive 1 alert, despite the other webhook failing, got %v", received)
+	}
+	if contentType != "application/json" {
+		t.Fatalf("expected content type application/json, got %q", contentType)
+	}
+	exp := map[string]interface{}{
+		"labels":      map[string]interface{}{"alertname": "high-errors"},
+		"annotations": map[string]interface{}{"summary": "too many errors"},
+		"startsAt":    "1970-01-01T01:40:00Z",
+		"endsAt":      "1970-01-01T02:00:00Z",
+	}
+	if !reflect.DeepEqual(received[0][0], exp) {
+		t.Fatalf("expected alert %v, got %v", exp, received[0][0])
+	}
+
+	if err := n.post(failing.URL, []byte("[]")); err == nil {
+		t.Fatalf("expected an error for a non 2xx response")
+	}
+}
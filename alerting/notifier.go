@@ -0,0 +1,80 @@
+package alerting
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"time"
+
+	"github.com/grafana/metrictank/stats"
+	log "github.com/sirupsen/logrus"
+)
+
+var (
+	// metric alerting.notifications.sent is the number of notifications successfully posted to webhooks
+	notificationsSent = stats.NewCounter32("alerting.notifications.sent")
+
+	// metric alerting.notifications.failed is the number of notifications that failed to post to webhooks
+	notificationsFailed = stats.NewCounter32("alerting.notifications.failed")
+)
+
+// webhookAlert is an alert in the format of the alertmanager api.
+// alerts of which endsAt is in the past are resolved.
+type webhookAlert struct {
+	Labels       map[string]string `json:"labels"`
+	Annotations  map[string]string `json:"annotations"`
+	StartsAt     time.Time         `json:"startsAt"`
+	EndsAt       time.Time         `json:"endsAt"`
+	GeneratorURL string            `json:"generatorURL,omitempty"`
+}
+
+// webhookNotifier posts alerts to webhooks, as a json array of alerts, like alertmanager expects them
+type webhookNotifier struct {
+	urls   []string
+	client *http.Client
+}
+
+func newWebhookNotifier(urls []string, timeout time.Duration) *webhookNotifier {
+	return &webhookNotifier{
+		urls:   urls,
+		client: &http.Client{Timeout: timeout},
+	}
+}
+
+// notify posts the alerts of the given rule to all webhooks. failures are logged, not retried:
+// alerts that are still firing get resent after the resend interval anyway.
+func (n *webhookNotifier) notify(rule string, alerts []webhookAlert) {
+	if len(alerts) == 0 || len(n.urls) == 0 {
+		return
+	}
+	body, err := json.Marshal(alerts)
+	if err != nil {
+		log.Errorf("alerting: [%s]: failed to encode alerts: %s", rule, err.Error())
+		return
+	}
+	for _, url := range n.urls {
+		if err := n.post(url, body); err != nil {
+			notificationsFailed.Inc()
+			log.Errorf("alerting: [%s]: failed to notify webhook %q: %s", rule, url, err.Error())
+			continue
+		}
+		notificationsSent.Inc()
+	}
+}
+
+func (n *webhookNotifier) post(url string, body []byte) error {
+	resp, err := n.client.Post(url, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	// drain the body, so the connection can be reused
+	io.Copy(ioutil.Discard, resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	return nil
+}
@@ -45,6 +45,8 @@ type Server struct {
 	BackendStore    mdata.Store
 	PromQueryEngine *promql.Engine
 	Cache           cache.Cache
+	seriesCache     *seriesCache
+	scheduler       *queryScheduler
 	shutdown        chan struct{}
 	Tracer          opentracing.Tracer
 	prioritySetters []PrioritySetter
@@ -95,13 +97,15 @@ func NewServer() (*Server, error) {
 	})
 
 	return &Server{
-		Addr:     Addr,
-		SSL:      UseSSL,
-		certFile: certFile,
-		keyFile:  keyFile,
-		shutdown: make(chan struct{}),
-		Macaron:  m,
-		Tracer:   opentracing.NoopTracer{},
+		Addr:        Addr,
+		SSL:         UseSSL,
+		certFile:    certFile,
+		keyFile:     keyFile,
+		shutdown:    make(chan struct{}),
+		Macaron:     m,
+		Tracer:      opentracing.NoopTracer{},
+		seriesCache: newSeriesCache(seriesCacheMaxSize, seriesCacheUnstableWindow),
+		scheduler:   newQueryScheduler(querySlots, queryBulkSlots, queryQueueSize, queryQueueTimeout),
 	}, nil
 }
 
@@ -6,6 +6,7 @@ import (
 	"fmt"
 	"net/http"
 	"regexp"
+	"sort"
 	"strconv"
 	"strings"
 	"sync"
@@ -34,6 +35,12 @@ var (
 
 	// metric api.cluster.speculative.requests is how many speculative http requests made to peers
 	speculativeRequests = stats.NewCounter32("api.cluster.speculative.requests")
+
+	// metric api.cluster.hedged.requests is how many data requests were also sent to a replica of the peer, because the peer was slow or failed
+	hedgedRequests = stats.NewCounter32("api.cluster.hedged.requests")
+
+	// metric api.cluster.hedged.wins is how many data requests were answered by a replica of the peer, rather than the peer itself
+	hedgedWins = stats.NewCounter32("api.cluster.hedged.wins")
 )
 
 func (s *Server) explainPriority(ctx *middleware.Context) {
@@ -206,7 +213,7 @@ func (s *Server) indexAutoCompleteTags(ctx *middleware.Context, req models.Index
 		expressions = append(expressions, "__tag^="+req.Prefix)
 	}
 
-	query, err := tagquery.NewQueryFromStrings(expressions, 0)
+	query, err := tagquery.NewQueryFromStrings(expressions, req.From)
 	if err != nil {
 		response.Write(ctx, response.NewError(http.StatusBadRequest, err.Error()))
 		return
@@ -284,6 +291,7 @@ func (s *Server) indexTagDelSeries(ctx *middleware.Context, request models.Index
 		}
 
 		deleted := s.MetricIndex.DeleteTagged(request.OrgId, query)
+		s.seriesCache.invalidate(deleted)
 		res.Count = len(deleted)
 	}
 
@@ -346,7 +354,8 @@ func (s *Server) indexList(ctx *middleware.Context, req models.IndexList) {
 
 func (s *Server) getData(ctx *middleware.Context, request models.GetData) {
 	var ss models.StorageStats
-	series, err := s.getTargetsLocal(ctx.Req.Context(), &ss, request.Requests)
+	reqCtx := ctx.Req.Context()
+	series, err := s.getTargetsLocal(reqCtx, &ss, request.Requests)
 	if err != nil {
 		// the only errors returned are from us catching panics, so we should treat them
 		// all as internalServerErrors
@@ -354,6 +363,11 @@ func (s *Server) getData(ctx *middleware.Context, request models.GetData) {
 		response.Write(ctx, response.WrapError(err))
 		return
 	}
+	// when canceled, the data is incomplete
+	if reqCtx.Err() != nil {
+		response.Write(ctx, response.ContextErr(reqCtx.Err()))
+		return
+	}
 	response.Write(ctx, response.NewMsgp(200, &models.GetDataRespV1{Stats: ss, Series: series}))
 }
 
@@ -365,6 +379,7 @@ func (s *Server) indexDelete(ctx *middleware.Context, req models.IndexDelete) {
 	}
 
 	defs, err := s.MetricIndex.Delete(req.OrgId, req.Query)
+	s.seriesCache.invalidate(defs)
 	if err != nil {
 		// errors can only be caused by bad request.
 		response.Write(ctx, response.NewError(http.StatusBadRequest, err.Error()))
@@ -606,3 +621,106 @@ func (s *Server) peerQuerySpeculativeChan(ctx context.Context, data cluster.Trac
 
 	return resultChan, errorChan
 }
+
+// peerReplicas returns the ready members of the cluster, other than the given peer, that have the
+// same partitions as the peer, and hence the same data, ordered by priority.
+func peerReplicas(peer cluster.Node) []cluster.Node {
+	var replicas []cluster.Node
+	for _, member := range cluster.Manager.MemberList(true, true) {
+		if member.GetName() == peer.GetName() || !samePartitions(member.GetPartitions(), peer.GetPartitions()) {
+			continue
+		}
+		replicas = append(replicas, member)
+	}
+	sort.SliceStable(replicas, func(i, j int) bool {
+		return replicas[i].GetPriority() < replicas[j].GetPriority()
+	})
+	return replicas
+}
+
+func samePartitions(a, b []int32) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// postHedged posts the request to the first of the given peers, which must all have the same data.
+// if it has not responded after delay, the request is also sent to the next peer, and so on.
+// if a peer fails, the request is sent to the next peer right away. the first successful response wins,
+// the other requests are aborted. if delay is 0, the next peers are only tried when a peer fails.
+// ctx:          request context
+// peers:        the peer to query, followed by its replicas
+// delay:        time after which to send the request to the next peer
+// name:         name to be used in logging & tracing
+// path:         path to request on
+// body:         request to be submitted
+func postHedged(ctx context.Context, peers []cluster.Node, delay time.Duration, name, path string, body cluster.Traceable) ([]byte, error) {
+	reqCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	type hedgedResp struct {
+		peer int
+		buf  []byte
+		err  error
+	}
+	responses := make(chan hedgedResp, len(peers))
+	next := 0
+	pending := 0
+	askNext := func() {
+		i := next
+		next++
+		pending++
+		go func() {
+			log.Debugf("HTTP %s querying %s%s", name, peers[i].GetName(), path)
+			buf, err := peers[i].Post(reqCtx, name, path, body)
+			responses <- hedgedResp{i, buf, err}
+		}()
+	}
+	askNext()
+
+	var tickChan <-chan time.Time
+	if delay > 0 && len(peers) > 1 {
+		ticker := time.NewTicker(delay)
+		defer ticker.Stop()
+		tickChan = ticker.C
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil, response.ContextErr(ctx.Err())
+		case resp := <-responses:
+			pending--
+			// requests that were aborted because ctx is done don't return an error
+			if ctx.Err() != nil {
+				return nil, response.ContextErr(ctx.Err())
+			}
+			if resp.err == nil {
+				if resp.peer > 0 {
+					hedgedWins.Inc()
+				}
+				return resp.buf, nil
+			}
+			log.Errorf("HTTP %s error querying %s%s: %q", name, peers[resp.peer].GetName(), path, resp.err.Error())
+			if next < len(peers) {
+				hedgedRequests.Inc()
+				askNext()
+				continue
+			}
+			if pending == 0 {
+				return nil, resp.err
+			}
+		case <-tickChan:
+			if next < len(peers) {
+				hedgedRequests.Inc()
+				askNext()
+			}
+		}
+	}
+}
@@ -0,0 +1,107 @@
+package api
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/grafana/metrictank/api/models"
+	"github.com/grafana/metrictank/api/response"
+	"github.com/grafana/metrictank/cluster"
+)
+
+// hedgeTestNode is a peer that responds with its name after the given delay, or fails
+type hedgeTestNode struct {
+	name  string
+	delay time.Duration
+	fail  bool
+}
+
+func (n hedgeTestNode) IsLocal() bool          { return false }
+func (n hedgeTestNode) IsReady() bool          { return true }
+func (n hedgeTestNode) GetPartitions() []int32 { return []int32{0} }
+func (n hedgeTestNode) GetPriority() int       { return 0 }
+func (n hedgeTestNode) HasData() bool          { return true }
+func (n hedgeTestNode) GetName() string        { return n.name }
+func (n hedgeTestNode) Post(ctx context.Context, name, path string, body cluster.Traceable) ([]byte, error) {
+	select {
+	case <-ctx.Done():
+		// like cluster.HTTPNode
+		return nil, nil
+	case <-time.After(n.delay):
+	}
+	if n.fail {
+		return nil, response.NewError(http.StatusServiceUnavailable, "error trying to talk to peer")
+	}
+	return []byte(n.name), nil
+}
+
+func TestPostHedged(t *testing.T) {
+	fast := hedgeTestNode{name: "fast", delay: time.Millisecond}
+	slow := hedgeTestNode{name: "slow", delay: time.Second}
+	failing := hedgeTestNode{name: "failing", delay: time.Millisecond, fail: true}
+
+	cases := []struct {
+		name   string
+		peers  []cluster.Node
+		delay  time.Duration
+		expBuf string
+		expErr bool
+	}{
+		{"single peer", []cluster.Node{fast}, 0, "fast", false},
+		{"peer is fast enough", []cluster.Node{fast, slow}, 100 * time.Millisecond, "fast", false},
+		{"hedged to replica", []cluster.Node{slow, fast}, 10 * time.Millisecond, "fast", false},
+		{"failed over to replica", []cluster.Node{failing, fast}, 0, "fast", false},
+		{"all peers fail", []cluster.Node{failing, failing}, 10 * time.Millisecond, "", true},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			pre := time.Now()
+			buf, err := postHedged(context.Background(), c.peers, c.delay, "test", "/getdata", models.GetData{})
+			if (err != nil) != c.expErr {
+				t.Fatalf("expected error %t, got %v", c.expErr, err)
+			}
+			if string(buf) != c.expBuf {
+				t.Fatalf("expected response %q, got %q", c.expBuf, buf)
+			}
+			if time.Since(pre) > 500*time.Millisecond {
+				t.Fatalf("expected to not wait for the slow peer, took %s", time.Since(pre))
+			}
+		})
+	}
+}
+
+func TestPostHedgedDeadline(t *testing.T) {
+	slow := hedgeTestNode{name: "slow", delay: time.Second}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	_, err := postHedged(ctx, []cluster.Node{slow, slow}, 5*time.Millisecond, "test", "/getdata", models.GetData{})
+	if err != response.RequestDeadlineExceededErr {
+		t.Fatalf("expected deadline exceeded error, got %v", err)
+	}
+
+	ctx, cancel = context.WithCancel(context.Background())
+	cancel()
+	_, err = postHedged(ctx, []cluster.Node{slow}, 0, "test", "/getdata", models.GetData{})
+	if err != response.RequestCanceledErr {
+		t.Fatalf("expected canceled error, got %v", err)
+	}
+}
+
+func TestSamePartitions(t *testing.T) {
+	cases := []struct {
+		a, b []int32
+		exp  bool
+	}{
+		{[]int32{0, 1}, []int32{0, 1}, true},
+		{[]int32{0, 1}, []int32{0, 2}, false},
+		{[]int32{0, 1}, []int32{0}, false},
+		{nil, nil, true},
+	}
+	for i, c := range cases {
+		if got := samePartitions(c.a, c.b); got != c.exp {
+			t.Fatalf("case %d: expected %t, got %t", i, c.exp, got)
+		}
+	}
+}
@@ -5,16 +5,21 @@ import (
 	"net"
 	"net/http/httputil"
 	"net/url"
+	"regexp"
 	"time"
 
 	"github.com/grafana/globalconf"
+	"github.com/grafana/metrictank/conf"
 	log "github.com/sirupsen/logrus"
 )
 
 var (
-	maxPointsPerReqSoft int
-	maxPointsPerReqHard int
-	maxSeriesPerReq     int
+	maxPointsPerReqSoft  int
+	maxPointsPerReqHard  int
+	maxSeriesPerReq      int
+	maxPointsFetchPerReq uint64
+	maxChunksPerReq      uint64
+	maxMemoryPerReq      uint64
 
 	Addr             string
 	UseSSL           bool
@@ -22,13 +27,27 @@ var (
 	certFile         string
 	keyFile          string
 	multiTenant      bool
+	authKeysFile     string
 	fallbackGraphite string
 	timeZoneStr      string
 
 	getTargetsConcurrency int
 	tagdbDefaultLimit     uint
 	speculationThreshold  float64
+	hedgeDelay            time.Duration
+	queryTimeout          time.Duration
 
+	seriesCacheMaxSize        uint64
+	seriesCacheUnstableWindow time.Duration
+
+	querySlots        int
+	queryBulkSlots    int
+	queryQueueSize    int
+	queryQueueTimeout time.Duration
+	bulkUserAgentsStr string
+	bulkUserAgents    *regexp.Regexp // nil if no user agents are treated as bulk
+
+	apiKeys       *conf.APIKeys // nil if authentication is disabled
 	graphiteProxy *httputil.ReverseProxy
 	timeZone      *time.Location
 )
@@ -38,17 +57,30 @@ func ConfigSetup() {
 	apiCfg.IntVar(&maxPointsPerReqSoft, "max-points-per-req-soft", 1000000, "lower resolution rollups will be used to try and keep requests below this number of datapoints. (0 disables limit)")
 	apiCfg.IntVar(&maxPointsPerReqHard, "max-points-per-req-hard", 20000000, "limit of number of datapoints a request can return. Requests that exceed this limit will be rejected. (0 disables limit)")
 	apiCfg.IntVar(&maxSeriesPerReq, "max-series-per-req", 250000, "limit of number of series a request can operate on. Requests that exceed this limit will be rejected. (0 disables limit)")
+	apiCfg.Uint64Var(&maxPointsFetchPerReq, "max-points-fetch-per-req", 0, "limit of the estimated number of datapoints a request can fetch. Requests that exceed this limit will be rejected before any data is fetched. (0 disables limit)")
+	apiCfg.Uint64Var(&maxChunksPerReq, "max-chunks-per-req", 0, "limit of the estimated number of chunks a request can read. Requests that exceed this limit will be rejected before any data is fetched. (0 disables limit)")
+	apiCfg.Uint64Var(&maxMemoryPerReq, "max-memory-per-req", 0, "limit of the estimated memory in bytes a request needs for its datapoints. Requests that exceed this limit will be rejected before any data is fetched. (0 disables limit)")
 	apiCfg.StringVar(&Addr, "listen", ":6060", "http listener address.")
 	apiCfg.BoolVar(&UseSSL, "ssl", false, "use HTTPS")
 	apiCfg.BoolVar(&useGzip, "gzip", true, "use GZIP compression of all responses")
 	apiCfg.StringVar(&certFile, "cert-file", "", "SSL certificate file")
 	apiCfg.StringVar(&keyFile, "key-file", "", "SSL key file")
 	apiCfg.BoolVar(&multiTenant, "multi-tenant", true, "require x-org-id authentication to auth as a specific org. otherwise orgId 1 is assumed")
+	apiCfg.StringVar(&authKeysFile, "auth-keys-file", "", "path to api-keys.conf file. when set, requests must authenticate with an api key, which determines their org and role. empty to disable authentication")
 	apiCfg.StringVar(&fallbackGraphite, "fallback-graphite-addr", "http://localhost:8080", "in case our /render endpoint does not support the requested processing, proxy the request to this graphite")
 	apiCfg.StringVar(&timeZoneStr, "time-zone", "local", "timezone for interpreting from/until values when needed, specified using [zoneinfo name](https://en.wikipedia.org/wiki/Tz_database#Names_of_time_zones) e.g. 'America/New_York', 'UTC' or 'local' to use local server timezone")
 	apiCfg.IntVar(&getTargetsConcurrency, "get-targets-concurrency", 20, "maximum number of concurrent threads for fetching data on the local node. Each thread handles a single series.")
 	apiCfg.UintVar(&tagdbDefaultLimit, "tagdb-default-limit", 100, "default limit for tagdb query results, can be overridden with query parameter \"limit\"")
 	apiCfg.Float64Var(&speculationThreshold, "speculation-threshold", 1, "ratio of peer responses after which speculation is used. Set to 1 to disable.")
+	apiCfg.DurationVar(&hedgeDelay, "hedge-delay", 0, "when a peer did not respond to a data request within this time, also send the request to another replica of the same partitions, and use whichever responds first. failed data requests are retried on a replica as well. 0 to disable")
+	apiCfg.DurationVar(&queryTimeout, "query-timeout", 0, "deadline for render and prometheus queries, including the time spent waiting for a query slot. the deadline is propagated to all peers involved, so that they abort their work as well. 0 to disable")
+	apiCfg.Uint64Var(&seriesCacheMaxSize, "series-cache-max-size", 0, "maximum size in bytes of the cache of fetched series, which lets render requests for overlapping time ranges reuse the data of previous requests. 0 disables the cache")
+	apiCfg.DurationVar(&seriesCacheUnstableWindow, "series-cache-unstable-window", 5*time.Minute, "the most recent data is never reused from the series cache, but always fetched again, because it may not be final yet. e.g. because of ingestion delays or the reorder buffer")
+	apiCfg.IntVar(&querySlots, "query-slots", 0, "maximum number of queries (render and prometheus queries) that are executed concurrently. other queries wait in a queue. 0 disables the limit")
+	apiCfg.IntVar(&queryBulkSlots, "query-bulk-slots", 0, "maximum number of the query-slots that bulk queries may use, so that some are always left for interactive queries. 0 means bulk queries may use all slots")
+	apiCfg.IntVar(&queryQueueSize, "query-queue-size", 1000, "maximum number of queries that may wait for a slot, per class (interactive and bulk). when a queue is full, queries of its class are rejected")
+	apiCfg.DurationVar(&queryQueueTimeout, "query-queue-timeout", 10*time.Second, "queries that waited this long for a slot are rejected")
+	apiCfg.StringVar(&bulkUserAgentsStr, "bulk-user-agents", "", "regular expression matching the user agents of requests that are treated as bulk rather than interactive queries. the X-Query-Class header (bulk or interactive) overrides this. empty to treat all requests without header as interactive")
 	globalconf.Register("http", apiCfg, flag.ExitOnError)
 }
 
@@ -59,12 +91,40 @@ func ConfigProcess() {
 		log.Fatal("API listen address is not a valid TCP address.")
 	}
 
+	if authKeysFile != "" {
+		keys, err := conf.ReadAPIKeys(authKeysFile)
+		if err != nil {
+			log.Fatalf("API Cannot read auth-keys-file %q: %s", authKeysFile, err.Error())
+		}
+		if keys.Len() == 0 {
+			log.Warnf("API auth-keys-file %q contains no keys. all authenticated requests will be rejected", authKeysFile)
+		}
+		apiKeys = &keys
+	}
+
 	u, err := url.Parse(fallbackGraphite)
 	if err != nil {
 		log.Fatalf("API Cannot parse fallback-graphite-addr: %s", err.Error())
 	}
 	graphiteProxy = NewGraphiteProxy(u)
 
+	if hedgeDelay < 0 || queryTimeout < 0 {
+		log.Fatal("API hedge-delay and query-timeout must not be negative")
+	}
+
+	if querySlots < 0 || queryBulkSlots < 0 || queryQueueSize < 0 {
+		log.Fatal("API query-slots, query-bulk-slots and query-queue-size must not be negative")
+	}
+	if queryQueueTimeout <= 0 {
+		log.Fatal("API query-queue-timeout must be positive")
+	}
+	if bulkUserAgentsStr != "" {
+		bulkUserAgents, err = regexp.Compile(bulkUserAgentsStr)
+		if err != nil {
+			log.Fatalf("API Cannot parse bulk-user-agents: %s", err.Error())
+		}
+	}
+
 	if timeZoneStr == "local" {
 		timeZone = time.Local
 	} else {
@@ -10,6 +10,7 @@ import (
 	"time"
 
 	"github.com/grafana/metrictank/api/models"
+	"github.com/grafana/metrictank/cluster"
 	"github.com/grafana/metrictank/consolidation"
 	"github.com/grafana/metrictank/mdata"
 	"github.com/grafana/metrictank/mdata/cache"
@@ -225,6 +226,7 @@ func (s *Server) getTargets(ctx context.Context, ss *models.StorageStats, reqs [
 
 // getTargetsRemote issues the requests on other nodes
 // it's nothing more than a thin network wrapper around getTargetsLocal of a peer.
+// when hedge-delay is set, slow or failing peers are backed up by their replicas.
 func (s *Server) getTargetsRemote(ctx context.Context, ss *models.StorageStats, remoteReqs map[string][]models.Req) ([]models.Series, error) {
 	responses := make(chan getTargetsResp, len(remoteReqs))
 	rCtx, cancel := context.WithCancel(ctx)
@@ -236,7 +238,11 @@ func (s *Server) getTargetsRemote(ctx context.Context, ss *models.StorageStats,
 		go func(reqs []models.Req) {
 			defer wg.Done()
 			node := reqs[0].Node
-			buf, err := node.Post(rCtx, "getTargetsRemote", "/getdata", models.GetData{Requests: reqs})
+			peers := []cluster.Node{node}
+			if hedgeDelay > 0 {
+				peers = append(peers, peerReplicas(node)...)
+			}
+			buf, err := postHedged(rCtx, peers, hedgeDelay, "getTargetsRemote", "/getdata", models.GetData{Requests: reqs})
 			if err != nil {
 				cancel()
 				responses <- getTargetsResp{nil, err}
@@ -329,23 +335,40 @@ LOOP:
 
 }
 
-// getTarget returns the series for the request in canonical form.
+// getTarget returns the series for the request in canonical form, served from the series cache where possible.
 // as ConsolidateContext just processes what it's been given (not "stable" or bucket-aligned to the output interval)
 // we simply make sure to pass it the right input such that the output is canonical.
-func (s *Server) getTarget(ctx context.Context, ss *models.StorageStats, req models.Req) (out models.Series, err error) {
-	defer doRecover(&err)
-	normalize := req.AggNum > 1 // do we need to normalize points at runtime?
-	// normalize is runtime consolidation but only for the purpose of bringing high-res
-	// series to the same resolution of lower res series.
-
-	if normalize {
-		log.Debugf("DP getTarget() %s normalize:true", req.DebugString())
-	} else {
-		log.Debugf("DP getTarget() %s normalize:false", req.DebugString())
+func (s *Server) getTarget(ctx context.Context, ss *models.StorageStats, req models.Req) (models.Series, error) {
+	now := time.Now()
+	cached, fetchFrom := s.seriesCache.get(req)
+	if fetchFrom >= req.To {
+		return newSeries(req, cached), nil
+	}
+
+	fetchReq := req
+	fetchReq.From = fetchFrom
+	out, err := s.fetchTarget(ctx, ss, fetchReq)
+	if err != nil || ctx.Err() != nil {
+		if cached != nil {
+			pointSlicePool.Put(cached[:0])
+		}
+		return out, err
 	}
+	out.QueryFrom = req.From
+	if cached != nil {
+		fetched := out.Datapoints
+		out.Datapoints = append(cached, fetched...)
+		pointSlicePool.Put(fetched[:0])
+	}
+	s.seriesCache.add(req, out.Datapoints, now)
+	return out, nil
+}
 
-	out = models.Series{
+// newSeries creates the output series for the request, with the given datapoints
+func newSeries(req models.Req, points []schema.Point) models.Series {
+	return models.Series{
 		Target:       req.Target, // always simply the metric name from index
+		Datapoints:   points,
 		Interval:     req.OutInterval,
 		QueryPatt:    req.Pattern, // foo.* or foo.bar whatever the etName arg was
 		QueryFrom:    req.From,
@@ -365,6 +388,22 @@ func (s *Server) getTarget(ctx context.Context, ss *models.StorageStats, req mod
 			},
 		},
 	}
+}
+
+// fetchTarget fetches the data for the request, and normalizes it if needed
+func (s *Server) fetchTarget(ctx context.Context, ss *models.StorageStats, req models.Req) (out models.Series, err error) {
+	defer doRecover(&err)
+	normalize := req.AggNum > 1 // do we need to normalize points at runtime?
+	// normalize is runtime consolidation but only for the purpose of bringing high-res
+	// series to the same resolution of lower res series.
+
+	if normalize {
+		log.Debugf("DP getTarget() %s normalize:true", req.DebugString())
+	} else {
+		log.Debugf("DP getTarget() %s normalize:false", req.DebugString())
+	}
+
+	out = newSeries(req, nil)
 
 	// the easy case: we're reading the raw data.
 	if req.Archive == 0 {
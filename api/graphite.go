@@ -23,6 +23,7 @@ import (
 	"github.com/grafana/metrictank/expr"
 	"github.com/grafana/metrictank/expr/tagquery"
 	"github.com/grafana/metrictank/idx"
+	"github.com/grafana/metrictank/limits"
 	"github.com/grafana/metrictank/mdata"
 	"github.com/grafana/metrictank/stats"
 	"github.com/grafana/metrictank/tracing"
@@ -256,8 +257,8 @@ func (s *Server) renderMetrics(ctx *middleware.Context, request models.GraphiteR
 	// check to see if the request has been canceled, if so abort now.
 	select {
 	case <-execCtx.Done():
-		//request canceled
-		response.Write(ctx, response.RequestCanceledErr)
+		//request canceled or deadline exceeded
+		response.Write(ctx, response.ContextErr(execCtx.Err()))
 		return
 	default:
 	}
@@ -620,6 +621,7 @@ func (s *Server) metricsDeleteLocal(orgId uint32, query string) (int, error) {
 	}
 
 	defs, err := s.MetricIndex.Delete(orgId, query)
+	s.seriesCache.invalidate(defs)
 	return len(defs), err
 }
 
@@ -653,84 +655,28 @@ func (s *Server) metricsDeleteRemote(ctx context.Context, orgId uint32, query st
 	return resp.DeletedDefs, nil
 }
 
+// ExecutePlan executes the plan for the given org like a render request does, and returns the output series.
+// it is used to evaluate expressions on behalf of metrictank itself, e.g. for recording rules.
+func (s *Server) ExecutePlan(ctx context.Context, orgId uint32, plan expr.Plan) ([]models.Series, error) {
+	span := s.Tracer.StartSpan("executePlan")
+	defer span.Finish()
+	out, _, err := s.executePlan(opentracing.ContextWithSpan(ctx, span), orgId, plan)
+	return out, err
+}
+
 // executePlan looks up the needed data, retrieves it, and then invokes the processing
 // note if you do something like sum(foo.*) and all of those metrics happen to be on another node,
 // we will collect all the individual series from the peer, and then sum here. that could be optimized
 func (s *Server) executePlan(ctx context.Context, orgId uint32, plan expr.Plan) ([]models.Series, models.RenderMeta, error) {
 	var meta models.RenderMeta
+	meta.RenderStats.ReqsDeduped = plan.ReqsDeduped
+	meta.RenderStats.FuncsShared = plan.FuncsShared
 
-	minFrom := uint32(math.MaxUint32)
-	var maxTo uint32
-	var reqs []models.Req
-	metaTagEnrichmentData := make(map[string]tagquery.Tags)
-
-	// note that different patterns to query can have different from / to, so they require different index lookups
-	// e.g. target=movingAvg(foo.*, "1h")&target=foo.*
-	// note that in this case we fetch foo.* twice. can be optimized later
 	pre := time.Now()
-	for _, r := range plan.Reqs {
-		select {
-		case <-ctx.Done():
-			//request canceled
-			return nil, meta, nil
-		default:
-		}
-		var err error
-		var series []Series
-		var exprs tagquery.Expressions
-		const SeriesByTagIdent = "seriesByTag("
-		if strings.HasPrefix(r.Query, SeriesByTagIdent) {
-			startPos := len(SeriesByTagIdent)
-			endPos := strings.LastIndex(r.Query, ")")
-			exprs, err = getTagQueryExpressions(r.Query[startPos:endPos])
-			if err != nil {
-				return nil, meta, err
-			}
-
-			series, err = s.clusterFindByTag(ctx, orgId, exprs, int64(r.From), maxSeriesPerReq-len(reqs))
-		} else {
-			series, err = s.findSeries(ctx, orgId, []string{r.Query}, int64(r.From))
-		}
-		if err != nil {
-			return nil, meta, err
-		}
-
-		minFrom = util.Min(minFrom, r.From)
-		maxTo = util.Max(maxTo, r.To)
-
-		for _, s := range series {
-			for _, metric := range s.Series {
-				for _, archive := range metric.Defs {
-					var cons consolidation.Consolidator
-					consReq := r.Cons
-					if consReq == 0 {
-						// we will use the primary method dictated by the storage-aggregations rules
-						// note:
-						// * we can't just let the expr library take care of normalization, as we may have to fetch targets
-						//   from cluster peers; it's more efficient to have them normalize the data at the source.
-						// * a pattern may expand to multiple series, each of which can have their own aggregation method.
-						fn := mdata.Aggregations.Get(archive.AggId).AggregationMethod[0]
-						cons = consolidation.Consolidator(fn) // we use the same number assignments so we can cast them
-					} else {
-						// user specified a runtime consolidation function via consolidateBy()
-						// get the consolidation method of the most appropriate rollup based on the consolidation method
-						// requested by the user.  e.g. if the user requested 'min' but we only have 'avg' and 'sum' rollups,
-						// use 'avg'.
-						cons = closestAggMethod(consReq, mdata.Aggregations.Get(archive.AggId).AggregationMethod)
-					}
-
-					newReq := models.NewReq(
-						archive.Id, archive.NameWithTags(), r.Query, r.From, r.To, plan.MaxDataPoints, uint32(archive.Interval), cons, consReq, s.Node, archive.SchemaId, archive.AggId)
-					reqs = append(reqs, newReq)
-				}
-
-				if tagquery.MetaTagSupport && len(metric.Defs) > 0 && len(metric.MetaTags) > 0 {
-					metaTagEnrichmentData[metric.Defs[0].NameWithTags()] = metric.MetaTags
-				}
-			}
-		}
+	reqs, metaTagEnrichmentData, err := s.resolveSeries(ctx, orgId, plan)
+	if err != nil {
+		return nil, meta, err
 	}
-
 	meta.RenderStats.ResolveSeriesDuration = time.Since(pre)
 
 	select {
@@ -744,12 +690,13 @@ func (s *Server) executePlan(ctx context.Context, orgId uint32, plan expr.Plan)
 	if len(reqs) == 0 {
 		return nil, meta, nil
 	}
+	if !limits.AllowSeriesPerReq(orgId, len(reqs)) {
+		return nil, meta, response.NewError(http.StatusTooManyRequests, fmt.Sprintf("Request exceeds the max-series-per-req limit of org %d (%d). Reduce the number of targets or ask your admin to increase the limit.", orgId, limits.Get(orgId).MaxSeriesPerReq))
+	}
 
 	meta.RenderStats.SeriesFetch = uint32(len(reqs))
 
-	// note: if 1 series has a movingAvg that requires a long time range extension, it may push other reqs into another archive. can be optimized later
-	var err error
-	reqs, meta.RenderStats.PointsFetch, meta.RenderStats.PointsReturn, err = alignRequests(uint32(time.Now().Unix()), minFrom, maxTo, reqs)
+	reqs, err = prepareRequests(plan, reqs, &meta)
 	if err != nil {
 		log.Errorf("HTTP Render alignReq error: %s", err.Error())
 		return nil, meta, err
@@ -758,6 +705,14 @@ func (s *Server) executePlan(ctx context.Context, orgId uint32, plan expr.Plan)
 	span.SetTag("num_reqs", len(reqs))
 	span.SetTag("points_fetch", meta.RenderStats.PointsFetch)
 	span.SetTag("points_return", meta.RenderStats.PointsReturn)
+	span.SetTag("chunks_estimate", meta.Cost.Chunks)
+	reqRenderChunksEstimate.Value(int(meta.Cost.Chunks))
+
+	// reject expensive requests before we touch any storage
+	if err := checkCost(meta.Cost); err != nil {
+		reqRenderRejectedCost.Inc()
+		return nil, meta, err
+	}
 
 	for _, req := range reqs {
 		log.Debugf("HTTP Render %s - arch:%d archI:%d outI:%d aggN: %d from %s", req, req.Archive, req.ArchInterval, req.OutInterval, req.AggNum, req.Node.GetName())
@@ -806,6 +761,97 @@ func (s *Server) executePlan(ctx context.Context, orgId uint32, plan expr.Plan)
 	return out, meta, err
 }
 
+// resolveSeries looks up the series needed for the plan, and creates the requests to fetch them.
+// it also returns the meta tags to enrich the output series with, if any.
+func (s *Server) resolveSeries(ctx context.Context, orgId uint32, plan expr.Plan) ([]models.Req, map[string]tagquery.Tags, error) {
+	var reqs []models.Req
+	metaTagEnrichmentData := make(map[string]tagquery.Tags)
+
+	// note that different patterns to query can have different from / to, so they require different index lookups
+	// e.g. target=movingAvg(foo.*, "1h")&target=foo.*
+	// note that in this case we fetch foo.* twice. can be optimized later
+	// (identical requests however, have already been deduplicated by the planner)
+	for _, r := range plan.Reqs {
+		select {
+		case <-ctx.Done():
+			//request canceled
+			return nil, nil, nil
+		default:
+		}
+		var err error
+		var series []Series
+		var exprs tagquery.Expressions
+		const SeriesByTagIdent = "seriesByTag("
+		if strings.HasPrefix(r.Query, SeriesByTagIdent) {
+			startPos := len(SeriesByTagIdent)
+			endPos := strings.LastIndex(r.Query, ")")
+			exprs, err = getTagQueryExpressions(r.Query[startPos:endPos])
+			if err != nil {
+				return nil, nil, err
+			}
+
+			series, err = s.clusterFindByTag(ctx, orgId, exprs, int64(r.From), maxSeriesPerReq-len(reqs))
+		} else {
+			series, err = s.findSeries(ctx, orgId, []string{r.Query}, int64(r.From))
+		}
+		if err != nil {
+			return nil, nil, err
+		}
+
+		for _, s := range series {
+			for _, metric := range s.Series {
+				for _, archive := range metric.Defs {
+					var cons consolidation.Consolidator
+					consReq := r.Cons
+					if consReq == 0 {
+						// we will use the primary method dictated by the storage-aggregations rules
+						// note:
+						// * we can't just let the expr library take care of normalization, as we may have to fetch targets
+						//   from cluster peers; it's more efficient to have them normalize the data at the source.
+						// * a pattern may expand to multiple series, each of which can have their own aggregation method.
+						fn := mdata.Aggregations().Get(archive.AggId).AggregationMethod[0]
+						cons = consolidation.Consolidator(fn) // we use the same number assignments so we can cast them
+					} else {
+						// user specified a runtime consolidation function via consolidateBy()
+						// get the consolidation method of the most appropriate rollup based on the consolidation method
+						// requested by the user.  e.g. if the user requested 'min' but we only have 'avg' and 'sum' rollups,
+						// use 'avg'.
+						cons = closestAggMethod(consReq, mdata.Aggregations().Get(archive.AggId).AggregationMethod)
+					}
+
+					newReq := models.NewReq(
+						archive.Id, archive.NameWithTags(), r.Query, r.From, r.To, plan.MaxDataPoints, uint32(archive.Interval), cons, consReq, s.Node, archive.SchemaId, archive.AggId)
+					reqs = append(reqs, newReq)
+				}
+
+				if tagquery.MetaTagSupport && len(metric.Defs) > 0 && len(metric.MetaTags) > 0 {
+					metaTagEnrichmentData[metric.Defs[0].NameWithTags()] = metric.MetaTags
+				}
+			}
+		}
+	}
+	return reqs, metaTagEnrichmentData, nil
+}
+
+// prepareRequests aligns the requests, and sets the number of points and the cost estimate in meta
+func prepareRequests(plan expr.Plan, reqs []models.Req, meta *models.RenderMeta) ([]models.Req, error) {
+	minFrom := uint32(math.MaxUint32)
+	var maxTo uint32
+	for _, r := range plan.Reqs {
+		minFrom = util.Min(minFrom, r.From)
+		maxTo = util.Max(maxTo, r.To)
+	}
+
+	// note: if 1 series has a movingAvg that requires a long time range extension, it may push other reqs into another archive. can be optimized later
+	var err error
+	reqs, meta.RenderStats.PointsFetch, meta.RenderStats.PointsReturn, err = alignRequests(uint32(time.Now().Unix()), minFrom, maxTo, reqs)
+	if err != nil {
+		return nil, err
+	}
+	meta.Cost = estimateCost(reqs, meta.RenderStats.PointsReturn)
+	return reqs, nil
+}
+
 // getTagQueryExpressions takes a query string which includes multiple tag query expressions
 // example string: "'a=b', 'c=d', 'e!=~f.*'"
 // it then returns a slice of strings where each string is one of the expressions, and an error
@@ -1132,7 +1178,7 @@ func (s *Server) graphiteAutoCompleteTags(ctx *middleware.Context, request model
 		request.Limit = tagdbDefaultLimit
 	}
 
-	tags, err := s.clusterAutoCompleteTags(ctx.Req.Context(), ctx.OrgId, request.Prefix, request.Expr, request.Limit)
+	tags, err := s.clusterAutoCompleteTags(ctx.Req.Context(), ctx.OrgId, request.Prefix, request.Expr, 0, request.Limit)
 	if err != nil {
 		response.Write(ctx, response.WrapErrorForTagDB(err))
 		return
@@ -1141,10 +1187,12 @@ func (s *Server) graphiteAutoCompleteTags(ctx *middleware.Context, request model
 	response.Write(ctx, response.NewJson(200, tags, ""))
 }
 
-func (s *Server) clusterAutoCompleteTags(ctx context.Context, orgId uint32, prefix string, expressions []string, limit uint) ([]string, error) {
+// clusterAutoCompleteTags returns the tags of the series matching the expressions, that have been seen since from.
+// from is only used if there are expressions.
+func (s *Server) clusterAutoCompleteTags(ctx context.Context, orgId uint32, prefix string, expressions []string, from int64, limit uint) ([]string, error) {
 	tagSet := make(map[string]struct{})
 
-	data := models.IndexAutoCompleteTags{OrgId: orgId, Prefix: prefix, Expr: expressions, Limit: limit}
+	data := models.IndexAutoCompleteTags{OrgId: orgId, Prefix: prefix, Expr: expressions, From: from, Limit: limit}
 	responses, err := s.peerQuerySpeculative(ctx, data, "clusterAutoCompleteTags", "/index/tags/autoComplete/tags")
 	if err != nil {
 		return nil, err
@@ -1258,6 +1306,7 @@ func (s *Server) graphiteTagDelSeries(ctx *middleware.Context, request models.Gr
 			}
 
 			deleted := s.MetricIndex.DeleteTagged(ctx.OrgId, query)
+			s.seriesCache.invalidate(deleted)
 			res.Count += len(deleted)
 		}
 	}
@@ -1290,8 +1339,15 @@ func (s *Server) graphiteTagDelSeries(ctx *middleware.Context, request models.Gr
 	response.Write(ctx, response.NewJson(200, res, ""))
 }
 
+// showPlanResp is the response of showPlan
+type showPlanResp struct {
+	expr.Plan
+	Cost     models.QueryCost
+	Rejected string `json:",omitempty"` // why the request would be rejected based on its cost, if it would be
+}
+
 // showPlan attempts to create a Plan given a /render target query.
-// If the Plan creation is successful it returns 200, JSON marshaling of Plan.
+// If the Plan creation is successful it returns 200, JSON marshaling of Plan and its estimated cost.
 // Otherwise, it returns 400, error details.
 // This is needed to determine if a query cannot be resolved in localOnly mode.
 func (s *Server) showPlan(ctx *middleware.Context, request models.GraphiteRender) {
@@ -1333,9 +1389,29 @@ func (s *Server) showPlan(ctx *middleware.Context, request models.GraphiteRender
 		response.Write(ctx, response.NewError(http.StatusBadRequest, err.Error()))
 		return
 	}
+
+	resp := showPlanResp{Plan: plan}
+	reqs, _, err := s.resolveSeries(ctx.Req.Context(), ctx.OrgId, plan)
+	if err != nil {
+		response.Write(ctx, response.WrapError(err))
+		return
+	}
+	if len(reqs) > 0 {
+		var meta models.RenderMeta
+		_, err = prepareRequests(plan, reqs, &meta)
+		if err != nil {
+			response.Write(ctx, response.WrapError(err))
+			return
+		}
+		resp.Cost = meta.Cost
+		if err := checkCost(meta.Cost); err != nil {
+			resp.Rejected = err.Error()
+		}
+	}
+
 	switch request.Format {
 	case "json":
-		response.Write(ctx, response.NewJson(200, plan, ""))
+		response.Write(ctx, response.NewJson(200, resp, ""))
 	default:
 		response.Write(ctx, response.NewError(http.StatusBadRequest, "Unsupported response format requested: "+request.Format))
 	}
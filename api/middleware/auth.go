@@ -0,0 +1,89 @@
+package middleware
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/grafana/metrictank/conf"
+	"gopkg.in/macaron.v1"
+)
+
+// KeyStore resolves api keys (or bearer tokens) to the org and role they grant.
+// conf.APIKeys, loaded from an api-keys.conf file, is the default implementation
+type KeyStore interface {
+	Get(key string) (conf.APIKey, bool)
+}
+
+// AuthMiddleware authenticates requests against the given keystore, and sets the org and role of the request context.
+// It is used instead of OrgMiddleware when authentication is enabled.
+// Requests without credentials are let through with no org and no role, so that the routes that require neither
+// (such as health checks) keep working. Routes that need them should use RequireRole and RequireOrg.
+// Admin keys may act on behalf of any org by setting the x-org-id header; other keys are restricted to their own org.
+func AuthMiddleware(keys KeyStore, multiTenant bool) macaron.Handler {
+	return func(c *macaron.Context) {
+		ctx := &Context{
+			Context: c,
+		}
+		token, ok := getToken(c.Req.Request)
+		if !ok {
+			c.Map(ctx)
+			return
+		}
+		key, ok := keys.Get(token)
+		if !ok {
+			c.PlainText(401, []byte("invalid api key."))
+			return
+		}
+		ctx.OrgId = key.OrgId
+		ctx.Role = key.Role
+		if key.Role == conf.RoleAdmin {
+			org, err := getOrg(c.Req.Request, multiTenant)
+			if err != nil {
+				c.PlainText(400, []byte(err.Error()))
+				return
+			}
+			if org != 0 {
+				ctx.OrgId = org
+			}
+		} else if c.Req.Header.Get("x-org-id") != "" {
+			org, err := getOrg(c.Req.Request, multiTenant)
+			if err != nil {
+				c.PlainText(400, []byte(err.Error()))
+				return
+			}
+			if multiTenant && org != key.OrgId {
+				c.PlainText(403, []byte("api key is not valid for the requested org."))
+				return
+			}
+		}
+		c.Map(ctx)
+	}
+}
+
+// getToken extracts the api key from the request.
+// we support bearer tokens, as well as basic auth with the api key as password
+// (the username is ignored), which is what graphite clients typically support.
+func getToken(req *http.Request) (string, bool) {
+	if _, password, ok := req.BasicAuth(); ok {
+		return password, password != ""
+	}
+	header := req.Header.Get("Authorization")
+	if len(header) > 7 && strings.EqualFold(header[:7], "bearer ") {
+		return strings.TrimSpace(header[7:]), true
+	}
+	return "", false
+}
+
+// RequireRole rejects requests that are not authorized for the given role
+func RequireRole(role conf.Role) macaron.Handler {
+	return func(c *Context) {
+		if c.Role == conf.RoleNone {
+			c.Header().Set("WWW-Authenticate", `Bearer realm="metrictank"`)
+			c.PlainText(401, []byte("authentication required."))
+			return
+		}
+		if c.Role < role {
+			c.PlainText(403, []byte("api key does not have the "+role.String()+" role."))
+		}
+	}
+}
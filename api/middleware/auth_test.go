@@ -0,0 +1,81 @@
+package middleware
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/grafana/metrictank/conf"
+	"gopkg.in/macaron.v1"
+)
+
+func TestAuth(t *testing.T) {
+	keys := conf.NewAPIKeys()
+	keys.Add("reader", conf.APIKey{Name: "reader", OrgId: 2, Role: conf.RoleRead})
+	keys.Add("writer", conf.APIKey{Name: "writer", OrgId: 2, Role: conf.RoleWrite})
+	keys.Add("admin", conf.APIKey{Name: "admin", OrgId: 1, Role: conf.RoleAdmin})
+
+	m := macaron.New()
+	m.Use(macaron.Renderer())
+	m.Use(AuthMiddleware(keys, true))
+	handler := func(c *Context) {
+		c.PlainText(200, []byte(fmt.Sprintf("%d", c.OrgId)))
+	}
+	m.Get("/public", handler)
+	m.Get("/read", RequireRole(conf.RoleRead), RequireOrg(), handler)
+	m.Get("/write", RequireRole(conf.RoleWrite), RequireOrg(), handler)
+	m.Get("/admin", RequireRole(conf.RoleAdmin), handler)
+
+	cases := []struct {
+		path    string
+		auth    func(req *http.Request)
+		org     string
+		expCode int
+		expOrg  string
+	}{
+		{path: "/public", expCode: 200, expOrg: "0"},
+		{path: "/read", expCode: 401},
+		{path: "/read", auth: bearer("unknown"), expCode: 401},
+		{path: "/public", auth: bearer("unknown"), expCode: 401},
+		{path: "/read", auth: bearer("reader"), expCode: 200, expOrg: "2"},
+		{path: "/read", auth: basic("reader"), expCode: 200, expOrg: "2"},
+		{path: "/read", auth: bearer("reader"), org: "2", expCode: 200, expOrg: "2"},
+		{path: "/read", auth: bearer("reader"), org: "3", expCode: 403},
+		{path: "/write", auth: bearer("reader"), expCode: 403},
+		{path: "/write", auth: bearer("writer"), expCode: 200, expOrg: "2"},
+		{path: "/admin", auth: bearer("writer"), expCode: 403},
+		{path: "/admin", auth: bearer("admin"), expCode: 200, expOrg: "1"},
+		{path: "/write", auth: bearer("admin"), org: "3", expCode: 200, expOrg: "3"},
+		{path: "/write", auth: bearer("admin"), org: "foo", expCode: 400},
+	}
+	for i, c := range cases {
+		req := httptest.NewRequest("GET", c.path, nil)
+		if c.auth != nil {
+			c.auth(req)
+		}
+		if c.org != "" {
+			req.Header.Set("x-org-id", c.org)
+		}
+		w := httptest.NewRecorder()
+		m.ServeHTTP(w, req)
+		if w.Code != c.expCode {
+			t.Fatalf("case %d: expected code %d, got %d (%s)", i, c.expCode, w.Code, w.Body.String())
+		}
+		if c.expCode == 200 && w.Body.String() != c.expOrg {
+			t.Fatalf("case %d: expected org %s, got %s", i, c.expOrg, w.Body.String())
+		}
+	}
+}
+
+func bearer(key string) func(req *http.Request) {
+	return func(req *http.Request) {
+		req.Header.Set("Authorization", "Bearer "+key)
+	}
+}
+
+func basic(key string) func(req *http.Request) {
+	return func(req *http.Request) {
+		req.SetBasicAuth("api_key", key)
+	}
+}
@@ -0,0 +1,63 @@
+package middleware
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/grafana/metrictank/cluster"
+	"github.com/grafana/metrictank/stats"
+	"gopkg.in/macaron.v1"
+)
+
+var (
+	// metric api.request.canceled is how many requests were canceled while being handled, typically because the client disconnected
+	reqCanceled = stats.NewCounterRate32("api.request.canceled")
+
+	// metric api.request.deadline_exceeded is how many requests exceeded their deadline while being handled
+	reqDeadlineExceeded = stats.NewCounterRate32("api.request.deadline_exceeded")
+)
+
+// Deadline returns a middleware that sets the deadline of the request context.
+// the deadline is the one a peer propagated via the deadline header, if any,
+// and timeout after the start of the request, if timeout is not 0. whichever comes first.
+// any work done on behalf of the request, including requests to other peers, is aborted
+// once the deadline passes or the client disconnects. requests of which the deadline already
+// passed, e.g. because they took too long to get to us, are rejected right away.
+func Deadline(timeout time.Duration) macaron.Handler {
+	return func(c *Context) {
+		var deadline time.Time
+		if timeout > 0 {
+			deadline = time.Now().Add(timeout)
+		}
+		if h := c.Req.Header.Get(cluster.DeadlineHeader); h != "" {
+			nanos, err := strconv.ParseInt(h, 10, 64)
+			if err == nil && (deadline.IsZero() || nanos < deadline.UnixNano()) {
+				deadline = time.Unix(0, nanos)
+			}
+		}
+
+		ctx := c.Req.Context()
+		if !deadline.IsZero() {
+			if !time.Now().Before(deadline) {
+				reqDeadlineExceeded.Inc()
+				c.PlainText(http.StatusGatewayTimeout, []byte("request deadline exceeded"))
+				return
+			}
+			var cancel context.CancelFunc
+			ctx, cancel = context.WithDeadline(ctx, deadline)
+			defer cancel()
+			c.Req = macaron.Request{c.Req.WithContext(ctx)}
+		}
+
+		c.Next()
+
+		switch ctx.Err() {
+		case context.Canceled:
+			reqCanceled.Inc()
+		case context.DeadlineExceeded:
+			reqDeadlineExceeded.Inc()
+		}
+	}
+}
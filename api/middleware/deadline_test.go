@@ -0,0 +1,69 @@
+package middleware
+
+import (
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/grafana/metrictank/cluster"
+	"gopkg.in/macaron.v1"
+)
+
+func TestDeadline(t *testing.T) {
+	var got time.Time
+	var hasDeadline bool
+	handler := func(c *Context) {
+		got, hasDeadline = c.Req.Context().Deadline()
+		c.PlainText(200, []byte("ok"))
+	}
+	m := macaron.New()
+	m.Use(macaron.Renderer())
+	m.Use(OrgMiddleware(false))
+	m.Get("/peer", Deadline(0), handler)
+	m.Get("/query", Deadline(time.Minute), handler)
+
+	now := time.Now()
+	header := func(d time.Time) string {
+		return strconv.FormatInt(d.UnixNano(), 10)
+	}
+	cases := []struct {
+		path        string
+		header      string
+		expCode     int
+		expDeadline time.Time // zero if no deadline expected
+	}{
+		{path: "/peer", expCode: 200},
+		{path: "/peer", header: "foo", expCode: 200},
+		{path: "/peer", header: header(now.Add(time.Second)), expCode: 200, expDeadline: now.Add(time.Second)},
+		{path: "/peer", header: header(now.Add(-time.Second)), expCode: 504},
+		{path: "/query", expCode: 200, expDeadline: now.Add(time.Minute)},
+		{path: "/query", header: header(now.Add(time.Second)), expCode: 200, expDeadline: now.Add(time.Second)},
+		{path: "/query", header: header(now.Add(time.Hour)), expCode: 200, expDeadline: now.Add(time.Minute)},
+	}
+	for i, c := range cases {
+		got, hasDeadline = time.Time{}, false
+		req := httptest.NewRequest("GET", c.path, nil)
+		if c.header != "" {
+			req.Header.Set(cluster.DeadlineHeader, c.header)
+		}
+		w := httptest.NewRecorder()
+		m.ServeHTTP(w, req)
+		if w.Code != c.expCode {
+			t.Fatalf("case %d: expected code %d, got %d", i, c.expCode, w.Code)
+		}
+		if c.expCode != 200 {
+			continue
+		}
+		if c.expDeadline.IsZero() {
+			if hasDeadline {
+				t.Fatalf("case %d: expected no deadline, got %s", i, got)
+			}
+			continue
+		}
+		// the timeout is relative to the time the request was handled, a bit after now
+		if !hasDeadline || got.Before(c.expDeadline) || got.Sub(c.expDeadline) > time.Second {
+			t.Fatalf("case %d: expected deadline %s, got %s (set: %t)", i, c.expDeadline, got, hasDeadline)
+		}
+	}
+}
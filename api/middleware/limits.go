@@ -0,0 +1,23 @@
+package middleware
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/grafana/metrictank/limits"
+	"gopkg.in/macaron.v1"
+)
+
+// LimitConcurrentQueries rejects requests of orgs that already have as many queries
+// in flight as their max-concurrent-queries limit allows.
+func LimitConcurrentQueries() macaron.Handler {
+	return func(c *Context) {
+		release, ok := limits.AcquireQuery(c.OrgId)
+		if !ok {
+			c.PlainText(http.StatusTooManyRequests, []byte(fmt.Sprintf("too many concurrent queries: org %d exceeds its max-concurrent-queries limit (%d).", c.OrgId, limits.Get(c.OrgId).MaxConcurrentQueries)))
+			return
+		}
+		defer release()
+		c.Next()
+	}
+}
@@ -6,6 +6,7 @@ import (
 	"net/http"
 	"strconv"
 
+	"github.com/grafana/metrictank/conf"
 	"github.com/rs/cors"
 	"gopkg.in/macaron.v1"
 )
@@ -13,6 +14,7 @@ import (
 type Context struct {
 	*macaron.Context
 	OrgId uint32
+	Role  conf.Role
 	Body  io.ReadCloser
 }
 
@@ -23,9 +25,11 @@ func OrgMiddleware(multiTenant bool) macaron.Handler {
 			c.PlainText(400, []byte(err.Error()))
 			return
 		}
+		// without authentication, everyone is trusted to act as admin
 		ctx := &Context{
 			Context: c,
 			OrgId:   org,
+			Role:    conf.RoleAdmin,
 		}
 		c.Map(ctx)
 	}
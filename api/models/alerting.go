@@ -0,0 +1,30 @@
+package models
+
+import "time"
+
+// AlertingRuleStatus is the status of an alerting rule, and its alerts
+type AlertingRuleStatus struct {
+	Name      string `json:"name"`
+	Expr      string `json:"expr"`
+	Type      string `json:"type"`
+	OrgId     uint32 `json:"orgId"`
+	Interval  uint32 `json:"interval"`
+	For       uint32 `json:"for"`
+	Condition string `json:"condition"`
+	// whether the node evaluates the rule, i.e. whether it is a ready primary for partition 0
+	Active bool `json:"active"`
+
+	LastEvaluation time.Time     `json:"lastEvaluation"`
+	LastDuration   time.Duration `json:"lastDuration"`
+	LastError      string        `json:"lastError"`
+	Alerts         []Alert       `json:"alerts"`
+}
+
+// Alert is a pending or firing alert of an alerting rule
+type Alert struct {
+	Labels      map[string]string `json:"labels"`
+	Annotations map[string]string `json:"annotations"`
+	State       string            `json:"state"`
+	Value       string            `json:"value"` // formatted like prometheus does, as json doesn't support all float values
+	ActiveAt    time.Time         `json:"activeAt"`
+}
@@ -24,6 +24,7 @@ func (rwm ResponseWithMeta) MarshalJSONFast(b []byte) ([]byte, error) {
 type RenderMeta struct {
 	RenderStats
 	StorageStats
+	Cost QueryCost
 }
 
 func (rm RenderMeta) MarshalJSONFast(b []byte) ([]byte, error) {
@@ -34,6 +35,8 @@ func (rm RenderMeta) MarshalJSONFast(b []byte) ([]byte, error) {
 	b, _ = rm.RenderStats.MarshalJSONFastRaw(b)
 	b = append(b, ',')
 	b, _ = rm.StorageStats.MarshalJSONFastRaw(b)
+	b = append(b, ',')
+	b, _ = rm.Cost.MarshalJSONFastRaw(b)
 	b = append(b, `}}`...)
 	return b, nil
 }
@@ -46,6 +49,8 @@ type RenderStats struct {
 	SeriesFetch           uint32        `json:"executeplan.series-fetch.count"`
 	PointsFetch           uint32        `json:"executeplan.points-fetch.count"`
 	PointsReturn          uint32        `json:"executeplan.points-return.count"`
+	ReqsDeduped           uint32        `json:"executeplan.reqs-deduped.count"`
+	FuncsShared           uint32        `json:"executeplan.funcs-shared.count"`
 }
 
 func (s RenderStats) MarshalJSONFast(b []byte) ([]byte, error) {
@@ -69,5 +74,51 @@ func (s RenderStats) MarshalJSONFastRaw(b []byte) ([]byte, error) {
 	b = strconv.AppendUint(b, uint64(s.PointsFetch), 10)
 	b = append(b, `,"executeplan.points-return.count":`...)
 	b = strconv.AppendUint(b, uint64(s.PointsReturn), 10)
+	b = append(b, `,"executeplan.reqs-deduped.count":`...)
+	b = strconv.AppendUint(b, uint64(s.ReqsDeduped), 10)
+	b = append(b, `,"executeplan.funcs-shared.count":`...)
+	b = strconv.AppendUint(b, uint64(s.FuncsShared), 10)
+	return b, nil
+}
+
+// QueryCost is an estimate of the resources needed to execute a render request.
+// it is computed after the requests have been aligned, but before any data is fetched.
+type QueryCost struct {
+	Series      uint32        // number of series to fetch
+	PointsFetch uint64        // number of points to fetch, including both the sum and cnt rollups to compute averages
+	Chunks      uint64        // number of chunks the points are spread over
+	Memory      uint64        // bytes needed for the fetched and returned points
+	Archives    []ArchiveCost // the cost per archive read from, by ascending archive
+}
+
+// ArchiveCost is the part of a QueryCost caused by reading from one archive (0 for raw data, 1 for the first rollup, etc)
+type ArchiveCost struct {
+	Archive     uint8
+	Series      uint32
+	PointsFetch uint64
+	Chunks      uint64
+}
+
+func (c QueryCost) MarshalJSONFastRaw(b []byte) ([]byte, error) {
+	b = append(b, `"executeplan.cost.series.count":`...)
+	b = strconv.AppendUint(b, uint64(c.Series), 10)
+	b = append(b, `,"executeplan.cost.points-fetch.count":`...)
+	b = strconv.AppendUint(b, c.PointsFetch, 10)
+	b = append(b, `,"executeplan.cost.chunks.count":`...)
+	b = strconv.AppendUint(b, c.Chunks, 10)
+	b = append(b, `,"executeplan.cost.memory.bytes":`...)
+	b = strconv.AppendUint(b, c.Memory, 10)
+	for _, a := range c.Archives {
+		prefix := `,"executeplan.cost.archive-` + strconv.Itoa(int(a.Archive))
+		b = append(b, prefix...)
+		b = append(b, `.series.count":`...)
+		b = strconv.AppendUint(b, uint64(a.Series), 10)
+		b = append(b, prefix...)
+		b = append(b, `.points-fetch.count":`...)
+		b = strconv.AppendUint(b, a.PointsFetch, 10)
+		b = append(b, prefix...)
+		b = append(b, `.chunks.count":`...)
+		b = strconv.AppendUint(b, a.Chunks, 10)
+	}
 	return b, nil
 }
@@ -92,6 +92,7 @@ type IndexAutoCompleteTags struct {
 	OrgId  uint32   `json:"orgId" binding:"Required"`
 	Prefix string   `json:"Prefix"`
 	Expr   []string `json:"expressions"`
+	From   int64    `json:"from"`
 	Limit  uint     `json:"limit"`
 }
 
@@ -100,6 +101,7 @@ func (t IndexAutoCompleteTags) Trace(span opentracing.Span) {
 	span.LogFields(
 		traceLog.String("prefix", t.Prefix),
 		traceLog.String("expressions", fmt.Sprintf("%q", t.Expr)),
+		traceLog.Int64("from", t.From),
 		traceLog.Int("limit", int(t.Limit)),
 	)
 }
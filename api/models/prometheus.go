@@ -28,6 +28,24 @@ type PrometheusSeriesQuery struct {
 	End   string   `form:"end"`     //<rfc3339 | unix_timestamp>: End timestamp.
 }
 
+type PrometheusLabelsQuery struct {
+	Match []string `form:"match[]"` //<series_selector>: Repeated series selector argument that selects the series from which to read the label names. Optional.
+	Start string   `form:"start"`   //<rfc3339 | unix_timestamp>: Start timestamp. Optional.
+	End   string   `form:"end"`     //<rfc3339 | unix_timestamp>: End timestamp. Optional.
+}
+
+type PrometheusMetadataQuery struct {
+	Limit  int    `form:"limit"`  //<number>: Maximum number of metrics to return. Optional.
+	Metric string `form:"metric"` //<string>: A metric name to filter metadata for. All metric metadata is retrieved if left empty. Optional.
+}
+
+// PrometheusMetadata describes the metadata of a metric
+type PrometheusMetadata struct {
+	Type string `json:"type"`
+	Help string `json:"help"`
+	Unit string `json:"unit"`
+}
+
 type PrometheusSeriesSet struct {
 	cur    int
 	series []storage.Series
@@ -0,0 +1,21 @@
+package models
+
+import "time"
+
+// RecordingRuleStatus is the status of a recording rule on a node
+type RecordingRuleStatus struct {
+	Name      string `json:"name"`
+	Expr      string `json:"expr"`
+	Series    string `json:"series"`
+	OrgId     uint32 `json:"orgId"`
+	Interval  uint32 `json:"interval"`
+	Partition int32  `json:"partition"`
+	// whether the node evaluates the rule, i.e. whether it is a ready primary for the partition of the rule
+	Active bool `json:"active"`
+
+	LastEvaluation time.Time     `json:"lastEvaluation"`
+	LastDuration   time.Duration `json:"lastDuration"`
+	LastError      string        `json:"lastError"`
+	SeriesWritten  int           `json:"seriesWritten"` // by the last evaluation
+	PointsWritten  int           `json:"pointsWritten"` // by the last evaluation
+}
@@ -0,0 +1,6 @@
+package models
+
+type RulesReloadResp struct {
+	// the number of archives of which the schema, aggregation or index rule changed
+	ArchivesChanged int `json:"archivesChanged"`
+}
@@ -63,7 +63,7 @@ type SeriesMetaPropertiesExport struct {
 
 // Export returns a human-friendly version of the SeriesMetaProperties.
 func (smp SeriesMetaProperties) Export() SeriesMetaPropertiesExport {
-	schema := mdata.Schemas.Get(smp.SchemaID)
+	schema := mdata.Schemas().Get(smp.SchemaID)
 	return SeriesMetaPropertiesExport{
 		SchemaName:            schema.Name,
 		SchemaRetentions:      schema.Retentions.Orig,
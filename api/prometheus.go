@@ -4,23 +4,35 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"io/ioutil"
 	"math"
 	"net/http"
+	"regexp"
+	"sort"
 	"strconv"
 	"strings"
 	"time"
 
+	"github.com/gogo/protobuf/proto"
+	"github.com/golang/snappy"
 	"github.com/grafana/metrictank/api/middleware"
 	"github.com/grafana/metrictank/api/models"
 	"github.com/grafana/metrictank/api/response"
+	"github.com/grafana/metrictank/expr/tagquery"
 	"github.com/grafana/metrictank/schema"
+	opentracing "github.com/opentracing/opentracing-go"
 	"github.com/prometheus/common/model"
 	"github.com/prometheus/prometheus/pkg/labels"
+	"github.com/prometheus/prometheus/prompb"
 	"github.com/prometheus/prometheus/promql"
 	"github.com/prometheus/prometheus/storage"
 )
 
 type orgID string
+
+// limitErrKey is the context key under which the querier reports requests that exceed the limits of the org
+type limitErrKey string
+
 type status string
 
 const (
@@ -77,6 +89,14 @@ func promQueryResultInternalError(err error) response.Response {
 	}, "")
 }
 
+func promQueryResultError(code int, err error) response.Response {
+	return response.NewJson(code, prometheusQueryResult{
+		Status:    statusError,
+		Error:     err,
+		ErrorType: errorExec,
+	}, "")
+}
+
 func promQueryResultCanceled(err error) response.Response {
 	return response.NewJson(http.StatusInternalServerError, prometheusQueryResult{
 		Status:    statusError,
@@ -111,6 +131,164 @@ func (s *Server) prometheusLabelValues(ctx *middleware.Context) {
 	return
 }
 
+// prometheusLabels returns all label names, optionally limited to the series matching
+// any of the given selectors that have been seen since the given start time
+func (s *Server) prometheusLabels(ctx *middleware.Context, request models.PrometheusLabelsQuery) {
+	var from int64
+	if request.Start != "" {
+		start, err := parseTime(request.Start)
+		if err != nil {
+			response.Write(ctx, promQueryResultBadData(fmt.Errorf("invalid start time: %v", err)))
+			return
+		}
+		from = start.Unix()
+	}
+	// note: the index only knows when series were last updated, so we can't filter by end time.
+	if request.End != "" {
+		if _, err := parseTime(request.End); err != nil {
+			response.Write(ctx, promQueryResultBadData(fmt.Errorf("invalid end time: %v", err)))
+			return
+		}
+	}
+
+	var selectors [][]string
+	for _, selector := range request.Match {
+		matchers, err := promql.ParseMetricSelector(selector)
+		if err != nil {
+			response.Write(ctx, promQueryResultBadData(fmt.Errorf("invalid metric selector: %v", err)))
+			return
+		}
+		expressions := matchersToExpressions(matchers)
+		if _, err := tagquery.ParseExpressions(expressions); err != nil {
+			response.Write(ctx, promQueryResultBadData(fmt.Errorf("invalid metric selector: %v", err)))
+			return
+		}
+		selectors = append(selectors, expressions)
+	}
+
+	reqCtx := ctx.Req.Context()
+	labelSet := make(map[string]struct{})
+	if len(selectors) == 0 && from == 0 {
+		tags, err := s.clusterTags(reqCtx, ctx.OrgId, "")
+		if err != nil {
+			response.Write(ctx, promQueryResultExecError(fmt.Errorf("query failed: %v", err)))
+			return
+		}
+		for _, tag := range tags {
+			labelSet[tag] = struct{}{}
+		}
+	} else {
+		if len(selectors) == 0 {
+			selectors = append(selectors, []string{"name=~.+"})
+		}
+		// the peers only send us the label names of the matching series, not the series themselves.
+		// there are few label names, so we don't truncate them.
+		for _, expressions := range selectors {
+			tags, err := s.clusterAutoCompleteTags(reqCtx, ctx.OrgId, "", expressions, from, math.MaxUint32)
+			if err != nil {
+				response.Write(ctx, promQueryResultExecError(fmt.Errorf("query failed: %v", err)))
+				return
+			}
+			for _, tag := range tags {
+				labelSet[tag] = struct{}{}
+			}
+		}
+	}
+
+	if _, ok := labelSet["name"]; ok {
+		delete(labelSet, "name")
+		labelSet[model.MetricNameLabel] = struct{}{}
+	}
+	labelNames := make([]string, 0, len(labelSet))
+	for name := range labelSet {
+		labelNames = append(labelNames, name)
+	}
+	sort.Strings(labelNames)
+
+	response.Write(ctx, response.NewJson(200, prometheusQueryResult{Status: statusSuccess, Data: labelNames}, ""))
+}
+
+// prometheusMetadata returns the metadata (type and unit) of the metrics, keyed by metric name.
+// if no metric is given, it returns the metadata of the first metrics by name, up to the limit,
+// or the tagdb-default-limit if no limit is given.
+func (s *Server) prometheusMetadata(ctx *middleware.Context, request models.PrometheusMetadataQuery) {
+	reqCtx := ctx.Req.Context()
+	expression := "name=" + request.Metric
+	if request.Metric == "" {
+		limit := tagdbDefaultLimit
+		if request.Limit > 0 {
+			limit = uint(request.Limit)
+		}
+		names, err := s.clusterAutoCompleteTagValues(reqCtx, ctx.OrgId, "name", "", nil, limit)
+		if err != nil {
+			response.Write(ctx, promQueryResultExecError(fmt.Errorf("query failed: %v", err)))
+			return
+		}
+		if len(names) == 0 {
+			response.Write(ctx, response.NewJson(200, prometheusQueryResult{Status: statusSuccess, Data: map[string][]models.PrometheusMetadata{}}, ""))
+			return
+		}
+		for i, name := range names {
+			names[i] = regexp.QuoteMeta(name)
+		}
+		expression = "name=~(" + strings.Join(names, "|") + ")$"
+	}
+	expressions, err := tagquery.ParseExpressions([]string{expression})
+	if err != nil {
+		response.Write(ctx, promQueryResultBadData(fmt.Errorf("invalid metric: %v", err)))
+		return
+	}
+
+	series, err := s.clusterFindByTag(reqCtx, ctx.OrgId, expressions, 0, maxSeriesPerReq)
+	if err != nil {
+		response.Write(ctx, promQueryResultExecError(fmt.Errorf("query failed: %v", err)))
+		return
+	}
+
+	metadata := make(map[string][]models.PrometheusMetadata)
+	for _, serie := range series {
+		for _, metric := range serie.Series {
+			for _, archive := range metric.Defs {
+				md := models.PrometheusMetadata{
+					Type: mtypeToPrometheusType(archive.Mtype),
+				}
+				if archive.Unit != "unknown" {
+					md.Unit = archive.Unit
+				}
+				if containsMetadata(metadata[archive.Name], md) {
+					continue
+				}
+				if _, ok := metadata[archive.Name]; !ok && request.Limit > 0 && len(metadata) >= request.Limit {
+					continue
+				}
+				metadata[archive.Name] = append(metadata[archive.Name], md)
+			}
+		}
+	}
+
+	response.Write(ctx, response.NewJson(200, prometheusQueryResult{Status: statusSuccess, Data: metadata}, ""))
+}
+
+func containsMetadata(list []models.PrometheusMetadata, md models.PrometheusMetadata) bool {
+	for _, m := range list {
+		if m == md {
+			return true
+		}
+	}
+	return false
+}
+
+// mtypeToPrometheusType converts our metric types into prometheus metric types
+func mtypeToPrometheusType(mtype string) string {
+	switch mtype {
+	case "counter", "count":
+		return "counter"
+	case "gauge", "rate":
+		return "gauge"
+	}
+	return "unknown"
+}
+
 func (s *Server) prometheusQueryRange(ctx *middleware.Context, request models.PrometheusRangeQuery) {
 	start, err := parseTime(request.Start)
 	if err != nil {
@@ -142,9 +320,17 @@ func (s *Server) prometheusQueryRange(ctx *middleware.Context, request models.Pr
 		return
 	}
 
+	var limitErr error
 	newCtx := context.WithValue(ctx.Req.Context(), orgID("org-id"), ctx.OrgId)
+	newCtx = context.WithValue(newCtx, limitErrKey("limit-err"), &limitErr)
 	res := qry.Exec(newCtx)
 
+	// the promql engine swallows errors of the querier, so we need to check for limit violations separately
+	if limitErr != nil {
+		response.Write(ctx, promQueryResultError(response.WrapError(limitErr).Code(), fmt.Errorf("query failed: %v", limitErr)))
+		return
+	}
+
 	if res.Err != nil {
 		if res.Err != nil {
 			switch res.Err.(type) {
@@ -186,9 +372,17 @@ func (s *Server) prometheusQueryInstant(ctx *middleware.Context, request models.
 		return
 	}
 
+	var limitErr error
 	newCtx := context.WithValue(ctx.Req.Context(), orgID("org-id"), ctx.OrgId)
+	newCtx = context.WithValue(newCtx, limitErrKey("limit-err"), &limitErr)
 	res := qry.Exec(newCtx)
 
+	// the promql engine swallows errors of the querier, so we need to check for limit violations separately
+	if limitErr != nil {
+		response.Write(ctx, promQueryResultError(response.WrapError(limitErr).Code(), fmt.Errorf("query failed: %v", limitErr)))
+		return
+	}
+
 	if res.Err != nil {
 		if res.Err != nil {
 			switch res.Err.(type) {
@@ -216,6 +410,29 @@ func (s *Server) prometheusQueryInstant(ctx *middleware.Context, request models.
 	))
 }
 
+// PromQLInstantQuery evaluates a promql expression for the given org at the given time, like an instant query does.
+// it is used to evaluate expressions on behalf of metrictank itself, e.g. for alerting rules.
+func (s *Server) PromQLInstantQuery(ctx context.Context, orgId uint32, query string, ts time.Time) (promql.Value, error) {
+	qry, err := s.PromQueryEngine.NewInstantQuery(query, ts)
+	if err != nil {
+		return nil, err
+	}
+
+	span := s.Tracer.StartSpan("promQLInstantQuery")
+	defer span.Finish()
+	var limitErr error
+	ctx = opentracing.ContextWithSpan(ctx, span)
+	ctx = context.WithValue(ctx, orgID("org-id"), orgId)
+	ctx = context.WithValue(ctx, limitErrKey("limit-err"), &limitErr)
+	res := qry.Exec(ctx)
+
+	// the promql engine swallows errors of the querier, so we need to check for limit violations separately
+	if limitErr != nil {
+		return nil, limitErr
+	}
+	return res.Value, res.Err
+}
+
 func (s *Server) prometheusQuerySeries(ctx *middleware.Context, request models.PrometheusSeriesQuery) {
 	start, err := parseTime(request.Start)
 	if err != nil {
@@ -272,6 +489,113 @@ func (s *Server) prometheusQuerySeries(ctx *middleware.Context, request models.P
 	return
 }
 
+// prometheusRead implements the prometheus remote read protocol,
+// so that prometheus can use metrictank as long-term storage.
+func (s *Server) prometheusRead(ctx *middleware.Context) {
+	compressed, err := ioutil.ReadAll(ctx.Req.Request.Body)
+	if err != nil {
+		response.Write(ctx, response.NewError(http.StatusBadRequest, fmt.Sprintf("read error: %v", err)))
+		return
+	}
+	reqBuf, err := snappy.Decode(nil, compressed)
+	if err != nil {
+		response.Write(ctx, response.NewError(http.StatusBadRequest, fmt.Sprintf("decode error: %v", err)))
+		return
+	}
+	var req prompb.ReadRequest
+	if err := proto.Unmarshal(reqBuf, &req); err != nil {
+		response.Write(ctx, response.NewError(http.StatusBadRequest, fmt.Sprintf("unmarshal error: %v", err)))
+		return
+	}
+
+	resp := prompb.ReadResponse{
+		Results: make([]*prompb.QueryResult, len(req.Queries)),
+	}
+	for i, query := range req.Queries {
+		matchers, err := fromLabelMatchers(query.Matchers)
+		if err != nil {
+			response.Write(ctx, response.NewError(http.StatusBadRequest, err.Error()))
+			return
+		}
+		// prometheus' end is inclusive, ours is exclusive
+		from := uint32(query.StartTimestampMs / 1000)
+		to := uint32(query.EndTimestampMs/1000) + 1
+		q := NewQuerier(ctx.Req.Context(), s, from, to, ctx.OrgId, false)
+		set, err := q.Select(matchers...)
+		q.Close()
+		if err == errNoSeriesFound {
+			resp.Results[i] = &prompb.QueryResult{}
+			continue
+		}
+		if err != nil {
+			response.Write(ctx, response.WrapError(err))
+			return
+		}
+		resp.Results[i], err = toQueryResult(set)
+		if err != nil {
+			response.Write(ctx, response.WrapError(err))
+			return
+		}
+	}
+
+	response.Write(ctx, response.NewSnappyProtobuf(200, &resp))
+}
+
+// fromLabelMatchers converts remote read label matchers into prometheus matchers
+func fromLabelMatchers(in []*prompb.LabelMatcher) ([]*labels.Matcher, error) {
+	out := make([]*labels.Matcher, 0, len(in))
+	for _, m := range in {
+		var mt labels.MatchType
+		switch m.Type {
+		case prompb.LabelMatcher_EQ:
+			mt = labels.MatchEqual
+		case prompb.LabelMatcher_NEQ:
+			mt = labels.MatchNotEqual
+		case prompb.LabelMatcher_RE:
+			mt = labels.MatchRegexp
+		case prompb.LabelMatcher_NRE:
+			mt = labels.MatchNotRegexp
+		default:
+			return nil, fmt.Errorf("invalid matcher type %d", m.Type)
+		}
+		matcher, err := labels.NewMatcher(mt, m.Name, m.Value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid matcher %s: %v", m.Name, err)
+		}
+		out = append(out, matcher)
+	}
+	return out, nil
+}
+
+// toQueryResult converts a SeriesSet into a remote read query result
+func toQueryResult(set storage.SeriesSet) (*prompb.QueryResult, error) {
+	result := &prompb.QueryResult{}
+	for set.Next() {
+		series := set.At()
+		ts := &prompb.TimeSeries{}
+		for _, l := range series.Labels() {
+			ts.Labels = append(ts.Labels, &prompb.Label{Name: l.Name, Value: l.Value})
+		}
+		it := series.Iterator()
+		for it.Next() {
+			t, v := it.At()
+			// NaNs are the gaps in our series. prometheus would take them for real values
+			if math.IsNaN(v) {
+				continue
+			}
+			ts.Samples = append(ts.Samples, &prompb.Sample{Timestamp: t, Value: v})
+		}
+		if it.Err() != nil {
+			return nil, it.Err()
+		}
+		if len(ts.Samples) == 0 {
+			continue
+		}
+		result.Timeseries = append(result.Timeseries, ts)
+	}
+	return result, set.Err()
+}
+
 func parseTime(s string) (time.Time, error) {
 	if t, err := strconv.ParseFloat(s, 64); err == nil {
 		s, ns := math.Modf(t)
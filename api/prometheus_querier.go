@@ -2,13 +2,17 @@ package api
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"math"
+	"net/http"
 	"time"
 
 	"github.com/grafana/metrictank/api/models"
+	"github.com/grafana/metrictank/api/response"
 	"github.com/grafana/metrictank/consolidation"
 	"github.com/grafana/metrictank/expr/tagquery"
+	"github.com/grafana/metrictank/limits"
 	"github.com/grafana/metrictank/mdata"
 	"github.com/grafana/metrictank/util"
 	"github.com/prometheus/common/model"
@@ -17,6 +21,11 @@ import (
 	log "github.com/sirupsen/logrus"
 )
 
+var errNoSeriesFound = errors.New("no series found")
+
+// promLabelsLimit is the max number of label names or values returned by the index
+const promLabelsLimit = 100000
+
 // Querier creates a new querier that will operate on the subject server
 // it needs the org-id stored in a context value
 func (s *Server) Querier(ctx context.Context, min, max int64) (storage.Querier, error) {
@@ -53,17 +62,7 @@ func (q *querier) Select(matchers ...*labels.Matcher) (storage.SeriesSet, error)
 	var target string
 	var reqs []models.Req
 
-	expressions := []string{}
-	for _, matcher := range matchers {
-		if matcher.Name == model.MetricNameLabel {
-			matcher.Name = "name"
-		}
-		if matcher.Type == labels.MatchNotRegexp {
-			expressions = append(expressions, fmt.Sprintf("%s!=~%s", matcher.Name, matcher.Value))
-		} else {
-			expressions = append(expressions, fmt.Sprintf("%s%s%s", matcher.Name, matcher.Type, matcher.Value))
-		}
-	}
+	expressions := matchersToExpressions(matchers)
 
 	parsedExpressions, err := tagquery.ParseExpressions(expressions)
 	if err != nil {
@@ -75,6 +74,14 @@ func (q *querier) Select(matchers ...*labels.Matcher) (storage.SeriesSet, error)
 		return nil, err
 	}
 
+	if !q.metadataOnly && !limits.AllowSeriesPerReq(q.OrgID, len(series)) {
+		err := response.NewError(http.StatusTooManyRequests, fmt.Sprintf("Request exceeds the max-series-per-req limit of org %d (%d). Reduce the number of series selected or ask your admin to increase the limit.", q.OrgID, limits.Get(q.OrgID).MaxSeriesPerReq))
+		if limitErr, ok := q.ctx.Value(limitErrKey("limit-err")).(*error); ok {
+			*limitErr = err
+		}
+		return nil, err
+	}
+
 	if q.metadataOnly {
 		return BuildMetadataSeriesSet(series)
 	}
@@ -85,7 +92,7 @@ func (q *querier) Select(matchers ...*labels.Matcher) (storage.SeriesSet, error)
 		for _, metric := range s.Series {
 			for _, archive := range metric.Defs {
 				consReq := consolidation.None
-				fn := mdata.Aggregations.Get(archive.AggId).AggregationMethod[0]
+				fn := mdata.Aggregations().Get(archive.AggId).AggregationMethod[0]
 				cons := consolidation.Consolidator(fn)
 
 				newReq := models.NewReq(archive.Id, archive.NameWithTags(), target, q.from, q.to, math.MaxUint32, uint32(archive.Interval), cons, consReq, s.Node, archive.SchemaId, archive.AggId)
@@ -103,7 +110,7 @@ func (q *querier) Select(matchers ...*labels.Matcher) (storage.SeriesSet, error)
 
 	reqRenderSeriesCount.Value(len(reqs))
 	if len(reqs) == 0 {
-		return nil, fmt.Errorf("no series found")
+		return nil, errNoSeriesFound
 	}
 
 	// note: if 1 series has a movingAvg that requires a long time range extension, it may push other reqs into another archive. can be optimized later
@@ -135,7 +142,24 @@ func (q *querier) LabelValues(name string) ([]string, error) {
 		return nil, err
 	}
 
-	return q.MetricIndex.FindTagValuesWithQuery(q.OrgID, name, "", query, 100000), nil
+	return q.MetricIndex.FindTagValuesWithQuery(q.OrgID, name, "", query, promLabelsLimit), nil
+}
+
+// matchersToExpressions converts prometheus label matchers into tag query expressions
+// note that the __name__ label corresponds to our name tag
+func matchersToExpressions(matchers []*labels.Matcher) []string {
+	expressions := []string{}
+	for _, matcher := range matchers {
+		if matcher.Name == model.MetricNameLabel {
+			matcher.Name = "name"
+		}
+		if matcher.Type == labels.MatchNotRegexp {
+			expressions = append(expressions, fmt.Sprintf("%s!=~%s", matcher.Name, matcher.Value))
+		} else {
+			expressions = append(expressions, fmt.Sprintf("%s%s%s", matcher.Name, matcher.Type, matcher.Value))
+		}
+	}
+	return expressions
 }
 
 // Close releases the resources of the Querier.
@@ -0,0 +1,96 @@
+package api
+
+import (
+	"math"
+	"reflect"
+	"testing"
+
+	"github.com/grafana/metrictank/api/models"
+	"github.com/grafana/metrictank/schema"
+	"github.com/prometheus/prometheus/pkg/labels"
+	"github.com/prometheus/prometheus/prompb"
+)
+
+func TestFromLabelMatchers(t *testing.T) {
+	in := []*prompb.LabelMatcher{
+		{Type: prompb.LabelMatcher_EQ, Name: "__name__", Value: "foo"},
+		{Type: prompb.LabelMatcher_NEQ, Name: "a", Value: "b"},
+		{Type: prompb.LabelMatcher_RE, Name: "c", Value: "d.*"},
+		{Type: prompb.LabelMatcher_NRE, Name: "e", Value: "f.*"},
+	}
+	exp := []labels.MatchType{labels.MatchEqual, labels.MatchNotEqual, labels.MatchRegexp, labels.MatchNotRegexp}
+	out, err := fromLabelMatchers(in)
+	if err != nil {
+		t.Fatalf("expected no error, got %s", err)
+	}
+	if len(out) != len(exp) {
+		t.Fatalf("expected %d matchers, got %d", len(exp), len(out))
+	}
+	for i, m := range out {
+		if m.Type != exp[i] || m.Name != in[i].Name || m.Value != in[i].Value {
+			t.Fatalf("matcher %d: expected %s %s %q, got %s", i, in[i].Name, exp[i], in[i].Value, m)
+		}
+	}
+
+	_, err = fromLabelMatchers([]*prompb.LabelMatcher{{Type: prompb.LabelMatcher_RE, Name: "a", Value: "("}})
+	if err == nil {
+		t.Fatalf("expected error for invalid regex, got nil")
+	}
+}
+
+func TestToQueryResult(t *testing.T) {
+	set, _ := SeriesToSeriesSet([]models.Series{
+		{
+			Target: "foo;a=b",
+			Datapoints: []schema.Point{
+				{Val: 1, Ts: 10},
+				{Val: math.NaN(), Ts: 15},
+				{Val: 2, Ts: 20},
+			},
+		},
+		{
+			Target: "bar;a=b",
+			Datapoints: []schema.Point{
+				{Val: math.NaN(), Ts: 10},
+				{Val: math.NaN(), Ts: 20},
+			},
+		},
+	})
+	res, err := toQueryResult(set)
+	if err != nil {
+		t.Fatalf("expected no error, got %s", err)
+	}
+	exp := &prompb.QueryResult{
+		Timeseries: []*prompb.TimeSeries{
+			{
+				Labels: []*prompb.Label{
+					{Name: "__name__", Value: "foo"},
+					{Name: "a", Value: "b"},
+				},
+				Samples: []*prompb.Sample{
+					{Value: 1, Timestamp: 10000},
+					{Value: 2, Timestamp: 20000},
+				},
+			},
+		},
+	}
+	if !reflect.DeepEqual(res, exp) {
+		t.Fatalf("expected %v, got %v", exp, res)
+	}
+}
+
+func TestMtypeToPrometheusType(t *testing.T) {
+	cases := map[string]string{
+		"counter":   "counter",
+		"count":     "counter",
+		"gauge":     "gauge",
+		"rate":      "gauge",
+		"timestamp": "unknown",
+		"":          "unknown",
+	}
+	for in, exp := range cases {
+		if got := mtypeToPrometheusType(in); got != exp {
+			t.Errorf("mtype %q: expected %q, got %q", in, exp, got)
+		}
+	}
+}
@@ -0,0 +1,95 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+
+	"github.com/grafana/metrictank/api/models"
+	"github.com/grafana/metrictank/api/response"
+	"github.com/grafana/metrictank/consolidation"
+	"github.com/grafana/metrictank/mdata"
+	"github.com/grafana/metrictank/stats"
+)
+
+var (
+	// metric api.request.render.chunks_estimate is the estimated number of chunks a /render request needs to read
+	reqRenderChunksEstimate = stats.NewMeter32("api.request.render.chunks_estimate", false)
+
+	// metric api.request.render.rejected_cost is the number of /render requests rejected because their estimated cost exceeded a limit
+	reqRenderRejectedCost = stats.NewCounter32("api.request.render.rejected_cost")
+)
+
+// estimateCost estimates the cost of fetching the data for the given aligned requests,
+// which will return pointsReturn points after runtime consolidation
+func estimateCost(reqs []models.Req, pointsReturn uint32) models.QueryCost {
+	var cost models.QueryCost
+	archives := make(map[uint8]*models.ArchiveCost)
+	for _, req := range reqs {
+		a, ok := archives[req.Archive]
+		if !ok {
+			a = &models.ArchiveCost{Archive: req.Archive}
+			archives[req.Archive] = a
+		}
+
+		// averages of rollups are computed from the sum and cnt rollups, so we need to fetch 2 series
+		fetches := uint64(1)
+		if req.Archive > 0 && req.Consolidator == consolidation.Avg {
+			fetches = 2
+		}
+		points := fetches * uint64((req.To-req.From)/req.ArchInterval)
+		chunks := fetches * numChunks(req)
+
+		a.Series++
+		a.PointsFetch += points
+		a.Chunks += chunks
+		cost.Series++
+		cost.PointsFetch += points
+		cost.Chunks += chunks
+	}
+	cost.Memory = (cost.PointsFetch + uint64(pointsReturn)) * pointSize
+
+	for _, a := range archives {
+		cost.Archives = append(cost.Archives, *a)
+	}
+	sort.Slice(cost.Archives, func(i, j int) bool { return cost.Archives[i].Archive < cost.Archives[j].Archive })
+	return cost
+}
+
+// numChunks returns how many chunks of the archive cover the time range of the request
+func numChunks(req models.Req) uint64 {
+	if req.To <= req.From {
+		return 0
+	}
+	rets := mdata.Schemas().Get(req.SchemaId).Retentions.Rets
+	if int(req.Archive) >= len(rets) {
+		// the request was planned against other schemas than we have now (e.g. after a reload),
+		// so we don't know the chunkspan. conservatively assume a chunk per point
+		if req.ArchInterval == 0 {
+			return 0
+		}
+		return uint64((req.To-1)/req.ArchInterval-req.From/req.ArchInterval) + 1
+	}
+	chunkSpan := rets[req.Archive].ChunkSpan
+	if chunkSpan == 0 {
+		return 0
+	}
+	return uint64((req.To-1)/chunkSpan-req.From/chunkSpan) + 1
+}
+
+// checkCost returns an error if the cost exceeds any of the configured limits
+func checkCost(cost models.QueryCost) error {
+	check := func(name, desc string, estimate, limit uint64) error {
+		if limit == 0 || estimate <= limit {
+			return nil
+		}
+		return response.NewError(http.StatusRequestEntityTooLarge, fmt.Sprintf("request is estimated to need %d %s, which exceeds the %s limit of %d. Reduce the time range or number of targets or ask your admin to increase the limit.", estimate, desc, name, limit))
+	}
+	if err := check("max-points-fetch-per-req", "datapoints to be fetched", cost.PointsFetch, maxPointsFetchPerReq); err != nil {
+		return err
+	}
+	if err := check("max-chunks-per-req", "chunks to be read", cost.Chunks, maxChunksPerReq); err != nil {
+		return err
+	}
+	return check("max-memory-per-req", "bytes of memory", cost.Memory, maxMemoryPerReq)
+}
@@ -0,0 +1,78 @@
+package api
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/grafana/metrictank/api/models"
+	"github.com/grafana/metrictank/conf"
+	"github.com/grafana/metrictank/consolidation"
+	"github.com/grafana/metrictank/mdata"
+	"github.com/grafana/metrictank/test"
+)
+
+func TestEstimateCost(t *testing.T) {
+	// raw 10s data in 600s chunks, and a 60s rollup in 3600s chunks
+	mdata.SetSingleSchema(conf.MustParseRetentions("10s:1d:600s:2,60s:30d:3600s:2"))
+
+	reqs := []models.Req{
+		// 360 points over chunks 0, 600 ... 3600
+		reqOut(test.GetMKey(1), 0, 3600, 800, 10, consolidation.Max, 0, 0, 0, 10, 86400, 10, 1),
+		// 360 points over chunks 3600 and 7200. avg needs the sum and cnt rollups
+		reqOut(test.GetMKey(2), 3600, 25200, 800, 10, consolidation.Avg, 0, 0, 1, 60, 2592000, 60, 1),
+		// 60 points over chunk 3600
+		reqOut(test.GetMKey(3), 3600, 7200, 800, 10, consolidation.Max, 0, 0, 1, 60, 2592000, 60, 1),
+	}
+	exp := models.QueryCost{
+		Series:      3,
+		PointsFetch: 360 + 2*360 + 60,
+		Chunks:      6 + 2*6 + 1,
+		Memory:      (360 + 2*360 + 60 + 100) * pointSize,
+		Archives: []models.ArchiveCost{
+			{Archive: 0, Series: 1, PointsFetch: 360, Chunks: 6},
+			{Archive: 1, Series: 2, PointsFetch: 2*360 + 60, Chunks: 2*6 + 1},
+		},
+	}
+	if cost := estimateCost(reqs, 100); !reflect.DeepEqual(cost, exp) {
+		t.Fatalf("expected cost %+v, got %+v", exp, cost)
+	}
+}
+
+func TestNumChunksUnknownArchive(t *testing.T) {
+	// only raw data: archive 1 doesn't exist (anymore)
+	mdata.SetSingleSchema(conf.MustParseRetentions("10s:1d:600s:2"))
+
+	req := reqOut(test.GetMKey(1), 3600, 7200, 800, 10, consolidation.Max, 0, 0, 1, 60, 2592000, 60, 1)
+	if chunks := numChunks(req); chunks != 60 {
+		t.Fatalf("expected a chunk per point for an unknown archive, got %d chunks", chunks)
+	}
+}
+
+func TestCheckCost(t *testing.T) {
+	defer func(points, chunks, memory uint64) {
+		maxPointsFetchPerReq, maxChunksPerReq, maxMemoryPerReq = points, chunks, memory
+	}(maxPointsFetchPerReq, maxChunksPerReq, maxMemoryPerReq)
+
+	cost := models.QueryCost{Series: 10, PointsFetch: 1000, Chunks: 20, Memory: 16000}
+	cases := []struct {
+		points, chunks, memory uint64
+		expErr                 string
+	}{
+		{0, 0, 0, ""},
+		{1000, 20, 16000, ""},
+		{999, 0, 0, "max-points-fetch-per-req"},
+		{0, 19, 0, "max-chunks-per-req"},
+		{0, 0, 15999, "max-memory-per-req"},
+	}
+	for i, c := range cases {
+		maxPointsFetchPerReq, maxChunksPerReq, maxMemoryPerReq = c.points, c.chunks, c.memory
+		err := checkCost(cost)
+		if c.expErr == "" && err != nil {
+			t.Fatalf("case %d: expected no error, got %s", i, err)
+		}
+		if c.expErr != "" && (err == nil || !strings.Contains(err.Error(), c.expErr)) {
+			t.Fatalf("case %d: expected an error about %s, got %v", i, c.expErr, err)
+		}
+	}
+}
@@ -29,7 +29,7 @@ func testAlign(reqs []models.Req, retentions []conf.Retentions, outReqs []models
 		}
 	}
 
-	mdata.Schemas = conf.NewSchemas(schemas)
+	mdata.SetSchemas(conf.NewSchemas(schemas))
 	out, _, _, err := alignRequests(now, reqs[0].From, reqs[0].To, reqs)
 	if err != outErr {
 		t.Errorf("different err value expected: %v, got: %v", outErr, err)
@@ -449,7 +449,7 @@ func testMaxPointsPerReq(maxPointsSoft, maxPointsHard int, reqs []models.Req, t
 	maxPointsPerReqSoft = maxPointsSoft
 	maxPointsPerReqHard = maxPointsHard
 
-	mdata.Schemas = conf.NewSchemas([]conf.Schema{{
+	schemas := conf.NewSchemas([]conf.Schema{{
 		Pattern: regexp.MustCompile(".*"),
 		Retentions: conf.BuildFromRetentions(
 			conf.NewRetentionMT(1, 2*day, 600, 2, 0),
@@ -457,6 +457,7 @@ func testMaxPointsPerReq(maxPointsSoft, maxPointsHard int, reqs []models.Req, t
 			conf.NewRetentionMT(3600, 30*day, 600, 2, 0),
 		),
 	}})
+	mdata.SetSchemas(schemas)
 
 	out, _, _, err := alignRequests(30*day, reqs[0].From, reqs[0].To, reqs)
 	maxPointsPerReqSoft = origMaxPointsPerReqSoft
@@ -517,7 +518,7 @@ func BenchmarkAlignRequests(b *testing.B) {
 		reqRaw(test.GetMKey(2), 0, 3600*24*7, 1000, 30, consolidation.Avg, 4, 0),
 		reqRaw(test.GetMKey(3), 0, 3600*24*7, 1000, 60, consolidation.Avg, 8, 0),
 	}
-	mdata.Schemas = conf.NewSchemas([]conf.Schema{
+	schemas := conf.NewSchemas([]conf.Schema{
 		{
 			Pattern: regexp.MustCompile("a"),
 			Retentions: conf.BuildFromRetentions(
@@ -546,6 +547,7 @@ func BenchmarkAlignRequests(b *testing.B) {
 			),
 		},
 	})
+	mdata.SetSchemas(schemas)
 
 	for n := 0; n < b.N; n++ {
 		res, _, _, _ = alignRequests(14*24*3600, 0, 3600*24*7, reqs)
@@ -1,6 +1,7 @@
 package response
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"net/http"
@@ -108,3 +109,14 @@ func (r *ErrorResp) ValidateAndFixCode() {
 }
 
 var RequestCanceledErr = NewError(499, "request canceled")
+
+var RequestDeadlineExceededErr = NewError(http.StatusGatewayTimeout, "request deadline exceeded")
+
+// ContextErr returns the error to respond with to a request of which the context is done,
+// based on the error of the context
+func ContextErr(err error) *ErrorResp {
+	if err == context.DeadlineExceeded {
+		return RequestDeadlineExceededErr
+	}
+	return RequestCanceledErr
+}
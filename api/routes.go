@@ -4,6 +4,7 @@ import (
 	"github.com/go-macaron/binding"
 	"github.com/grafana/metrictank/api/middleware"
 	"github.com/grafana/metrictank/api/models"
+	"github.com/grafana/metrictank/conf"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"github.com/raintank/gziper"
 	"gopkg.in/macaron.v1"
@@ -17,7 +18,11 @@ func (s *Server) RegisterRoutes() {
 	r.Use(middleware.RequestStats())
 	r.Use(middleware.Tracer(s.Tracer))
 	r.Use(macaron.Renderer())
-	r.Use(middleware.OrgMiddleware(multiTenant))
+	if apiKeys != nil {
+		r.Use(middleware.AuthMiddleware(*apiKeys, multiTenant))
+	} else {
+		r.Use(middleware.OrgMiddleware(multiTenant))
+	}
 	r.Use(middleware.CorsHandler())
 	form := binding.Form
 	bind := binding.Bind
@@ -25,61 +30,76 @@ func (s *Server) RegisterRoutes() {
 	cBody := middleware.CaptureBody
 	ready := middleware.NodeReady()
 	noTrace := middleware.DisableTracing
+	limitQueries := middleware.LimitConcurrentQueries()
+	schedule := s.scheduleQuery()
+	deadline := middleware.Deadline(queryTimeout)
+	peerDeadline := middleware.Deadline(0)
+	// roles only matter when authentication is enabled. otherwise every request is treated as admin.
+	// the cluster-internal routes take the org from the request body, so they require admin.
+	read := middleware.RequireRole(conf.RoleRead)
+	write := middleware.RequireRole(conf.RoleWrite)
+	admin := middleware.RequireRole(conf.RoleAdmin)
 
 	r.Get("/", noTrace, s.appStatus)
 	r.Get("/node", noTrace, s.getNodeStatus)
-	r.Post("/node", bind(models.NodeStatus{}), s.setNodeStatus)
-	r.Get("/priority", s.explainPriority)
-	r.Get("/debug/pprof/block", blockHandler)
-	r.Get("/debug/pprof/mutex", mutexHandler)
+	r.Post("/node", admin, bind(models.NodeStatus{}), s.setNodeStatus)
+	r.Get("/priority", read, s.explainPriority)
+	r.Get("/debug/pprof/block", admin, blockHandler)
+	r.Get("/debug/pprof/mutex", admin, mutexHandler)
 
-	r.Get("/cluster", s.getClusterStatus)
-	r.Post("/cluster", bind(models.ClusterMembers{}), s.postClusterMembers)
+	r.Get("/cluster", read, s.getClusterStatus)
+	r.Post("/cluster", admin, bind(models.ClusterMembers{}), s.postClusterMembers)
 
-	r.Combo("/getdata", ready, bind(models.GetData{})).Get(s.getData).Post(s.getData)
+	r.Combo("/getdata", admin, ready, peerDeadline, bind(models.GetData{})).Get(s.getData).Post(s.getData)
 
-	r.Combo("/index/find", ready, bind(models.IndexFind{})).Get(s.indexFind).Post(s.indexFind)
-	r.Combo("/index/list", ready, bind(models.IndexList{})).Get(s.indexList).Post(s.indexList)
-	r.Combo("/index/delete", ready, bind(models.IndexDelete{})).Get(s.indexDelete).Post(s.indexDelete)
-	r.Combo("/index/get", ready, bind(models.IndexGet{})).Get(s.indexGet).Post(s.indexGet)
-	r.Combo("/index/find_by_tag", ready, bind(models.IndexFindByTag{})).Get(s.indexFindByTag).Post(s.indexFindByTag)
-	r.Combo("/index/tags", ready, bind(models.IndexTags{})).Get(s.indexTags).Post(s.indexTags)
-	r.Combo("/index/tag_details", ready, bind(models.IndexTagDetails{})).Get(s.indexTagDetails).Post(s.indexTagDetails)
-	r.Combo("/index/tags/autoComplete/tags", ready, bind(models.IndexAutoCompleteTags{})).Get(s.indexAutoCompleteTags).Post(s.indexAutoCompleteTags)
-	r.Combo("/index/tags/autoComplete/values", ready, bind(models.IndexAutoCompleteTagValues{})).Get(s.indexAutoCompleteTagValues).Post(s.indexAutoCompleteTagValues)
-	r.Combo("/index/tags/delSeries", ready, bind(models.IndexTagDelSeries{})).Get(s.indexTagDelSeries).Post(s.indexTagDelSeries)
+	r.Combo("/index/find", admin, ready, peerDeadline, bind(models.IndexFind{})).Get(s.indexFind).Post(s.indexFind)
+	r.Combo("/index/list", admin, ready, peerDeadline, bind(models.IndexList{})).Get(s.indexList).Post(s.indexList)
+	r.Combo("/index/delete", admin, ready, peerDeadline, bind(models.IndexDelete{})).Get(s.indexDelete).Post(s.indexDelete)
+	r.Combo("/index/get", admin, ready, peerDeadline, bind(models.IndexGet{})).Get(s.indexGet).Post(s.indexGet)
+	r.Combo("/index/find_by_tag", admin, ready, peerDeadline, bind(models.IndexFindByTag{})).Get(s.indexFindByTag).Post(s.indexFindByTag)
+	r.Combo("/index/tags", admin, ready, peerDeadline, bind(models.IndexTags{})).Get(s.indexTags).Post(s.indexTags)
+	r.Combo("/index/tag_details", admin, ready, peerDeadline, bind(models.IndexTagDetails{})).Get(s.indexTagDetails).Post(s.indexTagDetails)
+	r.Combo("/index/tags/autoComplete/tags", admin, ready, peerDeadline, bind(models.IndexAutoCompleteTags{})).Get(s.indexAutoCompleteTags).Post(s.indexAutoCompleteTags)
+	r.Combo("/index/tags/autoComplete/values", admin, ready, peerDeadline, bind(models.IndexAutoCompleteTagValues{})).Get(s.indexAutoCompleteTagValues).Post(s.indexAutoCompleteTagValues)
+	r.Combo("/index/tags/delSeries", admin, ready, peerDeadline, bind(models.IndexTagDelSeries{})).Get(s.indexTagDelSeries).Post(s.indexTagDelSeries)
 
-	r.Combo("/ccache/delete", bind(models.CCacheDelete{})).Post(s.ccacheDelete).Get(s.ccacheDelete)
+	r.Combo("/ccache/delete", admin, bind(models.CCacheDelete{})).Post(s.ccacheDelete).Get(s.ccacheDelete)
+	r.Post("/rules/reload", admin, s.reloadRules)
+	r.Get("/recording-rules", admin, s.recordingRules)
+	r.Get("/alerting-rules", admin, s.alertingRules)
 
 	r.Options("/*", func(ctx *macaron.Context) {
 		ctx.Write(nil)
 	})
 
-	r.Combo("/showplan", cBody, withOrg, ready, bind(models.GraphiteRender{})).Get(s.showPlan).Post(s.showPlan)
+	r.Combo("/showplan", cBody, read, withOrg, ready, bind(models.GraphiteRender{})).Get(s.showPlan).Post(s.showPlan)
 
 	// Graphite endpoints
-	r.Combo("/render", cBody, withOrg, ready, bind(models.GraphiteRender{})).Get(s.renderMetrics).Post(s.renderMetrics)
-	r.Combo("/metrics/find", withOrg, ready, bind(models.GraphiteFind{})).Get(s.metricsFind).Post(s.metricsFind)
-	r.Get("/metrics/index.json", withOrg, ready, s.metricsIndex)
-	r.Post("/metrics/delete", withOrg, ready, bind(models.MetricsDelete{}), s.metricsDelete)
-	r.Combo("/tags/findSeries", withOrg, ready, bind(models.GraphiteTagFindSeries{})).Get(s.graphiteTagFindSeries).Post(s.graphiteTagFindSeries)
-	r.Combo("/tags", withOrg, ready, bind(models.GraphiteTags{})).Get(s.graphiteTags).Post(s.graphiteTags)
-	r.Combo("/tags/:tag([0-9a-zA-Z]+)", withOrg, ready, bind(models.GraphiteTagDetails{})).Get(s.graphiteTagDetails).Post(s.graphiteTagDetails)
-	r.Combo("/tags/autoComplete/tags", withOrg, ready, bind(models.GraphiteAutoCompleteTags{})).Get(s.graphiteAutoCompleteTags).Post(s.graphiteAutoCompleteTags)
-	r.Combo("/tags/autoComplete/values", withOrg, ready, bind(models.GraphiteAutoCompleteTagValues{})).Get(s.graphiteAutoCompleteTagValues).Post(s.graphiteAutoCompleteTagValues)
-	r.Post("/tags/delSeries", withOrg, ready, bind(models.GraphiteTagDelSeries{}), s.graphiteTagDelSeries)
-	r.Combo("/functions", withOrg).Get(s.graphiteFunctions).Post(s.graphiteFunctions)
-	r.Combo("/functions/:func(.+)", withOrg).Get(s.graphiteFunctions).Post(s.graphiteFunctions)
+	r.Combo("/render", cBody, read, withOrg, ready, limitQueries, deadline, schedule, bind(models.GraphiteRender{})).Get(s.renderMetrics).Post(s.renderMetrics)
+	r.Combo("/metrics/find", read, withOrg, ready, bind(models.GraphiteFind{})).Get(s.metricsFind).Post(s.metricsFind)
+	r.Get("/metrics/index.json", read, withOrg, ready, s.metricsIndex)
+	r.Post("/metrics/delete", write, withOrg, ready, bind(models.MetricsDelete{}), s.metricsDelete)
+	r.Combo("/tags/findSeries", read, withOrg, ready, bind(models.GraphiteTagFindSeries{})).Get(s.graphiteTagFindSeries).Post(s.graphiteTagFindSeries)
+	r.Combo("/tags", read, withOrg, ready, bind(models.GraphiteTags{})).Get(s.graphiteTags).Post(s.graphiteTags)
+	r.Combo("/tags/:tag([0-9a-zA-Z]+)", read, withOrg, ready, bind(models.GraphiteTagDetails{})).Get(s.graphiteTagDetails).Post(s.graphiteTagDetails)
+	r.Combo("/tags/autoComplete/tags", read, withOrg, ready, bind(models.GraphiteAutoCompleteTags{})).Get(s.graphiteAutoCompleteTags).Post(s.graphiteAutoCompleteTags)
+	r.Combo("/tags/autoComplete/values", read, withOrg, ready, bind(models.GraphiteAutoCompleteTagValues{})).Get(s.graphiteAutoCompleteTagValues).Post(s.graphiteAutoCompleteTagValues)
+	r.Post("/tags/delSeries", write, withOrg, ready, bind(models.GraphiteTagDelSeries{}), s.graphiteTagDelSeries)
+	r.Combo("/functions", read, withOrg).Get(s.graphiteFunctions).Post(s.graphiteFunctions)
+	r.Combo("/functions/:func(.+)", read, withOrg).Get(s.graphiteFunctions).Post(s.graphiteFunctions)
 
 	// Meta Tags
-	r.Post("/metaTags/upsert", withOrg, ready, bind(models.MetaTagRecordUpsert{}), s.metaTagRecordUpsert)
-	r.Post("/metaTags/swap", withOrg, ready, bind(models.MetaTagRecordSwap{}), s.metaTagRecordSwap)
-	r.Get("/metaTags", withOrg, ready, s.getMetaTagRecords)
+	r.Post("/metaTags/upsert", write, withOrg, ready, bind(models.MetaTagRecordUpsert{}), s.metaTagRecordUpsert)
+	r.Post("/metaTags/swap", write, withOrg, ready, bind(models.MetaTagRecordSwap{}), s.metaTagRecordSwap)
+	r.Get("/metaTags", read, withOrg, ready, s.getMetaTagRecords)
 
 	// Prometheus endpoints
-	r.Combo("/prometheus/api/v1/query_range", cBody, withOrg, ready, form(models.PrometheusRangeQuery{})).Get(s.prometheusQueryRange).Post(s.prometheusQueryRange)
-	r.Combo("/prometheus/api/v1/query", cBody, withOrg, ready, form(models.PrometheusQueryInstant{})).Get(s.prometheusQueryInstant).Post(s.prometheusQueryInstant)
-	r.Combo("/prometheus/api/v1/series", cBody, withOrg, ready, form(models.PrometheusSeriesQuery{})).Get(s.prometheusQuerySeries).Post(s.prometheusQuerySeries)
-	r.Get("/prometheus/api/v1/label/:name/values", cBody, withOrg, ready, s.prometheusLabelValues)
+	r.Combo("/prometheus/api/v1/query_range", cBody, read, withOrg, ready, limitQueries, deadline, schedule, form(models.PrometheusRangeQuery{})).Get(s.prometheusQueryRange).Post(s.prometheusQueryRange)
+	r.Combo("/prometheus/api/v1/query", cBody, read, withOrg, ready, limitQueries, deadline, schedule, form(models.PrometheusQueryInstant{})).Get(s.prometheusQueryInstant).Post(s.prometheusQueryInstant)
+	r.Combo("/prometheus/api/v1/series", cBody, read, withOrg, ready, form(models.PrometheusSeriesQuery{})).Get(s.prometheusQuerySeries).Post(s.prometheusQuerySeries)
+	r.Get("/prometheus/api/v1/label/:name/values", cBody, read, withOrg, ready, s.prometheusLabelValues)
+	r.Combo("/prometheus/api/v1/labels", cBody, read, withOrg, ready, form(models.PrometheusLabelsQuery{})).Get(s.prometheusLabels).Post(s.prometheusLabels)
+	r.Get("/prometheus/api/v1/metadata", cBody, read, withOrg, ready, form(models.PrometheusMetadataQuery{}), s.prometheusMetadata)
+	r.Post("/prometheus/api/v1/read", read, withOrg, ready, limitQueries, deadline, schedule, s.prometheusRead)
 	r.Get("/prometheus/metrics", promhttp.Handler())
 }
@@ -0,0 +1,68 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/grafana/metrictank/alerting"
+	"github.com/grafana/metrictank/api/middleware"
+	"github.com/grafana/metrictank/api/models"
+	"github.com/grafana/metrictank/api/response"
+	"github.com/grafana/metrictank/idx/memory"
+	"github.com/grafana/metrictank/mdata"
+	"github.com/grafana/metrictank/recording"
+	log "github.com/sirupsen/logrus"
+)
+
+var errNoMemoryIndex = errors.New("rules can only be reloaded on nodes with a memory based index")
+
+// ReloadRules re-reads the storage-schemas, storage-aggregation and index-rules files.
+// if any of them is invalid, or the schemas need ttls the store wasn't set up for, nothing changes. otherwise the new rules are installed,
+// the archives in the index are re-evaluated against them, and the AggMetrics switch over
+// to their new settings as described at mdata.AggMetrics.Reconfigure.
+// It returns the number of archives of which any of the ids changed.
+func (s *Server) ReloadRules() (int, error) {
+	index, ok := s.MetricIndex.(memory.MemoryIndex)
+	if !ok {
+		return 0, errNoMemoryIndex
+	}
+	schemas, aggregations, err := mdata.ReadRules()
+	if err != nil {
+		return 0, err
+	}
+	if err := mdata.CheckReloadedSchemas(schemas); err != nil {
+		return 0, err
+	}
+	indexRules, err := memory.ReadIndexRules()
+	if err != nil {
+		return 0, err
+	}
+
+	changed := index.UpdateRules(func() {
+		mdata.SetSchemas(schemas)
+		mdata.SetAggregations(aggregations)
+		memory.SetIndexRules(indexRules)
+	})
+	if s.MemoryStore != nil {
+		s.MemoryStore.Reconfigure()
+	}
+	log.Infof("API: reloaded rules. %d archives changed", changed)
+	return changed, nil
+}
+
+func (s *Server) reloadRules(ctx *middleware.Context) {
+	changed, err := s.ReloadRules()
+	if err != nil {
+		response.Write(ctx, response.NewError(http.StatusBadRequest, err.Error()))
+		return
+	}
+	response.Write(ctx, response.NewJson(http.StatusOK, models.RulesReloadResp{ArchivesChanged: changed}, ""))
+}
+
+func (s *Server) recordingRules(ctx *middleware.Context) {
+	response.Write(ctx, response.NewJson(http.StatusOK, recording.Status(), ""))
+}
+
+func (s *Server) alertingRules(ctx *middleware.Context) {
+	response.Write(ctx, response.NewJson(http.StatusOK, alerting.Status(), ""))
+}
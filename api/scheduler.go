@@ -0,0 +1,225 @@
+package api
+
+import (
+	"container/list"
+	"context"
+	"errors"
+	"net/http"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/grafana/metrictank/api/middleware"
+	"github.com/grafana/metrictank/api/response"
+	"github.com/grafana/metrictank/stats"
+	"gopkg.in/macaron.v1"
+)
+
+// queryClass is the class of traffic a query belongs to. each class has its own queue
+type queryClass int
+
+const (
+	classInteractive queryClass = iota // e.g. dashboards being looked at. always gets priority
+	classBulk                          // e.g. reporting or batch jobs, which can wait
+	numQueryClasses
+)
+
+func (c queryClass) String() string {
+	if c == classBulk {
+		return "bulk"
+	}
+	return "interactive"
+}
+
+var (
+	errQueueFull    = errors.New("query queue is full")
+	errQueueTimeout = errors.New("timed out waiting in the query queue")
+)
+
+// schedulerStats are the stats of one of the queues of the scheduler
+type schedulerStats struct {
+	queued   *stats.Gauge32
+	running  *stats.Gauge32
+	wait     *stats.LatencyHistogram15s32
+	rejected *stats.Counter32
+}
+
+func newSchedulerStats(class queryClass) schedulerStats {
+	return schedulerStats{
+		// metric api.scheduler.interactive.queued is the number of interactive queries waiting for a query slot
+		// metric api.scheduler.bulk.queued is the number of bulk queries waiting for a query slot
+		queued: stats.NewGauge32("api.scheduler." + class.String() + ".queued"),
+		// metric api.scheduler.interactive.running is the number of interactive queries holding a query slot
+		// metric api.scheduler.bulk.running is the number of bulk queries holding a query slot
+		running: stats.NewGauge32("api.scheduler." + class.String() + ".running"),
+		// metric api.scheduler.interactive.wait is how long interactive queries waited for a query slot
+		// metric api.scheduler.bulk.wait is how long bulk queries waited for a query slot
+		wait: stats.NewLatencyHistogram15s32("api.scheduler." + class.String() + ".wait"),
+		// metric api.scheduler.interactive.rejected is the number of interactive queries rejected because the queue was full or they waited too long
+		// metric api.scheduler.bulk.rejected is the number of bulk queries rejected because the queue was full or they waited too long
+		rejected: stats.NewCounter32("api.scheduler." + class.String() + ".rejected"),
+	}
+}
+
+// queryScheduler bounds how many queries are executed concurrently.
+// queries that can't get one of the slots right away wait in the queue of their class.
+// whenever a slot frees up, it goes to the interactive queue first, and only then to the bulk queue.
+// a nil *queryScheduler does not limit anything.
+type queryScheduler struct {
+	sync.Mutex
+	slots     int // how many queries may run concurrently
+	bulkSlots int // how many of the slots bulk queries may use
+	maxQueued int // how many queries may wait in each queue
+	timeout   time.Duration
+
+	running [numQueryClasses]int
+	queues  [numQueryClasses]*list.List // of chan struct{}, closed when the query gets its slot
+	stats   [numQueryClasses]schedulerStats
+}
+
+// newQueryScheduler creates a new query scheduler. it returns nil, which does not limit anything, when slots is 0
+func newQueryScheduler(slots, bulkSlots, maxQueued int, timeout time.Duration) *queryScheduler {
+	if slots == 0 {
+		return nil
+	}
+	if bulkSlots == 0 || bulkSlots > slots {
+		bulkSlots = slots
+	}
+	q := &queryScheduler{
+		slots:     slots,
+		bulkSlots: bulkSlots,
+		maxQueued: maxQueued,
+		timeout:   timeout,
+	}
+	for c := queryClass(0); c < numQueryClasses; c++ {
+		q.queues[c] = list.New()
+		q.stats[c] = newSchedulerStats(c)
+	}
+	return q
+}
+
+// canRun returns whether a query of the given class can get a slot right now. the lock must be held
+func (q *queryScheduler) canRun(class queryClass) bool {
+	if q.running[classInteractive]+q.running[classBulk] >= q.slots {
+		return false
+	}
+	return class != classBulk || q.running[classBulk] < q.bulkSlots
+}
+
+// start marks a query of the given class as running. the lock must be held
+func (q *queryScheduler) start(class queryClass) {
+	q.running[class]++
+	q.stats[class].running.Set(q.running[class])
+}
+
+// acquire waits until the query gets a slot. if it returns nil, the caller must call release once the query is done.
+func (q *queryScheduler) acquire(ctx context.Context, class queryClass) error {
+	if q == nil {
+		return nil
+	}
+	pre := time.Now()
+	q.Lock()
+	queue := q.queues[class]
+	if queue.Len() == 0 && q.canRun(class) {
+		q.start(class)
+		q.Unlock()
+		q.stats[class].wait.Value(0)
+		return nil
+	}
+	if queue.Len() >= q.maxQueued {
+		q.Unlock()
+		q.stats[class].rejected.Inc()
+		return errQueueFull
+	}
+	ready := make(chan struct{})
+	elem := queue.PushBack(ready)
+	q.stats[class].queued.Set(queue.Len())
+	q.Unlock()
+
+	timer := time.NewTimer(q.timeout)
+	defer timer.Stop()
+	var err error
+	select {
+	case <-ready:
+		q.stats[class].wait.Value(time.Since(pre))
+		return nil
+	case <-timer.C:
+		err = errQueueTimeout
+	case <-ctx.Done():
+		err = ctx.Err()
+	}
+
+	q.Lock()
+	defer q.Unlock()
+	select {
+	case <-ready:
+		// we got a slot while giving up. hand it to the next in line
+		q.running[class]--
+		q.stats[class].running.Set(q.running[class])
+		q.dispatch()
+	default:
+		queue.Remove(elem)
+		q.stats[class].queued.Set(queue.Len())
+	}
+	q.stats[class].rejected.Inc()
+	return err
+}
+
+// release frees the slot of a query that completed
+func (q *queryScheduler) release(class queryClass) {
+	if q == nil {
+		return
+	}
+	q.Lock()
+	q.running[class]--
+	q.stats[class].running.Set(q.running[class])
+	q.dispatch()
+	q.Unlock()
+}
+
+// dispatch hands out the free slots to the waiting queries. the lock must be held
+func (q *queryScheduler) dispatch() {
+	for class := classInteractive; class < numQueryClasses; class++ {
+		queue := q.queues[class]
+		for queue.Len() > 0 && q.canRun(class) {
+			ready := queue.Remove(queue.Front()).(chan struct{})
+			q.start(class)
+			close(ready)
+		}
+		q.stats[class].queued.Set(queue.Len())
+	}
+}
+
+// classifyQuery returns the class of the request, based on the X-Query-Class header if set,
+// otherwise based on its user agent
+func classifyQuery(req *http.Request, bulkUserAgents *regexp.Regexp) queryClass {
+	switch strings.ToLower(req.Header.Get("X-Query-Class")) {
+	case "bulk":
+		return classBulk
+	case "interactive":
+		return classInteractive
+	}
+	if bulkUserAgents != nil && bulkUserAgents.MatchString(req.UserAgent()) {
+		return classBulk
+	}
+	return classInteractive
+}
+
+// scheduleQuery returns a handler that makes queries wait for a slot of the query scheduler
+func (s *Server) scheduleQuery() macaron.Handler {
+	return func(c *middleware.Context) {
+		class := classifyQuery(c.Req.Request, bulkUserAgents)
+		if err := s.scheduler.acquire(c.Req.Context(), class); err != nil {
+			if err == errQueueFull || err == errQueueTimeout {
+				c.PlainText(http.StatusServiceUnavailable, []byte(err.Error()+" ("+class.String()+"). the server is overloaded, try again later."))
+			} else {
+				// the deadline of the query passed, or the client went away, while it was queued
+				response.Write(c, response.ContextErr(err))
+			}
+			return
+		}
+		defer s.scheduler.release(class)
+		c.Next()
+	}
+}
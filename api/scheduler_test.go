@@ -0,0 +1,154 @@
+package api
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/grafana/metrictank/api/middleware"
+	"gopkg.in/macaron.v1"
+)
+
+// waitQueued waits until the given number of queries wait in the queue of the class
+func waitQueued(t *testing.T, q *queryScheduler, class queryClass, num int) {
+	t.Helper()
+	for i := 0; i < 1000; i++ {
+		q.Lock()
+		l := q.queues[class].Len()
+		q.Unlock()
+		if l == num {
+			return
+		}
+		time.Sleep(time.Millisecond)
+	}
+	t.Fatalf("timed out waiting for %d queued %s queries", num, class)
+}
+
+func TestQuerySchedulerPriority(t *testing.T) {
+	q := newQueryScheduler(1, 0, 10, time.Minute)
+	if err := q.acquire(context.Background(), classBulk); err != nil {
+		t.Fatalf("expected free slot, got %s", err)
+	}
+
+	order := make(chan queryClass, 2)
+	for _, class := range []queryClass{classBulk, classInteractive} {
+		go func(class queryClass) {
+			if err := q.acquire(context.Background(), class); err != nil {
+				t.Errorf("unexpected error %s", err)
+			}
+			order <- class
+			q.release(class)
+		}(class)
+		waitQueued(t, q, class, 1)
+	}
+
+	// the interactive query arrived last, but should run first
+	q.release(classBulk)
+	if class := <-order; class != classInteractive {
+		t.Fatalf("expected the interactive query to run first, got %s", class)
+	}
+	if class := <-order; class != classBulk {
+		t.Fatalf("expected the bulk query to run second, got %s", class)
+	}
+}
+
+func TestQuerySchedulerBulkSlots(t *testing.T) {
+	q := newQueryScheduler(2, 1, 10, 10*time.Millisecond)
+	if err := q.acquire(context.Background(), classBulk); err != nil {
+		t.Fatalf("expected free slot, got %s", err)
+	}
+	// the other slot is reserved for interactive queries
+	if err := q.acquire(context.Background(), classBulk); err != errQueueTimeout {
+		t.Fatalf("expected %q, got %v", errQueueTimeout, err)
+	}
+	if err := q.acquire(context.Background(), classInteractive); err != nil {
+		t.Fatalf("expected free slot, got %s", err)
+	}
+	if err := q.acquire(context.Background(), classInteractive); err != errQueueTimeout {
+		t.Fatalf("expected %q, got %v", errQueueTimeout, err)
+	}
+}
+
+func TestQuerySchedulerQueueFull(t *testing.T) {
+	q := newQueryScheduler(1, 0, 1, time.Minute)
+	if err := q.acquire(context.Background(), classInteractive); err != nil {
+		t.Fatalf("expected free slot, got %s", err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() {
+		done <- q.acquire(ctx, classInteractive)
+	}()
+	waitQueued(t, q, classInteractive, 1)
+	if err := q.acquire(context.Background(), classInteractive); err != errQueueFull {
+		t.Fatalf("expected %q, got %v", errQueueFull, err)
+	}
+
+	// a canceled query leaves the queue
+	cancel()
+	if err := <-done; err != context.Canceled {
+		t.Fatalf("expected %q, got %v", context.Canceled, err)
+	}
+	waitQueued(t, q, classInteractive, 0)
+	q.release(classInteractive)
+	if q.running[classInteractive] != 0 {
+		t.Fatalf("expected no running queries, got %d", q.running[classInteractive])
+	}
+}
+
+func TestScheduleQueryDeadline(t *testing.T) {
+	s := &Server{scheduler: newQueryScheduler(1, 0, 10, time.Minute)}
+	m := macaron.New()
+	m.Use(macaron.Renderer())
+	m.Use(middleware.OrgMiddleware(false))
+	m.Get("/render", middleware.Deadline(20*time.Millisecond), s.scheduleQuery(), func(c *middleware.Context) {
+		c.PlainText(200, []byte("ok"))
+	})
+
+	// hold the only slot, so the query has to wait until its deadline passes
+	if err := s.scheduler.acquire(context.Background(), classInteractive); err != nil {
+		t.Fatalf("expected free slot, got %s", err)
+	}
+	defer s.scheduler.release(classInteractive)
+
+	w := httptest.NewRecorder()
+	m.ServeHTTP(w, httptest.NewRequest("GET", "/render", nil))
+	if w.Code != http.StatusGatewayTimeout {
+		t.Fatalf("expected code %d, got %d: %s", http.StatusGatewayTimeout, w.Code, w.Body.String())
+	}
+}
+
+func TestQuerySchedulerDisabled(t *testing.T) {
+	q := newQueryScheduler(0, 0, 0, time.Minute)
+	for i := 0; i < 10; i++ {
+		if err := q.acquire(context.Background(), classInteractive); err != nil {
+			t.Fatalf("expected disabled scheduler to admit all queries, got %s", err)
+		}
+	}
+	q.release(classInteractive)
+}
+
+func TestClassifyQuery(t *testing.T) {
+	bulk := regexp.MustCompile("^report-generator")
+	cases := []struct {
+		header    string
+		userAgent string
+		exp       queryClass
+	}{
+		{"", "Grafana/6.7", classInteractive},
+		{"", "report-generator/1.0", classBulk},
+		{"Bulk", "Grafana/6.7", classBulk},
+		{"interactive", "report-generator/1.0", classInteractive},
+	}
+	for i, c := range cases {
+		req, _ := http.NewRequest("GET", "/render", nil)
+		req.Header.Set("X-Query-Class", c.header)
+		req.Header.Set("User-Agent", c.userAgent)
+		if class := classifyQuery(req, bulk); class != c.exp {
+			t.Fatalf("case %d: expected %s, got %s", i, c.exp, class)
+		}
+	}
+}
@@ -0,0 +1,214 @@
+package api
+
+import (
+	"container/list"
+	"sync"
+	"time"
+
+	"github.com/grafana/metrictank/api/models"
+	"github.com/grafana/metrictank/consolidation"
+	"github.com/grafana/metrictank/idx"
+	"github.com/grafana/metrictank/schema"
+	"github.com/grafana/metrictank/stats"
+)
+
+var (
+	// metric api.series_cache.hit-full is how many series were served entirely from the series cache
+	seriesCacheHitFull = stats.NewCounterRate32("api.series_cache.hit-full")
+
+	// metric api.series_cache.hit-partial is how many series were served partially from the series cache, with only the tail fetched
+	seriesCacheHitPartial = stats.NewCounterRate32("api.series_cache.hit-partial")
+
+	// metric api.series_cache.miss is how many series could not be served from the series cache at all
+	seriesCacheMiss = stats.NewCounterRate32("api.series_cache.miss")
+
+	// metric api.series_cache.evict is how many series were evicted from the series cache because it was full
+	seriesCacheEvict = stats.NewCounter32("api.series_cache.evict")
+
+	// metric api.series_cache.invalidate is how many series were removed from the series cache because they were deleted
+	seriesCacheInvalidate = stats.NewCounter32("api.series_cache.invalidate")
+
+	// metric api.series_cache.size is the size in bytes of the points in the series cache
+	seriesCacheSize = stats.NewGauge64("api.series_cache.size")
+)
+
+// the in-memory size of a schema.Point, including padding
+const pointSize = 16
+
+// seriesCacheVariant identifies one of the ways the data of a series can be fetched.
+// together with the MKey, which includes the org, it determines the points of the output,
+// whatever the target, from/to or maxDataPoints of the render request that led to it.
+type seriesCacheVariant struct {
+	archive      uint8
+	archInterval uint32
+	outInterval  uint32
+	aggNum       uint32
+	consolidator consolidation.Consolidator
+}
+
+type seriesCacheEntry struct {
+	key     schema.MKey
+	variant seriesCacheVariant
+
+	// the points cover [from, to), in canonical form: one point for every multiple of outInterval
+	from   uint32
+	to     uint32
+	points []schema.Point
+
+	// points with a timestamp >= stableTo were too recent to be final when they were fetched,
+	// e.g. because more data may still come in for them, so they can not be reused.
+	stableTo uint32
+}
+
+// seriesCache caches the output of getTarget, so that repeated render requests (typically dashboards
+// that refresh every few seconds with a sliding time window) don't have to fetch, decode and
+// normalize all the data again: the part of the window that overlaps with the previous request is
+// served from the cache, and only the new tail is fetched.
+// a nil *seriesCache is a disabled cache, on which all methods can be called.
+type seriesCache struct {
+	sync.Mutex
+	maxSize        uint64
+	unstableWindow uint32
+	size           uint64
+
+	// the least recently used entry is at the back
+	lru    *list.List
+	series map[schema.MKey]map[seriesCacheVariant]*list.Element
+}
+
+// newSeriesCache creates a new series cache. it returns nil, the disabled cache, when maxSize is 0
+func newSeriesCache(maxSize uint64, unstableWindow time.Duration) *seriesCache {
+	if maxSize == 0 {
+		return nil
+	}
+	return &seriesCache{
+		maxSize:        maxSize,
+		unstableWindow: uint32(unstableWindow.Seconds()),
+		lru:            list.New(),
+		series:         make(map[schema.MKey]map[seriesCacheVariant]*list.Element),
+	}
+}
+
+func newSeriesCacheVariant(req models.Req) seriesCacheVariant {
+	return seriesCacheVariant{
+		archive:      req.Archive,
+		archInterval: req.ArchInterval,
+		outInterval:  req.OutInterval,
+		aggNum:       req.AggNum,
+		consolidator: req.Consolidator,
+	}
+}
+
+// get returns the cached points for the request, and the timestamp from which on the
+// points still need to be fetched. if the returned timestamp is >= req.To, all points
+// are served from the cache. the returned slice is from the pointSlicePool.
+func (c *seriesCache) get(req models.Req) ([]schema.Point, uint32) {
+	if c == nil {
+		return nil, req.From
+	}
+	c.Lock()
+	defer c.Unlock()
+	elem, ok := c.series[req.MKey][newSeriesCacheVariant(req)]
+	if !ok {
+		seriesCacheMiss.Inc()
+		return nil, req.From
+	}
+	e := elem.Value.(*seriesCacheEntry)
+
+	// we can only serve the start of the requested range
+	reuseTo := req.To
+	if e.to < reuseTo {
+		reuseTo = e.to
+	}
+	if e.stableTo < reuseTo {
+		reuseTo = e.stableTo
+	}
+	if e.from > req.From || reuseTo <= req.From {
+		seriesCacheMiss.Inc()
+		return nil, req.From
+	}
+
+	points := pointSlicePool.Get().([]schema.Point)
+	for _, p := range e.points {
+		if p.Ts >= reuseTo {
+			break
+		}
+		if p.Ts >= req.From {
+			points = append(points, p)
+		}
+	}
+	c.lru.MoveToFront(elem)
+	if reuseTo >= req.To {
+		seriesCacheHitFull.Inc()
+	} else {
+		seriesCacheHitPartial.Inc()
+	}
+	return points, reuseTo
+}
+
+// add caches the points for the request, as fetched at the given time.
+// points that are already cached, but that are not covered by the request, are dropped.
+func (c *seriesCache) add(req models.Req, points []schema.Point, now time.Time) {
+	if c == nil {
+		return
+	}
+	size := uint64(len(points)) * pointSize
+	if size > c.maxSize {
+		return
+	}
+
+	e := &seriesCacheEntry{
+		key:      req.MKey,
+		variant:  newSeriesCacheVariant(req),
+		from:     req.From,
+		to:       req.To,
+		points:   make([]schema.Point, len(points)),
+		stableTo: uint32(now.Unix()) - c.unstableWindow,
+	}
+	copy(e.points, points)
+
+	c.Lock()
+	defer c.Unlock()
+	if elem, ok := c.series[e.key][e.variant]; ok {
+		c.remove(elem)
+	}
+	variants, ok := c.series[e.key]
+	if !ok {
+		variants = make(map[seriesCacheVariant]*list.Element)
+		c.series[e.key] = variants
+	}
+	variants[e.variant] = c.lru.PushFront(e)
+	c.size += size
+
+	for c.size > c.maxSize {
+		c.remove(c.lru.Back())
+		seriesCacheEvict.Inc()
+	}
+	seriesCacheSize.SetUint64(c.size)
+}
+
+// invalidate removes all cached data of the given series
+func (c *seriesCache) invalidate(defs []idx.Archive) {
+	if c == nil {
+		return
+	}
+	c.Lock()
+	defer c.Unlock()
+	for _, def := range defs {
+		for _, elem := range c.series[def.Id] {
+			c.remove(elem)
+			seriesCacheInvalidate.Inc()
+		}
+	}
+	seriesCacheSize.SetUint64(c.size)
+}
+
+func (c *seriesCache) remove(elem *list.Element) {
+	e := c.lru.Remove(elem).(*seriesCacheEntry)
+	c.size -= uint64(len(e.points)) * pointSize
+	variants := c.series[e.key]
+	delete(variants, e.variant)
+	if len(variants) == 0 {
+		delete(c.series, e.key)
+	}
+}
@@ -0,0 +1,137 @@
+package api
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/grafana/metrictank/api/models"
+	"github.com/grafana/metrictank/cluster"
+	"github.com/grafana/metrictank/conf"
+	"github.com/grafana/metrictank/consolidation"
+	"github.com/grafana/metrictank/idx"
+	"github.com/grafana/metrictank/mdata"
+	"github.com/grafana/metrictank/mdata/cache"
+	"github.com/grafana/metrictank/schema"
+	"github.com/grafana/metrictank/test"
+)
+
+// TestSeriesCacheSlidingWindow checks that the output of getTarget with the series cache
+// is the same as without, for a window that slides forward while new data comes in
+func TestSeriesCacheSlidingWindow(t *testing.T) {
+	cluster.Init("default", "test", time.Now(), "http", 6060)
+	store := mdata.NewMockStore()
+	store.Drop = true
+
+	mdata.SetSingleAgg(conf.Avg)
+	mdata.SetSingleSchema(conf.MustParseRetentions("10s:100000s:10min:10:true"))
+
+	cache := cache.NewCCache()
+	metrics := mdata.NewAggMetrics(store, cache, false, nil, 0, 0, 0)
+	srv, _ := NewServer()
+	srv.BindBackendStore(store)
+	srv.BindMemoryStore(metrics)
+	srv.BindCache(cache)
+	srv.seriesCache = newSeriesCache(1024*1024, time.Minute)
+
+	for _, outInterval := range []uint32{10, 30} {
+		id := test.GetMKey(int(outInterval))
+		metric := metrics.GetOrCreate(id, 0, 0, 10)
+		var last uint32
+		for window := uint32(0); window < 5; window++ {
+			// more data comes in, and the window slides forward
+			for ; last < 300+window*100; last += 10 {
+				metric.Add(last+10, float64(last))
+			}
+			req := reqOut(id, 11+window*100, last+1, 1000, 10, consolidation.Avg, 0, 0, 0, 10, 0, outInterval, outInterval/10)
+
+			got, err := srv.getTarget(test.NewContext(), &models.StorageStats{}, req)
+			if err != nil {
+				t.Fatalf("outInterval %d window %d: unexpected error %s", outInterval, window, err)
+			}
+			exp, err := srv.fetchTarget(test.NewContext(), &models.StorageStats{}, req)
+			if err != nil {
+				t.Fatalf("outInterval %d window %d: unexpected error %s", outInterval, window, err)
+			}
+			if !reflect.DeepEqual(exp, got) {
+				t.Fatalf("outInterval %d window %d: expected %v, got %v", outInterval, window, exp, got)
+			}
+		}
+	}
+}
+
+func TestSeriesCacheGet(t *testing.T) {
+	key := test.GetMKey(1)
+	req := reqOut(key, 101, 201, 1000, 10, consolidation.Avg, 0, 0, 0, 10, 0, 10, 1)
+	var points []schema.Point
+	for ts := uint32(110); ts <= 200; ts += 10 {
+		points = append(points, schema.Point{Val: float64(ts), Ts: ts})
+	}
+
+	c := newSeriesCache(1024, time.Minute)
+	c.add(req, points, time.Unix(300, 0))
+
+	cases := []struct {
+		from, to  uint32
+		expPoints []schema.Point
+		expFetch  uint32
+	}{
+		{101, 201, points, 201},      // same request
+		{151, 191, points[5:9], 191}, // inside
+		{151, 301, points[5:], 201},  // window slid forward, only fetch the tail
+		{51, 201, nil, 51},           // the start is not cached
+		{201, 301, nil, 201},         // nothing overlaps
+	}
+	for i, c2 := range cases {
+		req := reqOut(key, c2.from, c2.to, 1000, 10, consolidation.Avg, 0, 0, 0, 10, 0, 10, 1)
+		got, fetch := c.get(req)
+		if fetch != c2.expFetch || len(got) != len(c2.expPoints) || (len(got) > 0 && !reflect.DeepEqual(got, c2.expPoints)) {
+			t.Fatalf("case %d: expected %v and fetch from %d, got %v and fetch from %d", i, c2.expPoints, c2.expFetch, got, fetch)
+		}
+	}
+
+	// a different variant of the same series is not a hit
+	other := reqOut(key, 101, 201, 1000, 10, consolidation.Avg, 0, 0, 0, 10, 0, 20, 2)
+	if _, fetch := c.get(other); fetch != 101 {
+		t.Fatalf("expected a miss for another output interval, got fetch from %d", fetch)
+	}
+
+	// points that were too recent when they got fetched, must be fetched again
+	c.add(req, points, time.Unix(210, 0))
+	got, fetch := c.get(req)
+	if fetch != 150 || !reflect.DeepEqual(got, points[:4]) {
+		t.Fatalf("expected points until 150, got %v and fetch from %d", got, fetch)
+	}
+
+	c.invalidate([]idx.Archive{{MetricDefinition: schema.MetricDefinition{Id: key}}})
+	if _, fetch := c.get(req); fetch != 101 {
+		t.Fatalf("expected a miss after invalidation, got fetch from %d", fetch)
+	}
+	if c.size != 0 || c.lru.Len() != 0 || len(c.series) != 0 {
+		t.Fatalf("expected empty cache after invalidation, got size %d, %d entries and %d series", c.size, c.lru.Len(), len(c.series))
+	}
+}
+
+func TestSeriesCacheEvict(t *testing.T) {
+	points := make([]schema.Point, 4)
+	c := newSeriesCache(3*4*pointSize, time.Minute)
+	for i := 1; i <= 4; i++ {
+		c.add(reqOut(test.GetMKey(i), 1, 41, 1000, 10, consolidation.Avg, 0, 0, 0, 10, 0, 10, 1), points, time.Now())
+	}
+	if c.size != 3*4*pointSize || c.lru.Len() != 3 {
+		t.Fatalf("expected 3 entries, got %d with size %d", c.lru.Len(), c.size)
+	}
+	if _, ok := c.series[test.GetMKey(1)]; ok {
+		t.Fatalf("expected the least recently used series to be evicted")
+	}
+}
+
+func TestSeriesCacheDisabled(t *testing.T) {
+	c := newSeriesCache(0, time.Minute)
+	req := reqOut(test.GetMKey(1), 101, 201, 1000, 10, consolidation.Avg, 0, 0, 0, 10, 0, 10, 1)
+	c.add(req, []schema.Point{{Val: 1, Ts: 110}}, time.Now())
+	if _, fetch := c.get(req); fetch != 101 {
+		t.Fatalf("expected the disabled cache to miss, got fetch from %d", fetch)
+	}
+	c.invalidate(nil)
+}
@@ -23,6 +23,7 @@ var (
 	minAvailableShards int
 	gcPercent          int
 	gcPercentNotReady  int
+	peerAuthKey        string
 	GossipSettlePeriod time.Duration // if gossip not enabled, will be 0 regardless of config
 
 	gossipSettlePeriodStr string
@@ -78,6 +79,7 @@ func ConfigSetup() {
 	clusterCfg.IntVar(&maxPrio, "max-priority", 10, "maximum priority before a node should be considered not-ready.")
 	clusterCfg.IntVar(&minAvailableShards, "min-available-shards", 0, "minimum number of shards that must be available for a query to be handled.")
 	clusterCfg.IntVar(&gcPercentNotReady, "gc-percent-not-ready", gcPercent, "GOGC value to use when node is not ready.  Defaults to GOGC")
+	clusterCfg.StringVar(&peerAuthKey, "peer-auth-key", "", "api key to authenticate with against cluster peers. must be an admin key of the peers' http auth-keys-file. only needed when authentication is enabled")
 	clusterCfg.StringVar(&gossipSettlePeriodStr, "gossip-settle-period", "10s", "duration until when the cluster topology can be considered up-to-date and this node to be ready to serve requests (when gossip enabled).")
 	globalconf.Register("cluster", clusterCfg, flag.ExitOnError)
 
@@ -49,6 +49,9 @@ var (
 	unmarshalErrJoin = stats.NewCounter32("cluster.decode_err.join")
 	// metric cluster.decode_err.update is a counter of json unmarshal errors
 	unmarshalErrUpdate = stats.NewCounter32("cluster.decode_err.update")
+
+	// metric cluster.requests.canceled is how many requests to peers were aborted because the query was canceled or exceeded its deadline
+	requestsCanceled = stats.NewCounter32("cluster.requests.canceled")
 )
 
 type ClusterManager interface {
@@ -21,6 +21,10 @@ import (
 	log "github.com/sirupsen/logrus"
 )
 
+// DeadlineHeader is the header by which the deadline of a query is propagated to the peers
+// the query fans out to, as a unix timestamp in nanoseconds.
+const DeadlineHeader = "X-Request-Deadline"
+
 type InvalidNodeModeErr string
 
 func (e InvalidNodeModeErr) Error() string {
@@ -292,11 +296,18 @@ func (n HTTPNode) Post(ctx context.Context, name, path string, body Traceable) (
 	req.Header.Add("Content-Type", "application/json")
 	ua := fmt.Sprintf("metrictank/%s (mode %s; state %s) Go/%s", n.Version, n.Mode.String(), n.State.String(), runtime.Version())
 	req.Header.Set("User-Agent", ua)
+	if peerAuthKey != "" {
+		req.Header.Set("Authorization", "Bearer "+peerAuthKey)
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		req.Header.Set(DeadlineHeader, strconv.FormatInt(deadline.UnixNano(), 10))
+	}
 	rsp, err := client.Do(req)
 
 	select {
 	case <-ctx.Done():
 		log.Debugf("CLU HTTPNode: context canceled on request to peer %s", n.Name)
+		requestsCanceled.Inc()
 		return nil, nil
 	default:
 	}
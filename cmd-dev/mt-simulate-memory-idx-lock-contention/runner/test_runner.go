@@ -48,7 +48,7 @@ func NewTestRun(metricsChan chan *schema.MetricData, queriesChan chan string, ad
 	index := memory.New()
 	index.Init()
 	// initializing with a `nil` store, that's a bit risky but good enough for the moment
-	mdata.Schemas = conf.NewSchemas(nil)
+	mdata.SetSchemas(conf.NewSchemas(nil))
 
 	runner := TestRun{
 		index:            index,
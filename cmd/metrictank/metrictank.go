@@ -18,25 +18,33 @@ import (
 	"github.com/Dieterbe/profiletrigger/heap"
 	"github.com/Shopify/sarama"
 	"github.com/grafana/globalconf"
+	"github.com/grafana/metrictank/alerting"
 	"github.com/grafana/metrictank/api"
 	"github.com/grafana/metrictank/cluster"
 	"github.com/grafana/metrictank/idx"
 	"github.com/grafana/metrictank/idx/bigtable"
 	"github.com/grafana/metrictank/idx/cassandra"
+	"github.com/grafana/metrictank/idx/file"
 	"github.com/grafana/metrictank/idx/memory"
 	"github.com/grafana/metrictank/input"
 	inCarbon "github.com/grafana/metrictank/input/carbon"
+	inInflux "github.com/grafana/metrictank/input/influx"
 	inKafkaMdm "github.com/grafana/metrictank/input/kafkamdm"
+	inOpenTSDB "github.com/grafana/metrictank/input/opentsdb"
 	inPrometheus "github.com/grafana/metrictank/input/prometheus"
 	"github.com/grafana/metrictank/jaeger"
+	"github.com/grafana/metrictank/limits"
 	"github.com/grafana/metrictank/logger"
 	"github.com/grafana/metrictank/mdata"
 	"github.com/grafana/metrictank/mdata/cache"
 	"github.com/grafana/metrictank/mdata/notifierKafka"
+	"github.com/grafana/metrictank/recording"
 	"github.com/grafana/metrictank/stats"
 	statsConfig "github.com/grafana/metrictank/stats/config"
 	bigtableStore "github.com/grafana/metrictank/store/bigtable"
 	cassandraStore "github.com/grafana/metrictank/store/cassandra"
+	diskStore "github.com/grafana/metrictank/store/disk"
+	tieredStore "github.com/grafana/metrictank/store/tiered"
 	"github.com/grafana/metrictank/util"
 	"github.com/raintank/dur"
 	log "github.com/sirupsen/logrus"
@@ -109,13 +117,16 @@ func main() {
 
 	// load config for metric ingestors
 	inCarbon.ConfigSetup()
+	inInflux.ConfigSetup()
 	inKafkaMdm.ConfigSetup()
+	inOpenTSDB.ConfigSetup()
 	inPrometheus.ConfigSetup()
 
 	// load config for metricIndexers
 	memory.ConfigSetup()
 	cassandra.ConfigSetup()
 	bigtable.ConfigSetup()
+	file.ConfigSetup()
 
 	// load config for API
 	api.ConfigSetup()
@@ -123,6 +134,15 @@ func main() {
 	// load config for cluster
 	cluster.ConfigSetup()
 
+	// per-org limits
+	limits.ConfigSetup()
+
+	// recording rules
+	recording.ConfigSetup()
+
+	// alerting rules
+	alerting.ConfigSetup()
+
 	// stats
 	statsConfig.ConfigSetup()
 
@@ -135,6 +155,12 @@ func main() {
 	// bigtable store
 	bigtableStore.ConfigSetup()
 
+	// disk store
+	diskStore.ConfigSetup()
+
+	// tiered store
+	tieredStore.ConfigSetup()
+
 	jaeger.ConfigSetup()
 
 	config.ParseAll()
@@ -179,19 +205,29 @@ func main() {
 	/***********************************
 		Validate remaining settings
 	***********************************/
+	input.ConfigProcess()
 	inCarbon.ConfigProcess()
+	inInflux.ConfigProcess()
 	inKafkaMdm.ConfigProcess(*instance)
+	inOpenTSDB.ConfigProcess()
 	memory.ConfigProcess()
 	inPrometheus.ConfigProcess()
 	notifierKafka.ConfigProcess(*instance)
 	statsConfig.ConfigProcess(*instance)
 	mdata.ConfigProcess()
+	cache.ConfigProcess()
 	cassandra.ConfigProcess()
 	bigtable.ConfigProcess()
+	file.ConfigProcess()
 	bigtableStore.ConfigProcess(mdata.MaxChunkSpan())
+	diskStore.ConfigProcess()
+	tieredStore.ConfigProcess()
 	jaeger.ConfigProcess()
+	limits.ConfigProcess()
+	recording.ConfigProcess()
+	alerting.ConfigProcess()
 
-	inputEnabled := inCarbon.Enabled || inKafkaMdm.Enabled || inPrometheus.Enabled
+	inputEnabled := inCarbon.Enabled || inInflux.Enabled || inKafkaMdm.Enabled || inOpenTSDB.Enabled || inPrometheus.Enabled
 	wantInput := cluster.Mode == cluster.ModeDev || cluster.Mode == cluster.ModeShard
 	if !inputEnabled && wantInput {
 		log.Fatal("you should enable at least 1 input plugin in 'dev' or 'shard' cluster mode")
@@ -257,33 +293,65 @@ func main() {
 	/***********************************
 		Initialize our backendStore
 	***********************************/
-	if cassandraStore.CliConfig.Enabled && bigtableStore.CliConfig.Enabled {
+	numStores := 0
+	for _, enabled := range []bool{cassandraStore.CliConfig.Enabled, bigtableStore.CliConfig.Enabled, diskStore.CliConfig.Enabled} {
+		if enabled {
+			numStores++
+		}
+	}
+	if numStores > 1 {
 		log.Fatal("only 1 backend store plugin can be enabled at once.")
 	}
 	if wantInput {
-		if !cassandraStore.CliConfig.Enabled && !bigtableStore.CliConfig.Enabled {
+		if numStores == 0 {
 			log.Fatal("at least 1 backend store plugin needs to be enabled in 'dev' or 'shard' cluster mode")
 		}
 	} else {
-		if cassandraStore.CliConfig.Enabled || bigtableStore.CliConfig.Enabled {
+		if numStores > 0 {
 			log.Fatal("no backend store plugin may be enabled in 'query' cluster mode")
 		}
 	}
+	if tieredStore.CliConfig.Enabled && numStores == 0 {
+		log.Fatal("the tiered store requires another backend store plugin to be enabled as primary store")
+	}
+	// with the tiered store, the primary store may only need to keep chunks for a shorter time
+	storeTTLs := mdata.TTLs()
+	if tieredStore.CliConfig.Enabled {
+		storeTTLs = tieredStore.CliConfig.PrimaryTTLs(storeTTLs)
+	}
 	if bigtableStore.CliConfig.Enabled {
 		schemaMaxChunkSpan := mdata.MaxChunkSpan()
-		store, err = bigtableStore.NewStore(bigtableStore.CliConfig, mdata.TTLs(), schemaMaxChunkSpan)
+		store, err = bigtableStore.NewStore(bigtableStore.CliConfig, storeTTLs, schemaMaxChunkSpan)
 		if err != nil {
 			log.Fatalf("failed to initialize bigtable backend store. %s", err)
 		}
 		store.SetTracer(tracer)
 	}
 	if cassandraStore.CliConfig.Enabled {
-		store, err = cassandraStore.NewCassandraStore(cassandraStore.CliConfig, mdata.TTLs())
+		store, err = cassandraStore.NewCassandraStore(cassandraStore.CliConfig, storeTTLs)
 		if err != nil {
 			log.Fatalf("failed to initialize cassandra backend store. %s", err)
 		}
 		store.SetTracer(tracer)
 	}
+	if diskStore.CliConfig.Enabled {
+		store, err = diskStore.NewStore(diskStore.CliConfig, storeTTLs)
+		if err != nil {
+			log.Fatalf("failed to initialize disk backend store. %s", err)
+		}
+		store.SetTracer(tracer)
+	}
+	if tieredStore.CliConfig.Enabled {
+		bucket, err := tieredStore.CliConfig.NewBucket()
+		if err != nil {
+			log.Fatalf("failed to initialize tiered store bucket. %s", err)
+		}
+		store, err = tieredStore.NewStore(tieredStore.CliConfig, store, bucket)
+		if err != nil {
+			log.Fatalf("failed to initialize tiered backend store. %s", err)
+		}
+		store.SetTracer(tracer)
+	}
 
 	/***********************************
 		Initialize the Chunk Cache
@@ -317,6 +385,14 @@ func main() {
 		inputs = append(inputs, inCarbon.New())
 	}
 
+	if inInflux.Enabled {
+		inputs = append(inputs, inInflux.New())
+	}
+
+	if inOpenTSDB.Enabled {
+		inputs = append(inputs, inOpenTSDB.New())
+	}
+
 	if inPrometheus.Enabled {
 		inputs = append(inputs, inPrometheus.New())
 	}
@@ -346,7 +422,7 @@ func main() {
 
 	idx.OrgIdPublic = uint32(*publicOrg)
 
-	idxEnabled := memory.Enabled || cassandra.CliConfig.Enabled || bigtable.CliConfig.Enabled
+	idxEnabled := memory.Enabled || cassandra.CliConfig.Enabled || bigtable.CliConfig.Enabled || file.CliConfig.Enabled
 	if !idxEnabled && wantInput {
 		log.Fatal("you should enable 1 index plugin in 'dev' or 'shard' cluster mode")
 	}
@@ -369,6 +445,12 @@ func main() {
 		}
 		metricIndex = bigtable.New(bigtable.CliConfig)
 	}
+	if file.CliConfig.Enabled {
+		if metricIndex != nil {
+			log.Fatal("Only 1 metricIndex handler can be enabled.")
+		}
+		metricIndex = file.New(file.CliConfig)
+	}
 
 	/***********************************
 		Initialize our API server
@@ -379,7 +461,10 @@ func main() {
 	}
 
 	apiServer.BindMetricIndex(metricIndex)
-	apiServer.BindMemoryStore(metrics)
+	// metrics is nil if input is disabled. binding the nil pointer would make a non-nil interface
+	if metrics != nil {
+		apiServer.BindMemoryStore(metrics)
+	}
 	apiServer.BindBackendStore(store)
 	apiServer.BindCache(ccache)
 	apiServer.BindTracer(tracer)
@@ -387,6 +472,20 @@ func main() {
 	cluster.Tracer = tracer
 	go apiServer.Run()
 
+	/***********************************
+		Reload the rules on SIGHUP
+	***********************************/
+	hupChan := make(chan os.Signal, 1)
+	signal.Notify(hupChan, syscall.SIGHUP)
+	go func() {
+		for range hupChan {
+			log.Info("Received SIGHUP. Reloading storage-schemas, storage-aggregation and index-rules")
+			if _, err := apiServer.ReloadRules(); err != nil {
+				log.Errorf("Failed to reload rules: %s", err.Error())
+			}
+		}
+	}()
+
 	/***********************************
 		Load index entries from the backend store.
 	***********************************/
@@ -398,6 +497,13 @@ func main() {
 		log.Infof("metricIndex initialized in %s. starting data consumption", time.Now().Sub(pre))
 	}
 
+	// query nodes don't have an index, and don't ingest series to count
+	var seriesCounter limits.SeriesCounter
+	if memIdx, ok := metricIndex.(memory.MemoryIndex); ok {
+		seriesCounter = memIdx.SeriesCount
+	}
+	limits.Start(seriesCounter)
+
 	/***********************************
 		Initialize MetricPersist notifiers
 	***********************************/
@@ -414,13 +520,28 @@ func main() {
 		log.Fatal("you should disable notifier plugins in 'query' cluster mode")
 	}
 
+	/***********************************
+		Replay the write-ahead log of our inputs
+	***********************************/
+	if wantInput {
+		err = input.StartWAL(metrics, metricIndex)
+		if err != nil {
+			log.Fatalf("failed to start write-ahead log: %s", err.Error())
+		}
+	}
+
 	/***********************************
 		Start our inputs
 	***********************************/
 	ctx, cancel := context.WithCancel(context.Background())
 	for _, plugin := range inputs {
-		if carbonPlugin, ok := plugin.(*inCarbon.Carbon); ok {
-			carbonPlugin.IntervalGetter(inCarbon.NewIndexIntervalGetter(metricIndex))
+		switch p := plugin.(type) {
+		case *inCarbon.Carbon:
+			p.IntervalGetter(input.NewIndexIntervalGetter(metricIndex))
+		case *inInflux.Influx:
+			p.IntervalGetter(input.NewIndexIntervalGetter(metricIndex))
+		case *inOpenTSDB.OpenTSDB:
+			p.IntervalGetter(input.NewIndexIntervalGetter(metricIndex))
 		}
 		err = plugin.Start(input.NewDefaultHandler(metrics, metricIndex, plugin.Name()), cancel)
 		if err != nil {
@@ -431,6 +552,15 @@ func main() {
 		apiServer.BindPrioritySetter(plugin)
 	}
 
+	// our own stats, and the results of the recording rules, are ingested like the data of an input
+	if wantInput {
+		statsConfig.SetSelfHandler(input.NewDefaultHandler(metrics, metricIndex, "self"))
+		recording.Start(apiServer, input.NewDefaultHandler(metrics, metricIndex, "recording"))
+	}
+
+	// alerting rules are evaluated by the ready primary node of partition 0
+	alerting.Start(apiServer)
+
 	// metric cluster.self.promotion_wait is how long a candidate (secondary node) has to wait until it can become a primary
 	// When the timer becomes 0 it means the in-memory buffer has been able to fully populate so that if you stop a primary
 	// and it was able to save its complete chunks, this node will be able to take over without dataloss.
@@ -503,6 +633,8 @@ func shutdown() {
 		timer.Stop()
 	}
 
+	input.StopWAL()
+
 	if cluster.Mode != cluster.ModeQuery {
 		log.Info("closing store")
 		store.Stop()
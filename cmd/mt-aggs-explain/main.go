@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"os"
 	"runtime"
+	"strings"
 
 	"github.com/grafana/metrictank/conf"
 	"github.com/grafana/metrictank/consolidation"
@@ -15,7 +16,8 @@ import (
 var (
 	version     = "(none)"
 	showVersion = flag.Bool("version", false, "print version string")
-	metric      = flag.String("metric", "", "specify a metric name to see which aggregation rule it matches")
+	metric      = flag.String("metric", "", "specify a metric name, optionally with tags (e.g. 'a.b;env=dev'), to see which aggregation rule it matches")
+	orgId       = flag.Uint("org", 1, "specify the org of the metric, for aggregation rules that only apply to a given org")
 )
 
 func init() {
@@ -57,8 +59,8 @@ func main() {
 	}
 
 	if *metric != "" {
-		aggI, agg := aggs.Match(*metric)
-		fmt.Printf("metric %q gets aggI %d\n", *metric, aggI)
+		aggI, agg := aggs.Match(uint32(*orgId), *metric)
+		fmt.Printf("metric %q of org %d gets aggI %d\n", *metric, *orgId, aggI)
 		show(agg)
 		fmt.Println()
 		fmt.Println()
@@ -77,6 +79,12 @@ func main() {
 func show(agg conf.Aggregation) {
 	fmt.Println("#", agg.Name)
 	fmt.Printf("pattern:   %10s\n", agg.Pattern)
+	if len(agg.Tags) > 0 {
+		fmt.Printf("tags:      %10s\n", strings.Join(agg.Tags.Strings(), ";"))
+	}
+	if agg.OrgId != 0 {
+		fmt.Printf("org:       %10d\n", agg.OrgId)
+	}
 	fmt.Printf("priority:  %10f\n", agg.XFilesFactor)
 	fmt.Printf("methods:\n")
 	for i, method := range agg.AggregationMethod {
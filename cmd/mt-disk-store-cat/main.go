@@ -0,0 +1,238 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"math"
+	"os"
+	"runtime"
+	"strings"
+	"time"
+
+	"github.com/grafana/metrictank/logger"
+	"github.com/grafana/metrictank/mdata/chunk"
+	"github.com/grafana/metrictank/schema"
+	"github.com/grafana/metrictank/store/disk"
+	"github.com/raintank/dur"
+	log "github.com/sirupsen/logrus"
+)
+
+var (
+	version = "(none)"
+
+	showVersion = flag.Bool("version", false, "print version string")
+	path        = flag.String("path", "/var/lib/metrictank/chunks", "directory of the disk store")
+	from        = flag.String("from", "-24h", "get data from (inclusive). only for points and point-summary format")
+	to          = flag.String("to", "now", "get data until (exclusive). only for points and point-summary format")
+	printTs     = flag.Bool("print-ts", false, "print time stamps instead of formatted dates")
+	timeZoneStr = flag.String("time-zone", "local", "time-zone to use for interpreting from/to when needed. (check your config)")
+)
+
+func init() {
+	formatter := &logger.TextFormatter{}
+	formatter.TimestampFormat = "2006-01-02 15:04:05.000"
+	log.SetFormatter(formatter)
+	log.SetLevel(log.WarnLevel)
+}
+
+func main() {
+	flag.Usage = func() {
+		fmt.Println("mt-disk-store-cat")
+		fmt.Println()
+		fmt.Println("Retrieves timeseries data from the local disk store. Either raw or with minimal processing")
+		fmt.Println("Metrictank must not be running with the same disk store path")
+		fmt.Println()
+		fmt.Println("Usage:")
+		fmt.Println()
+		fmt.Printf("	mt-disk-store-cat [flags] tables\n")
+		fmt.Println()
+		fmt.Printf("	mt-disk-store-cat [flags] <table-selector> <metric-selector> <format>\n")
+		fmt.Printf("	                     table-selector: '*' or name of a table. e.g. 'metric_128'\n")
+		fmt.Printf("	                     metric-selector: '*' or an id (of raw or aggregated series) or prefix:<prefix of the id>\n")
+		fmt.Printf("	                     format:\n")
+		fmt.Printf("	                            - points\n")
+		fmt.Printf("	                            - point-summary\n")
+		fmt.Printf("	                            - chunk-summary (shows t0, TTL, size and expiry of each chunk)\n")
+		fmt.Println()
+		fmt.Println("EXAMPLES:")
+		fmt.Println("mt-disk-store-cat -from='-1min' '*' '1.77c8c77afa22b67ef5b700c2a2b88d5f' points")
+		fmt.Println("mt-disk-store-cat 'metric_512' 'prefix:1.37cf' chunk-summary")
+		fmt.Println("Flags:")
+		flag.PrintDefaults()
+		fmt.Println("Notes:")
+		fmt.Println(" * points that are not in the `from <= ts < to` range, are prefixed with `-`. In range has prefix of '>`")
+		fmt.Println(" * chunk-summary also shows chunks that have expired but of which the file has not been deleted yet")
+	}
+	flag.Parse()
+
+	if *showVersion {
+		fmt.Printf("mt-disk-store-cat (version: %s - runtime: %s)\n", version, runtime.Version())
+		return
+	}
+	if flag.NArg() < 1 {
+		flag.Usage()
+		os.Exit(-1)
+	}
+	tableSelector := flag.Arg(0)
+	var metricSelector, format string
+	if tableSelector != "tables" {
+		if flag.NArg() < 3 {
+			flag.Usage()
+			os.Exit(-1)
+		}
+		metricSelector = flag.Arg(1)
+		format = flag.Arg(2)
+		if format != "points" && format != "point-summary" && format != "chunk-summary" {
+			flag.Usage()
+			os.Exit(-1)
+		}
+	}
+
+	loc := time.Local
+	if *timeZoneStr != "local" {
+		var err error
+		loc, err = time.LoadLocation(*timeZoneStr)
+		if err != nil {
+			log.Fatal(err.Error())
+		}
+	}
+
+	cfg := disk.NewStoreConfig()
+	cfg.Enabled = true
+	cfg.Path = *path
+	if _, err := os.Stat(cfg.Path); err != nil {
+		log.Fatalf("can't open disk store: %s", err.Error())
+	}
+	store, err := disk.NewStore(cfg, nil)
+	if err != nil {
+		log.Fatalf("failed to open disk store. %s", err.Error())
+	}
+	defer store.Stop()
+
+	if tableSelector == "tables" {
+		for _, table := range store.Tables() {
+			fmt.Println(table)
+		}
+		return
+	}
+
+	var tables []string
+	if tableSelector == "*" {
+		tables = store.Tables()
+	} else {
+		tables = []string{tableSelector}
+	}
+
+	now := time.Now()
+	fromUnix, err := dur.ParseDateTime(*from, loc, now, uint32(now.Add(-24*time.Hour).Unix()))
+	if err != nil {
+		log.Fatal(err.Error())
+	}
+	toUnix, err := dur.ParseDateTime(*to, loc, now, uint32(now.Add(time.Second).Unix()))
+	if err != nil {
+		log.Fatal(err.Error())
+	}
+
+	for _, table := range tables {
+		keys, err := store.Keys(table)
+		if err != nil {
+			log.Fatalf("table %q: %s", table, err.Error())
+		}
+		fmt.Println("## Table", table)
+		for _, key := range keys {
+			if !match(metricSelector, key) {
+				continue
+			}
+			fmt.Println("### Series", key.String())
+			switch format {
+			case "chunk-summary":
+				infos, err := store.Chunks(table, key)
+				if err != nil {
+					log.Fatal(err.Error())
+				}
+				for _, info := range infos {
+					fmt.Printf("t0:%s ttl:%s size:%d expires:%s\n", printTime(info.T0), dur.FormatDuration(info.TTL), info.Size, printTime(info.Expires))
+				}
+			case "points", "point-summary":
+				itgens, err := store.SearchTable(context.Background(), key, table, fromUnix, toUnix)
+				if err != nil {
+					log.Fatal(err.Error())
+				}
+				printPoints(itgens, fromUnix, toUnix, format == "point-summary")
+			}
+		}
+	}
+}
+
+func match(selector string, key schema.AMKey) bool {
+	if selector == "*" {
+		return true
+	}
+	if strings.HasPrefix(selector, "prefix:") {
+		return strings.HasPrefix(key.String(), strings.TrimPrefix(selector, "prefix:"))
+	}
+	return key.String() == selector
+}
+
+func printTime(ts uint32) string {
+	if *printTs {
+		return fmt.Sprintf("%d", ts)
+	}
+	return time.Unix(int64(ts), 0).Format("2006-01-02 15:04:05")
+}
+
+// printPoints prints the points in the chunks. in summary mode, consecutive points
+// with the same in-range and NaN status are collapsed into a single line.
+func printPoints(itgens []chunk.IterGen, from, to uint32, summary bool) {
+	var count int
+	first := true
+	var prevIn, prevNaN bool
+	var ts uint32
+	var val float64
+
+	for i, itgen := range itgens {
+		if !summary {
+			fmt.Printf("#### chunk %d (t0:%s, span:%d, format:%s, size:%d)\n", i, printTime(itgen.T0), itgen.Span(), itgen.Format(), itgen.Size())
+		}
+		iter, err := itgen.Get()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "chunk %d itergen.Get: %s", i, err)
+			continue
+		}
+		for iter.Next() {
+			ts, val = iter.Values()
+			nan := math.IsNaN(val)
+			in := ts >= from && ts < to
+			if !summary || first {
+				printRecord(ts, val, in, nan)
+			} else if nan == prevNaN && in == prevIn {
+				count++
+			} else {
+				fmt.Printf("... and %d more of in_range=%t nan=%t ...\n", count, prevIn, prevNaN)
+				printRecord(ts, val, in, nan)
+				count = 0
+			}
+			prevNaN = nan
+			prevIn = in
+			first = false
+		}
+	}
+	if count > 0 {
+		fmt.Printf("... and %d more of in_range=%t nan=%t ...\n", count, prevIn, prevNaN)
+		fmt.Println("last value was:")
+		printRecord(ts, val, prevIn, prevNaN)
+	}
+}
+
+func printRecord(ts uint32, val float64, in, nan bool) {
+	prefix := "- "
+	if in {
+		prefix = "> "
+	}
+	if nan {
+		fmt.Println(prefix, printTime(ts), "NAN")
+	} else {
+		fmt.Println(prefix, printTime(ts), val)
+	}
+}
@@ -195,7 +195,7 @@ func main() {
 		perror(err)
 	}
 
-	memory.IndexRules = conf.IndexRules{
+	indexRules := conf.IndexRules{
 		Rules: nil,
 		Default: conf.IndexRule{
 			Name:     "default",
@@ -207,8 +207,9 @@ func main() {
 	if maxStale != "0" {
 		maxStaleInt, err := dur.ParseNDuration(maxStale)
 		perror(err)
-		memory.IndexRules.Default.MaxStale = time.Duration(maxStaleInt) * time.Second
+		indexRules.Default.MaxStale = time.Duration(maxStaleInt) * time.Second
 	}
+	memory.SetIndexRules(indexRules)
 
 	var cutoffMin int64
 	if minStale != "0" {
@@ -115,14 +115,14 @@ func main() {
 
 	// we don't want to filter any metric definitions during the loading
 	// so MaxStale is set to 0
-	memory.IndexRules = conf.IndexRules{
+	memory.SetIndexRules(conf.IndexRules{
 		Rules: nil,
 		Default: conf.IndexRule{
 			Name:     "default",
 			Pattern:  regexp.MustCompile(""),
 			MaxStale: 0,
 		},
-	}
+	})
 
 	defCounters := counters{}
 	defs := make([]schema.MetricDefinition, 0)
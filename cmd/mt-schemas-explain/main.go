@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"os"
 	"runtime"
+	"strings"
 	"time"
 
 	"github.com/grafana/metrictank/conf"
@@ -17,7 +18,8 @@ var (
 	version      = "(none)"
 	showVersion  = flag.Bool("version", false, "print version string")
 	windowFactor = flag.Int("window-factor", 20, "size of compaction window relative to TTL")
-	metric       = flag.String("metric", "", "specify a metric name to see which schema it matches")
+	metric       = flag.String("metric", "", "specify a metric name, optionally with tags (e.g. 'a.b;env=dev'), to see which schema it matches")
+	orgId        = flag.Uint("org", 1, "specify the org of the metric, for schemas that only apply to a given org")
 	interval     = flag.Int("int", 0, "specify an interval to apply interval-based matching in addition to metric matching (e.g. to simulate kafka-mdm input)")
 )
 
@@ -60,9 +62,9 @@ func main() {
 	}
 
 	if *metric != "" {
-		schemaI, s := schemas.Match(*metric, *interval)
-		fmt.Printf("metric %q with interval %d gets schemaI %d\n", *metric, *interval, schemaI)
-		fmt.Printf("## [%q] pattern=%q prio=%d retentions=%v\n", s.Name, s.Pattern, s.Priority, s.Retentions)
+		schemaI, s := schemas.Match(uint32(*orgId), *metric, *interval)
+		fmt.Printf("metric %q of org %d with interval %d gets schemaI %d\n", *metric, *orgId, *interval, schemaI)
+		fmt.Printf("## [%q] pattern=%q tags=%q org=%d prio=%d retentions=%v\n", s.Name, s.Pattern, strings.Join(s.Tags.Strings(), ";"), s.OrgId, s.Priority, s.Retentions)
 		fmt.Println()
 	}
 
@@ -77,6 +79,12 @@ func main() {
 func display(schema conf.Schema) {
 	fmt.Println("#", schema.Name)
 	fmt.Printf("pattern:   %10s\n", schema.Pattern)
+	if len(schema.Tags) > 0 {
+		fmt.Printf("tags:      %10s\n", strings.Join(schema.Tags.Strings(), ";"))
+	}
+	if schema.OrgId != 0 {
+		fmt.Printf("org:       %10d\n", schema.OrgId)
+	}
 	fmt.Printf("priority:  %10d\n", schema.Priority)
 	fmt.Printf("retentions:%10s %10s %10s %10s %10s %15s %10s\n", "interval", "retention", "chunkspan", "numchunks", "ready", "tablename", "windowsize")
 	for _, ret := range schema.Retentions.Rets {
@@ -7,6 +7,7 @@ import (
 	"strings"
 
 	"github.com/alyu/configparser"
+	"github.com/grafana/metrictank/expr/tagquery"
 )
 
 // Aggregations holds the aggregation definitions
@@ -18,6 +19,8 @@ type Aggregations struct {
 type Aggregation struct {
 	Name              string
 	Pattern           *regexp.Regexp
+	Tags              tagquery.Expressions // if set, series must also satisfy all these expressions
+	OrgId             uint32               // if not 0, the aggregation only applies to series of this org
 	XFilesFactor      float64
 	AggregationMethod []Method
 }
@@ -56,9 +59,9 @@ func ReadAggregations(file string) (Aggregations, error) {
 			continue
 		}
 
-		item.Pattern, err = regexp.Compile(s.ValueOf("pattern"))
+		item.Pattern, item.Tags, item.OrgId, err = parseMatchers(item.Name, s)
 		if err != nil {
-			return Aggregations{}, fmt.Errorf("[%s]: failed to parse pattern %q: %s", item.Name, s.ValueOf("pattern"), err.Error())
+			return Aggregations{}, err
 		}
 
 		item.XFilesFactor, err = strconv.ParseFloat(s.ValueOf("xFilesFactor"), 64)
@@ -91,12 +94,14 @@ func ReadAggregations(file string) (Aggregations, error) {
 	return result, nil
 }
 
-// Match returns the correct aggregation setting for the given metric
+// Match returns the correct aggregation setting for the given metric of the given org.
+// metric is the name including the tags, if any, as in "name;key1=val1;key2=val2"
 // it can always find a valid setting, because there's a default catch all
 // also returns the index of the setting, to efficiently reference it
-func (a Aggregations) Match(metric string) (uint16, Aggregation) {
+func (a Aggregations) Match(orgId uint32, metric string) (uint16, Aggregation) {
+	series := newSeries(orgId, metric)
 	for i, s := range a.Data {
-		if s.Pattern.MatchString(metric) {
+		if series.matches(s.Pattern, s.Tags, s.OrgId) {
 			return uint16(i), s
 		}
 	}
@@ -0,0 +1,165 @@
+package conf
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/alyu/configparser"
+	"github.com/raintank/dur"
+)
+
+// the query languages alerting rules can be expressed in
+const (
+	AlertingRuleGraphite = "graphite"
+	AlertingRulePromQL   = "promql"
+)
+
+// AlertingRule is an expression that is evaluated periodically. every series it returns of which the value
+// satisfies the condition, for the given duration, becomes a firing alert
+type AlertingRule struct {
+	Name        string
+	Expr        string // the expression to evaluate
+	Type        string // the query language of the expression: AlertingRuleGraphite or AlertingRulePromQL
+	OrgId       uint32
+	Interval    uint32 // how often to evaluate the expression, in seconds
+	Window      uint32 // graphite only: the value of a series is its last non-null value within this many seconds
+	For         uint32 // how long the condition must be satisfied before an alert fires, in seconds
+	Condition   Condition
+	Labels      map[string]string // added to the labels of the series, to form the labels of the alerts
+	Annotations map[string]string // templates, executed with the labels and the value of the alert
+}
+
+// Condition compares a value against a threshold, as in "> 100"
+type Condition struct {
+	Op        string
+	Threshold float64
+}
+
+// the operators are ordered such that none is a prefix of an operator that comes after it
+var conditionOps = []string{">=", "<=", "==", "!=", ">", "<"}
+
+// ParseCondition parses a condition like "> 100"
+func ParseCondition(s string) (Condition, error) {
+	s = strings.TrimSpace(s)
+	for _, op := range conditionOps {
+		if !strings.HasPrefix(s, op) {
+			continue
+		}
+		threshold, err := strconv.ParseFloat(strings.TrimSpace(s[len(op):]), 64)
+		if err != nil {
+			return Condition{}, fmt.Errorf("invalid threshold in condition %q: %s", s, err.Error())
+		}
+		return Condition{op, threshold}, nil
+	}
+	return Condition{}, fmt.Errorf("invalid condition %q: must be one of %s followed by a number", s, strings.Join(conditionOps, ", "))
+}
+
+// Matches returns whether the value satisfies the condition
+func (c Condition) Matches(val float64) bool {
+	switch c.Op {
+	case ">=":
+		return val >= c.Threshold
+	case "<=":
+		return val <= c.Threshold
+	case "==":
+		return val == c.Threshold
+	case "!=":
+		return val != c.Threshold
+	case ">":
+		return val > c.Threshold
+	case "<":
+		return val < c.Threshold
+	}
+	return false
+}
+
+func (c Condition) String() string {
+	return c.Op + " " + strconv.FormatFloat(c.Threshold, 'f', -1, 64)
+}
+
+// ReadAlertingRules returns the rules defined in an alerting-rules.conf file
+func ReadAlertingRules(file string) ([]AlertingRule, error) {
+	config, err := configparser.Read(file)
+	if err != nil {
+		return nil, err
+	}
+	sections, err := config.AllSections()
+	if err != nil {
+		return nil, err
+	}
+
+	var rules []AlertingRule
+	for _, s := range sections {
+		name := strings.Trim(strings.SplitN(s.String(), "\n", 2)[0], " []")
+		if name == "" || strings.HasPrefix(name, "#") {
+			continue
+		}
+		rule, err := readAlertingRule(name, s)
+		if err != nil {
+			return nil, fmt.Errorf("[%s]: %s", name, err.Error())
+		}
+		rules = append(rules, rule)
+	}
+	return rules, nil
+}
+
+func readAlertingRule(name string, s *configparser.Section) (AlertingRule, error) {
+	rule := AlertingRule{
+		Name:        name,
+		Expr:        s.ValueOf("expr"),
+		Type:        AlertingRuleGraphite,
+		Labels:      make(map[string]string),
+		Annotations: make(map[string]string),
+	}
+	if rule.Expr == "" {
+		return AlertingRule{}, fmt.Errorf("expr is required")
+	}
+	if s.Exists("type") {
+		rule.Type = s.ValueOf("type")
+		if rule.Type != AlertingRuleGraphite && rule.Type != AlertingRulePromQL {
+			return AlertingRule{}, fmt.Errorf("invalid type %q: must be %s or %s", rule.Type, AlertingRuleGraphite, AlertingRulePromQL)
+		}
+	}
+
+	orgId, err := strconv.ParseUint(s.ValueOf("org-id"), 10, 32)
+	if err != nil || orgId < 1 {
+		return AlertingRule{}, fmt.Errorf("failed to parse org-id %q: must be a number >= 1", s.ValueOf("org-id"))
+	}
+	rule.OrgId = uint32(orgId)
+
+	rule.Interval, err = dur.ParseNDuration(s.ValueOf("interval"))
+	if err != nil {
+		return AlertingRule{}, fmt.Errorf("failed to parse interval %q: %s", s.ValueOf("interval"), err.Error())
+	}
+
+	rule.Window = rule.Interval
+	if s.Exists("window") {
+		rule.Window, err = dur.ParseNDuration(s.ValueOf("window"))
+		if err != nil {
+			return AlertingRule{}, fmt.Errorf("failed to parse window %q: %s", s.ValueOf("window"), err.Error())
+		}
+	}
+
+	if s.Exists("for") {
+		rule.For, err = dur.ParseDuration(s.ValueOf("for"))
+		if err != nil {
+			return AlertingRule{}, fmt.Errorf("failed to parse for %q: %s", s.ValueOf("for"), err.Error())
+		}
+	}
+
+	rule.Condition, err = ParseCondition(s.ValueOf("condition"))
+	if err != nil {
+		return AlertingRule{}, err
+	}
+
+	for _, option := range s.OptionNames() {
+		if strings.HasPrefix(option, "label.") {
+			rule.Labels[strings.TrimPrefix(option, "label.")] = s.ValueOf(option)
+		} else if strings.HasPrefix(option, "annotation.") {
+			rule.Annotations[strings.TrimPrefix(option, "annotation.")] = s.ValueOf(option)
+		}
+	}
+
+	return rule, nil
+}
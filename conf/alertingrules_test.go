@@ -0,0 +1,155 @@
+package conf
+
+import (
+	"io/ioutil"
+	"os"
+	"reflect"
+	"testing"
+)
+
+func TestReadAlertingRules(t *testing.T) {
+	cases := []struct {
+		in       string
+		expErr   bool
+		expRules []AlertingRule
+	}{
+		{
+			in: ``,
+		},
+		{
+			in: `
+[high-error-rate]
+expr = sumSeries(errors.*.count)
+org-id = 1
+interval = 1min
+window = 5min
+for = 10min
+condition = > 100
+label.severity = page
+annotation.summary = {{ .Value }} errors
+
+[instance-down]
+expr = up == 0
+type = promql
+org-id = 2
+interval = 30s
+condition = == 0
+`,
+			expRules: []AlertingRule{
+				{
+					Name:        "high-error-rate",
+					Expr:        "sumSeries(errors.*.count)",
+					Type:        AlertingRuleGraphite,
+					OrgId:       1,
+					Interval:    60,
+					Window:      300,
+					For:         600,
+					Condition:   Condition{">", 100},
+					Labels:      map[string]string{"severity": "page"},
+					Annotations: map[string]string{"summary": "{{ .Value }} errors"},
+				},
+				{
+					Name:        "instance-down",
+					Expr:        "up == 0",
+					Type:        AlertingRulePromQL,
+					OrgId:       2,
+					Interval:    30,
+					Window:      30,
+					Condition:   Condition{"==", 0},
+					Labels:      map[string]string{},
+					Annotations: map[string]string{},
+				},
+			},
+		},
+		{
+			in: `
+[no-expr]
+org-id = 1
+interval = 1min
+condition = > 100
+`,
+			expErr: true,
+		},
+		{
+			in: `
+[bad-type]
+expr = sumSeries(errors.*.count)
+type = sql
+org-id = 1
+interval = 1min
+condition = > 100
+`,
+			expErr: true,
+		},
+		{
+			in: `
+[no-interval]
+expr = sumSeries(errors.*.count)
+org-id = 1
+condition = > 100
+`,
+			expErr: true,
+		},
+		{
+			in: `
+[bad-condition]
+expr = sumSeries(errors.*.count)
+org-id = 1
+interval = 1min
+condition = about 100
+`,
+			expErr: true,
+		},
+	}
+	for i, c := range cases {
+		tmpfile, err := ioutil.TempFile("", "alerting-rules-test-readalertingrules")
+		if err != nil {
+			panic(err)
+		}
+
+		if _, err := tmpfile.Write([]byte(c.in)); err != nil {
+			panic(err)
+		}
+		if err := tmpfile.Close(); err != nil {
+			panic(err)
+		}
+
+		rules, err := ReadAlertingRules(tmpfile.Name())
+		os.Remove(tmpfile.Name())
+		if (err != nil) != c.expErr {
+			t.Fatalf("case %d, exp err %t, got err %v", i, c.expErr, err)
+		}
+		if err == nil && !reflect.DeepEqual(rules, c.expRules) {
+			t.Fatalf("case %d, exp rules %v, got %v", i, c.expRules, rules)
+		}
+	}
+}
+
+func TestCondition(t *testing.T) {
+	cases := []struct {
+		in      string
+		val     float64
+		expErr  bool
+		matches bool
+	}{
+		{in: "> 100", val: 101, matches: true},
+		{in: "> 100", val: 100, matches: false},
+		{in: ">=100", val: 100, matches: true},
+		{in: "< -1.5", val: -2, matches: true},
+		{in: "<= 0", val: 0.1, matches: false},
+		{in: "== 0", val: 0, matches: true},
+		{in: "!= 0", val: 0, matches: false},
+		{in: "=> 1", expErr: true},
+		{in: "> lots", expErr: true},
+		{in: "", expErr: true},
+	}
+	for i, c := range cases {
+		cond, err := ParseCondition(c.in)
+		if (err != nil) != c.expErr {
+			t.Fatalf("case %d, exp err %t, got err %v", i, c.expErr, err)
+		}
+		if err == nil && cond.Matches(c.val) != c.matches {
+			t.Fatalf("case %d, expected %q matching %f to be %t", i, c.in, c.val, c.matches)
+		}
+	}
+}
@@ -0,0 +1,129 @@
+package conf
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/alyu/configparser"
+)
+
+// Role determines which operations an api key is allowed to perform.
+// roles are ordered: each role includes the permissions of the roles before it.
+type Role int
+
+const (
+	RoleNone  Role = iota // unauthenticated
+	RoleRead              // querying data of the org
+	RoleWrite             // modifying and deleting data of the org
+	RoleAdmin             // everything, for any org, including cluster and cache management
+)
+
+func (r Role) String() string {
+	switch r {
+	case RoleNone:
+		return "none"
+	case RoleRead:
+		return "read"
+	case RoleWrite:
+		return "write"
+	case RoleAdmin:
+		return "admin"
+	}
+	return "unknown"
+}
+
+// RoleFromString parses a role as specified in the api keys file
+func RoleFromString(s string) (Role, error) {
+	switch s {
+	case "read":
+		return RoleRead, nil
+	case "write":
+		return RoleWrite, nil
+	case "admin":
+		return RoleAdmin, nil
+	}
+	return RoleNone, fmt.Errorf("unknown role %q. must be one of read, write, admin", s)
+}
+
+// APIKey is an api key (or bearer token) that grants the given role for an org
+type APIKey struct {
+	Name  string
+	OrgId uint32
+	Role  Role
+}
+
+// APIKeys holds the api keys, indexed by the sha256 hash of the key
+// rather than the key itself, so that lookups don't leak timing information about the keys
+type APIKeys struct {
+	keys map[[sha256.Size]byte]APIKey
+}
+
+// NewAPIKeys creates an empty set of api keys
+func NewAPIKeys() APIKeys {
+	return APIKeys{
+		keys: make(map[[sha256.Size]byte]APIKey),
+	}
+}
+
+// Add adds the given key
+func (a APIKeys) Add(key string, apiKey APIKey) {
+	a.keys[sha256.Sum256([]byte(key))] = apiKey
+}
+
+// Get returns the APIKey corresponding to the given key, if any
+func (a APIKeys) Get(key string) (APIKey, bool) {
+	apiKey, ok := a.keys[sha256.Sum256([]byte(key))]
+	return apiKey, ok
+}
+
+// Len returns the number of keys
+func (a APIKeys) Len() int {
+	return len(a.keys)
+}
+
+// ReadAPIKeys returns the api keys defined in an api-keys.conf file
+func ReadAPIKeys(file string) (APIKeys, error) {
+	config, err := configparser.Read(file)
+	if err != nil {
+		return APIKeys{}, err
+	}
+	sections, err := config.AllSections()
+	if err != nil {
+		return APIKeys{}, err
+	}
+
+	result := NewAPIKeys()
+
+	for _, s := range sections {
+		item := APIKey{}
+		item.Name = strings.Trim(strings.SplitN(s.String(), "\n", 2)[0], " []")
+		if item.Name == "" || strings.HasPrefix(item.Name, "#") {
+			continue
+		}
+
+		key := s.ValueOf("key")
+		if key == "" {
+			return APIKeys{}, fmt.Errorf("[%s]: key must not be empty", item.Name)
+		}
+		if _, ok := result.Get(key); ok {
+			return APIKeys{}, fmt.Errorf("[%s]: key is not unique", item.Name)
+		}
+
+		orgId, err := strconv.ParseUint(s.ValueOf("org-id"), 10, 32)
+		if err != nil || orgId < 1 {
+			return APIKeys{}, fmt.Errorf("[%s]: failed to parse org-id %q: must be a number >= 1", item.Name, s.ValueOf("org-id"))
+		}
+		item.OrgId = uint32(orgId)
+
+		item.Role, err = RoleFromString(s.ValueOf("role"))
+		if err != nil {
+			return APIKeys{}, fmt.Errorf("[%s]: %s", item.Name, err.Error())
+		}
+
+		result.Add(key, item)
+	}
+
+	return result, nil
+}
@@ -0,0 +1,118 @@
+package conf
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+func TestReadAPIKeys(t *testing.T) {
+	cases := []struct {
+		in      string
+		expErr  bool
+		expKeys map[string]APIKey
+	}{
+		{
+			in:      ``,
+			expKeys: map[string]APIKey{},
+		},
+		{
+			in: `
+[grafana]
+key = abc
+org-id = 2
+role = read
+
+[collector]
+key = def
+org-id = 2
+role = write
+
+# operators
+[ops]
+key = ghi
+org-id = 1
+role = admin
+`,
+			expKeys: map[string]APIKey{
+				"abc": {Name: "grafana", OrgId: 2, Role: RoleRead},
+				"def": {Name: "collector", OrgId: 2, Role: RoleWrite},
+				"ghi": {Name: "ops", OrgId: 1, Role: RoleAdmin},
+			},
+		},
+		{
+			in: `
+[nokey]
+org-id = 2
+role = read
+`,
+			expErr: true,
+		},
+		{
+			in: `
+[badorg]
+key = abc
+org-id = 0
+role = read
+`,
+			expErr: true,
+		},
+		{
+			in: `
+[badrole]
+key = abc
+org-id = 2
+role = superuser
+`,
+			expErr: true,
+		},
+		{
+			in: `
+[a]
+key = abc
+org-id = 2
+role = read
+
+[b]
+key = abc
+org-id = 3
+role = read
+`,
+			expErr: true,
+		},
+	}
+	for i, c := range cases {
+		tmpfile, err := ioutil.TempFile("", "apikeys-test-readapikeys")
+		if err != nil {
+			panic(err)
+		}
+
+		if _, err := tmpfile.Write([]byte(c.in)); err != nil {
+			panic(err)
+		}
+		if err := tmpfile.Close(); err != nil {
+			panic(err)
+		}
+
+		keys, err := ReadAPIKeys(tmpfile.Name())
+		os.Remove(tmpfile.Name())
+		if (err != nil) != c.expErr {
+			t.Fatalf("case %d, exp err %t, got err %v", i, c.expErr, err)
+		}
+		if err != nil {
+			continue
+		}
+		if keys.Len() != len(c.expKeys) {
+			t.Fatalf("case %d, exp %d keys, got %d", i, len(c.expKeys), keys.Len())
+		}
+		for key, expKey := range c.expKeys {
+			apiKey, ok := keys.Get(key)
+			if !ok || apiKey != expKey {
+				t.Fatalf("case %d, key %q: exp %v, got %v (found: %t)", i, key, expKey, apiKey, ok)
+			}
+		}
+		if _, ok := keys.Get("unknown"); ok {
+			t.Fatalf("case %d, did not expect to find an unknown key", i)
+		}
+	}
+}
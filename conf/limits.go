@@ -0,0 +1,117 @@
+package conf
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/alyu/configparser"
+)
+
+// Limit holds the limits that apply to an org. 0 means unlimited
+type Limit struct {
+	MaxActiveSeries      int // max number of series in the index
+	MaxIngestRate        int // max number of datapoints ingested per second
+	MaxSeriesPerReq      int // max number of series a render request can operate on
+	MaxConcurrentQueries int // max number of queries executing at the same time
+}
+
+// Limits holds the default limit, and the overrides for specific orgs
+type Limits struct {
+	Default Limit
+	Orgs    map[uint32]Limit
+}
+
+// NewLimits creates a set of limits that doesn't limit anything
+func NewLimits() Limits {
+	return Limits{
+		Orgs: make(map[uint32]Limit),
+	}
+}
+
+// Get returns the limit for the given org
+func (l Limits) Get(orgId uint32) Limit {
+	if limit, ok := l.Orgs[orgId]; ok {
+		return limit
+	}
+	return l.Default
+}
+
+// ReadLimits returns the limits defined in a limits.conf file.
+// the [default] section applies to all orgs. other sections apply to the org given by their org-id,
+// and inherit all settings they don't specify from the default section.
+func ReadLimits(file string) (Limits, error) {
+	config, err := configparser.Read(file)
+	if err != nil {
+		return Limits{}, err
+	}
+	sections, err := config.AllSections()
+	if err != nil {
+		return Limits{}, err
+	}
+
+	result := NewLimits()
+
+	// the default section must be processed first, as all others inherit from it
+	type orgSection struct {
+		name    string
+		section *configparser.Section
+	}
+	var orgSections []orgSection
+	for _, s := range sections {
+		name := strings.Trim(strings.SplitN(s.String(), "\n", 2)[0], " []")
+		if name == "" || strings.HasPrefix(name, "#") {
+			continue
+		}
+		if name == "default" {
+			result.Default, err = readLimit(s, Limit{})
+			if err != nil {
+				return Limits{}, fmt.Errorf("[%s]: %s", name, err.Error())
+			}
+			continue
+		}
+		orgSections = append(orgSections, orgSection{name, s})
+	}
+
+	for _, s := range orgSections {
+		orgId, err := strconv.ParseUint(s.section.ValueOf("org-id"), 10, 32)
+		if err != nil || orgId < 1 {
+			return Limits{}, fmt.Errorf("[%s]: failed to parse org-id %q: must be a number >= 1", s.name, s.section.ValueOf("org-id"))
+		}
+		if _, ok := result.Orgs[uint32(orgId)]; ok {
+			return Limits{}, fmt.Errorf("[%s]: duplicate limits for org-id %d", s.name, orgId)
+		}
+		limit, err := readLimit(s.section, result.Default)
+		if err != nil {
+			return Limits{}, fmt.Errorf("[%s]: %s", s.name, err.Error())
+		}
+		result.Orgs[uint32(orgId)] = limit
+	}
+
+	return result, nil
+}
+
+// readLimit reads the limit settings from the section. settings that are not present are taken from base
+func readLimit(s *configparser.Section, base Limit) (Limit, error) {
+	limit := base
+	settings := []struct {
+		name string
+		val  *int
+	}{
+		{"max-active-series", &limit.MaxActiveSeries},
+		{"max-ingest-rate", &limit.MaxIngestRate},
+		{"max-series-per-req", &limit.MaxSeriesPerReq},
+		{"max-concurrent-queries", &limit.MaxConcurrentQueries},
+	}
+	for _, setting := range settings {
+		if !s.Exists(setting.name) {
+			continue
+		}
+		val, err := strconv.Atoi(s.ValueOf(setting.name))
+		if err != nil || val < 0 {
+			return Limit{}, fmt.Errorf("failed to parse %s %q: must be a number >= 0", setting.name, s.ValueOf(setting.name))
+		}
+		*setting.val = val
+	}
+	return limit, nil
+}
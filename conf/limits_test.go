@@ -0,0 +1,121 @@
+package conf
+
+import (
+	"io/ioutil"
+	"os"
+	"reflect"
+	"testing"
+)
+
+func TestReadLimits(t *testing.T) {
+	cases := []struct {
+		in        string
+		expErr    bool
+		expLimits Limits
+	}{
+		{
+			in:        ``,
+			expLimits: NewLimits(),
+		},
+		{
+			in: `
+[acme]
+org-id = 5
+max-active-series = 1000
+max-concurrent-queries = 0
+
+[default]
+max-active-series = 100
+max-ingest-rate = 10
+max-series-per-req = 50
+max-concurrent-queries = 2
+`,
+			expLimits: Limits{
+				Default: Limit{
+					MaxActiveSeries:      100,
+					MaxIngestRate:        10,
+					MaxSeriesPerReq:      50,
+					MaxConcurrentQueries: 2,
+				},
+				Orgs: map[uint32]Limit{
+					5: {
+						MaxActiveSeries:      1000,
+						MaxIngestRate:        10,
+						MaxSeriesPerReq:      50,
+						MaxConcurrentQueries: 0,
+					},
+				},
+			},
+		},
+		{
+			in: `
+[acme]
+max-active-series = 1000
+`,
+			expErr: true,
+		},
+		{
+			in: `
+[acme]
+org-id = 5
+max-active-series = 1000
+
+[acme2]
+org-id = 5
+max-active-series = 2000
+`,
+			expErr: true,
+		},
+		{
+			in: `
+[default]
+max-ingest-rate = -1
+`,
+			expErr: true,
+		},
+		{
+			in: `
+[default]
+max-ingest-rate = lots
+`,
+			expErr: true,
+		},
+	}
+	for i, c := range cases {
+		tmpfile, err := ioutil.TempFile("", "limits-test-readlimits")
+		if err != nil {
+			panic(err)
+		}
+
+		if _, err := tmpfile.Write([]byte(c.in)); err != nil {
+			panic(err)
+		}
+		if err := tmpfile.Close(); err != nil {
+			panic(err)
+		}
+
+		limits, err := ReadLimits(tmpfile.Name())
+		os.Remove(tmpfile.Name())
+		if (err != nil) != c.expErr {
+			t.Fatalf("case %d, exp err %t, got err %v", i, c.expErr, err)
+		}
+		if err == nil && !reflect.DeepEqual(limits, c.expLimits) {
+			t.Fatalf("case %d, exp limits %v, got %v", i, c.expLimits, limits)
+		}
+	}
+}
+
+func TestLimitsGet(t *testing.T) {
+	limits := Limits{
+		Default: Limit{MaxIngestRate: 10},
+		Orgs: map[uint32]Limit{
+			5: {MaxIngestRate: 20},
+		},
+	}
+	if limit := limits.Get(5); limit.MaxIngestRate != 20 {
+		t.Fatalf("expected the limit of org 5, got %v", limit)
+	}
+	if limit := limits.Get(6); limit.MaxIngestRate != 10 {
+		t.Fatalf("expected the default limit for org 6, got %v", limit)
+	}
+}
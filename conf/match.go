@@ -0,0 +1,121 @@
+package conf
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/alyu/configparser"
+	"github.com/grafana/metrictank/expr/tagquery"
+)
+
+// series is a series being matched against the schema or aggregation rules
+type series struct {
+	orgId  uint32
+	metric string // the name, with the tags if any, as in "name;key1=val1;key2=val2"
+	name   string
+	tags   map[string]string
+}
+
+func newSeries(orgId uint32, metric string) *series {
+	s := &series{
+		orgId:  orgId,
+		metric: metric,
+		name:   metric,
+	}
+	if pos := strings.IndexByte(metric, ';'); pos >= 0 {
+		s.name = metric[:pos]
+		s.tags = make(map[string]string)
+		for _, tag := range strings.Split(metric[pos+1:], ";") {
+			kv := strings.SplitN(tag, "=", 2)
+			if len(kv) == 2 {
+				s.tags[kv[0]] = kv[1]
+			}
+		}
+	}
+	return s
+}
+
+// value returns the value of the given tag, the name being the value of the "name" tag
+func (s *series) value(key string) (string, bool) {
+	if key == "name" {
+		return s.name, true
+	}
+	val, ok := s.tags[key]
+	return val, ok
+}
+
+// matchesExpression returns whether the series satisfies the given tag expression
+func (s *series) matchesExpression(e tagquery.Expression) bool {
+	if !e.OperatesOnTag() {
+		val, ok := s.value(e.GetKey())
+		if !ok {
+			return e.GetDefaultDecision() == tagquery.Pass
+		}
+		return e.Matches(val)
+	}
+
+	// expressions operating on the tag keys either require one of the keys to match
+	// (e.g. the tag must be present), or all of them (e.g. the tag must not be present)
+	any := e.GetDefaultDecision() == tagquery.Fail
+	if e.Matches("name") == any {
+		return any
+	}
+	for key := range s.tags {
+		if e.Matches(key) == any {
+			return any
+		}
+	}
+	return !any
+}
+
+// matches returns whether the series matches a rule with the given pattern, tag expressions and org.
+// the pattern is matched against the name including the tags, all expressions must be satisfied,
+// and an orgId of 0 means the rule applies to all orgs.
+func (s *series) matches(pattern *regexp.Regexp, tags tagquery.Expressions, orgId uint32) bool {
+	if orgId != 0 && orgId != s.orgId {
+		return false
+	}
+	for _, e := range tags {
+		if !s.matchesExpression(e) {
+			return false
+		}
+	}
+	return pattern.MatchString(s.metric)
+}
+
+// parseMatchers parses the pattern, tags and orgId settings of a schema or aggregation section.
+// tags are tagquery expressions separated by ';'. an empty pattern matches everything.
+func parseMatchers(name string, sec *configparser.Section) (*regexp.Regexp, tagquery.Expressions, uint32, error) {
+	var tags tagquery.Expressions
+	if tagsStr := sec.ValueOf("tags"); tagsStr != "" {
+		var exprs []string
+		for _, expr := range strings.Split(tagsStr, ";") {
+			if expr = strings.TrimSpace(expr); expr != "" {
+				exprs = append(exprs, expr)
+			}
+		}
+		var err error
+		tags, err = tagquery.ParseExpressions(exprs)
+		if err != nil {
+			return nil, nil, 0, fmt.Errorf("[%s]: failed to parse tags %q: %s", name, tagsStr, err.Error())
+		}
+	}
+
+	patternStr := sec.ValueOf("pattern")
+	pattern, err := regexp.Compile(patternStr)
+	if err != nil {
+		return nil, nil, 0, fmt.Errorf("[%s]: failed to parse pattern %q: %s", name, patternStr, err.Error())
+	}
+
+	var orgId uint64
+	if orgIdStr := sec.ValueOf("orgId"); orgIdStr != "" {
+		orgId, err = strconv.ParseUint(orgIdStr, 10, 32)
+		if err != nil {
+			return nil, nil, 0, fmt.Errorf("[%s]: failed to parse orgId %q: %s", name, orgIdStr, err.Error())
+		}
+	}
+
+	return pattern, tags, uint32(orgId), nil
+}
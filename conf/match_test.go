@@ -0,0 +1,66 @@
+package conf
+
+import (
+	"regexp"
+	"testing"
+
+	"github.com/grafana/metrictank/expr/tagquery"
+)
+
+func mustParseExpressions(exprs ...string) tagquery.Expressions {
+	e, err := tagquery.ParseExpressions(exprs)
+	if err != nil {
+		panic(err)
+	}
+	return e
+}
+
+func TestSeriesMatches(t *testing.T) {
+	tests := []struct {
+		expr   string
+		metric string
+		want   bool
+	}{
+		{"env=dev", "a.b;env=dev", true},
+		{"env=dev", "a.b;env=prod", false},
+		{"env=dev", "a.b", false},
+		{"env!=dev", "a.b;env=prod", true},
+		{"env!=dev", "a.b", true},
+		{"env!=dev", "a.b;env=dev", false},
+		{"env=~d.*", "a.b;env=dev", true},
+		{"env=~d.*", "a.b;env=prod", false},
+		{"env!=~d.*", "a.b;env=prod", true},
+		{"env!=~d.*", "a.b", true},
+		{"env^=de", "a.b;env=dev", true},
+		{"env!=", "a.b;env=dev", true},
+		{"env!=", "a.b", false},
+		{"env=", "a.b", true},
+		{"env=", "a.b;env=dev", false},
+		{"__tag=~^e", "a.b;env=dev", true},
+		{"__tag=~^e", "a.b;dc=us", false},
+		{"__tag^=en", "a.b;dc=us;env=dev", true},
+		{"name=a.b", "a.b;env=dev", true},
+		{"name=~^b", "a.b;env=dev", false},
+	}
+	all := regexp.MustCompile("")
+	for _, tt := range tests {
+		got := newSeries(1, tt.metric).matches(all, mustParseExpressions(tt.expr), 0)
+		if got != tt.want {
+			t.Errorf("expression %q on %q: expected %t, got %t", tt.expr, tt.metric, tt.want, got)
+		}
+	}
+}
+
+func TestSeriesMatchesOrg(t *testing.T) {
+	all := regexp.MustCompile("")
+	s := newSeries(3, "a.b")
+	if !s.matches(all, nil, 0) {
+		t.Errorf("expected a rule without org to match all orgs")
+	}
+	if !s.matches(all, nil, 3) {
+		t.Errorf("expected a rule of org 3 to match a series of org 3")
+	}
+	if s.matches(all, nil, 4) {
+		t.Errorf("expected a rule of org 4 not to match a series of org 3")
+	}
+}
@@ -0,0 +1,90 @@
+package conf
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/alyu/configparser"
+	"github.com/raintank/dur"
+)
+
+// RecordingRule is a graphite expression that is evaluated periodically,
+// and of which the results are ingested as new series
+type RecordingRule struct {
+	Name      string
+	Expr      string // the graphite expression to evaluate
+	Series    string // name of the series to write the results to. the tags of the results, other than name, are added to it
+	OrgId     uint32
+	Interval  uint32 // how often to evaluate the expression, in seconds. every evaluation covers the last interval
+	Delay     uint32 // how long to wait for the data of an interval to come in, before evaluating it, in seconds
+	Partition int32  // partition to write the results to. the rule is evaluated by the primary node(s) of this partition
+}
+
+// ReadRecordingRules returns the rules defined in a recording-rules.conf file
+func ReadRecordingRules(file string) ([]RecordingRule, error) {
+	config, err := configparser.Read(file)
+	if err != nil {
+		return nil, err
+	}
+	sections, err := config.AllSections()
+	if err != nil {
+		return nil, err
+	}
+
+	var rules []RecordingRule
+	for _, s := range sections {
+		name := strings.Trim(strings.SplitN(s.String(), "\n", 2)[0], " []")
+		if name == "" || strings.HasPrefix(name, "#") {
+			continue
+		}
+		rule, err := readRecordingRule(name, s)
+		if err != nil {
+			return nil, fmt.Errorf("[%s]: %s", name, err.Error())
+		}
+		rules = append(rules, rule)
+	}
+	return rules, nil
+}
+
+func readRecordingRule(name string, s *configparser.Section) (RecordingRule, error) {
+	rule := RecordingRule{
+		Name:   name,
+		Expr:   s.ValueOf("expr"),
+		Series: s.ValueOf("series"),
+	}
+	if rule.Expr == "" {
+		return RecordingRule{}, fmt.Errorf("expr is required")
+	}
+	if rule.Series == "" || strings.ContainsAny(rule.Series, "; ") {
+		return RecordingRule{}, fmt.Errorf("failed to parse series %q: must be a non-empty name, without tags", rule.Series)
+	}
+
+	orgId, err := strconv.ParseUint(s.ValueOf("org-id"), 10, 32)
+	if err != nil || orgId < 1 {
+		return RecordingRule{}, fmt.Errorf("failed to parse org-id %q: must be a number >= 1", s.ValueOf("org-id"))
+	}
+	rule.OrgId = uint32(orgId)
+
+	rule.Interval, err = dur.ParseNDuration(s.ValueOf("interval"))
+	if err != nil {
+		return RecordingRule{}, fmt.Errorf("failed to parse interval %q: %s", s.ValueOf("interval"), err.Error())
+	}
+
+	if s.Exists("delay") {
+		rule.Delay, err = dur.ParseDuration(s.ValueOf("delay"))
+		if err != nil {
+			return RecordingRule{}, fmt.Errorf("failed to parse delay %q: %s", s.ValueOf("delay"), err.Error())
+		}
+	}
+
+	if s.Exists("partition") {
+		partition, err := strconv.ParseInt(s.ValueOf("partition"), 10, 32)
+		if err != nil || partition < 0 {
+			return RecordingRule{}, fmt.Errorf("failed to parse partition %q: must be a number >= 0", s.ValueOf("partition"))
+		}
+		rule.Partition = int32(partition)
+	}
+
+	return rule, nil
+}
@@ -0,0 +1,126 @@
+package conf
+
+import (
+	"io/ioutil"
+	"os"
+	"reflect"
+	"testing"
+)
+
+func TestReadRecordingRules(t *testing.T) {
+	cases := []struct {
+		in       string
+		expErr   bool
+		expRules []RecordingRule
+	}{
+		{
+			in: ``,
+		},
+		{
+			in: `
+[requests-by-dc]
+expr = groupByTags(seriesByTag('name=requests.count'), 'sum', 'dc')
+series = requests.count.by_dc
+org-id = 1
+interval = 1min
+delay = 30s
+partition = 3
+
+[errors]
+expr = sumSeries(errors.*.count)
+series = errors.count
+org-id = 2
+interval = 10
+`,
+			expRules: []RecordingRule{
+				{
+					Name:      "requests-by-dc",
+					Expr:      "groupByTags(seriesByTag('name=requests.count'), 'sum', 'dc')",
+					Series:    "requests.count.by_dc",
+					OrgId:     1,
+					Interval:  60,
+					Delay:     30,
+					Partition: 3,
+				},
+				{
+					Name:     "errors",
+					Expr:     "sumSeries(errors.*.count)",
+					Series:   "errors.count",
+					OrgId:    2,
+					Interval: 10,
+				},
+			},
+		},
+		{
+			in: `
+[no-expr]
+series = errors.count
+org-id = 1
+interval = 10
+`,
+			expErr: true,
+		},
+		{
+			in: `
+[tagged-series]
+expr = sumSeries(errors.*.count)
+series = errors.count;env=prod
+org-id = 1
+interval = 10
+`,
+			expErr: true,
+		},
+		{
+			in: `
+[no-org]
+expr = sumSeries(errors.*.count)
+series = errors.count
+interval = 10
+`,
+			expErr: true,
+		},
+		{
+			in: `
+[zero-interval]
+expr = sumSeries(errors.*.count)
+series = errors.count
+org-id = 1
+interval = 0
+`,
+			expErr: true,
+		},
+		{
+			in: `
+[bad-partition]
+expr = sumSeries(errors.*.count)
+series = errors.count
+org-id = 1
+interval = 10
+partition = -1
+`,
+			expErr: true,
+		},
+	}
+	for i, c := range cases {
+		tmpfile, err := ioutil.TempFile("", "recording-rules-test-readrecordingrules")
+		if err != nil {
+			panic(err)
+		}
+
+		if _, err := tmpfile.Write([]byte(c.in)); err != nil {
+			panic(err)
+		}
+		if err := tmpfile.Close(); err != nil {
+			panic(err)
+		}
+
+		rules, err := ReadRecordingRules(tmpfile.Name())
+		os.Remove(tmpfile.Name())
+		if (err != nil) != c.expErr {
+			t.Fatalf("case %d, exp err %t, got err %v", i, c.expErr, err)
+		}
+		if err == nil && !reflect.DeepEqual(rules, c.expRules) {
+			t.Fatalf("case %d, exp rules %v, got %v", i, c.expRules, rules)
+		}
+	}
+}
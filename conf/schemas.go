@@ -8,6 +8,7 @@ import (
 	"strings"
 
 	"github.com/alyu/configparser"
+	"github.com/grafana/metrictank/expr/tagquery"
 	"github.com/grafana/metrictank/util"
 )
 
@@ -28,9 +29,12 @@ func (s SchemaSlice) Less(i, j int) bool { return s[i].Priority >= s[j].Priority
 type Schema struct {
 	Name          string
 	Pattern       *regexp.Regexp
+	Tags          tagquery.Expressions // if set, series must also satisfy all these expressions
+	OrgId         uint32               // if not 0, the schema only applies to series of this org
 	Retentions    Retentions
 	Priority      int64
 	ReorderWindow uint32
+	DecimalChunks bool // use the decimal value compression for new chunks
 }
 
 func NewSchemas(schemas []Schema) Schemas {
@@ -57,19 +61,25 @@ func (s *Schemas) BuildIndex() {
 			s.index = append(s.index, Schema{
 				Name:          schema.Name,
 				Pattern:       schema.Pattern,
+				Tags:          schema.Tags,
+				OrgId:         schema.OrgId,
 				Retentions:    schema.Retentions.Sub(pos),
 				Priority:      schema.Priority,
 				ReorderWindow: schema.ReorderWindow,
+				DecimalChunks: schema.DecimalChunks,
 			})
 		}
 	}
 	// add the default schema
 	for pos := range s.DefaultSchema.Retentions.Rets {
 		s.index = append(s.index, Schema{
-			Name:       s.DefaultSchema.Name,
-			Pattern:    s.DefaultSchema.Pattern,
-			Retentions: s.DefaultSchema.Retentions.Sub(pos),
-			Priority:   s.DefaultSchema.Priority,
+			Name:          s.DefaultSchema.Name,
+			Pattern:       s.DefaultSchema.Pattern,
+			Tags:          s.DefaultSchema.Tags,
+			OrgId:         s.DefaultSchema.OrgId,
+			Retentions:    s.DefaultSchema.Retentions.Sub(pos),
+			Priority:      s.DefaultSchema.Priority,
+			DecimalChunks: s.DefaultSchema.DecimalChunks,
 		})
 	}
 }
@@ -96,12 +106,13 @@ func ReadSchemas(file string) (Schemas, error) {
 			continue
 		}
 
-		if sec.ValueOf("pattern") == "" {
-			return Schemas{}, fmt.Errorf("[%s]: empty pattern", schema.Name)
-		}
-		schema.Pattern, err = regexp.Compile(sec.ValueOf("pattern"))
+		schema.Pattern, schema.Tags, schema.OrgId, err = parseMatchers(schema.Name, sec)
 		if err != nil {
-			return Schemas{}, fmt.Errorf("[%s]: failed to parse pattern %q: %s", schema.Name, sec.ValueOf("pattern"), err.Error())
+			return Schemas{}, err
+		}
+		// a schema must explicitly say what it applies to. the pattern may only be omitted if it has tags
+		if sec.ValueOf("pattern") == "" && len(schema.Tags) == 0 {
+			return Schemas{}, fmt.Errorf("[%s]: empty pattern", schema.Name)
 		}
 
 		schema.Retentions, err = ParseRetentions(sec.ValueOf("retentions"))
@@ -131,6 +142,14 @@ func ReadSchemas(file string) (Schemas, error) {
 			}
 		}
 
+		decimalChunksStr := sec.ValueOf("decimalChunks")
+		if len(decimalChunksStr) > 0 {
+			schema.DecimalChunks, err = strconv.ParseBool(decimalChunksStr)
+			if err != nil {
+				return Schemas{}, fmt.Errorf("[%s]: Failed to parse decimalChunks conf, expected a bool: %s", schema.Name, decimalChunksStr)
+			}
+		}
+
 		schemas = append(schemas, schema)
 	}
 
@@ -139,7 +158,8 @@ func ReadSchemas(file string) (Schemas, error) {
 	return NewSchemas(schemas), nil
 }
 
-// Match returns the correct schema setting for the given metric
+// Match returns the correct schema setting for the given metric of the given org.
+// metric is the name including the tags, if any, as in "name;key1=val1;key2=val2"
 // it can always find a valid setting, because there's a default catch all
 // also returns the index of the setting, to efficiently reference it.
 //
@@ -159,7 +179,7 @@ func ReadSchemas(file string) (Schemas, error) {
 // |---------------------------------------------------------------------|
 //
 // When evaluating a match we start with the first schema in the index and
-// compare the regex pattern.
+// compare the regex pattern, as well as the tag expressions and org, if set.
 // - If it matches we then just find the retention set with the best fit. The
 //   best fit is when the interval is >= the rawInterval (first retention) and
 //   less then the interval of the next rollup.
@@ -169,11 +189,12 @@ func ReadSchemas(file string) (Schemas, error) {
 //     (pattern1), if it doesnt match we will then compare the pattern of
 //     schema2 (pattern2) and if that doesnt match we would try schema5
 //     (pattern3).
-func (s Schemas) Match(metric string, interval int) (uint16, Schema) {
+func (s Schemas) Match(orgId uint32, metric string, interval int) (uint16, Schema) {
+	series := newSeries(orgId, metric)
 	i := 0
 	for i < len(s.index) {
 		schema := s.index[i]
-		if schema.Pattern.MatchString(metric) {
+		if series.matches(schema.Pattern, schema.Tags, schema.OrgId) {
 			// no interval passed,use the raw retentions.
 			// This is primarily used by the carbon input plugin.
 			if interval == 0 {
@@ -44,25 +44,25 @@ func TestMatch(t *testing.T) {
 	schemas := schemasForTest()
 	Convey("When matching against first schema", t, func() {
 		Convey("When metric has 1s raw interval", func() {
-			id, schema := schemas.Match("a.foo", 1)
+			id, schema := schemas.Match(1, "a.foo", 1)
 			So(id, ShouldEqual, 0)
 			So(schema.Name, ShouldEqual, "a")
 			So(schema.Retentions.Rets[0].SecondsPerPoint, ShouldEqual, 10)
 		})
 		Convey("When metric has 10s raw interval", func() {
-			id, schema := schemas.Match("a.foo", 10)
+			id, schema := schemas.Match(1, "a.foo", 10)
 			So(id, ShouldEqual, 0)
 			So(schema.Name, ShouldEqual, "a")
 			So(schema.Retentions.Rets[0].SecondsPerPoint, ShouldEqual, 10)
 		})
 		Convey("When metric has 30s raw interval", func() {
-			id, schema := schemas.Match("a.foo", 30)
+			id, schema := schemas.Match(1, "a.foo", 30)
 			So(id, ShouldEqual, 0)
 			So(schema.Name, ShouldEqual, "a")
 			So(schema.Retentions.Rets[0].SecondsPerPoint, ShouldEqual, 10)
 		})
 		Convey("When metric has 2h raw interval", func() {
-			id, schema := schemas.Match("a.foo", 7200)
+			id, schema := schemas.Match(1, "a.foo", 7200)
 			So(id, ShouldEqual, 1)
 			So(schema.Name, ShouldEqual, "a")
 			So(schema.Retentions.Rets[0].SecondsPerPoint, ShouldEqual, 3600)
@@ -70,25 +70,25 @@ func TestMatch(t *testing.T) {
 	})
 	Convey("When matching against second schema", t, func() {
 		Convey("When metric has 1s raw interval", func() {
-			id, schema := schemas.Match("b.foo", 1)
+			id, schema := schemas.Match(1, "b.foo", 1)
 			So(id, ShouldEqual, 2)
 			So(schema.Name, ShouldEqual, "b")
 			So(schema.Retentions.Rets[0].SecondsPerPoint, ShouldEqual, 1)
 		})
 		Convey("When metric has 10s raw interval", func() {
-			id, schema := schemas.Match("b.foo", 10)
+			id, schema := schemas.Match(1, "b.foo", 10)
 			So(id, ShouldEqual, 2)
 			So(schema.Name, ShouldEqual, "b")
 			So(schema.Retentions.Rets[0].SecondsPerPoint, ShouldEqual, 1)
 		})
 		Convey("When metric has 30s raw interval", func() {
-			id, schema := schemas.Match("b.foo", 30)
+			id, schema := schemas.Match(1, "b.foo", 30)
 			So(id, ShouldEqual, 3)
 			So(schema.Name, ShouldEqual, "b")
 			So(schema.Retentions.Rets[0].SecondsPerPoint, ShouldEqual, 30)
 		})
 		Convey("When metric has 2h raw interval", func() {
-			id, schema := schemas.Match("b.foo", 7200)
+			id, schema := schemas.Match(1, "b.foo", 7200)
 			So(id, ShouldEqual, 4)
 			So(schema.Name, ShouldEqual, "b")
 			So(schema.Retentions.Rets[0].SecondsPerPoint, ShouldEqual, 600)
@@ -96,25 +96,25 @@ func TestMatch(t *testing.T) {
 	})
 	Convey("When matching against default schema", t, func() {
 		Convey("When metric has 1s raw interval", func() {
-			id, schema := schemas.Match("c.foo", 1)
+			id, schema := schemas.Match(1, "c.foo", 1)
 			So(id, ShouldEqual, 5)
 			So(schema.Name, ShouldEqual, "default")
 			So(schema.Retentions.Rets[0].SecondsPerPoint, ShouldEqual, 1)
 		})
 		Convey("When metric has 10s raw interval", func() {
-			id, schema := schemas.Match("c.foo", 10)
+			id, schema := schemas.Match(1, "c.foo", 10)
 			So(id, ShouldEqual, 5)
 			So(schema.Name, ShouldEqual, "default")
 			So(schema.Retentions.Rets[0].SecondsPerPoint, ShouldEqual, 1)
 		})
 		Convey("When metric has 30s raw interval", func() {
-			id, schema := schemas.Match("c.foo", 60)
+			id, schema := schemas.Match(1, "c.foo", 60)
 			So(id, ShouldEqual, 6)
 			So(schema.Name, ShouldEqual, "default")
 			So(schema.Retentions.Rets[0].SecondsPerPoint, ShouldEqual, 60)
 		})
 		Convey("When metric has 2h raw interval", func() {
-			id, schema := schemas.Match("c.foo", 7200)
+			id, schema := schemas.Match(1, "c.foo", 7200)
 			So(id, ShouldEqual, 8)
 			So(schema.Name, ShouldEqual, "default")
 			So(schema.Retentions.Rets[0].SecondsPerPoint, ShouldEqual, 3600)
@@ -136,7 +136,7 @@ func TestDefaultSchema(t *testing.T) {
 
 	Convey("When matching against first schema", t, func() {
 		Convey("When metric has 1s raw interval", func() {
-			id, schema := schemas.Match("a.foo", 1)
+			id, schema := schemas.Match(1, "a.foo", 1)
 			So(id, ShouldEqual, 0)
 			So(schema.Name, ShouldEqual, "a")
 			So(schema.Retentions.Rets[0].SecondsPerPoint, ShouldEqual, 10)
@@ -144,7 +144,7 @@ func TestDefaultSchema(t *testing.T) {
 	})
 	Convey("When series doesnt match any schema", t, func() {
 		Convey("When metric has 10s raw interval", func() {
-			id, schema := schemas.Match("d.foo", 10)
+			id, schema := schemas.Match(1, "d.foo", 10)
 			So(id, ShouldEqual, 2)
 			So(schema.Name, ShouldEqual, "default")
 			So(schema.Retentions.Rets[0].SecondsPerPoint, ShouldEqual, 1)
@@ -152,6 +152,44 @@ func TestDefaultSchema(t *testing.T) {
 	})
 }
 
+func TestMatchTagsAndOrg(t *testing.T) {
+	schemas := NewSchemas([]Schema{
+		{
+			Name:       "dev-org5",
+			Pattern:    regexp.MustCompile(""),
+			Tags:       mustParseExpressions("env=dev"),
+			OrgId:      5,
+			Retentions: BuildFromRetentions(NewRetentionMT(10, 3600, 60*10, 0, 0)),
+		},
+		{
+			Name:       "a-prod",
+			Pattern:    regexp.MustCompile("^a\\."),
+			Tags:       mustParseExpressions("env!=dev"),
+			Retentions: BuildFromRetentions(NewRetentionMT(60, 86400, 60*60, 0, 0)),
+		},
+	})
+
+	Convey("When matching tagged series", t, func() {
+		Convey("the org scoped schema only applies to its org", func() {
+			id, schema := schemas.Match(5, "b.foo;env=dev", 10)
+			So(id, ShouldEqual, 0)
+			So(schema.Name, ShouldEqual, "dev-org5")
+			_, schema = schemas.Match(1, "b.foo;env=dev", 10)
+			So(schema.Name, ShouldEqual, "default")
+		})
+		Convey("the tag expressions must all be satisfied", func() {
+			_, schema := schemas.Match(5, "a.foo;env=dev", 10)
+			So(schema.Name, ShouldEqual, "dev-org5")
+			_, schema = schemas.Match(1, "a.foo;env=dev", 10)
+			So(schema.Name, ShouldEqual, "default")
+			_, schema = schemas.Match(1, "a.foo;env=prod", 10)
+			So(schema.Name, ShouldEqual, "a-prod")
+			_, schema = schemas.Match(1, "a.foo", 10)
+			So(schema.Name, ShouldEqual, "a-prod")
+		})
+	})
+}
+
 func TestTTLs(t *testing.T) {
 	schemas := schemasForTest()
 	Convey("When getting list of TTLS", t, func() {
@@ -198,6 +236,32 @@ func TestReadSchemas(t *testing.T) {
 			want:    Schemas{},
 			wantErr: true,
 		},
+		{
+			name:    "bad_tags",
+			file:    "schemas_test_files/bad_tags.schemas",
+			want:    Schemas{},
+			wantErr: true,
+		},
+		{
+			name: "tags",
+			file: "schemas_test_files/tags.schemas",
+			want: NewSchemas([]Schema{
+				{
+					Name:    "dev",
+					Pattern: regexp.MustCompile(""),
+					Tags:    mustParseExpressions("env=dev", "tier=~gold|silver"),
+					OrgId:   5,
+					Retentions: Retentions{
+						Orig: "1s:1d:10min:2",
+						Rets: []Retention{
+							NewRetentionMT(1, 24*60*60, 10*60, 2, 0),
+						},
+					},
+					Priority: -1,
+				},
+			}),
+			wantErr: false,
+		},
 		{
 			name: "simple",
 			file: "schemas_test_files/simple.schemas",
@@ -241,6 +305,26 @@ func TestReadSchemas(t *testing.T) {
 			}),
 			wantErr: false,
 		},
+		{
+			name: "decimal_chunks",
+			file: "schemas_test_files/decimal_chunks.schemas",
+			want: NewSchemas([]Schema{
+				{
+					Name:    "default",
+					Pattern: regexp.MustCompile(".*"),
+					Retentions: Retentions{
+						Orig: "1s:8d:10min:2,1m:35d:2h:2",
+						Rets: []Retention{
+							NewRetentionMT(1, 8*24*60*60, 10*60, 2, 0),
+							NewRetentionMT(1*60, 35*24*60*60, 2*60*60, 2, 0),
+						},
+					},
+					Priority:      -1,
+					DecimalChunks: true,
+				},
+			}),
+			wantErr: false,
+		},
 		{
 			name: "multiple",
 			file: "schemas_test_files/multiple.schemas",
@@ -3,6 +3,8 @@ package expr
 import (
 	"fmt"
 	"regexp"
+	"sort"
+	"strconv"
 	"strings"
 
 	"github.com/grafana/metrictank/errors"
@@ -57,6 +59,38 @@ func (e expr) Print(indent int) string {
 	return "HUH-SHOULD-NEVER-HAPPEN"
 }
 
+// canonical returns a string representation of the expression which is the same
+// for all equivalent expressions, regardless of whitespace, quoting or order of keyword args
+func (e expr) canonical() string {
+	switch e.etype {
+	case etName:
+		return e.str
+	case etBool:
+		return strconv.FormatBool(e.bool)
+	case etInt:
+		return strconv.FormatInt(e.int, 10)
+	case etFloat:
+		return strconv.FormatFloat(e.float, 'g', -1, 64)
+	case etString:
+		return strconv.Quote(e.str)
+	case etFunc:
+		var args []string
+		for _, a := range e.args {
+			args = append(args, a.canonical())
+		}
+		keys := make([]string, 0, len(e.namedArgs))
+		for k := range e.namedArgs {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			args = append(args, k+"="+e.namedArgs[k].canonical())
+		}
+		return e.str + "(" + strings.Join(args, ",") + ")"
+	}
+	return "HUH-SHOULD-NEVER-HAPPEN"
+}
+
 // consumeBasicArg verifies that the argument at given pos matches the expected arg
 // it's up to the caller to assure that given pos is valid before calling.
 // if arg allows for multiple arguments, pos is advanced to cover all accepted arguments.
@@ -225,7 +259,7 @@ func generateValidatorError(key string, err error) error {
 // but for non-basic args (meaning a series, seriesList or seriesLists) the
 // appropriate value(s) will be assigned to exp.val
 // the returned pos is always the index where the next argument should be.
-func (e expr) consumeSeriesArg(pos int, exp Arg, context Context, stable bool, reqs []Req) (int, []Req, error) {
+func (e expr) consumeSeriesArg(pos int, exp Arg, context Context, stable bool, reqs []Req, shared map[string]*FuncShared) (int, []Req, error) {
 	got := e.args[pos]
 	var err error
 	var fn GraphiteFunc
@@ -241,7 +275,7 @@ Switch:
 			for _, a := range v.args {
 				switch v := a.(type) {
 				case ArgSeries, ArgSeriesList, ArgSeriesLists:
-					p, reqs, err := e.consumeSeriesArg(pos, v, context, stable, reqs)
+					p, reqs, err := e.consumeSeriesArg(pos, v, context, stable, reqs, shared)
 					if err != nil {
 						return 0, nil, err
 					}
@@ -261,7 +295,7 @@ Switch:
 		if got.etype != etName && got.etype != etFunc {
 			return 0, nil, ErrBadArgumentStr{"func or name", got.etype.String()}
 		}
-		fn, reqs, err = newplan(got, context, stable, reqs)
+		fn, reqs, err = newplan(got, context, stable, reqs, shared)
 		if err != nil {
 			return 0, nil, err
 		}
@@ -270,7 +304,7 @@ Switch:
 		if got.etype != etName && got.etype != etFunc {
 			return 0, nil, ErrBadArgumentStr{"func or name", got.etype.String()}
 		}
-		fn, reqs, err = newplan(got, context, stable, reqs)
+		fn, reqs, err = newplan(got, context, stable, reqs, shared)
 		if err != nil {
 			return 0, nil, err
 		}
@@ -279,7 +313,7 @@ Switch:
 		if got.etype != etName && got.etype != etFunc {
 			return 0, nil, ErrBadArgumentStr{"func or name", got.etype.String()}
 		}
-		fn, reqs, err = newplan(got, context, stable, reqs)
+		fn, reqs, err = newplan(got, context, stable, reqs, shared)
 		if err != nil {
 			return 0, nil, err
 		}
@@ -287,7 +321,7 @@ Switch:
 		// special case! consume all subsequent args (if any) in args that will also yield a seriesList
 		for len(e.args) > pos+1 && (e.args[pos+1].etype == etName || e.args[pos+1].etype == etFunc) {
 			pos++
-			fn, reqs, err = newplan(e.args[pos], context, stable, reqs)
+			fn, reqs, err = newplan(e.args[pos], context, stable, reqs, shared)
 			if err != nil {
 				return 0, nil, err
 			}
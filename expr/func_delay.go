@@ -0,0 +1,57 @@
+package expr
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+
+	"github.com/grafana/metrictank/api/models"
+	"github.com/grafana/metrictank/schema"
+)
+
+type FuncDelay struct {
+	in    GraphiteFunc
+	steps int64
+}
+
+func NewDelay() GraphiteFunc {
+	return &FuncDelay{}
+}
+
+func (s *FuncDelay) Signature() ([]Arg, []Arg) {
+	return []Arg{
+		ArgSeriesList{val: &s.in},
+		ArgInt{key: "steps", val: &s.steps, validator: []Validator{IntNonNegative}},
+	}, []Arg{ArgSeriesList{}}
+}
+
+func (s *FuncDelay) Context(context Context) Context {
+	return context
+}
+
+func (s *FuncDelay) Exec(cache map[Req][]models.Series) ([]models.Series, error) {
+	series, err := s.in.Exec(cache)
+	if err != nil {
+		return nil, err
+	}
+	steps := int(s.steps)
+	outputs := make([]models.Series, 0, len(series))
+	for _, serie := range series {
+		out := pointSlicePool.Get().([]schema.Point)
+		for i, p := range serie.Datapoints {
+			val := math.NaN()
+			if i >= steps {
+				val = serie.Datapoints[i-steps].Val
+			}
+			out = append(out, schema.Point{Val: val, Ts: p.Ts})
+		}
+		output := serie
+		output.Target = fmt.Sprintf("delay(%s,%d)", serie.Target, s.steps)
+		output.QueryPatt = fmt.Sprintf("delay(%s,%d)", serie.QueryPatt, s.steps)
+		output.Tags = serie.CopyTagsWith("delay", strconv.FormatInt(s.steps, 10))
+		output.Datapoints = out
+		outputs = append(outputs, output)
+	}
+	cache[Req{}] = append(cache[Req{}], outputs...)
+	return outputs, nil
+}
@@ -0,0 +1,113 @@
+package expr
+
+import (
+	"math"
+	"strconv"
+	"testing"
+
+	"github.com/grafana/metrictank/api/models"
+	"github.com/grafana/metrictank/schema"
+)
+
+func TestDelayZero(t *testing.T) {
+	testDelay(
+		0,
+		[]models.Series{
+			{
+				Interval:   10,
+				Target:     "a",
+				Datapoints: getCopy(a),
+			},
+		},
+		[]models.Series{
+			{
+				Interval:   10,
+				Target:     "delay(a,0)",
+				Datapoints: getCopy(a),
+			},
+		},
+		t,
+	)
+}
+
+func TestDelayTwo(t *testing.T) {
+	out := []schema.Point{
+		{Val: math.NaN(), Ts: 10},
+		{Val: math.NaN(), Ts: 20},
+		{Val: 0, Ts: 30},
+		{Val: 0, Ts: 40},
+		{Val: 5.5, Ts: 50},
+		{Val: math.NaN(), Ts: 60},
+	}
+	testDelay(
+		2,
+		[]models.Series{
+			{
+				Interval:   10,
+				Target:     "a",
+				Datapoints: getCopy(a),
+			},
+		},
+		[]models.Series{
+			{
+				Interval:   10,
+				Target:     "delay(a,2)",
+				Datapoints: out,
+			},
+		},
+		t,
+	)
+}
+
+func TestDelayBeyondLength(t *testing.T) {
+	testDelay(
+		10,
+		[]models.Series{
+			{
+				Interval:   10,
+				Target:     "b",
+				Datapoints: getCopy(b),
+			},
+		},
+		[]models.Series{
+			{
+				Interval:   10,
+				Target:     "delay(b,10)",
+				Datapoints: getCopy(allNulls),
+			},
+		},
+		t,
+	)
+}
+
+func testDelay(steps int64, in []models.Series, out []models.Series, t *testing.T) {
+	f := NewDelay()
+	f.(*FuncDelay).in = NewMock(in)
+	f.(*FuncDelay).steps = steps
+	gots, err := f.Exec(make(map[Req][]models.Series))
+	if err != nil {
+		t.Fatalf("case %d: err should be nil. got %q", steps, err)
+	}
+	if len(gots) != len(out) {
+		t.Fatalf("case %d: len output expected %d, got %d", steps, len(out), len(gots))
+	}
+	for i, g := range gots {
+		exp := out[i]
+		if g.Target != exp.Target {
+			t.Fatalf("case %d: expected target %q, got %q", steps, exp.Target, g.Target)
+		}
+		if g.Tags["delay"] != strconv.FormatInt(steps, 10) {
+			t.Fatalf("case %d: expected delay tag %d, got %q", steps, steps, g.Tags["delay"])
+		}
+		if len(g.Datapoints) != len(exp.Datapoints) {
+			t.Fatalf("case %d: len output expected %d, got %d", steps, len(exp.Datapoints), len(g.Datapoints))
+		}
+		for j, p := range g.Datapoints {
+			bothNaN := math.IsNaN(p.Val) && math.IsNaN(exp.Datapoints[j].Val)
+			if (bothNaN || p.Val == exp.Datapoints[j].Val) && p.Ts == exp.Datapoints[j].Ts {
+				continue
+			}
+			t.Fatalf("case %d: output point %d - expected %v got %v", steps, j, exp.Datapoints[j], p)
+		}
+	}
+}
@@ -0,0 +1,289 @@
+package expr
+
+import (
+	"fmt"
+	"math"
+
+	"github.com/grafana/metrictank/api/models"
+	"github.com/grafana/metrictank/schema"
+	"github.com/raintank/dur"
+)
+
+// holt-winters smoothing parameters, as used by graphite
+const (
+	hwAlpha = 0.1
+	hwBeta  = 0.0035
+	hwGamma = 0.1
+)
+
+// holtWintersAnalysis runs the holt-winters triple exponential smoothing over the given points,
+// and returns the predictions and deviations for each input point.
+// seasonLength is expressed in number of points.
+// missing input values (NaN) break the math, so we do the best we can and move on, like graphite does.
+func holtWintersAnalysis(points []schema.Point, seasonLength int) ([]float64, []float64) {
+	if seasonLength < 1 {
+		seasonLength = 1
+	}
+	intercepts := make([]float64, 0, len(points))
+	slopes := make([]float64, 0, len(points))
+	seasonals := make([]float64, 0, len(points))
+	predictions := make([]float64, 0, len(points))
+	deviations := make([]float64, 0, len(points))
+
+	getLastSeasonal := func(i int) float64 {
+		j := i - seasonLength
+		if j >= 0 {
+			return seasonals[j]
+		}
+		return 0
+	}
+	getLastDeviation := func(i int) float64 {
+		j := i - seasonLength
+		if j >= 0 {
+			return deviations[j]
+		}
+		return 0
+	}
+
+	nextPred := math.NaN()
+	for i, p := range points {
+		actual := p.Val
+		if math.IsNaN(actual) {
+			intercepts = append(intercepts, math.NaN())
+			slopes = append(slopes, 0)
+			seasonals = append(seasonals, 0)
+			predictions = append(predictions, nextPred)
+			deviations = append(deviations, 0)
+			nextPred = math.NaN()
+			continue
+		}
+
+		var lastIntercept, lastSlope, prediction float64
+		if i == 0 {
+			lastIntercept = actual
+			lastSlope = 0
+			// seed the first prediction as the first actual
+			prediction = actual
+		} else {
+			lastIntercept = intercepts[i-1]
+			lastSlope = slopes[i-1]
+			if math.IsNaN(lastIntercept) {
+				lastIntercept = actual
+			}
+			prediction = nextPred
+		}
+
+		lastSeasonal := getLastSeasonal(i)
+		nextLastSeasonal := getLastSeasonal(i + 1)
+		lastSeasonalDev := getLastDeviation(i)
+
+		intercept := hwAlpha*(actual-lastSeasonal) + (1-hwAlpha)*(lastIntercept+lastSlope)
+		slope := hwBeta*(intercept-lastIntercept) + (1-hwBeta)*lastSlope
+		seasonal := hwGamma*(actual-intercept) + (1-hwGamma)*lastSeasonal
+		nextPred = intercept + slope + nextLastSeasonal
+
+		predForDev := prediction
+		if math.IsNaN(predForDev) {
+			predForDev = 0
+		}
+		deviation := hwGamma*math.Abs(actual-predForDev) + (1-hwGamma)*lastSeasonalDev
+
+		intercepts = append(intercepts, intercept)
+		slopes = append(slopes, slope)
+		seasonals = append(seasonals, seasonal)
+		predictions = append(predictions, prediction)
+		deviations = append(deviations, deviation)
+	}
+	return predictions, deviations
+}
+
+// holtWintersBase holds the parameters and context handling common to all holt-winters functions:
+// they request an additional bootstrapInterval worth of data before the requested window, to train the model with.
+type holtWintersBase struct {
+	in                GraphiteFunc
+	bootstrapInterval string
+	seasonality       string
+
+	from uint32 // the original from of the request, before adding the bootstrap
+}
+
+func newHoltWintersBase() holtWintersBase {
+	return holtWintersBase{
+		bootstrapInterval: "7d",
+		seasonality:       "1d",
+	}
+}
+
+func (s *holtWintersBase) Context(context Context) Context {
+	s.from = context.from
+	bootstrap, _ := dur.ParseDuration(s.bootstrapInterval)
+	if bootstrap > context.from {
+		context.from = 0
+	} else {
+		context.from -= bootstrap
+	}
+	return context
+}
+
+// analyze runs the holt-winters analysis over the given series and returns the predictions and deviations
+// for the points that fall within the originally requested window, as well as the offset of the first
+// such point within serie.Datapoints
+func (s *holtWintersBase) analyze(serie models.Series) ([]float64, []float64, int) {
+	var seasonLength int
+	if serie.Interval != 0 {
+		seasonality, _ := dur.ParseDuration(s.seasonality)
+		seasonLength = int(seasonality / serie.Interval)
+	}
+	predictions, deviations := holtWintersAnalysis(serie.Datapoints, seasonLength)
+	start := 0
+	for start < len(serie.Datapoints) && serie.Datapoints[start].Ts < s.from {
+		start++
+	}
+	return predictions[start:], deviations[start:], start
+}
+
+// newSeries creates an output series from the given input series, for the originally requested window
+func (s *holtWintersBase) newSeries(serie models.Series, name string, points []schema.Point) models.Series {
+	return models.Series{
+		Target:       fmt.Sprintf("%s(%s)", name, serie.Target),
+		QueryPatt:    fmt.Sprintf("%s(%s)", name, serie.QueryPatt),
+		Tags:         serie.CopyTagsWith(name, "1"),
+		Interval:     serie.Interval,
+		QueryFrom:    s.from,
+		QueryTo:      serie.QueryTo,
+		QueryCons:    serie.QueryCons,
+		Consolidator: serie.Consolidator,
+		Meta:         serie.Meta,
+		Datapoints:   points,
+	}
+}
+
+// confidenceBands returns the lower and upper confidence band points for the given series
+func (s *holtWintersBase) confidenceBands(serie models.Series, delta float64) ([]schema.Point, []schema.Point, int) {
+	predictions, deviations, start := s.analyze(serie)
+	lower := pointSlicePool.Get().([]schema.Point)
+	upper := pointSlicePool.Get().([]schema.Point)
+	for i, forecast := range predictions {
+		ts := serie.Datapoints[start+i].Ts
+		// note that deviations are never NaN
+		scaledDeviation := delta * deviations[i]
+		lower = append(lower, schema.Point{Val: forecast - scaledDeviation, Ts: ts})
+		upper = append(upper, schema.Point{Val: forecast + scaledDeviation, Ts: ts})
+	}
+	return lower, upper, start
+}
+
+type FuncHoltWintersForecast struct {
+	holtWintersBase
+}
+
+func NewHoltWintersForecast() GraphiteFunc {
+	return &FuncHoltWintersForecast{newHoltWintersBase()}
+}
+
+func (s *FuncHoltWintersForecast) Signature() ([]Arg, []Arg) {
+	return []Arg{
+		ArgSeriesList{val: &s.in},
+		ArgString{key: "bootstrapInterval", opt: true, val: &s.bootstrapInterval, validator: []Validator{IsIntervalString}},
+		ArgString{key: "seasonality", opt: true, val: &s.seasonality, validator: []Validator{IsIntervalString}},
+	}, []Arg{ArgSeriesList{}}
+}
+
+func (s *FuncHoltWintersForecast) Exec(cache map[Req][]models.Series) ([]models.Series, error) {
+	series, err := s.in.Exec(cache)
+	if err != nil {
+		return nil, err
+	}
+	outputs := make([]models.Series, 0, len(series))
+	for _, serie := range series {
+		predictions, _, start := s.analyze(serie)
+		out := pointSlicePool.Get().([]schema.Point)
+		for i, pred := range predictions {
+			out = append(out, schema.Point{Val: pred, Ts: serie.Datapoints[start+i].Ts})
+		}
+		outputs = append(outputs, s.newSeries(serie, "holtWintersForecast", out))
+	}
+	cache[Req{}] = append(cache[Req{}], outputs...)
+	return outputs, nil
+}
+
+type FuncHoltWintersConfidenceBands struct {
+	holtWintersBase
+	delta float64
+}
+
+func NewHoltWintersConfidenceBands() GraphiteFunc {
+	return &FuncHoltWintersConfidenceBands{holtWintersBase: newHoltWintersBase(), delta: 3}
+}
+
+func (s *FuncHoltWintersConfidenceBands) Signature() ([]Arg, []Arg) {
+	return []Arg{
+		ArgSeriesList{val: &s.in},
+		ArgFloat{key: "delta", opt: true, val: &s.delta},
+		ArgString{key: "bootstrapInterval", opt: true, val: &s.bootstrapInterval, validator: []Validator{IsIntervalString}},
+		ArgString{key: "seasonality", opt: true, val: &s.seasonality, validator: []Validator{IsIntervalString}},
+	}, []Arg{ArgSeriesList{}}
+}
+
+func (s *FuncHoltWintersConfidenceBands) Exec(cache map[Req][]models.Series) ([]models.Series, error) {
+	series, err := s.in.Exec(cache)
+	if err != nil {
+		return nil, err
+	}
+	outputs := make([]models.Series, 0, 2*len(series))
+	for _, serie := range series {
+		lower, upper, _ := s.confidenceBands(serie, s.delta)
+		outputs = append(outputs,
+			s.newSeries(serie, "holtWintersConfidenceLower", lower),
+			s.newSeries(serie, "holtWintersConfidenceUpper", upper),
+		)
+	}
+	cache[Req{}] = append(cache[Req{}], outputs...)
+	return outputs, nil
+}
+
+type FuncHoltWintersAberration struct {
+	holtWintersBase
+	delta float64
+}
+
+func NewHoltWintersAberration() GraphiteFunc {
+	return &FuncHoltWintersAberration{holtWintersBase: newHoltWintersBase(), delta: 3}
+}
+
+func (s *FuncHoltWintersAberration) Signature() ([]Arg, []Arg) {
+	return []Arg{
+		ArgSeriesList{val: &s.in},
+		ArgFloat{key: "delta", opt: true, val: &s.delta},
+		ArgString{key: "bootstrapInterval", opt: true, val: &s.bootstrapInterval, validator: []Validator{IsIntervalString}},
+		ArgString{key: "seasonality", opt: true, val: &s.seasonality, validator: []Validator{IsIntervalString}},
+	}, []Arg{ArgSeriesList{}}
+}
+
+func (s *FuncHoltWintersAberration) Exec(cache map[Req][]models.Series) ([]models.Series, error) {
+	series, err := s.in.Exec(cache)
+	if err != nil {
+		return nil, err
+	}
+	outputs := make([]models.Series, 0, len(series))
+	for _, serie := range series {
+		lower, upper, start := s.confidenceBands(serie, s.delta)
+		out := pointSlicePool.Get().([]schema.Point)
+		for i, p := range serie.Datapoints[start:] {
+			var aberration float64
+			switch {
+			case math.IsNaN(p.Val):
+			case !math.IsNaN(upper[i].Val) && p.Val > upper[i].Val:
+				aberration = p.Val - upper[i].Val
+			case !math.IsNaN(lower[i].Val) && p.Val < lower[i].Val:
+				aberration = p.Val - lower[i].Val
+			}
+			out = append(out, schema.Point{Val: aberration, Ts: p.Ts})
+		}
+		pointSlicePool.Put(lower[:0])
+		pointSlicePool.Put(upper[:0])
+		outputs = append(outputs, s.newSeries(serie, "holtWintersAberration", out))
+	}
+	cache[Req{}] = append(cache[Req{}], outputs...)
+	return outputs, nil
+}
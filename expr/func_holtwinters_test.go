@@ -0,0 +1,147 @@
+package expr
+
+import (
+	"math"
+	"reflect"
+	"testing"
+
+	"github.com/grafana/metrictank/api/models"
+	"github.com/grafana/metrictank/schema"
+)
+
+func TestHoltWintersPlan(t *testing.T) {
+	from := uint32(1000000)
+	to := uint32(2000000)
+	cases := []struct {
+		target string
+		expReq []Req
+	}{
+		{`holtWintersForecast(a)`, []Req{NewReq("a", from-7*86400, to, 0)}},
+		{`holtWintersConfidenceBands(a, 2, "1d")`, []Req{NewReq("a", from-86400, to, 0)}},
+		{`holtWintersAberration(a, bootstrapInterval="1h")`, []Req{NewReq("a", from-3600, to, 0)}},
+	}
+	for i, c := range cases {
+		exprs, err := ParseMany([]string{c.target})
+		if err != nil {
+			t.Fatalf("case %d: %q: parse error %s", i, c.target, err)
+		}
+		plan, err := NewPlan(exprs, from, to, 800, true, nil)
+		if err != nil {
+			t.Fatalf("case %d: %q: plan error %s", i, c.target, err)
+		}
+		if !reflect.DeepEqual(plan.Reqs, c.expReq) {
+			t.Errorf("case %d: %q, expected req %v - got %v", i, c.target, c.expReq, plan.Reqs)
+		}
+	}
+}
+
+// getHoltWintersInput returns a constant series from ts 10 through 120, with an optional spike at ts 100
+func getHoltWintersInput(spike bool) []models.Series {
+	var points []schema.Point
+	for ts := uint32(10); ts <= 120; ts += 10 {
+		val := 5.0
+		if spike && ts == 100 {
+			val = 500
+		}
+		points = append(points, schema.Point{Val: val, Ts: ts})
+	}
+	return []models.Series{
+		{
+			Target:     "a",
+			QueryPatt:  "a",
+			Interval:   10,
+			Datapoints: points,
+		},
+	}
+}
+
+func TestHoltWintersForecastConstant(t *testing.T) {
+	f := NewHoltWintersForecast()
+	hw := f.(*FuncHoltWintersForecast)
+	hw.bootstrapInterval = "60s"
+	hw.seasonality = "30s"
+	hw.in = NewMock(getHoltWintersInput(false))
+	ctx := f.Context(Context{from: 70, to: 130})
+	if ctx.from != 10 {
+		t.Fatalf("expected context from 10, got %d", ctx.from)
+	}
+	got, err := f.Exec(make(map[Req][]models.Series))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 1 {
+		t.Fatalf("expected 1 output series, got %d", len(got))
+	}
+	if got[0].Target != "holtWintersForecast(a)" {
+		t.Fatalf("expected target %q, got %q", "holtWintersForecast(a)", got[0].Target)
+	}
+	if got[0].QueryFrom != 70 {
+		t.Fatalf("expected QueryFrom 70, got %d", got[0].QueryFrom)
+	}
+	if len(got[0].Datapoints) != 6 {
+		t.Fatalf("expected 6 points, got %d", len(got[0].Datapoints))
+	}
+	for i, p := range got[0].Datapoints {
+		exp := schema.Point{Val: 5, Ts: 70 + uint32(i)*10}
+		if math.Abs(p.Val-exp.Val) > 1e-9 || p.Ts != exp.Ts {
+			t.Fatalf("point %d - expected %v got %v", i, exp, p)
+		}
+	}
+}
+
+func TestHoltWintersConfidenceBandsConstant(t *testing.T) {
+	f := NewHoltWintersConfidenceBands()
+	hw := f.(*FuncHoltWintersConfidenceBands)
+	hw.bootstrapInterval = "60s"
+	hw.seasonality = "30s"
+	hw.in = NewMock(getHoltWintersInput(false))
+	f.Context(Context{from: 70, to: 130})
+	got, err := f.Exec(make(map[Req][]models.Series))
+	if err != nil {
+		t.Fatal(err)
+	}
+	expTargets := []string{"holtWintersConfidenceLower(a)", "holtWintersConfidenceUpper(a)"}
+	if len(got) != len(expTargets) {
+		t.Fatalf("expected %d output series, got %d", len(expTargets), len(got))
+	}
+	for i, exp := range expTargets {
+		if got[i].Target != exp {
+			t.Fatalf("series %d: expected target %q, got %q", i, exp, got[i].Target)
+		}
+		if got[i].Tags[exp[:len(exp)-3]] != "1" {
+			t.Fatalf("series %d: expected tag %q to be set. got tags %v", i, exp[:len(exp)-3], got[i].Tags)
+		}
+		for j, p := range got[i].Datapoints {
+			if math.Abs(p.Val-5) > 1e-9 {
+				t.Fatalf("series %d point %d: expected val 5, got %v", i, j, p)
+			}
+		}
+	}
+}
+
+func TestHoltWintersAberrationSpike(t *testing.T) {
+	f := NewHoltWintersAberration()
+	hw := f.(*FuncHoltWintersAberration)
+	hw.bootstrapInterval = "60s"
+	hw.seasonality = "30s"
+	hw.in = NewMock(getHoltWintersInput(true))
+	f.Context(Context{from: 70, to: 130})
+	got, err := f.Exec(make(map[Req][]models.Series))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 1 {
+		t.Fatalf("expected 1 output series, got %d", len(got))
+	}
+	if got[0].Target != "holtWintersAberration(a)" {
+		t.Fatalf("expected target %q, got %q", "holtWintersAberration(a)", got[0].Target)
+	}
+	for _, p := range got[0].Datapoints {
+		switch {
+		case p.Ts < 100 && p.Val != 0:
+			t.Fatalf("expected no aberration before the spike, got %v", p)
+		case p.Ts == 100 && p.Val <= 0:
+			t.Fatalf("expected positive aberration at the spike, got %v", p)
+		}
+	}
+}
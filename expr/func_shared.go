@@ -0,0 +1,43 @@
+package expr
+
+import (
+	"github.com/grafana/metrictank/api/models"
+)
+
+// internal function that wraps a function which is used in multiple places of a plan.
+// (e.g. target=movingAverage(sum(foo),10)&target=sum(foo))
+// it executes the wrapped function only once and returns its output to every caller
+type FuncShared struct {
+	in   GraphiteFunc
+	uses uint32 // number of places in the plan that use this function
+	done bool
+	out  []models.Series
+	err  error
+}
+
+func NewShared(in GraphiteFunc) *FuncShared {
+	return &FuncShared{in: in, uses: 1}
+}
+
+func (s *FuncShared) Signature() ([]Arg, []Arg) {
+	return s.in.Signature()
+}
+
+func (s *FuncShared) Context(context Context) Context {
+	return context
+}
+
+func (s *FuncShared) Exec(cache map[Req][]models.Series) ([]models.Series, error) {
+	if !s.done {
+		s.out, s.err = s.in.Exec(cache)
+		s.done = true
+	}
+	if s.err != nil {
+		return nil, s.err
+	}
+	// callers may modify the properties of the series they get (though not their datapoints),
+	// so each caller gets its own copy of the series
+	out := make([]models.Series, len(s.out))
+	copy(out, s.out)
+	return out, nil
+}
@@ -0,0 +1,119 @@
+package expr
+
+import (
+	"fmt"
+	"math"
+
+	"github.com/grafana/metrictank/api/models"
+	"github.com/grafana/metrictank/schema"
+	"github.com/raintank/dur"
+)
+
+type FuncTimeShift struct {
+	in        GraphiteFunc
+	timeShift string
+	resetEnd  bool
+	alignDST  bool
+
+	shift int // number of seconds to add to the timestamps of the input. typically negative
+}
+
+func NewTimeShift() GraphiteFunc {
+	return &FuncTimeShift{resetEnd: true}
+}
+
+func (s *FuncTimeShift) Signature() ([]Arg, []Arg) {
+	return []Arg{
+		ArgSeriesList{val: &s.in},
+		ArgString{key: "timeShift", val: &s.timeShift, validator: []Validator{IsSignedIntervalString}},
+		// we always align the output to the requested window, so resetEnd is implied.
+		// we don't support timezones, so alignDST has no effect.
+		// these are accepted for compatibility with graphite
+		ArgBool{key: "resetEnd", opt: true, val: &s.resetEnd},
+		ArgBool{key: "alignDST", opt: true, val: &s.alignDST},
+	}, []Arg{ArgSeriesList{}}
+}
+
+func (s *FuncTimeShift) Context(context Context) Context {
+	s.timeShift = normalizeTimeShift(s.timeShift)
+	s.shift = parseTimeShift(s.timeShift)
+	return shiftContext(context, s.shift)
+}
+
+func (s *FuncTimeShift) Exec(cache map[Req][]models.Series) ([]models.Series, error) {
+	series, err := s.in.Exec(cache)
+	if err != nil {
+		return nil, err
+	}
+	outputs := make([]models.Series, 0, len(series))
+	for _, serie := range series {
+		output := shiftSeries(serie, -s.shift)
+		output.Target = fmt.Sprintf("timeShift(%s, \"%s\")", serie.Target, s.timeShift)
+		output.QueryPatt = fmt.Sprintf("timeShift(%s, \"%s\")", serie.QueryPatt, s.timeShift)
+		output.Tags = serie.CopyTagsWith("timeShift", s.timeShift)
+		outputs = append(outputs, output)
+	}
+	cache[Req{}] = append(cache[Req{}], outputs...)
+	return outputs, nil
+}
+
+// normalizeTimeShift makes the sign of a timeshift explicit.
+// like graphite, timeshifts without a sign are interpreted as shifts into the past
+func normalizeTimeShift(in string) string {
+	if len(in) > 0 && in[0] >= '0' && in[0] <= '9' {
+		return "-" + in
+	}
+	return in
+}
+
+// parseTimeShift parses a (validated) timeshift such as "-1d" or "+2h" into a number of seconds
+func parseTimeShift(in string) int {
+	in = normalizeTimeShift(in)
+	secs, _ := dur.ParseDuration(in[1:])
+	if in[0] == '-' {
+		return -int(secs)
+	}
+	return int(secs)
+}
+
+// shiftContext moves the timeframe of the context by the given number of seconds
+func shiftContext(context Context, shift int) Context {
+	context.from = shiftTs(context.from, shift)
+	context.to = shiftTs(context.to, shift)
+	return context
+}
+
+// shiftTs moves the timestamp by the given number of seconds, clamping the result
+// to the range of uint32, rather than wrapping around
+func shiftTs(ts uint32, shift int) uint32 {
+	shifted := int64(ts) + int64(shift)
+	if shifted < 0 {
+		return 0
+	}
+	if shifted > math.MaxUint32 {
+		return math.MaxUint32
+	}
+	return uint32(shifted)
+}
+
+// shiftSeries returns a copy of the series, with all timestamps moved by the given
+// number of seconds. This is used to realign data that was fetched with a shifted context
+// onto the original timeframe.
+func shiftSeries(in models.Series, shift int) models.Series {
+	out := pointSlicePool.Get().([]schema.Point)
+	for _, p := range in.Datapoints {
+		out = append(out, schema.Point{Val: p.Val, Ts: shiftTs(p.Ts, shift)})
+	}
+	return models.Series{
+		Target:       in.Target,
+		QueryPatt:    in.QueryPatt,
+		Tags:         in.Tags,
+		Interval:     in.Interval,
+		QueryFrom:    shiftTs(in.QueryFrom, shift),
+		QueryTo:      shiftTs(in.QueryTo, shift),
+		QueryCons:    in.QueryCons,
+		Consolidator: in.Consolidator,
+		Meta:         in.Meta,
+		Datapoints:   out,
+	}
+}
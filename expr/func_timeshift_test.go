@@ -0,0 +1,124 @@
+package expr
+
+import (
+	"math"
+	"reflect"
+	"testing"
+
+	"github.com/grafana/metrictank/api/models"
+	"github.com/grafana/metrictank/schema"
+)
+
+func TestTimeShiftPlan(t *testing.T) {
+	from := uint32(100000)
+	to := uint32(200000)
+	cases := []struct {
+		target string
+		expReq []Req
+	}{
+		{`timeShift(a, "1h")`, []Req{NewReq("a", from-3600, to-3600, 0)}},
+		{`timeShift(a, "-1d")`, []Req{NewReq("a", from-86400, to-86400, 0)}},
+		{`timeShift(a, "+10s")`, []Req{NewReq("a", from+10, to+10, 0)}},
+		// shifting before the epoch clamps at 0, rather than wrapping around
+		{`timeShift(a, "2d")`, []Req{NewReq("a", 0, to-172800, 0)}},
+		{`timeStack(a, "1h", 0, 3)`, []Req{
+			NewReq("a", from, to, 0),
+			NewReq("a", from-3600, to-3600, 0),
+			NewReq("a", from-7200, to-7200, 0),
+		}},
+	}
+	for i, c := range cases {
+		exprs, err := ParseMany([]string{c.target})
+		if err != nil {
+			t.Fatalf("case %d: %q: parse error %s", i, c.target, err)
+		}
+		plan, err := NewPlan(exprs, from, to, 800, true, nil)
+		if err != nil {
+			t.Fatalf("case %d: %q: plan error %s", i, c.target, err)
+		}
+		if !reflect.DeepEqual(plan.Reqs, c.expReq) {
+			t.Errorf("case %d: %q, expected req %v - got %v", i, c.target, c.expReq, plan.Reqs)
+		}
+	}
+}
+
+func TestTimeShiftBadInterval(t *testing.T) {
+	exprs, err := ParseMany([]string{`timeShift(a, "1x")`})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = NewPlan(exprs, 1000, 2000, 800, true, nil)
+	if err == nil {
+		t.Fatal("expected error for invalid timeShift, got nil")
+	}
+}
+
+func TestTimeShiftExec(t *testing.T) {
+	f := NewTimeShift()
+	f.(*FuncTimeShift).timeShift = "20s"
+	f.(*FuncTimeShift).in = NewMock([]models.Series{
+		{
+			Interval:   10,
+			Target:     "a",
+			QueryPatt:  "a",
+			QueryFrom:  -20 + 30,
+			QueryTo:    -20 + 70,
+			Datapoints: getCopy(a),
+		},
+	})
+	f.Context(Context{from: 30, to: 70})
+	got, err := f.Exec(make(map[Req][]models.Series))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 1 {
+		t.Fatalf("expected 1 output series, got %d", len(got))
+	}
+	if got[0].Target != `timeShift(a, "-20s")` {
+		t.Fatalf("expected target %q, got %q", `timeShift(a, "-20s")`, got[0].Target)
+	}
+	if got[0].Tags["timeShift"] != "-20s" {
+		t.Fatalf("expected timeShift tag %q, got %q", "-20s", got[0].Tags["timeShift"])
+	}
+	if got[0].QueryFrom != 30 || got[0].QueryTo != 70 {
+		t.Fatalf("expected query window 30-70, got %d-%d", got[0].QueryFrom, got[0].QueryTo)
+	}
+	for i, p := range got[0].Datapoints {
+		exp := schema.Point{Val: a[i].Val, Ts: a[i].Ts + 20}
+		bothNaN := math.IsNaN(p.Val) && math.IsNaN(exp.Val)
+		if (!bothNaN && p.Val != exp.Val) || p.Ts != exp.Ts {
+			t.Fatalf("point %d - expected %v got %v", i, exp, p)
+		}
+	}
+}
+
+func TestTimeStackExec(t *testing.T) {
+	f := NewTimeStack()
+	f.(*FuncTimeStack).timeShiftUnit = "10s"
+	f.(*FuncTimeStack).timeShiftStart = 0
+	f.(*FuncTimeStack).timeShiftEnd = 2
+	f.(*FuncTimeStack).Contexts(Context{from: 10, to: 70})
+	f.(*FuncTimeStack).in = []GraphiteFunc{
+		NewMock([]models.Series{{Interval: 10, Target: "a", Datapoints: getCopy(a)}}),
+		NewMock([]models.Series{{Interval: 10, Target: "a", Datapoints: getCopy(b)}}),
+	}
+	got, err := f.Exec(make(map[Req][]models.Series))
+	if err != nil {
+		t.Fatal(err)
+	}
+	expTargets := []string{"timeShift(a, -10s, 0)", "timeShift(a, -10s, 1)"}
+	if len(got) != len(expTargets) {
+		t.Fatalf("expected %d output series, got %d", len(expTargets), len(got))
+	}
+	for i, exp := range expTargets {
+		if got[i].Target != exp {
+			t.Fatalf("series %d: expected target %q, got %q", i, exp, got[i].Target)
+		}
+	}
+	if got[0].Datapoints[0].Ts != 10 || got[1].Datapoints[0].Ts != 20 {
+		t.Fatalf("expected shifted first timestamps 10 and 20, got %d and %d", got[0].Datapoints[0].Ts, got[1].Datapoints[0].Ts)
+	}
+	if got[1].Tags["timeShift"] != "1" || got[1].Tags["timeShiftUnit"] != "-10s" {
+		t.Fatalf("unexpected tags %v", got[1].Tags)
+	}
+}
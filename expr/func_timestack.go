@@ -0,0 +1,79 @@
+package expr
+
+import (
+	"fmt"
+	"strconv"
+
+	"github.com/grafana/metrictank/api/models"
+	"github.com/grafana/metrictank/errors"
+)
+
+type FuncTimeStack struct {
+	in             []GraphiteFunc // one entry per shift (per input)
+	timeShiftUnit  string
+	timeShiftStart int64
+	timeShiftEnd   int64
+
+	unit int // number of seconds to shift for each step. typically negative
+}
+
+func NewTimeStack() GraphiteFunc {
+	return &FuncTimeStack{timeShiftUnit: "1d", timeShiftStart: 0, timeShiftEnd: 7}
+}
+
+func (s *FuncTimeStack) Signature() ([]Arg, []Arg) {
+	return []Arg{
+		// this is a seriesList in graphite, but we need the planner to append
+		// an input for every shift, hence ArgSeriesLists. see MultiContexter
+		ArgSeriesLists{val: &s.in},
+		ArgString{key: "timeShiftUnit", opt: true, val: &s.timeShiftUnit, validator: []Validator{IsSignedIntervalString}},
+		ArgInt{key: "timeShiftStart", opt: true, val: &s.timeShiftStart},
+		ArgInt{key: "timeShiftEnd", opt: true, val: &s.timeShiftEnd},
+	}, []Arg{ArgSeriesList{}}
+}
+
+func (s *FuncTimeStack) Context(context Context) Context {
+	return context
+}
+
+// Contexts returns a context for every shift in the range [timeShiftStart, timeShiftEnd)
+func (s *FuncTimeStack) Contexts(context Context) []Context {
+	s.timeShiftUnit = normalizeTimeShift(s.timeShiftUnit)
+	s.unit = parseTimeShift(s.timeShiftUnit)
+	var contexts []Context
+	for shift := s.timeShiftStart; shift < s.timeShiftEnd; shift++ {
+		contexts = append(contexts, shiftContext(context, s.unit*int(shift)))
+	}
+	return contexts
+}
+
+func (s *FuncTimeStack) Exec(cache map[Req][]models.Series) ([]models.Series, error) {
+	numShifts := int(s.timeShiftEnd - s.timeShiftStart)
+	if numShifts <= 0 {
+		return nil, nil
+	}
+	if len(s.in)%numShifts != 0 {
+		return nil, errors.NewInternalf("timeStack: got %d inputs for %d shifts", len(s.in), numShifts)
+	}
+	perShift := len(s.in) / numShifts
+
+	var outputs []models.Series
+	for i, in := range s.in {
+		series, err := in.Exec(cache)
+		if err != nil {
+			return nil, err
+		}
+		shift := s.timeShiftStart + int64(i/perShift)
+		shiftStr := strconv.FormatInt(shift, 10)
+		for _, serie := range series {
+			output := shiftSeries(serie, -s.unit*int(shift))
+			output.Target = fmt.Sprintf("timeShift(%s, %s, %d)", serie.Target, s.timeShiftUnit, shift)
+			output.QueryPatt = output.Target
+			output.Tags = serie.CopyTagsWith("timeShiftUnit", s.timeShiftUnit)
+			output.Tags["timeShift"] = shiftStr
+			outputs = append(outputs, output)
+		}
+	}
+	cache[Req{}] = append(cache[Req{}], outputs...)
+	return outputs, nil
+}
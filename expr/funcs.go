@@ -37,6 +37,15 @@ type GraphiteFunc interface {
 	Exec(map[Req][]models.Series) ([]models.Series, error)
 }
 
+// MultiContexter may be implemented by a GraphiteFunc that needs its series inputs to be set up
+// multiple times, each time with a different context. e.g. timeStack needs the same input
+// for a range of timeshifts.
+// For such functions, the planner calls Contexts() and sets up the series inputs once for every returned context
+// (in that order). These functions should use ArgSeriesLists for their series inputs, so that each setup gets appended.
+type MultiContexter interface {
+	Contexts(c Context) []Context
+}
+
 type funcConstructor func() GraphiteFunc
 
 type funcDef struct {
@@ -49,70 +58,76 @@ var funcs map[string]funcDef
 func init() {
 	// keys must be sorted alphabetically. but functions with aliases can go together, in which case they are sorted by the first of their aliases
 	funcs = map[string]funcDef{
-		"absolute":              {NewAbsolute, true},
-		"alias":                 {NewAlias, true},
-		"aliasByTags":           {NewAliasByNode, true},
-		"aliasByNode":           {NewAliasByNode, true},
-		"aliasSub":              {NewAliasSub, true},
-		"asPercent":             {NewAsPercent, true},
-		"avg":                   {NewAggregateConstructor("average", crossSeriesAvg), true},
-		"averageAbove":          {NewFilterSeriesConstructor("average", ">"), true},
-		"averageBelow":          {NewFilterSeriesConstructor("average", "<="), true},
-		"averageSeries":         {NewAggregateConstructor("average", crossSeriesAvg), true},
-		"consolidateBy":         {NewConsolidateBy, true},
-		"countSeries":           {NewCountSeries, true},
-		"cumulative":            {NewConsolidateByConstructor("sum"), true},
-		"currentAbove":          {NewFilterSeriesConstructor("last", ">"), true},
-		"currentBelow":          {NewFilterSeriesConstructor("last", "<="), true},
-		"derivative":            {NewDerivative, true},
-		"diffSeries":            {NewAggregateConstructor("diff", crossSeriesDiff), true},
-		"divideSeries":          {NewDivideSeries, true},
-		"divideSeriesLists":     {NewDivideSeriesLists, true},
-		"exclude":               {NewExclude, true},
-		"fallbackSeries":        {NewFallbackSeries, true},
-		"filterSeries":          {NewFilterSeries, true},
-		"grep":                  {NewGrep, true},
-		"group":                 {NewGroup, true},
-		"groupByTags":           {NewGroupByTags, true},
-		"highest":               {NewHighestLowestConstructor("", true), true},
-		"highestAverage":        {NewHighestLowestConstructor("average", true), true},
-		"highestCurrent":        {NewHighestLowestConstructor("current", true), true},
-		"highestMax":            {NewHighestLowestConstructor("max", true), true},
-		"integral":              {NewIntegral, true},
-		"isNonNull":             {NewIsNonNull, true},
-		"keepLastValue":         {NewKeepLastValue, true},
-		"lowest":                {NewHighestLowestConstructor("", false), true},
-		"lowestAverage":         {NewHighestLowestConstructor("average", false), true},
-		"lowestCurrent":         {NewHighestLowestConstructor("current", false), true},
-		"max":                   {NewAggregateConstructor("max", crossSeriesMax), true},
-		"maximumAbove":          {NewFilterSeriesConstructor("max", ">"), true},
-		"maximumBelow":          {NewFilterSeriesConstructor("max", "<="), true},
-		"maxSeries":             {NewAggregateConstructor("max", crossSeriesMax), true},
-		"min":                   {NewAggregateConstructor("min", crossSeriesMin), true},
-		"minimumAbove":          {NewFilterSeriesConstructor("min", ">"), true},
-		"minimumBelow":          {NewFilterSeriesConstructor("min", "<="), true},
-		"minSeries":             {NewAggregateConstructor("min", crossSeriesMin), true},
-		"multiplySeries":        {NewAggregateConstructor("multiply", crossSeriesMultiply), true},
-		"movingAverage":         {NewMovingAverage, false},
-		"nonNegativeDerivative": {NewNonNegativeDerivative, true},
-		"perSecond":             {NewPerSecond, true},
-		"rangeOfSeries":         {NewAggregateConstructor("rangeOf", crossSeriesRange), true},
-		"removeAbovePercentile": {NewRemoveAboveBelowPercentileConstructor(true), true},
-		"removeAboveValue":      {NewRemoveAboveBelowValueConstructor(true), true},
-		"removeBelowPercentile": {NewRemoveAboveBelowPercentileConstructor(false), true},
-		"removeBelowValue":      {NewRemoveAboveBelowValueConstructor(false), true},
-		"scale":                 {NewScale, true},
-		"scaleToSeconds":        {NewScaleToSeconds, true},
-		"smartSummarize":        {NewSmartSummarize, false},
-		"sortBy":                {NewSortByConstructor("", false), true},
-		"sortByMaxima":          {NewSortByConstructor("max", true), true},
-		"sortByName":            {NewSortByName, true},
-		"sortByTotal":           {NewSortByConstructor("sum", true), true},
-		"stddevSeries":          {NewAggregateConstructor("stddev", crossSeriesStddev), true},
-		"sum":                   {NewAggregateConstructor("sum", crossSeriesSum), true},
-		"sumSeries":             {NewAggregateConstructor("sum", crossSeriesSum), true},
-		"summarize":             {NewSummarize, true},
-		"transformNull":         {NewTransformNull, true},
+		"absolute":                   {NewAbsolute, true},
+		"alias":                      {NewAlias, true},
+		"aliasByTags":                {NewAliasByNode, true},
+		"aliasByNode":                {NewAliasByNode, true},
+		"aliasSub":                   {NewAliasSub, true},
+		"asPercent":                  {NewAsPercent, true},
+		"avg":                        {NewAggregateConstructor("average", crossSeriesAvg), true},
+		"averageAbove":               {NewFilterSeriesConstructor("average", ">"), true},
+		"averageBelow":               {NewFilterSeriesConstructor("average", "<="), true},
+		"averageSeries":              {NewAggregateConstructor("average", crossSeriesAvg), true},
+		"consolidateBy":              {NewConsolidateBy, true},
+		"countSeries":                {NewCountSeries, true},
+		"cumulative":                 {NewConsolidateByConstructor("sum"), true},
+		"currentAbove":               {NewFilterSeriesConstructor("last", ">"), true},
+		"currentBelow":               {NewFilterSeriesConstructor("last", "<="), true},
+		"delay":                      {NewDelay, true},
+		"derivative":                 {NewDerivative, true},
+		"diffSeries":                 {NewAggregateConstructor("diff", crossSeriesDiff), true},
+		"divideSeries":               {NewDivideSeries, true},
+		"divideSeriesLists":          {NewDivideSeriesLists, true},
+		"exclude":                    {NewExclude, true},
+		"fallbackSeries":             {NewFallbackSeries, true},
+		"filterSeries":               {NewFilterSeries, true},
+		"grep":                       {NewGrep, true},
+		"group":                      {NewGroup, true},
+		"groupByTags":                {NewGroupByTags, true},
+		"highest":                    {NewHighestLowestConstructor("", true), true},
+		"highestAverage":             {NewHighestLowestConstructor("average", true), true},
+		"highestCurrent":             {NewHighestLowestConstructor("current", true), true},
+		"highestMax":                 {NewHighestLowestConstructor("max", true), true},
+		"holtWintersAberration":      {NewHoltWintersAberration, true},
+		"holtWintersConfidenceBands": {NewHoltWintersConfidenceBands, true},
+		"holtWintersForecast":        {NewHoltWintersForecast, true},
+		"integral":                   {NewIntegral, true},
+		"isNonNull":                  {NewIsNonNull, true},
+		"keepLastValue":              {NewKeepLastValue, true},
+		"lowest":                     {NewHighestLowestConstructor("", false), true},
+		"lowestAverage":              {NewHighestLowestConstructor("average", false), true},
+		"lowestCurrent":              {NewHighestLowestConstructor("current", false), true},
+		"max":                        {NewAggregateConstructor("max", crossSeriesMax), true},
+		"maximumAbove":               {NewFilterSeriesConstructor("max", ">"), true},
+		"maximumBelow":               {NewFilterSeriesConstructor("max", "<="), true},
+		"maxSeries":                  {NewAggregateConstructor("max", crossSeriesMax), true},
+		"min":                        {NewAggregateConstructor("min", crossSeriesMin), true},
+		"minimumAbove":               {NewFilterSeriesConstructor("min", ">"), true},
+		"minimumBelow":               {NewFilterSeriesConstructor("min", "<="), true},
+		"minSeries":                  {NewAggregateConstructor("min", crossSeriesMin), true},
+		"multiplySeries":             {NewAggregateConstructor("multiply", crossSeriesMultiply), true},
+		"movingAverage":              {NewMovingAverage, false},
+		"nonNegativeDerivative":      {NewNonNegativeDerivative, true},
+		"perSecond":                  {NewPerSecond, true},
+		"rangeOfSeries":              {NewAggregateConstructor("rangeOf", crossSeriesRange), true},
+		"removeAbovePercentile":      {NewRemoveAboveBelowPercentileConstructor(true), true},
+		"removeAboveValue":           {NewRemoveAboveBelowValueConstructor(true), true},
+		"removeBelowPercentile":      {NewRemoveAboveBelowPercentileConstructor(false), true},
+		"removeBelowValue":           {NewRemoveAboveBelowValueConstructor(false), true},
+		"scale":                      {NewScale, true},
+		"scaleToSeconds":             {NewScaleToSeconds, true},
+		"smartSummarize":             {NewSmartSummarize, false},
+		"sortBy":                     {NewSortByConstructor("", false), true},
+		"sortByMaxima":               {NewSortByConstructor("max", true), true},
+		"sortByName":                 {NewSortByName, true},
+		"sortByTotal":                {NewSortByConstructor("sum", true), true},
+		"stddevSeries":               {NewAggregateConstructor("stddev", crossSeriesStddev), true},
+		"sum":                        {NewAggregateConstructor("sum", crossSeriesSum), true},
+		"sumSeries":                  {NewAggregateConstructor("sum", crossSeriesSum), true},
+		"summarize":                  {NewSummarize, true},
+		"timeShift":                  {NewTimeShift, true},
+		"timeStack":                  {NewTimeStack, true},
+		"transformNull":              {NewTransformNull, true},
 	}
 }
 
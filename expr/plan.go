@@ -7,6 +7,7 @@ import (
 	"github.com/grafana/metrictank/api/models"
 	"github.com/grafana/metrictank/consolidation"
 	"github.com/grafana/metrictank/errors"
+	"github.com/grafana/metrictank/schema"
 )
 
 // Req represents a request for one/more series
@@ -35,10 +36,11 @@ type Plan struct {
 	MaxDataPoints uint32
 	From          uint32                  // global request scoped from
 	To            uint32                  // global request scoped to
+	ReqsDeduped   uint32                  // number of requests that were not added to Reqs because an identical request was already present
+	FuncsShared   uint32                  // number of function calls that were not planned because an identical one (same args and context) is executed and its output shared
 	data          map[Req][]models.Series // input data to work with. set via Run(), as well as
-	// new data generated by processing funcs. useful for two reasons:
-	// 1) reuse partial calculations e.g. queries like target=movingAvg(sum(foo), 10)&target=sum(foo) (TODO)
-	// 2) central place to return data back to pool when we're done.
+	// new data generated by processing funcs. This is the central place to return data back to pool when we're done.
+	// note that partial calculations are reused via FuncShared, e.g. queries like target=movingAvg(sum(foo), 10)&target=sum(foo)
 }
 
 func (p Plan) Dump(w io.Writer) {
@@ -54,6 +56,8 @@ func (p Plan) Dump(w io.Writer) {
 	fmt.Fprintf(w, "MaxDataPoints: %d\n", p.MaxDataPoints)
 	fmt.Fprintf(w, "From: %d\n", p.From)
 	fmt.Fprintf(w, "To: %d\n", p.To)
+	fmt.Fprintf(w, "ReqsDeduped: %d\n", p.ReqsDeduped)
+	fmt.Fprintf(w, "FuncsShared: %d\n", p.FuncsShared)
 }
 
 // NewPlan validates the expressions and comes up with the initial (potentially non-optimal) execution plan
@@ -62,22 +66,29 @@ func (p Plan) Dump(w io.Writer) {
 // * make sure function exists
 // * validation of arguments
 // * allow functions to modify the Context (change data range or consolidation)
+// * share functions (and requests) that are used multiple times with the same arguments and context
 // * future version: allow functions to mark safe to pre-aggregate using consolidateBy or not
 func NewPlan(exprs []*expr, from, to, mdp uint32, stable bool, reqs []Req) (Plan, error) {
 	var err error
 	var funcs []GraphiteFunc
+	shared := make(map[string]*FuncShared)
 	for _, e := range exprs {
 		var fn GraphiteFunc
 		context := Context{
 			from: from,
 			to:   to,
 		}
-		fn, reqs, err = newplan(e, context, stable, reqs)
+		fn, reqs, err = newplan(e, context, stable, reqs, shared)
 		if err != nil {
 			return Plan{}, err
 		}
 		funcs = append(funcs, fn)
 	}
+	var funcsShared uint32
+	for _, fn := range shared {
+		funcsShared += fn.uses - 1
+	}
+	reqs, reqsDeduped := dedupReqs(reqs)
 	return Plan{
 		Reqs:          reqs,
 		exprs:         exprs,
@@ -85,11 +96,30 @@ func NewPlan(exprs []*expr, from, to, mdp uint32, stable bool, reqs []Req) (Plan
 		MaxDataPoints: mdp,
 		From:          from,
 		To:            to,
+		ReqsDeduped:   reqsDeduped,
+		FuncsShared:   funcsShared,
 	}, nil
 }
 
+// dedupReqs removes duplicate requests, retaining the order of first occurrence.
+// it returns the deduplicated requests and the number of requests removed
+func dedupReqs(reqs []Req) ([]Req, uint32) {
+	seen := make(map[Req]struct{}, len(reqs))
+	out := reqs[:0]
+	for _, r := range reqs {
+		if _, ok := seen[r]; ok {
+			continue
+		}
+		seen[r] = struct{}{}
+		out = append(out, r)
+	}
+	return out, uint32(len(reqs) - len(out))
+}
+
 // newplan adds requests as needed for the given expr, resolving function calls as needed
-func newplan(e *expr, context Context, stable bool, reqs []Req) (GraphiteFunc, []Req, error) {
+// function calls that were already planned within the same context are not planned again:
+// instead the previously planned function is returned, wrapped into a FuncShared, so that it only executes once.
+func newplan(e *expr, context Context, stable bool, reqs []Req, shared map[string]*FuncShared) (GraphiteFunc, []Req, error) {
 	if e.etype != etFunc && e.etype != etName {
 		return nil, nil, errors.NewBadRequest("request must be a function call or metric pattern")
 	}
@@ -117,14 +147,25 @@ func newplan(e *expr, context Context, stable bool, reqs []Req) (GraphiteFunc, [
 		return nil, nil, ErrUnknownFunction(e.str)
 	}
 
+	key := fmt.Sprintf("%d-%d-%d-%s", context.from, context.to, context.consol, e.canonical())
+	if fn, ok := shared[key]; ok {
+		fn.uses++
+		return fn, reqs, nil
+	}
+
 	fn := fdef.constr()
-	reqs, err := newplanFunc(e, fn, context, stable, reqs)
-	return fn, reqs, err
+	reqs, err := newplanFunc(e, fn, context, stable, reqs, shared)
+	if err != nil || shared == nil {
+		return fn, reqs, err
+	}
+	sharedFn := NewShared(fn)
+	shared[key] = sharedFn
+	return sharedFn, reqs, nil
 }
 
 // newplanFunc adds requests as needed for the given expr, and validates the function input
 // provided you already know the expression is a function call to the given function
-func newplanFunc(e *expr, fn GraphiteFunc, context Context, stable bool, reqs []Req) ([]Req, error) {
+func newplanFunc(e *expr, fn GraphiteFunc, context Context, stable bool, reqs []Req, shared map[string]*FuncShared) ([]Req, error) {
 	// first comes the interesting task of validating the arguments as specified by the function,
 	// against the arguments that were parsed.
 
@@ -193,23 +234,30 @@ func newplanFunc(e *expr, fn GraphiteFunc, context Context, stable bool, reqs []
 
 	// functions now have their non-series input args set,
 	// so they should now be able to specify any context alterations
-	context = fn.Context(context)
-	// now that we know the needed context for the data coming into
+	var contexts []Context
+	if mc, ok := fn.(MultiContexter); ok {
+		contexts = mc.Contexts(context)
+	} else {
+		contexts = []Context{fn.Context(context)}
+	}
+	// now that we know the needed context(s) for the data coming into
 	// this function, we can set up the input arguments for the function
 	// that are series
-	pos = 0
-	for _, argExp = range argsExp {
-		if pos >= len(e.args) {
-			break // no more args specified. we're done.
-		}
-		switch argExp.(type) {
-		case ArgSeries, ArgSeriesList, ArgSeriesLists, ArgIn:
-			pos, reqs, err = e.consumeSeriesArg(pos, argExp, context, stable, reqs)
-			if err != nil {
-				return nil, err
+	for _, context := range contexts {
+		pos = 0
+		for _, argExp = range argsExp {
+			if pos >= len(e.args) {
+				break // no more args specified. we're done.
+			}
+			switch argExp.(type) {
+			case ArgSeries, ArgSeriesList, ArgSeriesLists, ArgIn:
+				pos, reqs, err = e.consumeSeriesArg(pos, argExp, context, stable, reqs, shared)
+				if err != nil {
+					return nil, err
+				}
+			default:
+				pos++
 			}
-		default:
-			pos++
 		}
 	}
 	return reqs, err
@@ -226,6 +274,13 @@ func (p Plan) Run(input map[Req][]models.Series) ([]models.Series, error) {
 		}
 		out = append(out, series...)
 	}
+	// consolidation happens in place, so we must make sure to consolidate each set of datapoints
+	// only once, even when it is returned multiple times (e.g. target=sum(foo)&target=sum(foo))
+	type consolidated struct {
+		points   []schema.Point
+		interval uint32
+	}
+	done := make(map[*schema.Point]consolidated)
 	for i, o := range out {
 		if p.MaxDataPoints != 0 && len(o.Datapoints) > int(p.MaxDataPoints) {
 			// series may have been created by a function that didn't know which consolidation function to default to.
@@ -233,7 +288,12 @@ func (p Plan) Run(input map[Req][]models.Series) ([]models.Series, error) {
 			if o.Consolidator == 0 {
 				o.Consolidator = consolidation.Avg
 			}
-			out[i].Datapoints, out[i].Interval = consolidation.ConsolidateNudged(o.Datapoints, o.Interval, p.MaxDataPoints, o.Consolidator)
+			if c, ok := done[&o.Datapoints[0]]; ok {
+				out[i].Datapoints, out[i].Interval = c.points, c.interval
+			} else {
+				out[i].Datapoints, out[i].Interval = consolidation.ConsolidateNudged(o.Datapoints, o.Interval, p.MaxDataPoints, o.Consolidator)
+				done[&o.Datapoints[0]] = consolidated{out[i].Datapoints, out[i].Interval}
+			}
 			out[i].Meta = out[i].Meta.CopyWithChange(func(in models.SeriesMetaProperties) models.SeriesMetaProperties {
 				in.AggNumRC = consolidation.AggEvery(uint32(len(o.Datapoints)), p.MaxDataPoints)
 				in.ConsolidatorRC = o.Consolidator
@@ -196,7 +196,7 @@ func TestArgs(t *testing.T) {
 			args:      c.args,
 			namedArgs: c.namedArgs,
 		}
-		req, err := newplanFunc(e, fn, Context{from: from, to: to}, stable, nil)
+		req, err := newplanFunc(e, fn, Context{from: from, to: to}, stable, nil, nil)
 		if !reflect.DeepEqual(err, c.expErr) {
 			t.Errorf("case %d: %q, expected error %v - got %v", i, c.name, c.expErr, err)
 		}
@@ -217,7 +217,7 @@ func TestArgInMissing(t *testing.T) {
 		},
 		namedArgs: nil,
 	}
-	_, err := newplanFunc(e, fn, Context{from: 0, to: 1000}, true, nil)
+	_, err := newplanFunc(e, fn, Context{from: 0, to: 1000}, true, nil, nil)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -241,7 +241,7 @@ func TestArgInSeriesPositional(t *testing.T) {
 		},
 		namedArgs: nil,
 	}
-	_, err := newplanFunc(e, fn, Context{from: 0, to: 1000}, true, nil)
+	_, err := newplanFunc(e, fn, Context{from: 0, to: 1000}, true, nil, nil)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -265,7 +265,7 @@ func TestArgInSeriesPositionalNone(t *testing.T) {
 		},
 		namedArgs: nil,
 	}
-	_, err := newplanFunc(e, fn, Context{from: 0, to: 1000}, true, nil)
+	_, err := newplanFunc(e, fn, Context{from: 0, to: 1000}, true, nil, nil)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -289,7 +289,7 @@ func TestArgInIntPositional(t *testing.T) {
 		},
 		namedArgs: nil,
 	}
-	_, err := newplanFunc(e, fn, Context{from: 0, to: 1000}, true, nil)
+	_, err := newplanFunc(e, fn, Context{from: 0, to: 1000}, true, nil, nil)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -313,7 +313,7 @@ func TestArgInINFPositional(t *testing.T) {
 		},
 		namedArgs: nil,
 	}
-	_, err := newplanFunc(e, fn, Context{from: 0, to: 1000}, true, nil)
+	_, err := newplanFunc(e, fn, Context{from: 0, to: 1000}, true, nil, nil)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -335,7 +335,7 @@ func TestArgInSeriesKeyword(t *testing.T) {
 			"total": {etype: etName, str: "total.*"},
 		},
 	}
-	_, err := newplanFunc(e, fn, Context{from: 0, to: 1000}, true, nil)
+	_, err := newplanFunc(e, fn, Context{from: 0, to: 1000}, true, nil, nil)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -360,7 +360,7 @@ func TestArgInIntKeyword(t *testing.T) {
 			"total": {etype: etInt, str: "10", int: 10},
 		},
 	}
-	_, err := newplanFunc(e, fn, Context{from: 0, to: 1000}, true, nil)
+	_, err := newplanFunc(e, fn, Context{from: 0, to: 1000}, true, nil, nil)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -761,3 +761,117 @@ func TestTargetErrors(t *testing.T) {
 		}
 	}
 }
+
+// TestSharedSubExpressions tests whether identical (sub)expressions within the same context
+// are planned only once, and whether identical requests are deduplicated
+func TestSharedSubExpressions(t *testing.T) {
+	from := uint32(1000)
+	to := uint32(2000)
+	stable := true
+	cases := []struct {
+		targets        []string
+		expReq         []Req
+		expReqsDeduped uint32
+		expFuncsShared uint32
+	}{
+		{
+			[]string{"a", "a"},
+			[]Req{NewReq("a", from, to, 0)},
+			1,
+			0,
+		},
+		{
+			[]string{"sum(a)", "scale(sum(a), 2)"},
+			[]Req{NewReq("a", from, to, 0)},
+			0,
+			1,
+		},
+		{
+			// whitespace, quoting and keyword args don't matter
+			[]string{`perSecond(a, maxValue=5)`, `perSecond(a,maxValue=5)`, `sum(perSecond(a, maxValue=5))`},
+			[]Req{NewReq("a", from, to, 0)},
+			0,
+			2,
+		},
+		{
+			// different context, so can't be shared
+			[]string{`sum(a)`, `consolidateBy(sum(a), "max")`},
+			[]Req{NewReq("a", from, to, 0), NewReq("a", from, to, consolidation.Max)},
+			0,
+			0,
+		},
+		{
+			[]string{`sum(a)`, `sum(b)`},
+			[]Req{NewReq("a", from, to, 0), NewReq("b", from, to, 0)},
+			0,
+			0,
+		},
+	}
+
+	for i, c := range cases {
+		exprs, err := ParseMany(c.targets)
+		if err != nil {
+			t.Fatal(err)
+		}
+		plan, err := NewPlan(exprs, from, to, 800, stable, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(plan.Reqs, c.expReq) {
+			t.Errorf("case %d: %v, expected req %v - got %v", i, c.targets, c.expReq, plan.Reqs)
+		}
+		if plan.ReqsDeduped != c.expReqsDeduped {
+			t.Errorf("case %d: %v, expected %d requests deduped - got %d", i, c.targets, c.expReqsDeduped, plan.ReqsDeduped)
+		}
+		if plan.FuncsShared != c.expFuncsShared {
+			t.Errorf("case %d: %v, expected %d funcs shared - got %d", i, c.targets, c.expFuncsShared, plan.FuncsShared)
+		}
+	}
+}
+
+// TestSharedRun tests that shared outputs are correct, and only runtime-consolidated once
+func TestSharedRun(t *testing.T) {
+	from := uint32(10)
+	to := uint32(70)
+	exprs, err := ParseMany([]string{"sum(a)", "sum(a)", "scale(sum(a), 2)"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	plan, err := NewPlan(exprs, from, to, 3, true, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	input := map[Req][]models.Series{
+		NewReq("a", from, to, 0): {{
+			QueryPatt:    "a",
+			Target:       "a",
+			Interval:     10,
+			Consolidator: consolidation.Sum,
+			Datapoints:   getCopy(c),
+		}},
+	}
+	out, err := plan.Run(input)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(out) != 3 {
+		t.Fatalf("expected 3 output series, got %d", len(out))
+	}
+	expTargets := []string{"sumSeries(a)", "sumSeries(a)", "scale(sumSeries(a),2.000000)"}
+	for i, exp := range expTargets {
+		if out[i].Target != exp {
+			t.Errorf("series %d: expected target %q, got %q", i, exp, out[i].Target)
+		}
+	}
+	if !reflect.DeepEqual(out[0].Datapoints, out[1].Datapoints) {
+		t.Fatalf("expected identical output for identical targets. got %v and %v", out[0].Datapoints, out[1].Datapoints)
+	}
+	if len(out[0].Datapoints) != 3 || out[0].Interval != 20 {
+		t.Fatalf("expected 3 points at interval 20, got %d points at interval %d", len(out[0].Datapoints), out[0].Interval)
+	}
+	for j, p := range out[2].Datapoints {
+		if p.Val != 2*out[0].Datapoints[j].Val {
+			t.Fatalf("point %d: expected scaled value %f, got %f", j, 2*out[0].Datapoints[j].Val, p.Val)
+		}
+	}
+}
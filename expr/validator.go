@@ -7,6 +7,7 @@ import (
 )
 
 var ErrIntPositive = errors.NewBadRequest("integer must be positive")
+var ErrIntNonNegative = errors.NewBadRequest("integer must not be negative")
 var ErrInvalidAggFunc = errors.NewBadRequest("Invalid aggregation func")
 var ErrNonNegativePercent = errors.NewBadRequest("The requested percent is required to be greater than 0")
 
@@ -21,6 +22,14 @@ func IntPositive(e *expr) error {
 	return nil
 }
 
+// IntNonNegative validates whether an int is zero or positive
+func IntNonNegative(e *expr) error {
+	if e.int < 0 {
+		return ErrIntNonNegative
+	}
+	return nil
+}
+
 func IsAggFunc(e *expr) error {
 	if getCrossSeriesAggFunc(e.str) == nil {
 		return ErrInvalidAggFunc
@@ -37,6 +46,16 @@ func IsIntervalString(e *expr) error {
 	return err
 }
 
+// IsSignedIntervalString validates whether a string is an interval string, optionally prefixed with + or -
+func IsSignedIntervalString(e *expr) error {
+	str := e.str
+	if len(str) > 0 && (str[0] == '-' || str[0] == '+') {
+		str = str[1:]
+	}
+	_, err := dur.ParseDuration(str)
+	return err
+}
+
 func IsOperator(e *expr) error {
 	switch e.str {
 	case "=", "!=", ">", ">=", "<", "<=":
@@ -183,7 +183,7 @@ func (b *BigtableIdx) Init() error {
 	}
 
 	b.rebuildIndex()
-	if memory.IndexRules.Prunable() {
+	if memory.IndexRules().Prunable() {
 		b.wg.Add(1)
 		go b.prune()
 	}
@@ -357,11 +357,11 @@ func (b *BigtableIdx) LoadPartition(partition int32, defs []schema.MetricDefinit
 	}
 
 	// getting all cutoffs once saves having to recompute everytime we have a match
-	cutoffs := memory.IndexRules.Cutoffs(now)
+	cutoffs := memory.IndexRules().Cutoffs(now)
 
 NAMES:
 	for nameWithTags, defsByName := range defsByNames {
-		irId, _ := memory.IndexRules.Match(nameWithTags)
+		irId, _ := memory.IndexRules().Match(nameWithTags)
 		cutoff := cutoffs[irId]
 		for _, def := range defsByName {
 			if def.LastUpdate > cutoff {
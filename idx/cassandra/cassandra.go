@@ -240,7 +240,7 @@ func (c *CasIdx) Init() error {
 	//Rebuild the in-memory index.
 	c.rebuildIndex()
 
-	if memory.IndexRules.Prunable() {
+	if memory.IndexRules().Prunable() {
 		go c.prune()
 	}
 
@@ -462,11 +462,11 @@ func (c *CasIdx) load(defs []schema.MetricDefinition, iter cqlIterator, now time
 	}
 
 	// getting all cutoffs once saves having to recompute everytime we have a match
-	cutoffs := memory.IndexRules.Cutoffs(now)
+	cutoffs := memory.IndexRules().Cutoffs(now)
 
 NAMES:
 	for nameWithTags, defsByName := range defsByNames {
-		irId, _ := memory.IndexRules.Match(nameWithTags)
+		irId, _ := memory.IndexRules().Match(nameWithTags)
 		cutoff := cutoffs[irId]
 		for _, def := range defsByName {
 			if def.LastUpdate >= cutoff {
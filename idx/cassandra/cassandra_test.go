@@ -563,7 +563,7 @@ func TestPruneStaleOnLoad(t *testing.T) {
 	now := time.Now()
 
 	iter := testIterator{}
-	memory.IndexRules = conf.IndexRules{
+	memory.SetIndexRules(conf.IndexRules{
 		Rules: []conf.IndexRule{
 			{
 				Name:     "longterm",
@@ -581,7 +581,7 @@ func TestPruneStaleOnLoad(t *testing.T) {
 			Pattern:  regexp.MustCompile(""),
 			MaxStale: 0,
 		},
-	}
+	})
 	iter.rows = append(iter.rows, cassRow{
 		id:         test.GetMKey(1).String(),
 		name:       "longtermrecentenough",
@@ -636,7 +636,7 @@ func TestPruneStaleOnLoadWithTags(t *testing.T) {
 	now := time.Now()
 
 	iter := testIterator{}
-	memory.IndexRules = conf.IndexRules{
+	memory.SetIndexRules(conf.IndexRules{
 		Rules: []conf.IndexRule{
 			{
 				Name:     "longterm",
@@ -654,7 +654,7 @@ func TestPruneStaleOnLoadWithTags(t *testing.T) {
 			Pattern:  regexp.MustCompile(""),
 			MaxStale: 0,
 		},
-	}
+	})
 	iter.rows = append(iter.rows, cassRow{
 		id:         test.GetMKey(1).String(),
 		orgId:      1,
@@ -0,0 +1,70 @@
+package file
+
+import (
+	"errors"
+	"flag"
+	"time"
+
+	"github.com/grafana/globalconf"
+	log "github.com/sirupsen/logrus"
+)
+
+type IdxConfig struct {
+	Enabled          bool
+	Dir              string
+	UpdateInterval   time.Duration
+	updateInterval32 uint32
+	PruneInterval    time.Duration
+	SnapshotInterval time.Duration
+	SyncInterval     time.Duration
+}
+
+func (cfg *IdxConfig) Validate() error {
+	cfg.updateInterval32 = uint32(cfg.UpdateInterval.Nanoseconds() / int64(time.Second))
+	if cfg.Dir == "" {
+		return errors.New("dir must be set")
+	}
+	if cfg.PruneInterval == 0 {
+		return errors.New("prune-interval must be greater then 0")
+	}
+	if cfg.SnapshotInterval <= 0 {
+		return errors.New("snapshot-interval must be greater then 0")
+	}
+	if cfg.SyncInterval <= 0 {
+		return errors.New("sync-interval must be greater then 0")
+	}
+	return nil
+}
+
+// return IdxConfig with default values set.
+func NewIdxConfig() *IdxConfig {
+	return &IdxConfig{
+		Enabled:          false,
+		Dir:              "/var/lib/metrictank/index",
+		UpdateInterval:   time.Hour * 3,
+		PruneInterval:    time.Hour * 3,
+		SnapshotInterval: time.Hour,
+		SyncInterval:     time.Second,
+	}
+}
+
+var CliConfig = NewIdxConfig()
+
+func ConfigSetup() {
+	fileIdx := flag.NewFlagSet("file-idx", flag.ExitOnError)
+
+	fileIdx.BoolVar(&CliConfig.Enabled, "enabled", CliConfig.Enabled, "")
+	fileIdx.StringVar(&CliConfig.Dir, "dir", CliConfig.Dir, "directory to store the write-ahead log and snapshots of the index in")
+	fileIdx.DurationVar(&CliConfig.UpdateInterval, "update-interval", CliConfig.UpdateInterval, "frequency at which we should update the metricDef lastUpdate field, use 0s for instant updates")
+	fileIdx.DurationVar(&CliConfig.PruneInterval, "prune-interval", CliConfig.PruneInterval, "Interval at which the index should be checked for stale series.")
+	fileIdx.DurationVar(&CliConfig.SnapshotInterval, "snapshot-interval", CliConfig.SnapshotInterval, "Interval at which a snapshot of the index is written, after which the older write-ahead log files are deleted")
+	fileIdx.DurationVar(&CliConfig.SyncInterval, "sync-interval", CliConfig.SyncInterval, "Interval at which the write-ahead log is synced to disk. changes that were not synced yet are lost on a crash")
+
+	globalconf.Register("file-idx", fileIdx, flag.ExitOnError)
+}
+
+func ConfigProcess() {
+	if err := CliConfig.Validate(); err != nil {
+		log.Fatalf("file-idx: Config validation error. %s", err)
+	}
+}
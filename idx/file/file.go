@@ -0,0 +1,525 @@
+// Package file implements a metric index that persists the metricDefinitions in local files,
+// so that a single node can restart without losing its index, without needing cassandra or bigtable.
+package file
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/grafana/metrictank/expr/tagquery"
+	"github.com/grafana/metrictank/idx"
+	"github.com/grafana/metrictank/idx/memory"
+	"github.com/grafana/metrictank/schema"
+	"github.com/grafana/metrictank/stats"
+	log "github.com/sirupsen/logrus"
+)
+
+var (
+	// metric idx.file.wal.ok is how many records were successfully written to the write-ahead log
+	statWalOk = stats.NewCounter32("idx.file.wal.ok")
+	// metric idx.file.wal.fail is how many records could not be written to the write-ahead log
+	statWalFail = stats.NewCounter32("idx.file.wal.fail")
+	// metric idx.file.snapshot is the duration of writing a snapshot of the file idx
+	statSnapshotDuration = stats.NewLatencyHistogram12h32("idx.file.snapshot")
+
+	// metric idx.file.add is the duration of an add of one metric to the file idx, including the add to the in-memory index and the write to the write-ahead log
+	statAddDuration = stats.NewLatencyHistogram15s32("idx.file.add")
+	// metric idx.file.update is the duration of an update of one metric to the file idx, including the update to the in-memory index and any write to the write-ahead log
+	statUpdateDuration = stats.NewLatencyHistogram15s32("idx.file.update")
+	// metric idx.file.prune is the duration of a prune of the file idx, including the prune of the in-memory index and the writes to the write-ahead log
+	statPruneDuration = stats.NewLatencyHistogram15s32("idx.file.prune")
+	// metric idx.file.delete is the duration of a delete of one or more metrics from the file idx, including the delete from the in-memory index and the writes to the write-ahead log
+	statDeleteDuration = stats.NewLatencyHistogram15s32("idx.file.delete")
+)
+
+// FileIdx implements the the "MetricIndex" interface
+type FileIdx struct {
+	memory.MemoryIndex
+	Config *IdxConfig
+
+	sync.Mutex // protects the fields below
+	seq        uint64
+	wal        *os.File
+	walWriter  *bufio.Writer
+	dirty      bool
+	orgs       map[uint32]struct{} // orgs that have metricDefs, so we know what to snapshot
+	metaOrgs   map[uint32]struct{} // orgs that have meta records
+
+	shutdown chan struct{}
+	wg       sync.WaitGroup
+}
+
+func New(cfg *IdxConfig) *FileIdx {
+	if err := cfg.Validate(); err != nil {
+		log.Fatalf("file-idx: %s", err)
+	}
+	return &FileIdx{
+		MemoryIndex: memory.New(),
+		Config:      cfg,
+		orgs:        make(map[uint32]struct{}),
+		metaOrgs:    make(map[uint32]struct{}),
+		shutdown:    make(chan struct{}),
+	}
+}
+
+// Init rebuilds the in-memory index from the newest snapshot and the write-ahead log,
+// opens a new write-ahead log file and starts the sync, snapshot and pruning routines
+func (f *FileIdx) Init() error {
+	log.Infof("initializing file-idx. Dir=%s", f.Config.Dir)
+	if err := f.MemoryIndex.Init(); err != nil {
+		return err
+	}
+	if err := os.MkdirAll(f.Config.Dir, 0755); err != nil {
+		return fmt.Errorf("file-idx: failed to create %s: %s", f.Config.Dir, err)
+	}
+	if err := f.rebuildIndex(); err != nil {
+		return err
+	}
+	if err := f.openWal(f.seq + 1); err != nil {
+		return err
+	}
+
+	f.wg.Add(2)
+	go f.syncLoop()
+	go f.snapshotLoop()
+	if memory.IndexRules().Prunable() {
+		f.wg.Add(1)
+		go f.prune()
+	}
+	return nil
+}
+
+// rebuildIndex loads the newest snapshot, and replays the write-ahead log files on top of it
+func (f *FileIdx) rebuildIndex() error {
+	log.Info("file-idx: Rebuilding Memory Index from snapshot and write-ahead log")
+	pre := time.Now()
+	st := newState()
+
+	snapshots, err := listFiles(f.Config.Dir, snapshotPrefix)
+	if err != nil {
+		return fmt.Errorf("file-idx: failed to list snapshots: %s", err)
+	}
+	var first uint64
+	if len(snapshots) > 0 {
+		first = snapshots[len(snapshots)-1]
+		path := fileName(f.Config.Dir, snapshotPrefix, first)
+		// snapshots are written to a temporary file first, so they are always complete
+		if _, err := st.readFile(path); err != nil {
+			return fmt.Errorf("file-idx: failed to read snapshot %s: %s", path, err)
+		}
+		f.seq = first
+	}
+	wals, err := listFiles(f.Config.Dir, walPrefix)
+	if err != nil {
+		return fmt.Errorf("file-idx: failed to list write-ahead log files: %s", err)
+	}
+	var replayed int
+	for _, seq := range wals {
+		if seq > f.seq {
+			f.seq = seq
+		}
+		if seq < first {
+			continue
+		}
+		path := fileName(f.Config.Dir, walPrefix, seq)
+		num, err := st.readFile(path)
+		replayed += num
+		if err == errCorruptRecord {
+			// we crashed while writing this record. we never append to old files, so we can just skip it
+			log.Warnf("file-idx: ignoring incomplete record at the end of %s", path)
+		} else if err != nil {
+			return fmt.Errorf("file-idx: failed to replay %s: %s", path, err)
+		}
+	}
+
+	byPartition := make(map[int32][]schema.MetricDefinition)
+	for _, def := range st.defs {
+		byPartition[def.Partition] = append(byPartition[def.Partition], def)
+		f.orgs[def.OrgId] = struct{}{}
+	}
+	var num int
+	for partition, defs := range byPartition {
+		num += f.MemoryIndex.LoadPartition(partition, defs)
+	}
+
+	// meta records must be loaded after the metricDefs, like the cassandra index does
+	orgIds := make([]uint32, 0, len(st.metaOps))
+	for orgId := range st.metaOps {
+		orgIds = append(orgIds, orgId)
+	}
+	sort.Slice(orgIds, func(i, j int) bool { return orgIds[i] < orgIds[j] })
+	for _, orgId := range orgIds {
+		f.metaOrgs[orgId] = struct{}{}
+		if !memory.TagSupport || !memory.MetaTagSupport {
+			continue
+		}
+		for _, op := range st.metaOps[orgId] {
+			var err error
+			if op.swap {
+				err = f.MemoryIndex.MetaTagRecordSwap(orgId, op.records)
+			} else {
+				err = f.MemoryIndex.MetaTagRecordUpsert(orgId, op.records[0])
+			}
+			if err != nil {
+				log.Errorf("file-idx: failed to restore meta records of org %d: %s", orgId, err)
+			}
+		}
+	}
+
+	log.Infof("file-idx: Rebuilding Memory Index Complete. Imported %d, replayed %d write-ahead log records. Took %s", num, replayed, time.Since(pre))
+	return nil
+}
+
+// openWal opens a new write-ahead log file with the given sequence number
+// caller must hold the lock, if the index is in use
+func (f *FileIdx) openWal(seq uint64) error {
+	path := fileName(f.Config.Dir, walPrefix, seq)
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return fmt.Errorf("file-idx: failed to open write-ahead log %s: %s", path, err)
+	}
+	f.seq = seq
+	f.wal = file
+	f.walWriter = bufio.NewWriter(file)
+	return nil
+}
+
+// sync flushes the write-ahead log and syncs it to disk
+// caller must hold the lock
+func (f *FileIdx) sync() error {
+	if !f.dirty {
+		return nil
+	}
+	if err := f.walWriter.Flush(); err != nil {
+		return err
+	}
+	if err := f.wal.Sync(); err != nil {
+		return err
+	}
+	f.dirty = false
+	return nil
+}
+
+func (f *FileIdx) syncLoop() {
+	defer f.wg.Done()
+	ticker := time.NewTicker(f.Config.SyncInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			f.Lock()
+			err := f.sync()
+			f.Unlock()
+			if err != nil {
+				log.Errorf("file-idx: failed to sync write-ahead log: %s", err)
+			}
+		case <-f.shutdown:
+			return
+		}
+	}
+}
+
+// write appends the records to the write-ahead log
+func (f *FileIdx) write(records ...[]byte) error {
+	f.Lock()
+	defer f.Unlock()
+	for _, record := range records {
+		if _, err := f.walWriter.Write(record); err != nil {
+			statWalFail.Add(len(records))
+			return err
+		}
+	}
+	f.dirty = true
+	statWalOk.Add(len(records))
+	return nil
+}
+
+// saveDef journals the archive's metricDef and updates its LastSave
+func (f *FileIdx) saveDef(now uint32, archive idx.Archive) idx.Archive {
+	record, err := encodeDef(&archive.MetricDefinition)
+	if err == nil {
+		f.Lock()
+		f.orgs[archive.OrgId] = struct{}{}
+		f.Unlock()
+		err = f.write(record)
+	}
+	if err != nil {
+		// LastSave isn't updated, so we'll try again next time the metric is seen
+		log.Errorf("file-idx: failed to save def %s: %s", archive.Id, err)
+		return archive
+	}
+	archive.LastSave = now
+	f.MemoryIndex.UpdateArchiveLastSave(archive.Id, archive.Partition, now)
+	return archive
+}
+
+// deleteDefs journals the deletion of the archives
+func (f *FileIdx) deleteDefs(archives []idx.Archive) {
+	if len(archives) == 0 {
+		return
+	}
+	records := make([][]byte, len(archives))
+	for i, archive := range archives {
+		records[i] = encodeRecord(opDeleteDef, []byte(archive.Id.String()))
+	}
+	if err := f.write(records...); err != nil {
+		log.Errorf("file-idx: failed to save deletion of %d defs: %s", len(archives), err)
+	}
+}
+
+// Update updates an existing archive, if found.
+// It returns whether it was found, and - if so - the (updated) existing archive and its old partition
+func (f *FileIdx) Update(point schema.MetricPoint, partition int32) (idx.Archive, int32, bool) {
+	pre := time.Now()
+
+	archive, oldPartition, inMemory := f.MemoryIndex.Update(point, partition)
+
+	if inMemory {
+		now := uint32(time.Now().Unix())
+		if oldPartition != partition || archive.LastSave < (now-f.Config.updateInterval32) {
+			archive = f.saveDef(now, archive)
+		}
+	}
+
+	statUpdateDuration.Value(time.Since(pre))
+	return archive, oldPartition, inMemory
+}
+
+func (f *FileIdx) AddOrUpdate(mkey schema.MKey, data *schema.MetricData, partition int32) (idx.Archive, int32, bool) {
+	pre := time.Now()
+
+	archive, oldPartition, inMemory := f.MemoryIndex.AddOrUpdate(mkey, data, partition)
+
+	stat := statUpdateDuration
+	if !inMemory {
+		stat = statAddDuration
+	}
+
+	now := uint32(time.Now().Unix())
+	if !inMemory || oldPartition != partition || archive.LastSave < (now-f.Config.updateInterval32) {
+		archive = f.saveDef(now, archive)
+	}
+
+	stat.Value(time.Since(pre))
+	return archive, oldPartition, inMemory
+}
+
+func (f *FileIdx) Find(orgId uint32, pattern string, from int64) ([]idx.Node, error) {
+	// like with the cassandra index, the lastUpdate timestamp is only saved every updateInterval,
+	// so we offset the from time to err on the "too inclusive" side
+	if from > int64(f.Config.updateInterval32) {
+		from -= int64(f.Config.updateInterval32)
+	}
+	return f.MemoryIndex.Find(orgId, pattern, from)
+}
+
+func (f *FileIdx) Delete(orgId uint32, pattern string) ([]idx.Archive, error) {
+	pre := time.Now()
+	defs, err := f.MemoryIndex.Delete(orgId, pattern)
+	if err != nil {
+		return defs, err
+	}
+	f.deleteDefs(defs)
+	statDeleteDuration.Value(time.Since(pre))
+	return defs, nil
+}
+
+func (f *FileIdx) DeleteTagged(orgId uint32, query tagquery.Query) []idx.Archive {
+	pre := time.Now()
+	defs := f.MemoryIndex.DeleteTagged(orgId, query)
+	f.deleteDefs(defs)
+	statDeleteDuration.Value(time.Since(pre))
+	return defs
+}
+
+func (f *FileIdx) Prune(now time.Time) ([]idx.Archive, error) {
+	log.Info("file-idx: start pruning of series")
+	pruned, err := f.MemoryIndex.Prune(now)
+	f.deleteDefs(pruned)
+	duration := time.Since(now)
+	if err != nil {
+		log.Errorf("file-idx: pruning error: %s", err)
+	} else {
+		statPruneDuration.Value(duration)
+		log.Infof("file-idx: finished pruning of %d series in %s", len(pruned), duration)
+	}
+	return pruned, err
+}
+
+func (f *FileIdx) prune() {
+	defer f.wg.Done()
+	ticker := time.NewTicker(f.Config.PruneInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case now := <-ticker.C:
+			f.Prune(now)
+		case <-f.shutdown:
+			return
+		}
+	}
+}
+
+func (f *FileIdx) MetaTagRecordUpsert(orgId uint32, record tagquery.MetaTagRecord) error {
+	if err := f.MemoryIndex.MetaTagRecordUpsert(orgId, record); err != nil {
+		return err
+	}
+	return f.saveMeta(opMetaUpsert, orgId, record)
+}
+
+func (f *FileIdx) MetaTagRecordSwap(orgId uint32, records []tagquery.MetaTagRecord) error {
+	if err := f.MemoryIndex.MetaTagRecordSwap(orgId, records); err != nil {
+		return err
+	}
+	return f.saveMeta(opMetaSwap, orgId, records)
+}
+
+func (f *FileIdx) saveMeta(op byte, orgId uint32, v interface{}) error {
+	record, err := encodeMeta(op, orgId, v)
+	if err == nil {
+		f.Lock()
+		f.metaOrgs[orgId] = struct{}{}
+		f.Unlock()
+		err = f.write(record)
+	}
+	if err != nil {
+		log.Errorf("file-idx: failed to save meta records of org %d: %s", orgId, err)
+		return fmt.Errorf("Failed to save meta records: %s", err)
+	}
+	return nil
+}
+
+func (f *FileIdx) snapshotLoop() {
+	defer f.wg.Done()
+	ticker := time.NewTicker(f.Config.SnapshotInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			if err := f.Snapshot(); err != nil {
+				log.Errorf("file-idx: failed to write snapshot: %s", err)
+			}
+		case <-f.shutdown:
+			return
+		}
+	}
+}
+
+// Snapshot switches to a new write-ahead log file, writes the content of the index to a snapshot,
+// and deletes the older write-ahead log files and snapshots.
+// changes made while the snapshot is written may end up in both the snapshot and the new write-ahead log,
+// which is fine because replaying them again has no effect.
+func (f *FileIdx) Snapshot() error {
+	pre := time.Now()
+
+	f.Lock()
+	if err := f.sync(); err != nil {
+		f.Unlock()
+		return err
+	}
+	f.wal.Close()
+	if err := f.openWal(f.seq + 1); err != nil {
+		f.Unlock()
+		return err
+	}
+	seq := f.seq
+	orgs := make([]uint32, 0, len(f.orgs))
+	for orgId := range f.orgs {
+		orgs = append(orgs, orgId)
+	}
+	metaOrgs := make([]uint32, 0, len(f.metaOrgs))
+	for orgId := range f.metaOrgs {
+		metaOrgs = append(metaOrgs, orgId)
+	}
+	f.Unlock()
+
+	path := fileName(f.Config.Dir, snapshotPrefix, seq)
+	tmp := path + ".tmp"
+	file, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	w := bufio.NewWriter(file)
+	var num int
+	err = func() error {
+		for _, orgId := range orgs {
+			for _, archive := range f.MemoryIndex.List(orgId) {
+				// List also returns the defs of the public org
+				if archive.OrgId != orgId {
+					continue
+				}
+				record, err := encodeDef(&archive.MetricDefinition)
+				if err != nil {
+					return err
+				}
+				if _, err := w.Write(record); err != nil {
+					return err
+				}
+				num++
+			}
+		}
+		for _, orgId := range metaOrgs {
+			record, err := encodeMeta(opMetaSwap, orgId, f.MemoryIndex.MetaTagRecordList(orgId))
+			if err != nil {
+				return err
+			}
+			if _, err := w.Write(record); err != nil {
+				return err
+			}
+		}
+		if err := w.Flush(); err != nil {
+			return err
+		}
+		return file.Sync()
+	}()
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmp, path)
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return err
+	}
+
+	// the new snapshot and write-ahead log make all older files obsolete
+	for _, prefix := range []string{walPrefix, snapshotPrefix} {
+		seqs, err := listFiles(f.Config.Dir, prefix)
+		if err != nil {
+			return err
+		}
+		for _, s := range seqs {
+			if s < seq {
+				if err := os.Remove(fileName(f.Config.Dir, prefix, s)); err != nil {
+					log.Errorf("file-idx: failed to delete obsolete file: %s", err)
+				}
+			}
+		}
+	}
+
+	statSnapshotDuration.Value(time.Since(pre))
+	log.Infof("file-idx: wrote snapshot %s with %d defs in %s", path, num, time.Since(pre))
+	return nil
+}
+
+func (f *FileIdx) Stop() {
+	log.Info("file-idx: stopping")
+	f.MemoryIndex.Stop()
+	close(f.shutdown)
+	f.wg.Wait()
+
+	f.Lock()
+	defer f.Unlock()
+	if f.wal == nil {
+		return
+	}
+	if err := f.sync(); err != nil {
+		log.Errorf("file-idx: failed to sync write-ahead log: %s", err)
+	}
+	f.wal.Close()
+}
@@ -0,0 +1,156 @@
+package file
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/grafana/metrictank/cluster"
+	"github.com/grafana/metrictank/expr/tagquery"
+	"github.com/grafana/metrictank/idx/memory"
+	"github.com/grafana/metrictank/schema"
+)
+
+func init() {
+	cluster.Init("default", "test", time.Now(), "http", 6060)
+}
+
+func testConfig(t *testing.T) (*IdxConfig, func()) {
+	dir, err := ioutil.TempDir("", "file-idx")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg := NewIdxConfig()
+	cfg.Enabled = true
+	cfg.Dir = dir
+	return cfg, func() { os.RemoveAll(dir) }
+}
+
+func newIdx(t *testing.T, cfg *IdxConfig) *FileIdx {
+	ix := New(cfg)
+	if err := ix.Init(); err != nil {
+		t.Fatal(err)
+	}
+	return ix
+}
+
+func addSeries(ix *FileIdx, orgId uint32, prefix string, count int) {
+	for i := 0; i < count; i++ {
+		data := &schema.MetricData{
+			Name:     fmt.Sprintf("%s.%d", prefix, i),
+			OrgId:    int(orgId),
+			Interval: 10,
+			Time:     time.Now().Unix(),
+		}
+		data.SetId()
+		mkey, _ := schema.MKeyFromString(data.Id)
+		ix.AddOrUpdate(mkey, data, int32(i%2))
+	}
+}
+
+func count(ix *FileIdx, orgId uint32) int {
+	var n int
+	for _, archive := range ix.List(orgId) {
+		if archive.OrgId == orgId {
+			n++
+		}
+	}
+	return n
+}
+
+func checkCounts(t *testing.T, ix *FileIdx, exp map[uint32]int) {
+	t.Helper()
+	for orgId, n := range exp {
+		if got := count(ix, orgId); got != n {
+			t.Fatalf("org %d: expected %d series, got %d", orgId, n, got)
+		}
+	}
+}
+
+func TestRestore(t *testing.T) {
+	cfg, cleanup := testConfig(t)
+	defer cleanup()
+
+	ix := newIdx(t, cfg)
+	addSeries(ix, 1, "a", 5)
+	addSeries(ix, 2, "b", 3)
+	if _, err := ix.Delete(1, "a.1"); err != nil {
+		t.Fatal(err)
+	}
+	ix.Stop()
+
+	ix = newIdx(t, cfg)
+	checkCounts(t, ix, map[uint32]int{1: 4, 2: 3})
+	if nodes, _ := ix.Find(1, "a.1", 0); len(nodes) != 0 {
+		t.Fatalf("expected deleted series to stay deleted, got %v", nodes)
+	}
+	archives := ix.GetPath(2, "b.1")
+	if len(archives) != 1 || archives[0].Partition != 1 {
+		t.Fatalf("expected series b.1 in partition 1, got %v", archives)
+	}
+
+	// after a snapshot, only the snapshot and the new write-ahead log remain
+	if err := ix.Snapshot(); err != nil {
+		t.Fatal(err)
+	}
+	addSeries(ix, 3, "c", 2)
+	ix.Stop()
+	files, _ := ioutil.ReadDir(cfg.Dir)
+	if len(files) != 2 {
+		t.Fatalf("expected 2 files after snapshot, got %d", len(files))
+	}
+
+	// simulate a crash while writing a record
+	wals, _ := listFiles(cfg.Dir, walPrefix)
+	f, err := os.OpenFile(fileName(cfg.Dir, walPrefix, wals[len(wals)-1]), os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.Write([]byte{100, 0, 0, 0, 1, 2})
+	f.Close()
+
+	ix = newIdx(t, cfg)
+	defer ix.Stop()
+	checkCounts(t, ix, map[uint32]int{1: 4, 2: 3, 3: 2})
+}
+
+func TestRestoreMetaRecords(t *testing.T) {
+	memory.TagSupport = true
+	memory.MetaTagSupport = true
+	defer func() {
+		memory.TagSupport = false
+		memory.MetaTagSupport = false
+	}()
+	cfg, cleanup := testConfig(t)
+	defer cleanup()
+
+	record1, err := tagquery.ParseMetaTagRecord([]string{"meta=a"}, []string{"name=a.1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	record2, err := tagquery.ParseMetaTagRecord([]string{"meta=b"}, []string{"name=a.2"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ix := newIdx(t, cfg)
+	addSeries(ix, 1, "a", 3)
+	if err := ix.MetaTagRecordSwap(1, []tagquery.MetaTagRecord{record1}); err != nil {
+		t.Fatal(err)
+	}
+	if err := ix.Snapshot(); err != nil {
+		t.Fatal(err)
+	}
+	if err := ix.MetaTagRecordUpsert(1, record2); err != nil {
+		t.Fatal(err)
+	}
+	ix.Stop()
+
+	ix = newIdx(t, cfg)
+	defer ix.Stop()
+	if records := ix.MetaTagRecordList(1); len(records) != 2 {
+		t.Fatalf("expected 2 meta records, got %v", records)
+	}
+}
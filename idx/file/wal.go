@@ -0,0 +1,195 @@
+package file
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/grafana/metrictank/expr/tagquery"
+	"github.com/grafana/metrictank/schema"
+)
+
+// the write-ahead log and the snapshots are sequences of records:
+//
+// length  uint32 (length of the body)
+// crc     uint32 (IEEE crc32 of the body)
+// body:
+//   op      byte
+//   payload []byte (depends on op)
+//
+// a snapshot holds an opAddDef record for every metricDef, and an opMetaSwap record for every org with meta records.
+// files are named <kind>.<seq>. snapshot.N holds the state of the index as of the start of wal.N,
+// so to restore the index we load the newest snapshot and replay all wal files with the same or a higher seq.
+
+const (
+	opAddDef     byte = 1 // payload: msgp encoded MetricDefinition. also used for updates
+	opDeleteDef  byte = 2 // payload: the MKey string
+	opMetaUpsert byte = 3 // payload: orgId uint32, json encoded MetaTagRecord
+	opMetaSwap   byte = 4 // payload: orgId uint32, json encoded []MetaTagRecord
+
+	recordHeaderSize = 8
+	walPrefix        = "wal."
+	snapshotPrefix   = "snapshot."
+)
+
+var errCorruptRecord = errors.New("corrupt record")
+
+func encodeRecord(op byte, payload []byte) []byte {
+	buf := make([]byte, recordHeaderSize+1+len(payload))
+	body := buf[recordHeaderSize:]
+	body[0] = op
+	copy(body[1:], payload)
+	binary.LittleEndian.PutUint32(buf[0:], uint32(len(body)))
+	binary.LittleEndian.PutUint32(buf[4:], crc32.ChecksumIEEE(body))
+	return buf
+}
+
+func encodeDef(def *schema.MetricDefinition) ([]byte, error) {
+	payload, err := def.MarshalMsg(nil)
+	if err != nil {
+		return nil, err
+	}
+	return encodeRecord(opAddDef, payload), nil
+}
+
+func encodeMeta(op byte, orgId uint32, v interface{}) ([]byte, error) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	payload := make([]byte, 4+len(data))
+	binary.LittleEndian.PutUint32(payload, orgId)
+	copy(payload[4:], data)
+	return encodeRecord(op, payload), nil
+}
+
+// metaOp is a change to the meta records of an org
+type metaOp struct {
+	swap    bool
+	records []tagquery.MetaTagRecord // for upserts, this holds 1 record
+}
+
+// state is the content of the index, as restored from the snapshot and write-ahead log
+type state struct {
+	defs    map[schema.MKey]schema.MetricDefinition
+	metaOps map[uint32][]metaOp
+}
+
+func newState() *state {
+	return &state{
+		defs:    make(map[schema.MKey]schema.MetricDefinition),
+		metaOps: make(map[uint32][]metaOp),
+	}
+}
+
+func (s *state) apply(body []byte) error {
+	if len(body) < 1 {
+		return errCorruptRecord
+	}
+	payload := body[1:]
+	switch body[0] {
+	case opAddDef:
+		var def schema.MetricDefinition
+		if _, err := def.UnmarshalMsg(payload); err != nil {
+			return err
+		}
+		s.defs[def.Id] = def
+	case opDeleteDef:
+		key, err := schema.MKeyFromString(string(payload))
+		if err != nil {
+			return err
+		}
+		delete(s.defs, key)
+	case opMetaUpsert, opMetaSwap:
+		if len(payload) < 4 {
+			return errCorruptRecord
+		}
+		orgId := binary.LittleEndian.Uint32(payload)
+		if body[0] == opMetaSwap {
+			var records []tagquery.MetaTagRecord
+			if err := json.Unmarshal(payload[4:], &records); err != nil {
+				return err
+			}
+			// a swap replaces all previous changes
+			s.metaOps[orgId] = []metaOp{{swap: true, records: records}}
+		} else {
+			var record tagquery.MetaTagRecord
+			if err := json.Unmarshal(payload[4:], &record); err != nil {
+				return err
+			}
+			s.metaOps[orgId] = append(s.metaOps[orgId], metaOp{records: []tagquery.MetaTagRecord{record}})
+		}
+	default:
+		return fmt.Errorf("unknown op %d", body[0])
+	}
+	return nil
+}
+
+// readFile applies all records in the file to the state, and returns how many records it applied.
+// reading stops at the first incomplete or corrupt record, in which case errCorruptRecord is returned.
+func (s *state) readFile(path string) (int, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+	r := bufio.NewReader(f)
+	var header [recordHeaderSize]byte
+	var num int
+	for {
+		_, err := io.ReadFull(r, header[:])
+		if err == io.EOF {
+			return num, nil
+		}
+		if err != nil {
+			return num, errCorruptRecord
+		}
+		body := make([]byte, binary.LittleEndian.Uint32(header[0:]))
+		if _, err := io.ReadFull(r, body); err != nil {
+			return num, errCorruptRecord
+		}
+		if crc32.ChecksumIEEE(body) != binary.LittleEndian.Uint32(header[4:]) {
+			return num, errCorruptRecord
+		}
+		if err := s.apply(body); err != nil {
+			return num, err
+		}
+		num++
+	}
+}
+
+// listFiles returns the sequence numbers of the files of the given kind in dir, in ascending order
+func listFiles(dir, prefix string) ([]uint64, error) {
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	var seqs []uint64
+	for _, file := range files {
+		if !strings.HasPrefix(file.Name(), prefix) {
+			continue
+		}
+		seq, err := strconv.ParseUint(strings.TrimPrefix(file.Name(), prefix), 10, 64)
+		if err != nil {
+			// e.g. a snapshot that was not completely written
+			continue
+		}
+		seqs = append(seqs, seq)
+	}
+	sort.Slice(seqs, func(i, j int) bool { return seqs[i] < seqs[j] })
+	return seqs, nil
+}
+
+func fileName(dir, prefix string, seq uint64) string {
+	return filepath.Join(dir, fmt.Sprintf("%s%010d", prefix, seq))
+}
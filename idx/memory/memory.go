@@ -57,7 +57,9 @@ var (
 	metaTagEnricherBufferSize    = 10000
 	metaTagEnricherBufferTime    = 5 * time.Second
 	indexRulesFile               string
-	IndexRules                   conf.IndexRules
+	currentIndexRules            atomic.Value // holds a *conf.IndexRules. see IndexRules()
+	rulesLock                    sync.RWMutex
+	reloadLock                   sync.Mutex
 	Partitioned                  bool
 	findCacheSize                = 1000
 	findCacheInvalidateQueueSize = 200
@@ -71,6 +73,21 @@ var (
 	MetaTagSupport               = false
 )
 
+func init() {
+	currentIndexRules.Store(&conf.IndexRules{})
+}
+
+// IndexRules returns the current index rules.
+// they are replaced as a whole, never modified, so that readers always see a consistent set of rules.
+func IndexRules() *conf.IndexRules {
+	return currentIndexRules.Load().(*conf.IndexRules)
+}
+
+// SetIndexRules replaces the current index rules
+func SetIndexRules(rules conf.IndexRules) {
+	currentIndexRules.Store(&rules)
+}
+
 func ConfigSetup() *flag.FlagSet {
 	memoryIdx := flag.NewFlagSet("memory-idx", flag.ExitOnError)
 	memoryIdx.BoolVar(&Enabled, "enabled", false, "")
@@ -105,14 +122,11 @@ func ConfigProcess() {
 	if maxPruneLockTime > time.Second {
 		log.Fatalf("invalid max-prune-lock-time of %s. Must be <= 1 second", maxPruneLockTimeStr)
 	}
-	// read index-rules.conf
-	IndexRules, err = conf.ReadIndexRules(indexRulesFile)
-	if os.IsNotExist(err) {
-		log.Infof("Index-rules.conf file %s does not exist; using defaults", indexRulesFile)
-		IndexRules = conf.NewIndexRules()
-	} else if err != nil {
-		log.Fatalf("can't read index-rules file %q: %s", indexRulesFile, err.Error())
+	indexRules, err := ReadIndexRules()
+	if err != nil {
+		log.Fatal(err.Error())
 	}
+	SetIndexRules(indexRules)
 
 	if findCacheInvalidateMaxSize >= findCacheInvalidateQueueSize {
 		log.Fatal("find-cache-invalidate-max-size should be smaller than find-cache-invalidate-queue-size")
@@ -122,6 +136,18 @@ func ConfigProcess() {
 	tagquery.MatchCacheSize = matchCacheSize
 }
 
+// ReadIndexRules reads and validates the configured index-rules.conf file
+func ReadIndexRules() (conf.IndexRules, error) {
+	indexRules, err := conf.ReadIndexRules(indexRulesFile)
+	if os.IsNotExist(err) {
+		log.Infof("Index-rules.conf file %s does not exist; using defaults", indexRulesFile)
+		return conf.NewIndexRules(), nil
+	} else if err != nil {
+		return conf.IndexRules{}, fmt.Errorf("can't read index-rules file %q: %s", indexRulesFile, err.Error())
+	}
+	return indexRules, nil
+}
+
 // interface implemented by both UnpartitionedMemoryIdx and PartitionedMemoryIdx
 // this is needed to support unit tests.
 type MemoryIndex interface {
@@ -132,6 +158,8 @@ type MemoryIndex interface {
 	idsByTagQuery(uint32, TagQueryContext) chan schema.MKey
 	PurgeFindCache()
 	ForceInvalidationFindCache()
+	UpdateRules(install func()) int
+	SeriesCount(orgId uint32) int
 }
 
 func New() MemoryIndex {
@@ -267,6 +295,9 @@ type UnpartitionedMemoryIdx struct {
 	// and without tags. It also mixes all orgs into one flat map.
 	defById map[schema.MKey]*idx.Archive
 
+	// number of entries in defById, by orgId. kept so series can be counted without walking defById
+	seriesByOrg map[uint32]int
+
 	// used by hierarchy index only
 	tree map[uint32]*Tree // by orgId
 
@@ -285,6 +316,7 @@ type UnpartitionedMemoryIdx struct {
 func NewUnpartitionedMemoryIdx() *UnpartitionedMemoryIdx {
 	m := &UnpartitionedMemoryIdx{
 		defById:         make(map[schema.MKey]*idx.Archive),
+		seriesByOrg:     make(map[uint32]int),
 		defByTagSet:     make(defByTagSet),
 		tree:            make(map[uint32]*Tree),
 		tags:            make(map[uint32]TagIndex),
@@ -417,6 +449,7 @@ func (m *UnpartitionedMemoryIdx) AddOrUpdate(mkey schema.MKey, data *schema.Metr
 	}
 	def := schema.MetricDefinitionFromMetricData(data)
 	def.Partition = partition
+	rulesLock.RLock()
 	archive := createArchive(def)
 	if m.writeQueue == nil {
 		// writeQueue not enabled, so acquire a wlock and immediately add to the index.
@@ -429,6 +462,7 @@ func (m *UnpartitionedMemoryIdx) AddOrUpdate(mkey schema.MKey, data *schema.Metr
 		// writeQueue with the same mkey, it will be replaced.
 		m.writeQueue.Queue(archive)
 	}
+	rulesLock.RUnlock()
 
 	return CloneArchive(archive), 0, false
 }
@@ -894,9 +928,9 @@ func (m *UnpartitionedMemoryIdx) Load(defs []schema.MetricDefinition) int {
 
 func createArchive(def *schema.MetricDefinition) *idx.Archive {
 	path := def.NameWithTags()
-	schemaId, _ := mdata.MatchSchema(path, def.Interval)
-	aggId, _ := mdata.MatchAgg(path)
-	irId, _ := IndexRules.Match(path)
+	schemaId, _ := mdata.MatchSchema(def.OrgId, path, def.Interval)
+	aggId, _ := mdata.MatchAgg(def.OrgId, path)
+	irId, _ := IndexRules().Match(path)
 
 	return &idx.Archive{
 		MetricDefinition: *def,
@@ -906,6 +940,84 @@ func createArchive(def *schema.MetricDefinition) *idx.Archive {
 	}
 }
 
+// UpdateRules calls install, which replaces the schemas, aggregations and/or index rules,
+// and re-evaluates the SchemaId, AggId and IrId of all archives against the new rules.
+// It returns the number of archives of which any of them changed.
+func (m *UnpartitionedMemoryIdx) UpdateRules(install func()) int {
+	return updateRules([]*UnpartitionedMemoryIdx{m}, install)
+}
+
+// ruleChange is a new set of rule ids for an archive
+type ruleChange struct {
+	archive  *idx.Archive
+	schemaId uint16
+	aggId    uint16
+	irId     uint16
+}
+
+// updateRules installs new rules and re-evaluates the archives of all given indexes against them.
+// new archives are matched against the rules while holding a read lock on rulesLock, so once install
+// has run under the write lock, all archives that are added to the index or its write queue afterwards
+// match the new rules. the existing archives are then re-matched while only holding a read lock on
+// the index, and the changed ids are swapped in under a short write lock, so that queries and the
+// ingestion of new series are not blocked while matching.
+// queries that run in between may still see ids of the old rules, so lookups of them must be bounds checked.
+func updateRules(indexes []*UnpartitionedMemoryIdx, install func()) int {
+	reloadLock.Lock()
+	defer reloadLock.Unlock()
+
+	rulesLock.Lock()
+	install()
+	rulesLock.Unlock()
+
+	// archives waiting in the write queues may have been matched against the old rules.
+	// add them to the index, so they get re-matched below.
+	for _, m := range indexes {
+		if m.writeQueue != nil {
+			m.writeQueue.Lock()
+			m.writeQueue.flush()
+			m.writeQueue.Unlock()
+		}
+	}
+
+	schemas := mdata.Schemas()
+	aggregations := mdata.Aggregations()
+	indexRules := IndexRules()
+
+	var changed int
+	for _, m := range indexes {
+		var changes []ruleChange
+		m.RLock()
+		for _, archive := range m.defById {
+			path := archive.NameWithTags()
+			schemaId, _ := schemas.Match(archive.OrgId, path, archive.Interval)
+			aggId, _ := aggregations.Match(archive.OrgId, path)
+			irId, _ := indexRules.Match(path)
+			if schemaId != archive.SchemaId || aggId != archive.AggId || irId != archive.IrId {
+				changes = append(changes, ruleChange{archive, schemaId, aggId, irId})
+			}
+		}
+		m.RUnlock()
+
+		if len(changes) == 0 {
+			continue
+		}
+		m.Lock()
+		for _, c := range changes {
+			// the archive may have been deleted in the meantime
+			if m.defById[c.archive.Id] != c.archive {
+				continue
+			}
+			c.archive.SchemaId = c.schemaId
+			c.archive.AggId = c.aggId
+			c.archive.IrId = c.irId
+			changed++
+		}
+		m.Unlock()
+	}
+	return changed
+}
+
 func (m *UnpartitionedMemoryIdx) add(archive *idx.Archive) {
 	// there is a race condition that can lead to an archive being added
 	// to the writeQueue just after a queued copy of the archive was flushed.
@@ -933,6 +1045,7 @@ func (m *UnpartitionedMemoryIdx) add(archive *idx.Archive) {
 		if len(def.Tags) > 0 {
 			if _, ok := m.defById[def.Id]; !ok {
 				m.defById[def.Id] = archive
+				m.seriesByOrg[def.OrgId]++
 				statAdd.Inc()
 				log.Debugf("memory-idx: adding %s to DefById", path)
 			}
@@ -967,6 +1080,7 @@ func (m *UnpartitionedMemoryIdx) add(archive *idx.Archive) {
 			log.Debugf("memory-idx: existing index entry for %s. Adding %s to Defs list", path, def.Id)
 			node.Defs = append(node.Defs, def.Id)
 			m.defById[def.Id] = archive
+			m.seriesByOrg[def.OrgId]++
 			statAdd.Inc()
 			return
 		}
@@ -1013,6 +1127,7 @@ func (m *UnpartitionedMemoryIdx) add(archive *idx.Archive) {
 		Defs:     []schema.MKey{def.Id},
 	}
 	m.defById[def.Id] = archive
+	m.seriesByOrg[def.OrgId]++
 	statAdd.Inc()
 
 	return
@@ -1711,6 +1826,22 @@ func (m *UnpartitionedMemoryIdx) List(orgId uint32) []idx.Archive {
 	return defs
 }
 
+// SeriesCount returns the number of series of the given org, not including those of the public org
+func (m *UnpartitionedMemoryIdx) SeriesCount(orgId uint32) int {
+	m.RLock()
+	defer m.RUnlock()
+	return m.seriesByOrg[orgId]
+}
+
+// decSeries accounts for a series of the given org being removed from defById
+func (m *UnpartitionedMemoryIdx) decSeries(orgId uint32) {
+	if m.seriesByOrg[orgId] <= 1 {
+		delete(m.seriesByOrg, orgId)
+		return
+	}
+	m.seriesByOrg[orgId]--
+}
+
 func (m *UnpartitionedMemoryIdx) DeleteTagged(orgId uint32, query tagquery.Query) []idx.Archive {
 	if !TagSupport {
 		log.Warn("memory-idx: received tag query, but tag support is disabled")
@@ -1756,6 +1887,7 @@ func (m *UnpartitionedMemoryIdx) deleteTaggedByIdSet(orgId uint32, ids IdSet) []
 		}
 		deletedDefs = append(deletedDefs, CloneArchive(def))
 		delete(m.defById, idStr)
+		m.decSeries(def.OrgId)
 	}
 
 	statMetricsActive.DecUint32(uint32(len(deletedDefs)))
@@ -1836,6 +1968,7 @@ func (m *UnpartitionedMemoryIdx) delete(orgId uint32, n *Node, deleteEmptyParent
 		}
 		deletedDefs = append(deletedDefs, CloneArchive(archivePointer))
 		delete(m.defById, id)
+		m.decSeries(archivePointer.OrgId)
 	}
 
 	n.Defs = nil
@@ -1930,7 +2063,7 @@ func (m *UnpartitionedMemoryIdx) Prune(now time.Time) ([]idx.Archive, error) {
 	pre := time.Now()
 
 	// getting all cutoffs once saves having to recompute everytime we have a match
-	cutoffs := IndexRules.Cutoffs(now)
+	cutoffs := IndexRules().Cutoffs(now)
 
 	m.RLock()
 
@@ -503,6 +503,58 @@ func testDeleteTagged(t *testing.T) {
 	})
 }
 
+func TestSeriesCount(t *testing.T) {
+	withAndWithoutPartitonedIndex(testSeriesCount)(t)
+}
+
+func testSeriesCount(t *testing.T) {
+	idx.OrgIdPublic = 100
+	defer func() { idx.OrgIdPublic = 0 }()
+	_tagSupport := TagSupport
+	defer func() { TagSupport = _tagSupport }()
+	TagSupport = true
+
+	ix := New()
+	ix.Init()
+	defer ix.Stop()
+
+	var series []*schema.MetricData
+	series = append(series, getMetricData(idx.OrgIdPublic, 2, 3, 10, "metric.public", false)...)
+	untagged := getMetricData(1, 2, 5, 10, "metric.org1", false)
+	series = append(series, untagged...)
+	series = append(series, getMetricData(1, 2, 5, 10, "metric.org1", true)...)
+	for _, s := range series {
+		mkey, err := schema.MKeyFromString(s.Id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ix.AddOrUpdate(mkey, s, getPartition(s))
+		// updates of existing series don't change the count
+		ix.AddOrUpdate(mkey, s, getPartition(s))
+	}
+	if count := ix.SeriesCount(1); count != 10 {
+		t.Fatalf("expected org 1 to have 10 series, got %d", count)
+	}
+	if count := ix.SeriesCount(2); count != 0 {
+		t.Fatalf("expected org 2 to have 0 series, got %d", count)
+	}
+
+	if _, err := ix.Delete(1, untagged[0].Name); err != nil {
+		t.Fatal(err)
+	}
+	query, err := tagquery.NewQueryFromStrings([]string{"series_id=3"}, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ix.DeleteTagged(1, query)
+	if count := ix.SeriesCount(1); count != 8 {
+		t.Fatalf("expected org 1 to have 8 series after deleting 2, got %d", count)
+	}
+	if count := ix.SeriesCount(idx.OrgIdPublic); count != 3 {
+		t.Fatalf("expected the public org to have 3 series, got %d", count)
+	}
+}
+
 func TestDeleteNodeWith100kChildren(t *testing.T) {
 	withAndWithoutPartitonedIndex(withAndWithoutTagSupport(testDeleteNodeWith100kChildren))(t)
 }
@@ -714,7 +766,7 @@ func TestPruneTaggedSeries(t *testing.T) {
 
 func testPruneTaggedSeries(t *testing.T) {
 
-	IndexRules = conf.IndexRules{
+	SetIndexRules(conf.IndexRules{
 		Rules: []conf.IndexRule{
 			{
 				Name:     "longterm",
@@ -727,7 +779,7 @@ func testPruneTaggedSeries(t *testing.T) {
 			Pattern:  regexp.MustCompile(""),
 			MaxStale: 0,
 		},
-	}
+	})
 	ix := New()
 	ix.Init()
 	defer ix.Stop()
@@ -843,13 +895,13 @@ func testPruneTaggedSeriesWithCollidingTagSets(t *testing.T) {
 	defer func() { TagSupport = _tagSupport }()
 	TagSupport = true
 
-	IndexRules = conf.IndexRules{
+	SetIndexRules(conf.IndexRules{
 		Default: conf.IndexRule{
 			Name:     "default",
 			Pattern:  regexp.MustCompile(""),
 			MaxStale: time.Second,
 		},
-	}
+	})
 
 	ix := New()
 	ix.Init()
@@ -920,13 +972,13 @@ func TestPrune(t *testing.T) {
 }
 
 func testPrune(t *testing.T) {
-	IndexRules = conf.IndexRules{
+	SetIndexRules(conf.IndexRules{
 		Default: conf.IndexRule{
 			Name:     "default",
 			Pattern:  regexp.MustCompile(""),
 			MaxStale: time.Second,
 		},
-	}
+	})
 
 	ix := New()
 	ix.Init()
@@ -1007,6 +1059,92 @@ func testPrune(t *testing.T) {
 
 }
 
+func TestUpdateRules(t *testing.T) {
+	withAndWithoutPartitonedIndex(withAndWithoutTagSupport(testUpdateRules))(t)
+}
+
+func testUpdateRules(t *testing.T) {
+	defer func(schemas *conf.Schemas, indexRules *conf.IndexRules) {
+		mdata.SetSchemas(*schemas)
+		SetIndexRules(*indexRules)
+	}(mdata.Schemas(), IndexRules())
+	mdata.SetSchemas(conf.NewSchemas(nil))
+	SetIndexRules(conf.NewIndexRules())
+
+	ix := New()
+	ix.Init()
+	defer ix.Stop()
+
+	var fooKeys, bahKeys []schema.MKey
+	for _, s := range append(getSeriesNames(2, 5, "metric.bah"), getSeriesNames(2, 5, "metric.foo")...) {
+		d := &schema.MetricData{
+			Name:     s,
+			OrgId:    1,
+			Interval: 10,
+			Time:     10,
+		}
+		d.SetId()
+		mkey, err := schema.MKeyFromString(d.Id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ix.AddOrUpdate(mkey, d, getPartition(d))
+		if strings.HasPrefix(s, "metric.foo") {
+			fooKeys = append(fooKeys, mkey)
+		} else {
+			bahKeys = append(bahKeys, mkey)
+		}
+	}
+	// all series start out with the defaults, with id 0. the new rules give them other ids
+	newSchemas := conf.NewSchemas([]conf.Schema{
+		{
+			Name:       "qux",
+			Pattern:    regexp.MustCompile(`^metric\.qux`),
+			Retentions: conf.MustParseRetentions("10s:1d"),
+		},
+		{
+			Name:       "foo",
+			Pattern:    regexp.MustCompile(`^metric\.foo`),
+			Retentions: conf.MustParseRetentions("10s:1d"),
+		},
+	})
+	newIndexRules := conf.IndexRules{
+		Rules: []conf.IndexRule{
+			{
+				Name:     "qux",
+				Pattern:  regexp.MustCompile(`^metric\.qux`),
+				MaxStale: time.Hour,
+			},
+			{
+				Name:     "foo",
+				Pattern:  regexp.MustCompile(`^metric\.foo`),
+				MaxStale: time.Hour,
+			},
+		},
+		Default: conf.NewIndexRules().Default,
+	}
+	changed := ix.UpdateRules(func() {
+		mdata.SetSchemas(newSchemas)
+		SetIndexRules(newIndexRules)
+	})
+	if changed != len(fooKeys)+len(bahKeys) {
+		t.Fatalf("expected %d changed archives, got %d", len(fooKeys)+len(bahKeys), changed)
+	}
+
+	for _, key := range fooKeys {
+		archive, _ := ix.Get(key)
+		if archive.SchemaId != 1 || archive.IrId != 1 {
+			t.Fatalf("expected series %s to have schemaId 1 and irId 1, got %d and %d", key, archive.SchemaId, archive.IrId)
+		}
+	}
+	for _, key := range bahKeys {
+		archive, _ := ix.Get(key)
+		if archive.SchemaId != 2 || archive.IrId != 2 {
+			t.Fatalf("expected series %s to have schemaId 2 and irId 2, got %d and %d", key, archive.SchemaId, archive.IrId)
+		}
+	}
+}
+
 func TestSingleNodeMetric(t *testing.T) {
 	withAndWithoutPartitonedIndex(testSingleNodeMetric)(t)
 }
@@ -1411,18 +1549,19 @@ func TestMatchSchemaWithTags(t *testing.T) {
 
 func testMatchSchemaWithTags(t *testing.T) {
 	_tagSupport := TagSupport
-	_schemas := mdata.Schemas
+	_schemas := mdata.Schemas()
 	defer func() { TagSupport = _tagSupport }()
-	defer func() { mdata.Schemas = _schemas }()
+	defer func() { mdata.SetSchemas(*_schemas) }()
 
 	TagSupport = true
-	mdata.Schemas = conf.NewSchemas([]conf.Schema{
+	schemas := conf.NewSchemas([]conf.Schema{
 		{
 			Name:       "tag1_is_value3_or_value5",
 			Pattern:    regexp.MustCompile(".*;tag1=value[35](;.*|$)"),
 			Retentions: conf.MustParseRetentions("1s:1d:10min:2:true"),
 		},
 	})
+	mdata.SetSchemas(schemas)
 
 	ix := New()
 	ix.Init()
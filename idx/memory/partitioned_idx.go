@@ -255,6 +255,15 @@ func (p *PartitionedMemoryIdx) List(orgId uint32) []idx.Archive {
 	return response
 }
 
+// SeriesCount returns the number of series of the given org, not including those of the public org
+func (p *PartitionedMemoryIdx) SeriesCount(orgId uint32) int {
+	var count int
+	for _, m := range p.Partition {
+		count += m.SeriesCount(orgId)
+	}
+	return count
+}
+
 // Prune deletes all metrics that haven't been seen since the given timestamp.
 // It returns all Archives deleted and any error encountered.
 func (p *PartitionedMemoryIdx) Prune(oldest time.Time) ([]idx.Archive, error) {
@@ -486,6 +495,17 @@ func (p *PartitionedMemoryIdx) LoadPartition(partition int32, defs []schema.Metr
 	return p.Partition[partition].Load(defs)
 }
 
+// UpdateRules calls install, which replaces the schemas, aggregations and/or index rules,
+// and re-evaluates the SchemaId, AggId and IrId of all archives in all partitions against the new rules.
+// It returns the number of archives of which any of them changed.
+func (p *PartitionedMemoryIdx) UpdateRules(install func()) int {
+	indexes := make([]*UnpartitionedMemoryIdx, 0, len(p.Partition))
+	for _, m := range p.Partition {
+		indexes = append(indexes, m)
+	}
+	return updateRules(indexes, install)
+}
+
 func (p *PartitionedMemoryIdx) add(archive *idx.Archive) {
 	p.Partition[archive.Partition].add(archive)
 }
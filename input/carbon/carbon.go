@@ -4,12 +4,14 @@ package carbon
 
 import (
 	"bufio"
+	"bytes"
 	"context"
 	"flag"
 	"io"
 	"net"
 	"strings"
 	"sync"
+	"time"
 
 	"github.com/grafana/globalconf"
 	"github.com/grafana/metrictank/cluster"
@@ -20,52 +22,28 @@ import (
 	log "github.com/sirupsen/logrus"
 )
 
-// metric input.carbon.metrics_per_message is how many metrics per message were seen. in carbon's case this is always 1.
+// metric input.carbon.metrics_per_message is how many metrics per message were seen. for plaintext carbon this is always 1, for pickle it is the number of metrics in the pickled list.
 var metricsPerMessage = stats.NewMeter32("input.carbon.metrics_per_message", false)
 
-// metric input.carbon.metrics_decode_err is a count of times an input message (MetricData, MetricDataArray or carbon line) failed to parse
+// metric input.carbon.metrics_decode_err is a count of times an input message (carbon line, pickle message or pickled datapoint) failed to parse
 var metricsDecodeErr = stats.NewCounterRate32("input.carbon.metrics_decode_err")
 
+// metric input.carbon.udp_recv_err is a count of times reading an udp packet failed
+var udpRecvErr = stats.NewCounterRate32("input.carbon.udp_recv_err")
+
 type Carbon struct {
 	input.Handler
 	addrStr          string
 	addr             *net.TCPAddr
 	listener         *net.TCPListener
+	udpAddr          *net.UDPAddr // nil if the udp listener is disabled
+	udpConn          *net.UDPConn
+	pickleAddr       *net.TCPAddr // nil if the pickle listener is disabled
+	pickleListener   *net.TCPListener
 	handlerWaitGroup sync.WaitGroup
 	quit             chan struct{}
-	connTrack        *ConnTrack
-	intervalGetter   IntervalGetter
-}
-
-type ConnTrack struct {
-	sync.Mutex
-	conns map[string]net.Conn
-}
-
-func NewConnTrack() *ConnTrack {
-	return &ConnTrack{
-		conns: make(map[string]net.Conn),
-	}
-}
-
-func (c *ConnTrack) Add(conn net.Conn) {
-	c.Lock()
-	c.conns[conn.RemoteAddr().String()] = conn
-	c.Unlock()
-}
-
-func (c *ConnTrack) Remove(conn net.Conn) {
-	c.Lock()
-	delete(c.conns, conn.RemoteAddr().String())
-	c.Unlock()
-}
-
-func (c *ConnTrack) CloseAll() {
-	c.Lock()
-	for _, conn := range c.conns {
-		conn.Close()
-	}
-	c.Unlock()
+	connTrack        *input.ConnTrack
+	intervalGetter   input.IntervalGetter
 }
 
 func (c *Carbon) Name() string {
@@ -74,12 +52,16 @@ func (c *Carbon) Name() string {
 
 var Enabled bool
 var addr string
+var udpAddr string
+var pickleAddr string
 var partitionId int
 
 func ConfigSetup() {
 	inCarbon := flag.NewFlagSet("carbon-in", flag.ExitOnError)
 	inCarbon.BoolVar(&Enabled, "enabled", false, "")
 	inCarbon.StringVar(&addr, "addr", ":2003", "tcp listen address")
+	inCarbon.StringVar(&udpAddr, "udp-addr", "", "udp listen address for the plaintext protocol. empty to disable")
+	inCarbon.StringVar(&pickleAddr, "pickle-addr", "", "tcp listen address for the pickle protocol. empty to disable")
 	inCarbon.IntVar(&partitionId, "partition", 0, "partition Id.")
 	globalconf.Register("carbon-in", inCarbon, flag.ExitOnError)
 }
@@ -96,19 +78,33 @@ func New() *Carbon {
 	if err != nil {
 		log.Fatalf("carbon-in: %s", err.Error())
 	}
-	return &Carbon{
+	c := &Carbon{
 		addrStr:   addr,
 		addr:      addrT,
-		connTrack: NewConnTrack(),
+		connTrack: input.NewConnTrack(),
+	}
+	if udpAddr != "" {
+		c.udpAddr, err = net.ResolveUDPAddr("udp", udpAddr)
+		if err != nil {
+			log.Fatalf("carbon-in: %s", err.Error())
+		}
+	}
+	if pickleAddr != "" {
+		c.pickleAddr, err = net.ResolveTCPAddr("tcp", pickleAddr)
+		if err != nil {
+			log.Fatalf("carbon-in: %s", err.Error())
+		}
 	}
+	return c
 }
 
-func (c *Carbon) IntervalGetter(i IntervalGetter) {
+func (c *Carbon) IntervalGetter(i input.IntervalGetter) {
 	c.intervalGetter = i
 }
 
 func (c *Carbon) Start(handler input.Handler, cancel context.CancelFunc) error {
 	c.Handler = handler
+	c.quit = make(chan struct{})
 	l, err := net.ListenTCP("tcp", c.addr)
 	if nil != err {
 		log.Errorf("carbon-in: %s", err.Error())
@@ -116,8 +112,33 @@ func (c *Carbon) Start(handler input.Handler, cancel context.CancelFunc) error {
 	}
 	c.listener = l
 	log.Infof("carbon-in: listening on %v/tcp", c.addr)
-	c.quit = make(chan struct{})
-	go c.accept()
+	go c.accept(c.listener, c.handle)
+
+	if c.udpAddr != nil {
+		c.udpConn, err = net.ListenUDP("udp", c.udpAddr)
+		if nil != err {
+			log.Errorf("carbon-in: %s", err.Error())
+			c.listener.Close()
+			return err
+		}
+		log.Infof("carbon-in: listening on %v/udp", c.udpAddr)
+		c.handlerWaitGroup.Add(1)
+		go c.handleUDP()
+	}
+
+	if c.pickleAddr != nil {
+		c.pickleListener, err = net.ListenTCP("tcp", c.pickleAddr)
+		if nil != err {
+			log.Errorf("carbon-in: %s", err.Error())
+			c.listener.Close()
+			if c.udpConn != nil {
+				c.udpConn.Close()
+			}
+			return err
+		}
+		log.Infof("carbon-in: listening on %v/tcp for pickle protocol", c.pickleAddr)
+		go c.accept(c.pickleListener, c.handlePickle)
+	}
 	return nil
 }
 
@@ -131,9 +152,10 @@ func (c *Carbon) ExplainPriority() interface{} {
 	return "carbon-in: priority=0 (always in sync)"
 }
 
-func (c *Carbon) accept() {
+// accept accepts connections on the given listener, and hands each off to the given handler
+func (c *Carbon) accept(listener *net.TCPListener, handle func(net.Conn)) {
 	for {
-		conn, err := c.listener.AcceptTCP()
+		conn, err := listener.AcceptTCP()
 		if nil != err {
 			select {
 			case <-c.quit:
@@ -146,14 +168,26 @@ func (c *Carbon) accept() {
 		}
 		c.handlerWaitGroup.Add(1)
 		c.connTrack.Add(conn)
-		go c.handle(conn)
+		go handle(conn)
 	}
 }
 
 func (c *Carbon) Stop() {
 	log.Infof("carbon-in: shutting down.")
+	if c.quit == nil {
+		// we were never started
+		return
+	}
 	close(c.quit)
-	c.listener.Close()
+	if c.listener != nil {
+		c.listener.Close()
+	}
+	if c.udpConn != nil {
+		c.udpConn.Close()
+	}
+	if c.pickleListener != nil {
+		c.pickleListener.Close()
+	}
 	c.connTrack.CloseAll()
 	c.handlerWaitGroup.Wait()
 }
@@ -182,27 +216,80 @@ func (c *Carbon) handle(conn net.Conn) {
 			break
 		}
 
-		// no validation for m2.0 to provide a grace period in adopting new clients
-		key, val, ts, err := carbon20.ValidatePacket(buf, carbon20.MediumLegacy, carbon20.NoneM20)
+		c.handleLine(buf)
+	}
+	c.handlerWaitGroup.Done()
+}
+
+// handleUDP reads plaintext carbon lines from udp packets.
+// each packet may contain multiple newline separated lines.
+func (c *Carbon) handleUDP() {
+	defer c.handlerWaitGroup.Done()
+	// 64kB is the max size of an udp packet
+	buf := make([]byte, 65536)
+	var backoff time.Duration
+	for {
+		n, _, err := c.udpConn.ReadFromUDP(buf)
 		if err != nil {
-			metricsDecodeErr.Inc()
-			log.Errorf("carbon-in: invalid metric: %s", err.Error())
+			select {
+			case <-c.quit:
+				// we are shutting down.
+				return
+			default:
+			}
+			// errors reading a packet are transient, e.g. a truncated packet. keep listening,
+			// but back off if they keep coming, so we don't spin on an error that persists.
+			udpRecvErr.Inc()
+			log.Errorf("carbon-in: udp recv error: %s", err.Error())
+			if backoff == 0 {
+				backoff = 10 * time.Millisecond
+			} else if backoff < time.Second {
+				backoff *= 2
+			}
+			select {
+			case <-c.quit:
+				return
+			case <-time.After(backoff):
+			}
 			continue
 		}
-		nameSplits := strings.Split(string(key), ";")
-		md := &schema.MetricData{
-			Name:     nameSplits[0],
-			Interval: c.intervalGetter.GetInterval(nameSplits[0]),
-			Value:    val,
-			Unit:     "unknown",
-			Time:     int64(ts),
-			Mtype:    "gauge",
-			Tags:     nameSplits[1:],
-			OrgId:    1, // admin org
+		backoff = 0
+		for _, line := range bytes.Split(buf[:n], []byte("\n")) {
+			line = bytes.TrimSpace(line)
+			if len(line) == 0 {
+				continue
+			}
+			c.handleLine(line)
 		}
-		md.SetId()
-		metricsPerMessage.ValueUint32(1)
-		c.Handler.ProcessMetricData(md, int32(partitionId))
 	}
-	c.handlerWaitGroup.Done()
+}
+
+// handleLine validates and processes a single plaintext carbon line
+func (c *Carbon) handleLine(buf []byte) {
+	// no validation for m2.0 to provide a grace period in adopting new clients
+	key, val, ts, err := carbon20.ValidatePacket(buf, carbon20.MediumLegacy, carbon20.NoneM20)
+	if err != nil {
+		metricsDecodeErr.Inc()
+		log.Errorf("carbon-in: invalid metric: %s", err.Error())
+		return
+	}
+	metricsPerMessage.ValueUint32(1)
+	c.process(string(key), val, ts)
+}
+
+// process builds a MetricData out of a carbon datapoint and hands it to the handler
+func (c *Carbon) process(key string, val float64, ts uint32) {
+	nameSplits := strings.Split(key, ";")
+	md := &schema.MetricData{
+		Name:     nameSplits[0],
+		Interval: c.intervalGetter.GetInterval(1, nameSplits[0], nameSplits[1:]),
+		Value:    val,
+		Unit:     "unknown",
+		Time:     int64(ts),
+		Mtype:    "gauge",
+		Tags:     nameSplits[1:],
+		OrgId:    1, // admin org
+	}
+	md.SetId()
+	c.Handler.ProcessMetricData(md, int32(partitionId))
 }
@@ -0,0 +1,58 @@
+package carbon
+
+import (
+	"regexp"
+	"testing"
+
+	"github.com/grafana/metrictank/conf"
+	"github.com/grafana/metrictank/expr/tagquery"
+	"github.com/grafana/metrictank/idx/memory"
+	"github.com/grafana/metrictank/input"
+	"github.com/grafana/metrictank/mdata"
+	"github.com/grafana/metrictank/schema"
+	"github.com/grafana/metrictank/schema/msg"
+)
+
+type mockHandler struct {
+	mds []*schema.MetricData
+}
+
+func (h *mockHandler) ProcessMetricData(md *schema.MetricData, partition int32) {
+	h.mds = append(h.mds, md)
+}
+
+func (h *mockHandler) ProcessMetricPoint(point schema.MetricPoint, format msg.Format, partition int32) {
+}
+
+func TestProcessTagScopedSchema(t *testing.T) {
+	defer func(schemas *conf.Schemas) { mdata.SetSchemas(*schemas) }(mdata.Schemas())
+	tags, err := tagquery.ParseExpressions([]string{"env=dev"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	mdata.SetSchemas(conf.NewSchemas([]conf.Schema{
+		{
+			Name:       "dev",
+			Pattern:    regexp.MustCompile(""),
+			Tags:       tags,
+			Retentions: conf.BuildFromRetentions(conf.NewRetentionMT(60, 86400, 600, 0, 0)),
+		},
+	}))
+
+	index := memory.New()
+	index.Init()
+	defer index.Stop()
+	handler := &mockHandler{}
+	c := &Carbon{Handler: handler, intervalGetter: input.NewIndexIntervalGetter(index)}
+
+	c.process("foo.bar;env=dev", 1, 60)
+	c.process("foo.bar;env=prod", 1, 60)
+	c.process("foo.bar", 1, 60)
+
+	// the default schema has an interval of 1s
+	for i, exp := range []int{60, 1, 1} {
+		if handler.mds[i].Interval != exp {
+			t.Fatalf("expected %s to get interval %d, got %d", handler.mds[i].Id, exp, handler.mds[i].Interval)
+		}
+	}
+}
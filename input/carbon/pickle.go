@@ -0,0 +1,162 @@
+package carbon
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"net"
+	"strconv"
+
+	pickle "github.com/kisielk/og-rek"
+	"github.com/metrics20/go-metrics20/carbon20"
+	log "github.com/sirupsen/logrus"
+)
+
+// maxPickleSize is the largest pickle payload we accept. same as carbon's MAX_LENGTH.
+// anything larger is treated as a broken or malicious client, and the connection is closed.
+const maxPickleSize = 1024 * 1024
+
+var errPickleFormat = errors.New("pickle message is not a list")
+
+// pickleMetric is a single datapoint decoded from a pickle message
+type pickleMetric struct {
+	key string
+	val float64
+	ts  uint32
+}
+
+// handlePickle reads length-prefixed pickle messages from the connection.
+// each message is a pickled list of (path, (timestamp, value)) tuples, as sent by carbon-relay
+// and other clients that speak the carbon pickle protocol.
+func (c *Carbon) handlePickle(conn net.Conn) {
+	defer func() {
+		conn.Close()
+		c.connTrack.Remove(conn)
+		c.handlerWaitGroup.Done()
+	}()
+	r := bufio.NewReaderSize(conn, 4096)
+	var header [4]byte
+	var payload []byte
+	for {
+		_, err := io.ReadFull(r, header[:])
+		if err != nil {
+			select {
+			case <-c.quit:
+				// we are shutting down.
+				return
+			default:
+			}
+			if io.EOF != err {
+				log.Errorf("carbon-in: pickle recv error: %s", err.Error())
+			}
+			return
+		}
+		size := binary.BigEndian.Uint32(header[:])
+		if size > maxPickleSize {
+			metricsDecodeErr.Inc()
+			log.Errorf("carbon-in: pickle message of %d bytes from %s exceeds max size of %d bytes. closing connection", size, conn.RemoteAddr(), maxPickleSize)
+			return
+		}
+		if cap(payload) < int(size) {
+			payload = make([]byte, size)
+		}
+		payload = payload[:size]
+		_, err = io.ReadFull(r, payload)
+		if err != nil {
+			log.Errorf("carbon-in: pickle recv error: %s", err.Error())
+			return
+		}
+
+		metrics, err := decodePickle(payload)
+		if err != nil {
+			metricsDecodeErr.Inc()
+			log.Errorf("carbon-in: invalid pickle message: %s", err.Error())
+			continue
+		}
+		metricsPerMessage.ValueUint32(uint32(len(metrics)))
+		for _, m := range metrics {
+			c.process(m.key, m.val, m.ts)
+		}
+	}
+}
+
+// decodePickle decodes a pickle payload into the datapoints it contains.
+// datapoints that are invalid are skipped and counted as decode errors, like carbon does.
+// an error is only returned if the payload as a whole can't be decoded.
+func decodePickle(payload []byte) ([]pickleMetric, error) {
+	decoded, err := pickle.NewDecoder(bytes.NewReader(payload)).Decode()
+	if err != nil {
+		return nil, err
+	}
+	list, ok := decoded.([]interface{})
+	if !ok {
+		return nil, errPickleFormat
+	}
+	metrics := make([]pickleMetric, 0, len(list))
+	for _, item := range list {
+		m, err := decodePickleMetric(item)
+		if err != nil {
+			metricsDecodeErr.Inc()
+			log.Errorf("carbon-in: invalid pickled metric: %s", err.Error())
+			continue
+		}
+		metrics = append(metrics, m)
+	}
+	return metrics, nil
+}
+
+// decodePickleMetric decodes a single (path, (timestamp, value)) tuple
+func decodePickleMetric(item interface{}) (pickleMetric, error) {
+	var m pickleMetric
+	tuple, ok := item.(pickle.Tuple)
+	if !ok || len(tuple) != 2 {
+		return m, fmt.Errorf("expected (path, (timestamp, value)) tuple, got %v", item)
+	}
+	key, ok := tuple[0].(string)
+	if !ok {
+		return m, fmt.Errorf("expected string path, got %v", tuple[0])
+	}
+	if err := carbon20.ValidateKeyLegacy(key, carbon20.MediumLegacy); err != nil {
+		return m, err
+	}
+	point, ok := tuple[1].(pickle.Tuple)
+	if !ok || len(point) != 2 {
+		return m, fmt.Errorf("%s: expected (timestamp, value) tuple, got %v", key, tuple[1])
+	}
+	ts, err := pickleToFloat(point[0])
+	if err != nil {
+		return m, fmt.Errorf("%s: invalid timestamp: %s", key, err.Error())
+	}
+	if ts < 0 || ts > float64(^uint32(0)) {
+		return m, fmt.Errorf("%s: timestamp %f out of range", key, ts)
+	}
+	val, err := pickleToFloat(point[1])
+	if err != nil {
+		return m, fmt.Errorf("%s: invalid value: %s", key, err.Error())
+	}
+	m.key = key
+	m.ts = uint32(ts)
+	m.val = val
+	return m, nil
+}
+
+// pickleToFloat converts the numeric types (and strings) that clients may send into a float64,
+// like carbon's float() cast would.
+func pickleToFloat(v interface{}) (float64, error) {
+	switch n := v.(type) {
+	case float64:
+		return n, nil
+	case int64:
+		return float64(n), nil
+	case *big.Int:
+		f, _ := new(big.Float).SetInt(n).Float64()
+		return f, nil
+	case string:
+		return strconv.ParseFloat(n, 64)
+	}
+	return 0, fmt.Errorf("unsupported type %T", v)
+}
@@ -0,0 +1,94 @@
+package carbon
+
+import (
+	"bytes"
+	"math/big"
+	"reflect"
+	"testing"
+
+	pickle "github.com/kisielk/og-rek"
+)
+
+func encodePickle(t *testing.T, v interface{}) []byte {
+	buf := new(bytes.Buffer)
+	err := pickle.NewEncoder(buf).Encode(v)
+	if err != nil {
+		t.Fatalf("failed to encode pickle: %s", err.Error())
+	}
+	return buf.Bytes()
+}
+
+func TestDecodePickle(t *testing.T) {
+	cases := []struct {
+		name   string
+		in     interface{}
+		expErr bool
+		exp    []pickleMetric
+	}{
+		{
+			name:   "not a list",
+			in:     pickle.Tuple{"a.b", pickle.Tuple{int64(10), 1.5}},
+			expErr: true,
+		},
+		{
+			name: "empty list",
+			in:   []interface{}{},
+			exp:  []pickleMetric{},
+		},
+		{
+			name: "numeric types",
+			in: []interface{}{
+				pickle.Tuple{"a.b", pickle.Tuple{int64(10), 1.5}},
+				pickle.Tuple{"a.c;foo=bar", pickle.Tuple{20.7, int64(3)}},
+				pickle.Tuple{"a.d", pickle.Tuple{big.NewInt(30), "4.25"}},
+			},
+			exp: []pickleMetric{
+				{key: "a.b", ts: 10, val: 1.5},
+				{key: "a.c;foo=bar", ts: 20, val: 3},
+				{key: "a.d", ts: 30, val: 4.25},
+			},
+		},
+		{
+			name: "invalid entries are skipped",
+			in: []interface{}{
+				pickle.Tuple{"a.b", pickle.Tuple{int64(10), 1.5}},
+				pickle.Tuple{"a.b", int64(10), 1.5},
+				pickle.Tuple{int64(5), pickle.Tuple{int64(10), 1.5}},
+				pickle.Tuple{"a.c", pickle.Tuple{int64(10)}},
+				pickle.Tuple{"a.d", pickle.Tuple{-10.0, 1.5}},
+				pickle.Tuple{"a.e", pickle.Tuple{int64(10), "foo"}},
+				pickle.Tuple{"a.f", pickle.Tuple{int64(10), pickle.None{}}},
+				"a.g 1.5 10",
+				pickle.Tuple{"a.h", pickle.Tuple{int64(20), 2.5}},
+			},
+			exp: []pickleMetric{
+				{key: "a.b", ts: 10, val: 1.5},
+				{key: "a.h", ts: 20, val: 2.5},
+			},
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			metrics, err := decodePickle(encodePickle(t, c.in))
+			if c.expErr {
+				if err == nil {
+					t.Fatalf("expected error, got nil")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("expected no error, got %s", err.Error())
+			}
+			if !reflect.DeepEqual(metrics, c.exp) {
+				t.Fatalf("expected %v, got %v", c.exp, metrics)
+			}
+		})
+	}
+}
+
+func TestDecodePickleGarbage(t *testing.T) {
+	_, err := decodePickle([]byte("this is not a pickle"))
+	if err == nil {
+		t.Fatalf("expected error decoding garbage, got nil")
+	}
+}
@@ -0,0 +1,310 @@
+// package influx provides an input for the influxdb line protocol, over http and tcp.
+// it is meant for agents such as telegraf that speak the influxdb protocol natively.
+// every numeric field of a line becomes a metric named <measurement>.<field>, with the tags of the line.
+package influx
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/grafana/globalconf"
+	"github.com/grafana/metrictank/cluster"
+	"github.com/grafana/metrictank/input"
+	"github.com/grafana/metrictank/schema"
+	"github.com/grafana/metrictank/stats"
+	log "github.com/sirupsen/logrus"
+)
+
+// metric input.influx.metrics_per_message is how many metrics per message were seen. for http this is per request, for tcp per line.
+var metricsPerMessage = stats.NewMeter32("input.influx.metrics_per_message", false)
+
+// metric input.influx.metrics_decode_err is a count of times an input line failed to parse
+var metricsDecodeErr = stats.NewCounterRate32("input.influx.metrics_decode_err")
+
+// maxLineSize is the longest line we accept
+const maxLineSize = 1024 * 1024
+
+var (
+	Enabled      bool
+	addr         string
+	tcpAddr      string
+	tcpPrecision string
+	partitionID  int
+)
+
+func ConfigSetup() {
+	inInflux := flag.NewFlagSet("influx-in", flag.ExitOnError)
+	inInflux.BoolVar(&Enabled, "enabled", false, "")
+	inInflux.StringVar(&addr, "addr", ":8086", "http listen address for the /write endpoint")
+	inInflux.StringVar(&tcpAddr, "tcp-addr", "", "tcp listen address. empty to disable")
+	inInflux.StringVar(&tcpPrecision, "tcp-precision", "ns", "timestamp precision of lines received over tcp. one of ns, u, ms, s, m, h")
+	inInflux.IntVar(&partitionID, "partition", 0, "partition Id.")
+	globalconf.Register("influx-in", inInflux, flag.ExitOnError)
+}
+
+func ConfigProcess() {
+	if !Enabled {
+		return
+	}
+	if !validPrecision(tcpPrecision) {
+		log.Fatalf("influx-in: invalid tcp-precision %q", tcpPrecision)
+	}
+	cluster.Manager.SetPartitions([]int32{int32(partitionID)})
+}
+
+type Influx struct {
+	input.Handler
+	addr             string
+	tcpAddr          *net.TCPAddr // nil if the tcp listener is disabled
+	server           *http.Server
+	listener         *net.TCPListener
+	handlerWaitGroup sync.WaitGroup
+	quit             chan struct{}
+	connTrack        *input.ConnTrack
+	intervalGetter   input.IntervalGetter
+}
+
+func New() *Influx {
+	i := &Influx{
+		addr:      addr,
+		connTrack: input.NewConnTrack(),
+	}
+	if tcpAddr != "" {
+		var err error
+		i.tcpAddr, err = net.ResolveTCPAddr("tcp", tcpAddr)
+		if err != nil {
+			log.Fatalf("influx-in: %s", err.Error())
+		}
+	}
+	return i
+}
+
+func (i *Influx) Name() string {
+	return "influx"
+}
+
+func (i *Influx) IntervalGetter(ig input.IntervalGetter) {
+	i.intervalGetter = ig
+}
+
+func (i *Influx) Start(handler input.Handler, cancel context.CancelFunc) error {
+	i.Handler = handler
+	i.quit = make(chan struct{})
+
+	l, err := net.Listen("tcp", i.addr)
+	if err != nil {
+		log.Errorf("influx-in: %s", err.Error())
+		return err
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/write", i.handleWrite)
+	mux.HandleFunc("/ping", i.handlePing)
+	i.server = &http.Server{
+		Addr:    i.addr,
+		Handler: mux,
+	}
+	log.Infof("influx-in: listening on %v/http", i.addr)
+	go func() {
+		err := i.server.Serve(l)
+		if err != http.ErrServerClosed {
+			log.Errorf("influx-in: http server failed: %s", err.Error())
+			cancel()
+		}
+	}()
+
+	if i.tcpAddr != nil {
+		i.listener, err = net.ListenTCP("tcp", i.tcpAddr)
+		if err != nil {
+			log.Errorf("influx-in: %s", err.Error())
+			return err
+		}
+		log.Infof("influx-in: listening on %v/tcp", i.tcpAddr)
+		go i.accept()
+	}
+	return nil
+}
+
+// MaintainPriority is very simplistic for influx. there is no backfill,
+// so mark as ready immediately.
+func (i *Influx) MaintainPriority() {
+	cluster.Manager.SetPriority(0)
+}
+
+func (i *Influx) ExplainPriority() interface{} {
+	return "influx-in: priority=0 (always in sync)"
+}
+
+func (i *Influx) Stop() {
+	log.Info("influx-in: shutting down")
+	if i.quit == nil {
+		// we were never started
+		return
+	}
+	close(i.quit)
+	if i.server != nil {
+		i.server.Shutdown(context.Background())
+	}
+	if i.listener != nil {
+		i.listener.Close()
+	}
+	i.connTrack.CloseAll()
+	i.handlerWaitGroup.Wait()
+}
+
+// handlePing is used by clients to check whether the server is up
+func (i *Influx) handlePing(w http.ResponseWriter, req *http.Request) {
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// handleWrite handles the influxdb /write endpoint. the body is a set of newline separated lines.
+// like influxdb, valid lines are ingested even if some lines are invalid, in which case we report a partial write
+func (i *Influx) handleWrite(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodPost {
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	defer req.Body.Close()
+	precision := req.URL.Query().Get("precision")
+	if !validPrecision(precision) {
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("invalid precision %q", precision))
+		return
+	}
+
+	var body io.Reader = req.Body
+	if req.Header.Get("Content-Encoding") == "gzip" {
+		gz, err := gzip.NewReader(req.Body)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, fmt.Sprintf("invalid gzip data: %s", err.Error()))
+			return
+		}
+		defer gz.Close()
+		body = gz
+	}
+
+	now := time.Now().Unix()
+	var metrics uint32
+	var dropped int
+	var firstErr error
+	scanner := bufio.NewScanner(body)
+	scanner.Buffer(make([]byte, 0, 4096), maxLineSize)
+	for scanner.Scan() {
+		n, err := i.processLine(scanner.Bytes(), precision, now)
+		if err != nil {
+			dropped++
+			if firstErr == nil {
+				firstErr = err
+			}
+			continue
+		}
+		metrics += uint32(n)
+	}
+	metricsPerMessage.ValueUint32(metrics)
+	if err := scanner.Err(); err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("read error: %s", err.Error()))
+		return
+	}
+	if firstErr != nil {
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("partial write: %s dropped=%d", firstErr.Error(), dropped))
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// writeError writes an error response in the format influxdb clients expect
+func writeError(w http.ResponseWriter, code int, msg string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(map[string]string{"error": msg})
+}
+
+func (i *Influx) accept() {
+	for {
+		conn, err := i.listener.AcceptTCP()
+		if err != nil {
+			select {
+			case <-i.quit:
+				// we are shutting down.
+				return
+			default:
+			}
+			log.Errorf("influx-in: Accept Error: %s", err.Error())
+			return
+		}
+		i.handlerWaitGroup.Add(1)
+		i.connTrack.Add(conn)
+		go i.handle(conn)
+	}
+}
+
+// handle reads lines from a tcp connection
+func (i *Influx) handle(conn net.Conn) {
+	defer func() {
+		conn.Close()
+		i.connTrack.Remove(conn)
+		i.handlerWaitGroup.Done()
+	}()
+	scanner := bufio.NewScanner(conn)
+	scanner.Buffer(make([]byte, 0, 4096), maxLineSize)
+	for scanner.Scan() {
+		n, err := i.processLine(scanner.Bytes(), tcpPrecision, time.Now().Unix())
+		if err != nil {
+			log.Errorf("influx-in: %s", err.Error())
+			continue
+		}
+		metricsPerMessage.ValueUint32(uint32(n))
+	}
+	if err := scanner.Err(); err != nil {
+		select {
+		case <-i.quit:
+			// we are shutting down.
+			return
+		default:
+		}
+		log.Errorf("influx-in: Recv error: %s", err.Error())
+	}
+}
+
+// processLine parses a line and hands a MetricData for each numeric field to the handler.
+// lines without a timestamp get the given timestamp.
+// returns the number of metrics processed
+func (i *Influx) processLine(line []byte, precision string, now int64) (int, error) {
+	line = bytes.TrimSpace(line)
+	if len(line) == 0 || line[0] == '#' {
+		return 0, nil
+	}
+	p, err := parseLine(line)
+	if err != nil {
+		metricsDecodeErr.Inc()
+		return 0, fmt.Errorf("unable to parse %q: %s", line, err.Error())
+	}
+	ts := now
+	if p.hasTs {
+		ts = toSeconds(p.ts, precision)
+	}
+	for _, f := range p.fields {
+		name := p.measurement + "." + f.key
+		md := &schema.MetricData{
+			Name:     name,
+			Interval: i.intervalGetter.GetInterval(1, name, p.tags),
+			Value:    f.val,
+			Unit:     "unknown",
+			Time:     ts,
+			Mtype:    "gauge",
+			Tags:     p.tags,
+			OrgId:    1, // admin org
+		}
+		md.SetId()
+		i.Handler.ProcessMetricData(md, int32(partitionID))
+	}
+	return len(p.fields), nil
+}
@@ -0,0 +1,84 @@
+package influx
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/grafana/metrictank/schema"
+	"github.com/grafana/metrictank/schema/msg"
+)
+
+type mockHandler struct {
+	mds []*schema.MetricData
+}
+
+func (m *mockHandler) ProcessMetricData(md *schema.MetricData, partition int32) {
+	m.mds = append(m.mds, md)
+}
+
+func (m *mockHandler) ProcessMetricPoint(point schema.MetricPoint, format msg.Format, partition int32) {
+}
+
+type mockIntervalGetter struct{}
+
+func (m mockIntervalGetter) GetInterval(orgId uint32, name string, tags []string) int {
+	return 10
+}
+
+func TestHandleWrite(t *testing.T) {
+	cases := []struct {
+		name     string
+		url      string
+		body     string
+		expCode  int
+		expNames []string
+		expTime  int64
+	}{
+		{
+			name:     "valid",
+			url:      "/write?db=telegraf&precision=s",
+			body:     "cpu,host=a usage_idle=98.5,usage_user=1i 1556813561\n\nmem,host=a used=10 1556813561\n",
+			expCode:  http.StatusNoContent,
+			expNames: []string{"cpu.usage_idle", "cpu.usage_user", "mem.used"},
+			expTime:  1556813561,
+		},
+		{
+			name:     "partial",
+			url:      "/write",
+			body:     "cpu usage_idle=98.5 1556813561000000000\ncpu usage_idle\n",
+			expCode:  http.StatusBadRequest,
+			expNames: []string{"cpu.usage_idle"},
+			expTime:  1556813561,
+		},
+		{
+			name:    "invalid precision",
+			url:     "/write?precision=d",
+			body:    "cpu usage_idle=98.5 1556813561\n",
+			expCode: http.StatusBadRequest,
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			handler := &mockHandler{}
+			i := New()
+			i.Handler = handler
+			i.IntervalGetter(mockIntervalGetter{})
+
+			w := httptest.NewRecorder()
+			i.handleWrite(w, httptest.NewRequest("POST", c.url, strings.NewReader(c.body)))
+			if w.Code != c.expCode {
+				t.Fatalf("expected code %d, got %d (%s)", c.expCode, w.Code, w.Body.String())
+			}
+			if len(handler.mds) != len(c.expNames) {
+				t.Fatalf("expected %d metrics, got %d", len(c.expNames), len(handler.mds))
+			}
+			for j, md := range handler.mds {
+				if md.Name != c.expNames[j] || md.Time != c.expTime || md.Interval != 10 || md.Id == "" {
+					t.Fatalf("unexpected metric %d: %v", j, md)
+				}
+			}
+		})
+	}
+}
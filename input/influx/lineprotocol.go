@@ -0,0 +1,202 @@
+package influx
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+)
+
+var (
+	errMissingFields    = errors.New("missing fields")
+	errMissingName      = errors.New("missing measurement")
+	errInvalidTag       = errors.New("invalid tag")
+	errInvalidField     = errors.New("invalid field")
+	errInvalidTimestamp = errors.New("invalid timestamp")
+)
+
+// point is a single line of influx line protocol:
+// measurement[,tag=value...] field=value[,field=value...] [timestamp]
+type point struct {
+	measurement string
+	tags        []string // in key=value format
+	fields      []field  // only the numeric fields. string fields can't be stored and are skipped
+	ts          int64    // in the precision of the request
+	hasTs       bool
+}
+
+type field struct {
+	key string
+	val float64
+}
+
+// parseLine parses a single line of influx line protocol.
+// the caller is expected to skip empty lines and comments.
+func parseLine(line []byte) (point, error) {
+	var p point
+	keyEnd := indexUnescaped(line, " ", false)
+	if keyEnd < 0 {
+		return p, errMissingFields
+	}
+	keyParts := splitUnescaped(line[:keyEnd], ',', false)
+	p.measurement = unescape(keyParts[0])
+	if p.measurement == "" {
+		return p, errMissingName
+	}
+	for _, tag := range keyParts[1:] {
+		i := indexUnescaped(tag, "=", false)
+		if i <= 0 || i == len(tag)-1 {
+			return p, fmt.Errorf("%s: %q", errInvalidTag, tag)
+		}
+		p.tags = append(p.tags, unescape(tag[:i])+"="+unescape(tag[i+1:]))
+	}
+
+	rest := bytes.TrimLeft(line[keyEnd+1:], " ")
+	fieldsBuf := rest
+	var tsBuf []byte
+	if fieldsEnd := indexUnescaped(rest, " ", true); fieldsEnd >= 0 {
+		fieldsBuf = rest[:fieldsEnd]
+		tsBuf = bytes.TrimSpace(rest[fieldsEnd+1:])
+	}
+	if len(fieldsBuf) == 0 {
+		return p, errMissingFields
+	}
+	for _, f := range splitUnescaped(fieldsBuf, ',', true) {
+		i := indexUnescaped(f, "=", false)
+		if i <= 0 || i == len(f)-1 {
+			return p, fmt.Errorf("%s: %q", errInvalidField, f)
+		}
+		val, numeric, err := parseFieldValue(f[i+1:])
+		if err != nil {
+			return p, fmt.Errorf("%s: %q: %s", errInvalidField, f, err.Error())
+		}
+		if numeric {
+			p.fields = append(p.fields, field{key: unescape(f[:i]), val: val})
+		}
+	}
+
+	if len(tsBuf) > 0 {
+		ts, err := strconv.ParseInt(string(tsBuf), 10, 64)
+		if err != nil {
+			return p, fmt.Errorf("%s: %q", errInvalidTimestamp, tsBuf)
+		}
+		p.ts = ts
+		p.hasTs = true
+	}
+	return p, nil
+}
+
+// parseFieldValue parses a field value. numeric is false for string values, which we don't support.
+// booleans are converted to 1 and 0.
+func parseFieldValue(buf []byte) (val float64, numeric bool, err error) {
+	switch buf[0] {
+	case '"':
+		if len(buf) < 2 || buf[len(buf)-1] != '"' {
+			return 0, false, errors.New("unterminated string")
+		}
+		return 0, false, nil
+	}
+	s := string(buf)
+	switch s {
+	case "t", "T", "true", "True", "TRUE":
+		return 1, true, nil
+	case "f", "F", "false", "False", "FALSE":
+		return 0, true, nil
+	}
+	switch buf[len(buf)-1] {
+	case 'i':
+		i, err := strconv.ParseInt(s[:len(s)-1], 10, 64)
+		return float64(i), true, err
+	case 'u':
+		u, err := strconv.ParseUint(s[:len(s)-1], 10, 64)
+		return float64(u), true, err
+	}
+	val, err = strconv.ParseFloat(s, 64)
+	if err != nil {
+		return 0, false, err
+	}
+	// like influxdb, we don't accept NaN and infinity
+	if math.IsNaN(val) || math.IsInf(val, 0) {
+		return 0, false, fmt.Errorf("unsupported value %s", s)
+	}
+	return val, true, nil
+}
+
+// indexUnescaped returns the index of the first byte in buf that is one of the bytes in stop,
+// and that is not escaped with a backslash, nor - if quoted is set - within a double quoted string.
+// returns -1 if not found.
+func indexUnescaped(buf []byte, stop string, quoted bool) int {
+	inQuote := false
+	for i := 0; i < len(buf); i++ {
+		c := buf[i]
+		switch {
+		case c == '\\':
+			i++
+		case quoted && c == '"':
+			inQuote = !inQuote
+		case !inQuote && bytes.IndexByte([]byte(stop), c) >= 0:
+			return i
+		}
+	}
+	return -1
+}
+
+// splitUnescaped splits buf on every occurrence of sep, as found by indexUnescaped
+func splitUnescaped(buf []byte, sep byte, quoted bool) [][]byte {
+	var out [][]byte
+	stop := string(sep)
+	for {
+		i := indexUnescaped(buf, stop, quoted)
+		if i < 0 {
+			return append(out, buf)
+		}
+		out = append(out, buf[:i])
+		buf = buf[i+1:]
+	}
+}
+
+// unescape removes the backslashes from escaped commas, spaces, equal signs, quotes and backslashes
+func unescape(buf []byte) string {
+	if bytes.IndexByte(buf, '\\') < 0 {
+		return string(buf)
+	}
+	out := make([]byte, 0, len(buf))
+	for i := 0; i < len(buf); i++ {
+		if buf[i] == '\\' && i+1 < len(buf) {
+			switch buf[i+1] {
+			case ',', ' ', '=', '"', '\\':
+				i++
+			}
+		}
+		out = append(out, buf[i])
+	}
+	return string(out)
+}
+
+// toSeconds converts a timestamp in the given precision to a unix timestamp in seconds
+func toSeconds(ts int64, precision string) int64 {
+	switch precision {
+	case "u", "us":
+		return ts / 1e6
+	case "ms":
+		return ts / 1e3
+	case "s":
+		return ts
+	case "m":
+		return ts * 60
+	case "h":
+		return ts * 3600
+	}
+	// nanoseconds is the default
+	return ts / 1e9
+}
+
+// validPrecision returns whether the given timestamp precision is supported
+func validPrecision(precision string) bool {
+	switch precision {
+	case "", "n", "ns", "u", "us", "ms", "s", "m", "h":
+		return true
+	}
+	return false
+}
@@ -0,0 +1,100 @@
+package influx
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseLine(t *testing.T) {
+	cases := []struct {
+		in     string
+		expErr bool
+		exp    point
+	}{
+		{
+			in:  "cpu value=1",
+			exp: point{measurement: "cpu", fields: []field{{"value", 1}}},
+		},
+		{
+			in: "cpu,host=a,region=us-west usage_idle=98.5,usage_user=1i 1556813561098000000",
+			exp: point{
+				measurement: "cpu",
+				tags:        []string{"host=a", "region=us-west"},
+				fields:      []field{{"usage_idle", 98.5}, {"usage_user", 1}},
+				ts:          1556813561098000000,
+				hasTs:       true,
+			},
+		},
+		{
+			in: `disk,path=/var/lib free=12u,ok=true,failed=F,mode="rw, mounted" 10`,
+			exp: point{
+				measurement: "disk",
+				tags:        []string{"path=/var/lib"},
+				fields:      []field{{"free", 12}, {"ok", 1}, {"failed", 0}},
+				ts:          10,
+				hasTs:       true,
+			},
+		},
+		{
+			in: `my\ measurement,tag\,key=tag\ value\=x field\=key=-1.5e3`,
+			exp: point{
+				measurement: "my measurement",
+				tags:        []string{"tag,key=tag value=x"},
+				fields:      []field{{"field=key", -1500}},
+			},
+		},
+		{
+			in:  `log msg="hello world"`,
+			exp: point{measurement: "log"},
+		},
+		{in: "cpu", expErr: true},
+		{in: "cpu ", expErr: true},
+		{in: ",host=a value=1", expErr: true},
+		{in: "cpu,host value=1", expErr: true},
+		{in: "cpu,host= value=1", expErr: true},
+		{in: "cpu value", expErr: true},
+		{in: "cpu value=", expErr: true},
+		{in: "cpu value=abc", expErr: true},
+		{in: "cpu value=NaN", expErr: true},
+		{in: "cpu value=1.5i", expErr: true},
+		{in: `cpu value="unterminated`, expErr: true},
+		{in: "cpu value=1 notatimestamp", expErr: true},
+	}
+	for _, c := range cases {
+		p, err := parseLine([]byte(c.in))
+		if c.expErr {
+			if err == nil {
+				t.Errorf("%q: expected error, got %v", c.in, p)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%q: expected no error, got %s", c.in, err.Error())
+			continue
+		}
+		if !reflect.DeepEqual(p, c.exp) {
+			t.Errorf("%q: expected %v, got %v", c.in, c.exp, p)
+		}
+	}
+}
+
+func TestToSeconds(t *testing.T) {
+	cases := []struct {
+		ts        int64
+		precision string
+		exp       int64
+	}{
+		{1556813561098765432, "", 1556813561},
+		{1556813561098765432, "ns", 1556813561},
+		{1556813561098765, "u", 1556813561},
+		{1556813561098, "ms", 1556813561},
+		{1556813561, "s", 1556813561},
+		{25946892, "m", 1556813520},
+		{432448, "h", 1556812800},
+	}
+	for _, c := range cases {
+		if got := toSeconds(c.ts, c.precision); got != c.exp {
+			t.Errorf("toSeconds(%d, %q): expected %d, got %d", c.ts, c.precision, c.exp, got)
+		}
+	}
+}
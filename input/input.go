@@ -10,6 +10,7 @@ import (
 
 	"github.com/grafana/globalconf"
 	"github.com/grafana/metrictank/idx"
+	"github.com/grafana/metrictank/limits"
 	"github.com/grafana/metrictank/mdata"
 	"github.com/grafana/metrictank/schema"
 	"github.com/grafana/metrictank/schema/msg"
@@ -22,6 +23,7 @@ var rejectInvalidTags bool
 func ConfigSetup() {
 	input := flag.NewFlagSet("input", flag.ExitOnError)
 	input.BoolVar(&rejectInvalidTags, "reject-invalid-tags", true, "reject received metrics that have invalid tags")
+	walConfigSetup(input)
 	globalconf.Register("input", input, flag.ExitOnError)
 }
 
@@ -55,6 +57,8 @@ const (
 	invalidMtype     = "invalid-mtype"
 	invalidTagFormat = "invalid-tag-format"
 	unknownPointId   = "unknown-point-id"
+	rateLimited      = "rate-limited"
+	seriesLimited    = "series-limited"
 )
 
 func NewDefaultHandler(metrics mdata.Metrics, metricIndex idx.MetricIndex, input string) DefaultHandler {
@@ -83,6 +87,12 @@ func NewDefaultHandler(metrics mdata.Metrics, metricIndex idx.MetricIndex, input
 // ProcessMetricPoint updates the index if possible, and stores the data if we have an index entry
 // concurrency-safe.
 func (in DefaultHandler) ProcessMetricPoint(point schema.MetricPoint, format msg.Format, partition int32) {
+	in.processMetricPoint(point, format, partition, false)
+}
+
+// processMetricPoint processes the point. when replaying the write-ahead log,
+// the ingest limits are not applied, as the point was accepted before, and it is not written to the write-ahead log again.
+func (in DefaultHandler) processMetricPoint(point schema.MetricPoint, format msg.Format, partition int32, replay bool) {
 	if format == msg.FormatMetricPoint {
 		in.receivedMP.Inc()
 	} else {
@@ -97,6 +107,11 @@ func (in DefaultHandler) ProcessMetricPoint(point schema.MetricPoint, format msg
 		return
 	}
 
+	if !replay && !limits.AllowIngest(point.MKey.Org) {
+		mdata.PromDiscardedSamples.WithLabelValues(rateLimited, strconv.Itoa(int(point.MKey.Org))).Inc()
+		return
+	}
+
 	archive, _, ok := in.metricIndex.Update(point, partition)
 
 	if !ok {
@@ -107,11 +122,19 @@ func (in DefaultHandler) ProcessMetricPoint(point schema.MetricPoint, format msg
 
 	m := in.metrics.GetOrCreate(point.MKey, archive.SchemaId, archive.AggId, uint32(archive.Interval))
 	m.Add(point.Time, point.Value)
+	if !replay && journal != nil {
+		journal.writeMetricPoint(point, partition)
+	}
 }
 
 // ProcessMetricData assures the data is stored and the metadata is in the index
 // concurrency-safe.
 func (in DefaultHandler) ProcessMetricData(md *schema.MetricData, partition int32) {
+	in.processMetricData(md, partition, false)
+}
+
+// processMetricData processes the metricdata. see processMetricPoint for the meaning of replay
+func (in DefaultHandler) processMetricData(md *schema.MetricData, partition int32, replay bool) {
 	in.receivedMD.Inc()
 	err := md.Validate()
 	if err != nil {
@@ -173,8 +196,24 @@ func (in DefaultHandler) ProcessMetricData(md *schema.MetricData, partition int3
 		return
 	}
 
+	if !replay {
+		if !limits.AllowIngest(uint32(md.OrgId)) {
+			mdata.PromDiscardedSamples.WithLabelValues(rateLimited, strconv.Itoa(md.OrgId)).Inc()
+			return
+		}
+		if limits.Get(uint32(md.OrgId)).MaxActiveSeries > 0 {
+			if _, ok := in.metricIndex.Get(mkey); !ok && !limits.AllowNewSeries(uint32(md.OrgId)) {
+				mdata.PromDiscardedSamples.WithLabelValues(seriesLimited, strconv.Itoa(md.OrgId)).Inc()
+				return
+			}
+		}
+	}
+
 	archive, _, _ := in.metricIndex.AddOrUpdate(mkey, md, partition)
 
 	m := in.metrics.GetOrCreate(mkey, archive.SchemaId, archive.AggId, uint32(md.Interval))
 	m.Add(uint32(md.Time), md.Value)
+	if !replay && journal != nil {
+		journal.writeMetricData(md, partition)
+	}
 }
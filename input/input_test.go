@@ -182,19 +182,19 @@ func getDefaultHandler(t *testing.T) (DefaultHandler, idx.MetricIndex, func()) {
 	t.Helper()
 
 	oldRejectInvalidTags := rejectInvalidTags
-	oldSchemas := mdata.Schemas
+	oldSchemas := mdata.Schemas()
 	oldTagSupport := memory.TagSupport
 	memory.TagSupport = true
 	index := memory.New()
 
 	reset := func() {
 		rejectInvalidTags = oldRejectInvalidTags
-		mdata.Schemas = oldSchemas
+		mdata.SetSchemas(*oldSchemas)
 		memory.TagSupport = oldTagSupport
 		index.Stop()
 	}
 
-	mdata.Schemas = conf.NewSchemas(nil)
+	mdata.SetSchemas(conf.NewSchemas(nil))
 	metrics := mdata.NewAggMetrics(nil, nil, false, nil, 3600, 7200, 3600)
 	return NewDefaultHandler(metrics, index, "test"), index, reset
 }
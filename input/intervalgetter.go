@@ -0,0 +1,42 @@
+package input
+
+import (
+	"github.com/grafana/metrictank/idx"
+	"github.com/grafana/metrictank/mdata"
+	"github.com/grafana/metrictank/schema"
+)
+
+// IntervalGetter is anything that can return the interval for the given series
+// we don't want input plugins such as carbon to directly talk to an index because the api
+// surface is too big and it would couple too tightly which is annoying in unit tests
+type IntervalGetter interface {
+	GetInterval(orgId uint32, name string, tags []string) int
+}
+
+type IndexIntervalGetter struct {
+	idx idx.MetricIndex
+}
+
+func NewIndexIntervalGetter(idx idx.MetricIndex) IntervalGetter {
+	return IndexIntervalGetter{idx}
+}
+
+func (i IndexIntervalGetter) GetInterval(orgId uint32, name string, tags []string) int {
+	// the schemas are matched against the name with its tags, the same way the index does,
+	// so that schemas scoped to tags apply
+	path := name
+	if len(tags) > 0 {
+		def := schema.MetricDefinition{Name: name, Tags: append([]string(nil), tags...)}
+		path = def.NameWithTags()
+	}
+	archives := i.idx.GetPath(orgId, path)
+	for _, a := range archives {
+		// since the schemas are determined at runtime for new entries, they will be the same
+		// for any archive with the given path. so the first one we find is enough.
+		return a.Interval
+	}
+	// if it's the first time we're seeing this series, do the more expensive matching
+	// note that the index will also do this matching again first time it sees the metric
+	_, s := mdata.MatchSchema(orgId, path, 0)
+	return s.Retentions.Rets[0].SecondsPerPoint
+}
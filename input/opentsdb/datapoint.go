@@ -0,0 +1,139 @@
+package opentsdb
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/grafana/metrictank/input"
+	"github.com/grafana/metrictank/schema"
+)
+
+var (
+	errMissingMetric    = errors.New("missing metric")
+	errInvalidTimestamp = errors.New("invalid timestamp")
+	errInvalidValue     = errors.New("invalid value")
+	errInvalidTag       = errors.New("invalid tag")
+)
+
+// maxSecondsTimestamp is the largest timestamp that opentsdb interprets as seconds.
+// anything larger is in milliseconds.
+const maxSecondsTimestamp = 9999999999
+
+// datapoint is a single opentsdb datapoint, as sent to /api/put.
+// the telnet put format is parsed into the same structure.
+type datapoint struct {
+	Metric    string            `json:"metric"`
+	Timestamp int64             `json:"timestamp"`
+	Value     interface{}       `json:"value"` // a number, or a string holding a number
+	Tags      map[string]string `json:"tags"`
+}
+
+// decodePut decodes the body of a /api/put request, which is either a single datapoint
+// or a list of datapoints
+func decodePut(body []byte) ([]datapoint, error) {
+	body = bytes.TrimSpace(body)
+	if len(body) == 0 {
+		return nil, errors.New("empty body")
+	}
+	if body[0] == '[' {
+		var dps []datapoint
+		err := json.Unmarshal(body, &dps)
+		return dps, err
+	}
+	var dp datapoint
+	err := json.Unmarshal(body, &dp)
+	if err != nil {
+		return nil, err
+	}
+	return []datapoint{dp}, nil
+}
+
+// parseTelnetPut parses the arguments of a telnet put command:
+// put <metric> <timestamp> <value> <tagk1=tagv1 ...>
+// args is the list of space separated words following "put"
+func parseTelnetPut(args []string) (datapoint, error) {
+	var dp datapoint
+	if len(args) < 3 {
+		return dp, fmt.Errorf("not enough arguments (need at least 3, got %d)", len(args))
+	}
+	dp.Metric = args[0]
+	ts, err := strconv.ParseInt(args[1], 10, 64)
+	if err != nil {
+		return dp, fmt.Errorf("%s: %q", errInvalidTimestamp, args[1])
+	}
+	dp.Timestamp = ts
+	dp.Value = args[2]
+	dp.Tags = make(map[string]string, len(args)-3)
+	for _, tag := range args[3:] {
+		i := strings.IndexByte(tag, '=')
+		if i <= 0 || i == len(tag)-1 {
+			return dp, fmt.Errorf("%s: %q", errInvalidTag, tag)
+		}
+		dp.Tags[tag[:i]] = tag[i+1:]
+	}
+	return dp, nil
+}
+
+// toMetricData validates the datapoint and converts it to a MetricData
+func (dp datapoint) toMetricData(intervalGetter input.IntervalGetter) (*schema.MetricData, error) {
+	if dp.Metric == "" {
+		return nil, errMissingMetric
+	}
+	if dp.Timestamp <= 0 {
+		return nil, fmt.Errorf("%s: %d", errInvalidTimestamp, dp.Timestamp)
+	}
+	ts := dp.Timestamp
+	if ts > maxSecondsTimestamp {
+		ts /= 1000
+	}
+	val, err := dp.value()
+	if err != nil {
+		return nil, err
+	}
+	tags := make([]string, 0, len(dp.Tags))
+	for k, v := range dp.Tags {
+		if k == "" || v == "" {
+			return nil, fmt.Errorf("%s: %q=%q", errInvalidTag, k, v)
+		}
+		tags = append(tags, k+"="+v)
+	}
+	sort.Strings(tags)
+	md := &schema.MetricData{
+		Name:     dp.Metric,
+		Interval: intervalGetter.GetInterval(1, dp.Metric, tags),
+		Value:    val,
+		Unit:     "unknown",
+		Time:     ts,
+		Mtype:    "gauge",
+		Tags:     tags,
+		OrgId:    1, // admin org
+	}
+	md.SetId()
+	return md, nil
+}
+
+func (dp datapoint) value() (float64, error) {
+	var val float64
+	switch v := dp.Value.(type) {
+	case float64:
+		val = v
+	case string:
+		var err error
+		val, err = strconv.ParseFloat(v, 64)
+		if err != nil {
+			return 0, fmt.Errorf("%s: %q", errInvalidValue, v)
+		}
+	default:
+		return 0, fmt.Errorf("%s: %v", errInvalidValue, dp.Value)
+	}
+	if math.IsNaN(val) || math.IsInf(val, 0) {
+		return 0, fmt.Errorf("%s: %v", errInvalidValue, dp.Value)
+	}
+	return val, nil
+}
@@ -0,0 +1,110 @@
+package opentsdb
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+type mockIntervalGetter struct{}
+
+func (m mockIntervalGetter) GetInterval(orgId uint32, name string, tags []string) int {
+	return 10
+}
+
+func TestDecodePut(t *testing.T) {
+	cases := []struct {
+		body   string
+		expErr bool
+		exp    []datapoint
+	}{
+		{
+			body: `{"metric": "sys.cpu.nice", "timestamp": 1346846400, "value": 18, "tags": {"host": "web01", "dc": "lga"}}`,
+			exp: []datapoint{
+				{Metric: "sys.cpu.nice", Timestamp: 1346846400, Value: 18.0, Tags: map[string]string{"host": "web01", "dc": "lga"}},
+			},
+		},
+		{
+			body: `[{"metric": "sys.cpu.nice", "timestamp": 1346846400000, "value": "18.5", "tags": {"host": "web01"}}, {"metric": "sys.cpu.idle", "timestamp": 1346846400, "value": 9}]`,
+			exp: []datapoint{
+				{Metric: "sys.cpu.nice", Timestamp: 1346846400000, Value: "18.5", Tags: map[string]string{"host": "web01"}},
+				{Metric: "sys.cpu.idle", Timestamp: 1346846400, Value: 9.0},
+			},
+		},
+		{body: ``, expErr: true},
+		{body: `{"metric": "sys.cpu.nice"`, expErr: true},
+		{body: `"sys.cpu.nice"`, expErr: true},
+	}
+	for _, c := range cases {
+		dps, err := decodePut([]byte(c.body))
+		if c.expErr {
+			if err == nil {
+				t.Errorf("%q: expected error, got %v", c.body, dps)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%q: expected no error, got %s", c.body, err.Error())
+			continue
+		}
+		if !reflect.DeepEqual(dps, c.exp) {
+			t.Errorf("%q: expected %v, got %v", c.body, c.exp, dps)
+		}
+	}
+}
+
+func TestToMetricData(t *testing.T) {
+	cases := []struct {
+		line    string
+		expErr  bool
+		expName string
+		expTime int64
+		expVal  float64
+		expTags []string
+	}{
+		{
+			line:    "sys.cpu.user 1356998400 42.5 host=webserver01 cpu=0",
+			expName: "sys.cpu.user",
+			expTime: 1356998400,
+			expVal:  42.5,
+			expTags: []string{"cpu=0", "host=webserver01"},
+		},
+		{
+			line:    "sys.cpu.user 1356998400500 -3",
+			expName: "sys.cpu.user",
+			expTime: 1356998400,
+			expVal:  -3,
+			expTags: []string{},
+		},
+		{line: "sys.cpu.user 1356998400", expErr: true},
+		{line: "sys.cpu.user foo 42.5", expErr: true},
+		{line: "sys.cpu.user 0 42.5", expErr: true},
+		{line: "sys.cpu.user 1356998400 bar", expErr: true},
+		{line: "sys.cpu.user 1356998400 NaN", expErr: true},
+		{line: "sys.cpu.user 1356998400 42.5 host", expErr: true},
+		{line: "sys.cpu.user 1356998400 42.5 host=", expErr: true},
+	}
+	for _, c := range cases {
+		dp, err := parseTelnetPut(strings.Fields(c.line))
+		if err == nil {
+			md, err2 := dp.toMetricData(mockIntervalGetter{})
+			if err2 == nil {
+				if c.expErr {
+					t.Errorf("%q: expected error, got %v", c.line, md)
+					continue
+				}
+				if md.Name != c.expName || md.Time != c.expTime || md.Value != c.expVal || md.Interval != 10 || md.OrgId != 1 || md.Id == "" {
+					t.Errorf("%q: unexpected metricdata %v", c.line, md)
+				}
+				if !reflect.DeepEqual(md.Tags, c.expTags) {
+					t.Errorf("%q: expected tags %v, got %v", c.line, c.expTags, md.Tags)
+				}
+				continue
+			}
+			err = err2
+		}
+		if !c.expErr {
+			t.Errorf("%q: expected no error, got %s", c.line, err.Error())
+		}
+	}
+}
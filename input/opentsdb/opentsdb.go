@@ -0,0 +1,330 @@
+// package opentsdb provides an input for the opentsdb protocols:
+// the json /api/put http endpoint, and the telnet style put command over tcp.
+package opentsdb
+
+import (
+	"bufio"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/grafana/globalconf"
+	"github.com/grafana/metrictank/cluster"
+	"github.com/grafana/metrictank/input"
+	"github.com/grafana/metrictank/stats"
+	log "github.com/sirupsen/logrus"
+)
+
+// metric input.opentsdb.metrics_per_message is how many metrics per message were seen. for http this is per request, for telnet it is always 1.
+var metricsPerMessage = stats.NewMeter32("input.opentsdb.metrics_per_message", false)
+
+// metric input.opentsdb.metrics_decode_err is a count of times an input message (http request, datapoint or telnet line) failed to parse
+var metricsDecodeErr = stats.NewCounterRate32("input.opentsdb.metrics_decode_err")
+
+// maxLineSize is the longest telnet line we accept
+const maxLineSize = 64 * 1024
+
+var (
+	Enabled     bool
+	addr        string
+	telnetAddr  string
+	partitionID int
+)
+
+func ConfigSetup() {
+	inOpenTSDB := flag.NewFlagSet("opentsdb-in", flag.ExitOnError)
+	inOpenTSDB.BoolVar(&Enabled, "enabled", false, "")
+	inOpenTSDB.StringVar(&addr, "addr", ":4242", "http listen address for the /api/put endpoint")
+	inOpenTSDB.StringVar(&telnetAddr, "telnet-addr", "", "tcp listen address for the telnet put protocol. empty to disable")
+	inOpenTSDB.IntVar(&partitionID, "partition", 0, "partition Id.")
+	globalconf.Register("opentsdb-in", inOpenTSDB, flag.ExitOnError)
+}
+
+func ConfigProcess() {
+	if !Enabled {
+		return
+	}
+	cluster.Manager.SetPartitions([]int32{int32(partitionID)})
+}
+
+type OpenTSDB struct {
+	input.Handler
+	addr             string
+	telnetAddr       *net.TCPAddr // nil if the telnet listener is disabled
+	server           *http.Server
+	listener         *net.TCPListener
+	handlerWaitGroup sync.WaitGroup
+	quit             chan struct{}
+	connTrack        *input.ConnTrack
+	intervalGetter   input.IntervalGetter
+}
+
+func New() *OpenTSDB {
+	o := &OpenTSDB{
+		addr:      addr,
+		connTrack: input.NewConnTrack(),
+	}
+	if telnetAddr != "" {
+		var err error
+		o.telnetAddr, err = net.ResolveTCPAddr("tcp", telnetAddr)
+		if err != nil {
+			log.Fatalf("opentsdb-in: %s", err.Error())
+		}
+	}
+	return o
+}
+
+func (o *OpenTSDB) Name() string {
+	return "opentsdb"
+}
+
+func (o *OpenTSDB) IntervalGetter(i input.IntervalGetter) {
+	o.intervalGetter = i
+}
+
+func (o *OpenTSDB) Start(handler input.Handler, cancel context.CancelFunc) error {
+	o.Handler = handler
+	o.quit = make(chan struct{})
+
+	l, err := net.Listen("tcp", o.addr)
+	if err != nil {
+		log.Errorf("opentsdb-in: %s", err.Error())
+		return err
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/put", o.handlePut)
+	o.server = &http.Server{
+		Addr:    o.addr,
+		Handler: mux,
+	}
+	log.Infof("opentsdb-in: listening on %v/http", o.addr)
+	go func() {
+		err := o.server.Serve(l)
+		if err != http.ErrServerClosed {
+			log.Errorf("opentsdb-in: http server failed: %s", err.Error())
+			cancel()
+		}
+	}()
+
+	if o.telnetAddr != nil {
+		o.listener, err = net.ListenTCP("tcp", o.telnetAddr)
+		if err != nil {
+			log.Errorf("opentsdb-in: %s", err.Error())
+			return err
+		}
+		log.Infof("opentsdb-in: listening on %v/tcp", o.telnetAddr)
+		go o.accept()
+	}
+	return nil
+}
+
+// MaintainPriority is very simplistic for opentsdb. there is no backfill,
+// so mark as ready immediately.
+func (o *OpenTSDB) MaintainPriority() {
+	cluster.Manager.SetPriority(0)
+}
+
+func (o *OpenTSDB) ExplainPriority() interface{} {
+	return "opentsdb-in: priority=0 (always in sync)"
+}
+
+func (o *OpenTSDB) Stop() {
+	log.Info("opentsdb-in: shutting down")
+	if o.quit == nil {
+		// we were never started
+		return
+	}
+	close(o.quit)
+	if o.server != nil {
+		o.server.Shutdown(context.Background())
+	}
+	if o.listener != nil {
+		o.listener.Close()
+	}
+	o.connTrack.CloseAll()
+	o.handlerWaitGroup.Wait()
+}
+
+// putError describes a datapoint that could not be ingested, in "details" mode
+type putError struct {
+	Datapoint datapoint `json:"datapoint"`
+	Error     string    `json:"error"`
+}
+
+// putSummary is the response in "summary" mode
+type putSummary struct {
+	Failed  int `json:"failed"`
+	Success int `json:"success"`
+}
+
+// putDetails is the response in "details" mode
+type putDetails struct {
+	Errors  []putError `json:"errors"`
+	Failed  int        `json:"failed"`
+	Success int        `json:"success"`
+}
+
+// handlePut handles the opentsdb /api/put endpoint.
+// like opentsdb, valid datapoints are ingested even if some are invalid.
+// by default the response is empty, unless there were errors.
+// the summary and details query parameters request a summary of the ingestion, or a summary along with the errors, respectively.
+func (o *OpenTSDB) handlePut(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodPost {
+		writeError(w, http.StatusMethodNotAllowed, "Method not allowed", "The HTTP method ["+req.Method+"] is not permitted for this endpoint")
+		return
+	}
+	defer req.Body.Close()
+	var body io.Reader = req.Body
+	if req.Header.Get("Content-Encoding") == "gzip" {
+		gz, err := gzip.NewReader(req.Body)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, "Unable to decompress request", err.Error())
+			return
+		}
+		defer gz.Close()
+		body = gz
+	}
+	buf, err := ioutil.ReadAll(body)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "Unable to read request", err.Error())
+		return
+	}
+	dps, err := decodePut(buf)
+	if err != nil {
+		metricsDecodeErr.Inc()
+		writeError(w, http.StatusBadRequest, "Unable to parse the given JSON", err.Error())
+		return
+	}
+
+	errs := make([]putError, 0)
+	for _, dp := range dps {
+		md, err := dp.toMetricData(o.intervalGetter)
+		if err != nil {
+			metricsDecodeErr.Inc()
+			errs = append(errs, putError{Datapoint: dp, Error: err.Error()})
+			continue
+		}
+		o.Handler.ProcessMetricData(md, int32(partitionID))
+	}
+	success := len(dps) - len(errs)
+	metricsPerMessage.ValueUint32(uint32(success))
+
+	code := http.StatusOK
+	if len(errs) > 0 {
+		code = http.StatusBadRequest
+	}
+	query := req.URL.Query()
+	if _, ok := query["details"]; ok {
+		writeJSON(w, code, putDetails{Errors: errs, Failed: len(errs), Success: success})
+		return
+	}
+	if _, ok := query["summary"]; ok {
+		writeJSON(w, code, putSummary{Failed: len(errs), Success: success})
+		return
+	}
+	if len(errs) > 0 {
+		writeError(w, code, "One or more data points had errors", "Please see the TSD logs or append \"details\" to the put request")
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func writeJSON(w http.ResponseWriter, code int, resp interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(resp)
+}
+
+// writeError writes an error response in the format opentsdb uses
+func writeError(w http.ResponseWriter, code int, message, details string) {
+	writeJSON(w, code, map[string]interface{}{
+		"error": map[string]interface{}{
+			"code":    code,
+			"message": message,
+			"details": details,
+		},
+	})
+}
+
+func (o *OpenTSDB) accept() {
+	for {
+		conn, err := o.listener.AcceptTCP()
+		if err != nil {
+			select {
+			case <-o.quit:
+				// we are shutting down.
+				return
+			default:
+			}
+			log.Errorf("opentsdb-in: Accept Error: %s", err.Error())
+			return
+		}
+		o.handlerWaitGroup.Add(1)
+		o.connTrack.Add(conn)
+		go o.handle(conn)
+	}
+}
+
+// handle reads telnet style commands from a tcp connection.
+// like opentsdb, errors are reported back to the client.
+func (o *OpenTSDB) handle(conn net.Conn) {
+	defer func() {
+		conn.Close()
+		o.connTrack.Remove(conn)
+		o.handlerWaitGroup.Done()
+	}()
+	scanner := bufio.NewScanner(conn)
+	scanner.Buffer(make([]byte, 0, 4096), maxLineSize)
+	for scanner.Scan() {
+		words := strings.Fields(scanner.Text())
+		if len(words) == 0 {
+			continue
+		}
+		switch words[0] {
+		case "put":
+			err := o.handleTelnetPut(words[1:])
+			if err != nil {
+				fmt.Fprintf(conn, "put: %s\n", err.Error())
+			}
+		case "version":
+			fmt.Fprintf(conn, "metrictank opentsdb input\n")
+		case "exit":
+			return
+		default:
+			fmt.Fprintf(conn, "unknown command: %s.\n", words[0])
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		select {
+		case <-o.quit:
+			// we are shutting down.
+			return
+		default:
+		}
+		log.Errorf("opentsdb-in: Recv error: %s", err.Error())
+	}
+}
+
+func (o *OpenTSDB) handleTelnetPut(args []string) error {
+	dp, err := parseTelnetPut(args)
+	if err != nil {
+		metricsDecodeErr.Inc()
+		return err
+	}
+	md, err := dp.toMetricData(o.intervalGetter)
+	if err != nil {
+		metricsDecodeErr.Inc()
+		return err
+	}
+	metricsPerMessage.ValueUint32(1)
+	o.Handler.ProcessMetricData(md, int32(partitionID))
+	return nil
+}
@@ -0,0 +1,106 @@
+package opentsdb
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/grafana/metrictank/schema"
+	"github.com/grafana/metrictank/schema/msg"
+)
+
+type mockHandler struct {
+	mds []*schema.MetricData
+}
+
+func (m *mockHandler) ProcessMetricData(md *schema.MetricData, partition int32) {
+	m.mds = append(m.mds, md)
+}
+
+func (m *mockHandler) ProcessMetricPoint(point schema.MetricPoint, format msg.Format, partition int32) {
+}
+
+func TestHandlePut(t *testing.T) {
+	body := `[{"metric": "sys.cpu.nice", "timestamp": 1346846400, "value": 18, "tags": {"host": "web01"}}, {"metric": "", "timestamp": 1346846400, "value": 9}]`
+	cases := []struct {
+		name       string
+		url        string
+		body       string
+		expCode    int
+		expSummary *putSummary
+		expErrors  int
+	}{
+		{
+			name:    "valid",
+			url:     "/api/put",
+			body:    `{"metric": "sys.cpu.nice", "timestamp": 1346846400, "value": 18, "tags": {"host": "web01"}}`,
+			expCode: http.StatusNoContent,
+		},
+		{
+			name:    "valid with summary",
+			url:     "/api/put?summary",
+			body:    `{"metric": "sys.cpu.nice", "timestamp": 1346846400, "value": 18, "tags": {"host": "web01"}}`,
+			expCode: http.StatusOK,
+			expSummary: &putSummary{
+				Success: 1,
+			},
+		},
+		{
+			name:    "partial",
+			url:     "/api/put",
+			body:    body,
+			expCode: http.StatusBadRequest,
+		},
+		{
+			name:    "partial with summary",
+			url:     "/api/put?summary",
+			body:    body,
+			expCode: http.StatusBadRequest,
+			expSummary: &putSummary{
+				Success: 1,
+				Failed:  1,
+			},
+		},
+		{
+			name:    "partial with details",
+			url:     "/api/put?details",
+			body:    body,
+			expCode: http.StatusBadRequest,
+			expSummary: &putSummary{
+				Success: 1,
+				Failed:  1,
+			},
+			expErrors: 1,
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			handler := &mockHandler{}
+			o := New()
+			o.Handler = handler
+			o.IntervalGetter(mockIntervalGetter{})
+
+			w := httptest.NewRecorder()
+			o.handlePut(w, httptest.NewRequest("POST", c.url, strings.NewReader(c.body)))
+			if w.Code != c.expCode {
+				t.Fatalf("expected code %d, got %d (%s)", c.expCode, w.Code, w.Body.String())
+			}
+			if len(handler.mds) != 1 || handler.mds[0].Name != "sys.cpu.nice" {
+				t.Fatalf("expected sys.cpu.nice to be processed, got %v", handler.mds)
+			}
+			if c.expSummary == nil {
+				return
+			}
+			var resp putDetails
+			err := json.Unmarshal(w.Body.Bytes(), &resp)
+			if err != nil {
+				t.Fatalf("failed to decode response %q: %s", w.Body.String(), err.Error())
+			}
+			if resp.Success != c.expSummary.Success || resp.Failed != c.expSummary.Failed || len(resp.Errors) != c.expErrors {
+				t.Fatalf("unexpected response %q", w.Body.String())
+			}
+		})
+	}
+}
@@ -0,0 +1,425 @@
+package input
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"flag"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/grafana/metrictank/idx"
+	"github.com/grafana/metrictank/mdata"
+	"github.com/grafana/metrictank/schema"
+	"github.com/grafana/metrictank/schema/msg"
+	"github.com/grafana/metrictank/stats"
+	log "github.com/sirupsen/logrus"
+)
+
+// the write-ahead log holds all points accepted by the DefaultHandler, so that after a restart
+// the in-memory chunks can be restored from local disk, rather than by replaying the input (e.g. kafka).
+// it is a sequence of segment files, named segment.<seq>. a new segment is started every segment-duration.
+// each segment is a sequence of records:
+//
+// length    uint32 (length of the body)
+// crc       uint32 (IEEE crc32 of the body)
+// body:
+//   op        byte
+//   partition int32
+//   payload   []byte (depends on op)
+//
+// a segment is deleted once all of its points are in chunks that have been persisted, as reported via
+// SyncChunkSaveState (by the store on primaries, and by the persist notifiers on secondaries)
+
+const (
+	walOpMetricData  byte = 1 // payload: msgp encoded MetricData
+	walOpMetricPoint byte = 2 // payload: MetricPoint, marshaled with org
+
+	walHeaderSize    = 8
+	walSegmentPrefix = "segment."
+)
+
+var (
+	walEnabled            bool
+	walDir                string
+	walSegmentDuration    time.Duration
+	walSyncInterval       time.Duration
+	walCheckpointInterval time.Duration
+
+	errCorruptWalRecord = errors.New("corrupt record")
+
+	// the write-ahead log, if enabled and started
+	journal *wal
+
+	// metric input.wal.write.ok is how many points were written to the write-ahead log
+	walWriteOk = stats.NewCounter32("input.wal.write.ok")
+	// metric input.wal.write.fail is how many points could not be written to the write-ahead log
+	walWriteFail = stats.NewCounter32("input.wal.write.fail")
+	// metric input.wal.segments is the number of segments of the write-ahead log on disk
+	walSegments = stats.NewGauge32("input.wal.segments")
+	// metric input.wal.replay is the duration of the replay of the write-ahead log at startup
+	walReplayDuration = stats.NewLatencyHistogram12h32("input.wal.replay")
+	// metric input.wal.replayed is how many points were replayed from the write-ahead log at startup
+	walReplayed = stats.NewCounter32("input.wal.replayed")
+)
+
+func walConfigSetup(input *flag.FlagSet) {
+	input.BoolVar(&walEnabled, "wal-enabled", false, "keep a local write-ahead log of all ingested points, and replay it at startup to restore the in-memory chunks")
+	input.StringVar(&walDir, "wal-dir", "/var/lib/metrictank/wal", "directory to store the write-ahead log in")
+	input.DurationVar(&walSegmentDuration, "wal-segment-duration", 10*time.Minute, "how long to write to a segment of the write-ahead log before starting a new one. segments can only be deleted as a whole")
+	input.DurationVar(&walSyncInterval, "wal-sync-interval", time.Second, "how often to fsync the write-ahead log. points received since the last sync are lost on a crash")
+	input.DurationVar(&walCheckpointInterval, "wal-checkpoint-interval", time.Minute, "how often to delete segments of the write-ahead log of which all points have been persisted")
+}
+
+func ConfigProcess() {
+	if !walEnabled {
+		return
+	}
+	if walDir == "" {
+		log.Fatal("input: wal-dir must be set when the write-ahead log is enabled")
+	}
+	if walSegmentDuration <= 0 || walSyncInterval <= 0 || walCheckpointInterval <= 0 {
+		log.Fatal("input: wal-segment-duration, wal-sync-interval and wal-checkpoint-interval must be greater than 0")
+	}
+}
+
+// walSegment describes a segment that is no longer written to
+type walSegment struct {
+	seq   uint64
+	maxTs uint32 // highest timestamp of any point in the segment
+}
+
+type wal struct {
+	sync.Mutex
+	dir     string
+	metrics *mdata.AggMetrics
+
+	// the segment being written to
+	seq    uint64
+	file   *os.File
+	writer *bufio.Writer
+	opened time.Time
+	maxTs  uint32
+	dirty  bool
+
+	closed  []walSegment
+	stopped bool
+
+	shutdown chan struct{}
+	wg       sync.WaitGroup
+}
+
+// StartWAL replays the write-ahead log, if enabled, and starts writing all points subsequently accepted
+// by any DefaultHandler to it. it must be called before the input plugins are started.
+func StartWAL(metrics *mdata.AggMetrics, metricIndex idx.MetricIndex) error {
+	if !walEnabled {
+		return nil
+	}
+	if err := os.MkdirAll(walDir, 0755); err != nil {
+		return err
+	}
+	w := &wal{
+		dir:      walDir,
+		metrics:  metrics,
+		shutdown: make(chan struct{}),
+	}
+	if err := w.replay(NewDefaultHandler(metrics, metricIndex, "wal")); err != nil {
+		return err
+	}
+	if err := w.openSegment(w.seq + 1); err != nil {
+		return err
+	}
+	journal = w
+	w.wg.Add(1)
+	go w.run()
+	return nil
+}
+
+// StopWAL syncs and closes the write-ahead log. it must be called after the input plugins have been stopped.
+func StopWAL() {
+	w := journal
+	if w == nil {
+		return
+	}
+	close(w.shutdown)
+	w.wg.Wait()
+
+	w.Lock()
+	defer w.Unlock()
+	if err := w.sync(); err != nil {
+		log.Errorf("input: failed to sync write-ahead log: %s", err)
+	}
+	w.file.Close()
+	w.stopped = true
+}
+
+func segmentName(dir string, seq uint64) string {
+	return filepath.Join(dir, fmt.Sprintf("%s%010d", walSegmentPrefix, seq))
+}
+
+// listSegments returns the sequence numbers of all segments in dir, in ascending order
+func listSegments(dir string) ([]uint64, error) {
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	var seqs []uint64
+	for _, file := range files {
+		if !strings.HasPrefix(file.Name(), walSegmentPrefix) {
+			continue
+		}
+		seq, err := strconv.ParseUint(strings.TrimPrefix(file.Name(), walSegmentPrefix), 10, 64)
+		if err != nil {
+			continue
+		}
+		seqs = append(seqs, seq)
+	}
+	sort.Slice(seqs, func(i, j int) bool { return seqs[i] < seqs[j] })
+	return seqs, nil
+}
+
+func encodeWalRecord(op byte, partition int32, payload []byte) []byte {
+	buf := make([]byte, walHeaderSize+5+len(payload))
+	body := buf[walHeaderSize:]
+	body[0] = op
+	binary.LittleEndian.PutUint32(body[1:], uint32(partition))
+	copy(body[5:], payload)
+	binary.LittleEndian.PutUint32(buf[0:], uint32(len(body)))
+	binary.LittleEndian.PutUint32(buf[4:], crc32.ChecksumIEEE(body))
+	return buf
+}
+
+// replay feeds all points in the existing segments to the handler.
+// the segments are kept until their points have been persisted again.
+func (w *wal) replay(handler DefaultHandler) error {
+	pre := time.Now()
+	seqs, err := listSegments(w.dir)
+	if err != nil {
+		return err
+	}
+	var num int
+	for _, seq := range seqs {
+		path := segmentName(w.dir, seq)
+		n, maxTs, err := replaySegment(path, handler)
+		num += n
+		if err == errCorruptWalRecord {
+			// the result of a crash while writing the segment. all records before it were replayed.
+			log.Warnf("input: write-ahead log segment %s ends with a corrupt record. replayed the %d records before it", path, n)
+		} else if err != nil {
+			return fmt.Errorf("input: failed to replay write-ahead log segment %s: %s", path, err)
+		}
+		w.seq = seq
+		w.closed = append(w.closed, walSegment{seq: seq, maxTs: maxTs})
+	}
+	walSegments.SetUint32(uint32(len(w.closed)))
+	walReplayed.Add(num)
+	walReplayDuration.Value(time.Since(pre))
+	log.Infof("input: replayed %d points from %d write-ahead log segments in %s", num, len(seqs), time.Since(pre))
+	return nil
+}
+
+// replaySegment feeds all points in the segment to the handler, and returns how many it replayed and their highest timestamp.
+// reading stops at the first incomplete or corrupt record, in which case errCorruptWalRecord is returned.
+func replaySegment(path string, handler DefaultHandler) (int, uint32, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, 0, err
+	}
+	defer f.Close()
+	r := bufio.NewReader(f)
+	var header [walHeaderSize]byte
+	var num int
+	var maxTs uint32
+	for {
+		_, err := io.ReadFull(r, header[:])
+		if err == io.EOF {
+			return num, maxTs, nil
+		}
+		if err != nil {
+			return num, maxTs, errCorruptWalRecord
+		}
+		body := make([]byte, binary.LittleEndian.Uint32(header[0:]))
+		if _, err := io.ReadFull(r, body); err != nil {
+			return num, maxTs, errCorruptWalRecord
+		}
+		if len(body) < 5 || crc32.ChecksumIEEE(body) != binary.LittleEndian.Uint32(header[4:]) {
+			return num, maxTs, errCorruptWalRecord
+		}
+		partition := int32(binary.LittleEndian.Uint32(body[1:]))
+		payload := body[5:]
+		var ts uint32
+		switch body[0] {
+		case walOpMetricData:
+			md := &schema.MetricData{}
+			if _, err := md.UnmarshalMsg(payload); err != nil {
+				return num, maxTs, errCorruptWalRecord
+			}
+			handler.processMetricData(md, partition, true)
+			ts = uint32(md.Time)
+		case walOpMetricPoint:
+			if len(payload) != 32 {
+				return num, maxTs, errCorruptWalRecord
+			}
+			var point schema.MetricPoint
+			point.Unmarshal(payload)
+			handler.processMetricPoint(point, msg.FormatMetricPoint, partition, true)
+			ts = point.Time
+		default:
+			return num, maxTs, errCorruptWalRecord
+		}
+		if ts > maxTs {
+			maxTs = ts
+		}
+		num++
+	}
+}
+
+// openSegment starts a new segment with the given sequence number
+// caller must hold the lock, if the wal is in use
+func (w *wal) openSegment(seq uint64) error {
+	path := segmentName(w.dir, seq)
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return fmt.Errorf("input: failed to open write-ahead log segment %s: %s", path, err)
+	}
+	w.seq = seq
+	w.file = file
+	w.writer = bufio.NewWriter(file)
+	w.opened = time.Now()
+	w.maxTs = 0
+	walSegments.Inc()
+	return nil
+}
+
+// sync flushes the current segment and syncs it to disk
+// caller must hold the lock
+func (w *wal) sync() error {
+	if !w.dirty {
+		return nil
+	}
+	if err := w.writer.Flush(); err != nil {
+		return err
+	}
+	if err := w.file.Sync(); err != nil {
+		return err
+	}
+	w.dirty = false
+	return nil
+}
+
+// rotate closes the current segment and starts a new one
+// caller must hold the lock
+func (w *wal) rotate() error {
+	if err := w.sync(); err != nil {
+		return err
+	}
+	w.file.Close()
+	w.closed = append(w.closed, walSegment{seq: w.seq, maxTs: w.maxTs})
+	return w.openSegment(w.seq + 1)
+}
+
+// write appends the record for a point with the given timestamp to the current segment
+func (w *wal) write(record []byte, ts uint32) {
+	w.Lock()
+	if w.stopped {
+		// input plugins that did not stop in time
+		w.Unlock()
+		walWriteFail.Inc()
+		return
+	}
+	_, err := w.writer.Write(record)
+	if err == nil {
+		w.dirty = true
+		if ts > w.maxTs {
+			w.maxTs = ts
+		}
+	}
+	w.Unlock()
+	if err != nil {
+		walWriteFail.Inc()
+		log.Errorf("input: failed to write to write-ahead log: %s", err)
+		return
+	}
+	walWriteOk.Inc()
+}
+
+func (w *wal) writeMetricData(md *schema.MetricData, partition int32) {
+	payload, err := md.MarshalMsg(nil)
+	if err != nil {
+		walWriteFail.Inc()
+		log.Errorf("input: failed to encode metricdata %s for write-ahead log: %s", md.Id, err)
+		return
+	}
+	w.write(encodeWalRecord(walOpMetricData, partition, payload), uint32(md.Time))
+}
+
+func (w *wal) writeMetricPoint(point schema.MetricPoint, partition int32) {
+	payload, _ := point.Marshal(nil)
+	w.write(encodeWalRecord(walOpMetricPoint, partition, payload), point.Time)
+}
+
+// checkpoint deletes all closed segments of which all points have been persisted
+func (w *wal) checkpoint() {
+	persistedUntil := w.metrics.PersistedUntil()
+	w.Lock()
+	var keep []walSegment
+	var remove []walSegment
+	for _, s := range w.closed {
+		if s.maxTs < persistedUntil {
+			remove = append(remove, s)
+		} else {
+			keep = append(keep, s)
+		}
+	}
+	w.closed = keep
+	w.Unlock()
+
+	for _, s := range remove {
+		path := segmentName(w.dir, s.seq)
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			log.Errorf("input: failed to delete write-ahead log segment %s: %s", path, err)
+			continue
+		}
+		walSegments.Dec()
+	}
+	if len(remove) > 0 {
+		log.Debugf("input: deleted %d write-ahead log segments. all points before %d have been persisted", len(remove), persistedUntil)
+	}
+}
+
+func (w *wal) run() {
+	defer w.wg.Done()
+	syncTicker := time.NewTicker(walSyncInterval)
+	defer syncTicker.Stop()
+	checkpointTicker := time.NewTicker(walCheckpointInterval)
+	defer checkpointTicker.Stop()
+	for {
+		select {
+		case <-syncTicker.C:
+			w.Lock()
+			var err error
+			if w.maxTs > 0 && time.Since(w.opened) >= walSegmentDuration {
+				err = w.rotate()
+			} else {
+				err = w.sync()
+			}
+			w.Unlock()
+			if err != nil {
+				log.Errorf("input: failed to sync write-ahead log: %s", err)
+			}
+		case <-checkpointTicker.C:
+			w.checkpoint()
+		case <-w.shutdown:
+			return
+		}
+	}
+}
@@ -0,0 +1,147 @@
+package input
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/grafana/metrictank/cluster"
+	"github.com/grafana/metrictank/conf"
+	"github.com/grafana/metrictank/idx/memory"
+	"github.com/grafana/metrictank/mdata"
+	"github.com/grafana/metrictank/mdata/cache"
+	"github.com/grafana/metrictank/schema"
+	"github.com/grafana/metrictank/schema/msg"
+	backendStore "github.com/grafana/metrictank/store"
+)
+
+func setupWAL(t *testing.T) func() {
+	t.Helper()
+	dir, err := ioutil.TempDir("", "input-wal")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cluster.Init("default", "test", time.Now(), "http", 6060)
+	mdata.SetSingleSchema(conf.MustParseRetentions("10s:10000s:10min:10:true"))
+	mdata.SetSingleAgg(conf.Avg)
+
+	oldEnabled, oldDir := walEnabled, walDir
+	walEnabled = true
+	walDir = dir
+	walSegmentDuration = time.Hour
+	walSyncInterval = time.Hour
+	walCheckpointInterval = time.Hour
+	return func() {
+		walEnabled, walDir = oldEnabled, oldDir
+		os.RemoveAll(dir)
+	}
+}
+
+func newWALTestNode(t *testing.T) (*mdata.AggMetrics, DefaultHandler, func()) {
+	t.Helper()
+	metrics := mdata.NewAggMetrics(backendStore.NewDevnullStore(), &cache.MockCache{}, false, nil, 3600, 7200, 0)
+	index := memory.New()
+	index.Init()
+	if err := StartWAL(metrics, index); err != nil {
+		t.Fatalf("failed to start wal: %s", err)
+	}
+	return metrics, NewDefaultHandler(metrics, index, "test"), func() {
+		StopWAL()
+		index.Stop()
+	}
+}
+
+func TestWALReplay(t *testing.T) {
+	defer setupWAL(t)()
+
+	_, handler, stop := newWALTestNode(t)
+	md := getTestMetricData()
+	md.Interval = 10
+	md.SetId()
+	mkey, _ := schema.MKeyFromString(md.Id)
+	for ts := int64(10); ts <= 100; ts += 10 {
+		md.Time = ts
+		md.Value = float64(ts)
+		handler.ProcessMetricData(&md, 0)
+	}
+	for ts := uint32(110); ts <= 200; ts += 10 {
+		handler.ProcessMetricPoint(schema.MetricPoint{MKey: mkey, Time: ts, Value: float64(ts)}, msg.FormatMetricPoint, 0)
+	}
+	stop()
+
+	// a fresh node, with an empty index and no data, should get everything back from the wal
+	metrics, _, stop := newWALTestNode(t)
+	defer stop()
+	m, ok := metrics.Get(mkey)
+	if !ok {
+		t.Fatalf("expected metric %s to be restored", mkey)
+	}
+	res, err := m.Get(0, 1000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var points []schema.Point
+	for _, iter := range res.Iters {
+		for iter.Next() {
+			ts, val := iter.Values()
+			points = append(points, schema.Point{Val: val, Ts: ts})
+		}
+	}
+	if len(points) != 20 {
+		t.Fatalf("expected 20 points to be restored, got %d: %v", len(points), points)
+	}
+	for i, p := range points {
+		exp := uint32(i+1) * 10
+		if p.Ts != exp || p.Val != float64(exp) {
+			t.Fatalf("point %d: expected ts and value %d, got %v", i, exp, p)
+		}
+	}
+}
+
+func TestWALCheckpoint(t *testing.T) {
+	defer setupWAL(t)()
+
+	metrics, handler, stop := newWALTestNode(t)
+	defer stop()
+	md := getTestMetricData()
+	md.Interval = 10
+	md.SetId()
+	mkey, _ := schema.MKeyFromString(md.Id)
+	for ts := int64(10); ts <= 1200; ts += 10 {
+		md.Time = ts
+		handler.ProcessMetricData(&md, 0)
+	}
+	journal.Lock()
+	if err := journal.rotate(); err != nil {
+		t.Fatal(err)
+	}
+	journal.Unlock()
+
+	segments := func() int {
+		seqs, err := listSegments(walDir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return len(seqs)
+	}
+	if n := segments(); n != 2 {
+		t.Fatalf("expected 2 segments, got %d", n)
+	}
+
+	m, _ := metrics.Get(mkey)
+	am := m.(*mdata.AggMetric)
+
+	// the segment has a point at 1200, which is in the chunk that is still being written to
+	am.SyncChunkSaveState(600, false)()
+	journal.checkpoint()
+	if n := segments(); n != 2 {
+		t.Fatalf("expected 2 segments after partial persist, got %d", n)
+	}
+
+	am.SyncChunkSaveState(1200, false)()
+	journal.checkpoint()
+	if n := segments(); n != 1 {
+		t.Fatalf("expected 1 segment after full persist, got %d", n)
+	}
+}
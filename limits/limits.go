@@ -0,0 +1,260 @@
+// Package limits enforces the per-org limits defined in the limits file
+package limits
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/grafana/globalconf"
+	"github.com/grafana/metrictank/conf"
+	"github.com/grafana/metrictank/stats"
+	log "github.com/sirupsen/logrus"
+	"golang.org/x/time/rate"
+)
+
+var (
+	limitsFile     string
+	reloadInterval time.Duration
+
+	current  atomic.Value // conf.Limits
+	modTime  time.Time
+	orgsLock sync.Mutex
+	orgs     = make(map[uint32]*orgState)
+)
+
+func init() {
+	current.Store(conf.NewLimits())
+}
+
+func ConfigSetup() {
+	limitsCfg := flag.NewFlagSet("limits", flag.ExitOnError)
+	limitsCfg.StringVar(&limitsFile, "file", "", "path to the file defining the per-org limits. empty disables all limits")
+	limitsCfg.DurationVar(&reloadInterval, "reload-interval", time.Minute, "how often to check the limits file for changes and refresh the active series counts. 0 disables reloading")
+	globalconf.Register("limits", limitsCfg, flag.ExitOnError)
+}
+
+func ConfigProcess() {
+	if limitsFile == "" {
+		return
+	}
+	info, err := os.Stat(limitsFile)
+	if err != nil {
+		log.Fatalf("limits: can't read limits file %q: %s", limitsFile, err.Error())
+	}
+	l, err := conf.ReadLimits(limitsFile)
+	if err != nil {
+		log.Fatalf("limits: can't parse limits file %q: %s", limitsFile, err.Error())
+	}
+	modTime = info.ModTime()
+	Set(l)
+}
+
+// SeriesCounter returns the number of series in the index for the given org
+type SeriesCounter func(orgId uint32) int
+
+// Start periodically reloads the limits file if it changed, and refreshes the
+// active series counts of all orgs we track, using counter
+func Start(counter SeriesCounter) {
+	if limitsFile == "" {
+		return
+	}
+	setCounter(counter)
+	if reloadInterval == 0 {
+		return
+	}
+	go func() {
+		ticker := time.NewTicker(reloadInterval)
+		for range ticker.C {
+			reload()
+			refresh()
+		}
+	}()
+}
+
+// reload reads the limits file if it was modified since we last read it.
+// if the new file is invalid, we keep using the old limits
+func reload() {
+	info, err := os.Stat(limitsFile)
+	if err != nil {
+		log.Errorf("limits: can't read limits file %q, keeping current limits: %s", limitsFile, err.Error())
+		return
+	}
+	if !info.ModTime().After(modTime) {
+		return
+	}
+	l, err := conf.ReadLimits(limitsFile)
+	if err != nil {
+		log.Errorf("limits: can't parse limits file %q, keeping current limits: %s", limitsFile, err.Error())
+		return
+	}
+	modTime = info.ModTime()
+	Set(l)
+	log.Infof("limits: reloaded limits file %q", limitsFile)
+}
+
+// Get returns the limit for the given org
+func Get(orgId uint32) conf.Limit {
+	return current.Load().(conf.Limits).Get(orgId)
+}
+
+// Set replaces the current limits
+func Set(l conf.Limits) {
+	current.Store(l)
+}
+
+var counterLock sync.Mutex
+var seriesCounter SeriesCounter
+
+func setCounter(counter SeriesCounter) {
+	counterLock.Lock()
+	seriesCounter = counter
+	counterLock.Unlock()
+}
+
+func countSeries(orgId uint32) (int, bool) {
+	counterLock.Lock()
+	counter := seriesCounter
+	counterLock.Unlock()
+	if counter == nil {
+		return 0, false
+	}
+	return counter(orgId), true
+}
+
+// orgState tracks the usage of an org, for the limits that need state.
+// it is only created for orgs that have such a limit, to bound the number of per-org stats
+type orgState struct {
+	sync.Mutex
+	limiter       *rate.Limiter
+	limiterRate   int // the rate the limiter was created with
+	activeSeries  int
+	seriesCounted bool // whether activeSeries has been initialized from the index
+	queries       int32
+
+	rejectedActiveSeries      *stats.Counter32
+	rejectedIngestRate        *stats.Counter32
+	rejectedSeriesPerReq      *stats.Counter32
+	rejectedConcurrentQueries *stats.Counter32
+}
+
+func getOrgState(orgId uint32) *orgState {
+	orgsLock.Lock()
+	defer orgsLock.Unlock()
+	s, ok := orgs[orgId]
+	if ok {
+		return s
+	}
+	s = &orgState{
+		// metric limits.org.%d.rejected.active_series is the count of new series rejected because the org reached its max-active-series limit
+		rejectedActiveSeries: stats.NewCounter32(fmt.Sprintf("limits.org.%d.rejected.active_series", orgId)),
+		// metric limits.org.%d.rejected.ingest_rate is the count of datapoints rejected because the org exceeded its max-ingest-rate limit
+		rejectedIngestRate: stats.NewCounter32(fmt.Sprintf("limits.org.%d.rejected.ingest_rate", orgId)),
+		// metric limits.org.%d.rejected.series_per_req is the count of requests rejected because they exceeded the org's max-series-per-req limit
+		rejectedSeriesPerReq: stats.NewCounter32(fmt.Sprintf("limits.org.%d.rejected.series_per_req", orgId)),
+		// metric limits.org.%d.rejected.concurrent_queries is the count of queries rejected because the org reached its max-concurrent-queries limit
+		rejectedConcurrentQueries: stats.NewCounter32(fmt.Sprintf("limits.org.%d.rejected.concurrent_queries", orgId)),
+	}
+	orgs[orgId] = s
+	return s
+}
+
+// refresh resyncs the active series counts of all orgs with the index,
+// to account for series that were deleted or pruned
+func refresh() {
+	orgsLock.Lock()
+	ids := make([]uint32, 0, len(orgs))
+	for id := range orgs {
+		ids = append(ids, id)
+	}
+	orgsLock.Unlock()
+
+	for _, id := range ids {
+		count, ok := countSeries(id)
+		if !ok {
+			return
+		}
+		s := getOrgState(id)
+		s.Lock()
+		s.activeSeries = count
+		s.seriesCounted = true
+		s.Unlock()
+	}
+}
+
+// AllowIngest returns whether the org may ingest another datapoint
+// under its max-ingest-rate limit
+func AllowIngest(orgId uint32) bool {
+	limit := Get(orgId).MaxIngestRate
+	if limit == 0 {
+		return true
+	}
+	s := getOrgState(orgId)
+	s.Lock()
+	if s.limiter == nil || s.limiterRate != limit {
+		s.limiter = rate.NewLimiter(rate.Limit(limit), limit)
+		s.limiterRate = limit
+	}
+	limiter := s.limiter
+	s.Unlock()
+	if limiter.Allow() {
+		return true
+	}
+	s.rejectedIngestRate.Inc()
+	return false
+}
+
+// AllowNewSeries returns whether the org may add a new series to the index
+// under its max-active-series limit. if so, the series is accounted for.
+func AllowNewSeries(orgId uint32) bool {
+	limit := Get(orgId).MaxActiveSeries
+	if limit == 0 {
+		return true
+	}
+	s := getOrgState(orgId)
+	s.Lock()
+	defer s.Unlock()
+	if !s.seriesCounted {
+		s.activeSeries, s.seriesCounted = countSeries(orgId)
+	}
+	if s.activeSeries >= limit {
+		s.rejectedActiveSeries.Inc()
+		return false
+	}
+	s.activeSeries++
+	return true
+}
+
+// AllowSeriesPerReq returns whether a request of the org may operate on the given
+// number of series under its max-series-per-req limit
+func AllowSeriesPerReq(orgId uint32, series int) bool {
+	limit := Get(orgId).MaxSeriesPerReq
+	if limit == 0 || series <= limit {
+		return true
+	}
+	getOrgState(orgId).rejectedSeriesPerReq.Inc()
+	return false
+}
+
+// AcquireQuery returns whether the org may execute another query under its
+// max-concurrent-queries limit. if so, the caller must call the returned release function once the query completes.
+func AcquireQuery(orgId uint32) (func(), bool) {
+	limit := Get(orgId).MaxConcurrentQueries
+	if limit == 0 {
+		return noopRelease, true
+	}
+	s := getOrgState(orgId)
+	if atomic.AddInt32(&s.queries, 1) > int32(limit) {
+		atomic.AddInt32(&s.queries, -1)
+		s.rejectedConcurrentQueries.Inc()
+		return nil, false
+	}
+	return func() {
+		atomic.AddInt32(&s.queries, -1)
+	}, true
+}
+
+func noopRelease() {}
@@ -0,0 +1,118 @@
+package limits
+
+import (
+	"testing"
+
+	"github.com/grafana/metrictank/conf"
+)
+
+func reset(l conf.Limits, counter SeriesCounter) {
+	Set(l)
+	orgsLock.Lock()
+	orgs = make(map[uint32]*orgState)
+	orgsLock.Unlock()
+	setCounter(counter)
+}
+
+func TestAllowNewSeries(t *testing.T) {
+	l := conf.NewLimits()
+	l.Default.MaxActiveSeries = 5
+	l.Orgs[2] = conf.Limit{MaxActiveSeries: 0}
+	reset(l, func(orgId uint32) int { return 3 })
+
+	for i := 0; i < 2; i++ {
+		if !AllowNewSeries(1) {
+			t.Fatalf("new series %d of org 1 should have been allowed", i)
+		}
+	}
+	if AllowNewSeries(1) {
+		t.Fatalf("new series of org 1 should have been rejected")
+	}
+	for i := 0; i < 10; i++ {
+		if !AllowNewSeries(2) {
+			t.Fatalf("new series of org 2 should have been allowed: it has no limit")
+		}
+	}
+
+	// after refreshing from the index, we should be allowed again
+	setCounter(func(orgId uint32) int { return 1 })
+	refresh()
+	if !AllowNewSeries(1) {
+		t.Fatalf("new series of org 1 should have been allowed after refresh")
+	}
+}
+
+func TestAllowIngest(t *testing.T) {
+	l := conf.NewLimits()
+	l.Orgs[1] = conf.Limit{MaxIngestRate: 10}
+	reset(l, nil)
+
+	for i := 0; i < 10; i++ {
+		if !AllowIngest(1) {
+			t.Fatalf("point %d of org 1 should have been allowed", i)
+		}
+	}
+	if AllowIngest(1) {
+		t.Fatalf("point of org 1 should have been rejected")
+	}
+	for i := 0; i < 100; i++ {
+		if !AllowIngest(2) {
+			t.Fatalf("point of org 2 should have been allowed: it has no limit")
+		}
+	}
+}
+
+func TestAllowSeriesPerReq(t *testing.T) {
+	l := conf.NewLimits()
+	l.Default.MaxSeriesPerReq = 100
+	reset(l, nil)
+
+	if !AllowSeriesPerReq(1, 100) {
+		t.Fatalf("request for 100 series should have been allowed")
+	}
+	if AllowSeriesPerReq(1, 101) {
+		t.Fatalf("request for 101 series should have been rejected")
+	}
+}
+
+func TestAcquireQuery(t *testing.T) {
+	l := conf.NewLimits()
+	l.Default.MaxConcurrentQueries = 2
+	reset(l, nil)
+
+	release, ok := AcquireQuery(1)
+	if _, ok2 := AcquireQuery(1); !ok || !ok2 {
+		t.Fatalf("first 2 queries should have been allowed")
+	}
+	if _, ok := AcquireQuery(1); ok {
+		t.Fatalf("third query should have been rejected")
+	}
+	if _, ok := AcquireQuery(2); !ok {
+		t.Fatalf("query of another org should have been allowed")
+	}
+	release()
+	if _, ok := AcquireQuery(1); !ok {
+		t.Fatalf("query should have been allowed after a release")
+	}
+}
+
+func TestNoStateWithoutLimits(t *testing.T) {
+	l := conf.NewLimits()
+	l.Orgs[1] = conf.Limit{MaxIngestRate: 10}
+	reset(l, func(orgId uint32) int { return 0 })
+
+	for orgId := uint32(2); orgId < 10; orgId++ {
+		AllowIngest(orgId)
+		AllowNewSeries(orgId)
+		AllowSeriesPerReq(orgId, 1000)
+		release, _ := AcquireQuery(orgId)
+		release()
+	}
+	AllowIngest(1)
+
+	orgsLock.Lock()
+	defer orgsLock.Unlock()
+	if len(orgs) != 1 || orgs[1] == nil {
+		t.Fatalf("expected only org 1, which has a limit, to have state. got %d orgs", len(orgs))
+	}
+}
@@ -42,17 +42,25 @@ type AggMetric struct {
 	dropFirstChunk  bool
 	ingestFromT0    uint32
 	ttl             uint32
+	chunkFormat     chunk.Format // format of new chunks
 	lastSaveStart   uint32 // last chunk T0 that was added to the write Queue.
 	lastSaveFinish  uint32 // last chunk T0 successfully written to Cassandra.
 	lastWrite       uint32 // wall clock time of when last point was successfully added (possibly to the ROB)
 	firstTs         uint32 // timestamp of first point seen
+
+	// used to switch over to new settings after the rules were reloaded. see AggMetrics.reconfigure
+	settings aggMetricSettings // the settings this AggMetric was created with
+	gen      uint32            // generation of the rules the settings were last checked against. accessed atomically
+	next     *AggMetric        // AggMetric with the new settings, that takes over at the next chunk boundary
+	nextGen  uint32            // generation of the rules next was created for
+	switched bool              // whether next has taken over
 }
 
 // NewAggMetric creates a metric with given key, it retains the given number of chunks each chunkSpan seconds long
 // it optionally also creates aggregations with the given settings
 // the 0th retention is the native archive of this metric. if there's several others, we create aggregators, using agg.
 // it's the callers responsibility to make sure agg is not nil in that case!
-func NewAggMetric(store Store, cachePusher cache.CachePusher, key schema.AMKey, retentions conf.Retentions, reorderWindow, interval uint32, agg *conf.Aggregation, dropFirstChunk bool, ingestFrom int64) *AggMetric {
+func NewAggMetric(store Store, cachePusher cache.CachePusher, key schema.AMKey, retentions conf.Retentions, reorderWindow, interval uint32, chunkFormat chunk.Format, agg *conf.Aggregation, dropFirstChunk bool, ingestFrom int64) *AggMetric {
 
 	// note: during parsing of retentions, we assure there's at least 1.
 	ret := retentions.Rets[0]
@@ -66,6 +74,7 @@ func NewAggMetric(store Store, cachePusher cache.CachePusher, key schema.AMKey,
 		chunks:         make([]*chunk.Chunk, 0, ret.NumChunks),
 		dropFirstChunk: dropFirstChunk,
 		ttl:            uint32(ret.MaxRetention()),
+		chunkFormat:    chunkFormat,
 		// we set LastWrite here to make sure a new Chunk doesn't get immediately
 		// garbage collected right after creating it, before we can push to it.
 		lastWrite: uint32(time.Now().Unix()),
@@ -81,7 +90,7 @@ func NewAggMetric(store Store, cachePusher cache.CachePusher, key schema.AMKey,
 	origSplits := strings.Split(retentions.Orig, ":")
 	for i, ret := range retentions.Rets[1:] {
 		retOrig := origSplits[i+1]
-		m.aggregators = append(m.aggregators, NewAggregator(store, cachePusher, key, retOrig, ret, *agg, dropFirstChunk, ingestFrom))
+		m.aggregators = append(m.aggregators, NewAggregator(store, cachePusher, key, retOrig, ret, chunkFormat, *agg, dropFirstChunk, ingestFrom))
 	}
 
 	return &m
@@ -105,6 +114,69 @@ func (a *AggMetric) SyncChunkSaveState(ts uint32, sendPersist bool) ChunkSaveCal
 	}
 }
 
+// PersistedUntil returns the timestamp before which all points added to this AggMetric, and to its rollups,
+// have been persisted, based on the save state of the chunks. math.MaxUint32 means everything has been persisted.
+func (a *AggMetric) PersistedUntil() uint32 {
+	a.RLock()
+	defer a.RUnlock()
+	until := a.persistedUntil()
+	for _, agg := range a.aggregators {
+		if agg.agg.Cnt != 0 {
+			// the in-progress aggregation covers the points after the previous boundary
+			if b := agg.currentBoundary - agg.span + 1; b < until {
+				until = b
+			}
+		}
+		for _, m := range []*AggMetric{agg.minMetric, agg.maxMetric, agg.sumMetric, agg.cntMetric, agg.lstMetric} {
+			if m == nil {
+				continue
+			}
+			aggUntil := m.PersistedUntil()
+			if aggUntil == math.MaxUint32 {
+				continue
+			}
+			// a rollup point with timestamp ts covers the raw points in (ts-span, ts]
+			if aggUntil > agg.span {
+				aggUntil -= agg.span
+			} else {
+				aggUntil = 0
+			}
+			if aggUntil < until {
+				until = aggUntil
+			}
+		}
+	}
+	return until
+}
+
+// persistedUntil returns the timestamp before which all raw points have been persisted.
+// caller must hold lock
+func (a *AggMetric) persistedUntil() uint32 {
+	until := uint32(math.MaxUint32)
+	if a.rob != nil && !a.rob.IsEmpty() {
+		until = a.rob.Get()[0].Ts
+	}
+	if len(a.chunks) == 0 {
+		return until
+	}
+	currentChunk := a.chunks[a.currentChunkPos]
+	if a.lastSaveFinish >= currentChunk.Series.T0 {
+		return until
+	}
+	// points older than the oldest chunk are either persisted, or no longer in memory anyway
+	oldest := a.chunks[0].Series.T0
+	if len(a.chunks) == int(a.numChunks) {
+		oldest = a.chunks[(a.currentChunkPos+1)%len(a.chunks)].Series.T0
+	}
+	if a.lastSaveFinish > 0 && a.lastSaveFinish+a.chunkSpan > oldest {
+		oldest = a.lastSaveFinish + a.chunkSpan
+	}
+	if oldest < until {
+		until = oldest
+	}
+	return until
+}
+
 // Sync the saved state of a chunk by its T0.
 func (a *AggMetric) SyncAggregatedChunkSaveState(ts uint32, consolidator consolidation.Consolidator, aggSpan uint32) {
 	// no lock needed cause aggregators don't change at runtime
@@ -148,6 +220,13 @@ func (a *AggMetric) SyncAggregatedChunkSaveState(ts uint32, consolidator consoli
 }
 
 func (a *AggMetric) GetAggregated(consolidator consolidation.Consolidator, aggSpan, from, to uint32) (Result, error) {
+	a.RLock()
+	next, switched := a.next, a.switched
+	a.RUnlock()
+	if switched {
+		return next.GetAggregated(consolidator, aggSpan, from, to)
+	}
+
 	// no lock needed cause aggregators don't change at runtime
 	for _, a := range a.aggregators {
 		if a.span == aggSpan {
@@ -185,6 +264,10 @@ func (a *AggMetric) GetAggregated(consolidator consolidation.Consolidator, aggSp
 			return agg.Get(from, to)
 		}
 	}
+	if next != nil {
+		// the rollup is part of the new settings, which we haven't switched over to yet
+		return next.GetAggregated(consolidator, aggSpan, from, to)
+	}
 	err := fmt.Errorf("internal error: AggMetric.GetAggregated(): unknown aggSpan %d", aggSpan)
 	log.Errorf("AM: %s", err.Error())
 	badAggSpan.Inc()
@@ -204,6 +287,10 @@ func (a *AggMetric) Get(from, to uint32) (Result, error) {
 		return Result{}, ErrInvalidRange
 	}
 	a.RLock()
+	if a.switched {
+		a.RUnlock()
+		return a.next.Get(from, to)
+	}
 	defer a.RUnlock()
 
 	result := Result{
@@ -447,6 +534,11 @@ func (a *AggMetric) Add(ts uint32, val float64) {
 	a.Lock()
 	defer a.Unlock()
 
+	if a.next != nil && (a.switched || a.switchOver(ts)) {
+		a.next.Add(ts, val)
+		return
+	}
+
 	if a.rob == nil {
 		// write directly
 		a.add(ts, val)
@@ -479,7 +571,9 @@ func (a *AggMetric) add(ts uint32, val float64) {
 		// no data has been added to this AggMetric yet.
 		// note that we may not be aware of prior data that belongs into this chunk
 		// so we should track this cutoff point
-		a.chunks = append(a.chunks, chunk.NewFirst(t0))
+		c := chunk.NewFormat(t0, a.chunkFormat)
+		c.First = true
+		a.chunks = append(a.chunks, c)
 		a.firstTs = ts
 
 		if err := a.chunks[0].Push(ts, val); err != nil {
@@ -546,7 +640,7 @@ func (a *AggMetric) add(ts uint32, val float64) {
 
 		chunkCreate.Inc()
 		if len(a.chunks) < int(a.numChunks) {
-			a.chunks = append(a.chunks, chunk.New(t0))
+			a.chunks = append(a.chunks, chunk.NewFormat(t0, a.chunkFormat))
 			if err := a.chunks[a.currentChunkPos].Push(ts, val); err != nil {
 				panic(fmt.Sprintf("FATAL ERROR: this should never happen. Pushing initial value <%d,%f> to new chunk at pos %d failed: %q", ts, val, a.currentChunkPos, err))
 			}
@@ -556,7 +650,7 @@ func (a *AggMetric) add(ts uint32, val float64) {
 			chunkClear.Inc()
 			totalPoints.DecUint64(uint64(a.chunks[a.currentChunkPos].NumPoints))
 
-			a.chunks[a.currentChunkPos] = chunk.New(t0)
+			a.chunks[a.currentChunkPos] = chunk.NewFormat(t0, a.chunkFormat)
 			if err := a.chunks[a.currentChunkPos].Push(ts, val); err != nil {
 				panic(fmt.Sprintf("FATAL ERROR: this should never happen. Pushing initial value <%d,%f> to new chunk at pos %d failed: %q", ts, val, a.currentChunkPos, err))
 			}
@@ -569,6 +663,102 @@ func (a *AggMetric) add(ts uint32, val float64) {
 	a.addAggregators(ts, val)
 }
 
+// switchOver returns whether the AggMetric switches over to next, which has the new settings, as of the point with the given ts.
+// it does so once the point goes into chunks, in the new settings, that start after all chunks of the current settings
+// that are still being written to, so that no chunks of the current settings get overwritten in the store.
+// when switching over, the current chunks are closed and persisted, and in-progress aggregations are handed over to next.
+// caller must hold lock
+func (a *AggMetric) switchOver(ts uint32) bool {
+	if !a.canSwitchOver(ts) {
+		return false
+	}
+	if a.rob != nil {
+		// the buffered points go into the current chunks first, which may start new chunks
+		for _, p := range a.rob.Flush() {
+			a.add(p.Ts, p.Val)
+		}
+		if !a.canSwitchOver(ts) {
+			return false
+		}
+	}
+
+	a.finishCurrentChunk()
+	for _, agg := range a.aggregators {
+		agg.handOver(ts, a.next.aggregator(agg.span))
+	}
+	a.switched = true
+	return true
+}
+
+// canSwitchOver returns whether the chunks of next that the point with the given ts goes into,
+// start after the chunks that are being written to.
+// caller must hold lock
+func (a *AggMetric) canSwitchOver(ts uint32) bool {
+	if len(a.chunks) > 0 && ts-ts%a.next.chunkSpan <= a.chunks[a.currentChunkPos].Series.T0 {
+		return false
+	}
+	for _, agg := range a.aggregators {
+		if next := a.next.aggregator(agg.span); next != nil && !agg.canHandOver(ts, next) {
+			return false
+		}
+	}
+	return true
+}
+
+// aggregator returns the aggregator with the given span, if any
+func (a *AggMetric) aggregator(span uint32) *Aggregator {
+	for _, agg := range a.aggregators {
+		if agg.span == span {
+			return agg
+		}
+	}
+	return nil
+}
+
+// currentT0 returns the t0 of the chunk being written to, or 0 if there is none
+func (a *AggMetric) currentT0() uint32 {
+	a.RLock()
+	defer a.RUnlock()
+	if len(a.chunks) == 0 {
+		return 0
+	}
+	return a.chunks[a.currentChunkPos].Series.T0
+}
+
+// finishCurrentChunk closes the chunk being written to, if it isn't yet. it pushes it to the cache, and persists it if we are a primary
+// caller must hold lock
+func (a *AggMetric) finishCurrentChunk() {
+	if len(a.chunks) == 0 {
+		return
+	}
+	currentChunk := a.chunks[a.currentChunkPos]
+	if currentChunk.Series.Finished {
+		return
+	}
+	currentChunk.Finish()
+	a.pushToCache(currentChunk)
+	if cluster.Manager.IsPrimary() {
+		a.persist(a.currentChunkPos)
+	}
+}
+
+// numPoints returns the number of points in the chunks of the AggMetric and of its rollups
+// caller must hold lock
+func (a *AggMetric) numPoints() uint32 {
+	var points uint32
+	for _, c := range a.chunks {
+		points += c.NumPoints
+	}
+	for _, agg := range a.aggregators {
+		for _, m := range agg.metrics() {
+			m.RLock()
+			points += m.numPoints()
+			m.RUnlock()
+		}
+	}
+	return points
+}
+
 // collectable returns whether the AggMetric is garbage collectable
 // an Aggmetric is collectable based on two conditions:
 // * the AggMetric hasn't been written to in a configurable amount of time
@@ -600,6 +790,12 @@ func (a *AggMetric) GC(now, chunkMinTs, metricMinTs uint32) (uint32, bool) {
 	a.Lock()
 	defer a.Unlock()
 
+	if a.switched {
+		// we are only still around because we haven't been replaced by next yet
+		points, stale := a.next.GC(now, chunkMinTs, metricMinTs)
+		return points + a.numPoints(), stale
+	}
+
 	// unless it looks like the AggMetric is collectable, abort and mark as not stale
 	if !a.collectable(now, chunkMinTs) {
 		return 0, false
@@ -13,6 +13,7 @@ import (
 	"github.com/grafana/metrictank/cluster"
 	"github.com/grafana/metrictank/conf"
 	"github.com/grafana/metrictank/mdata/cache"
+	"github.com/grafana/metrictank/mdata/chunk"
 	"github.com/grafana/metrictank/mdata/chunk/tsz"
 	"github.com/grafana/metrictank/test"
 )
@@ -132,7 +133,7 @@ func testMetricPersistOptionalPrimary(t *testing.T, primary bool) {
 
 	chunkAddCount, chunkSpan := uint32(10), uint32(300)
 	rets := conf.MustParseRetentions("1s:1s:5min:5:true")
-	agg := NewAggMetric(mockstore, &mockCache, test.GetAMKey(42), rets, 0, chunkSpan, nil, false, 0)
+	agg := NewAggMetric(mockstore, &mockCache, test.GetAMKey(42), rets, 0, chunkSpan, chunk.FormatGoTszLongWithSpan, nil, false, 0)
 
 	for ts := chunkSpan; ts <= chunkSpan*chunkAddCount; ts += chunkSpan {
 		agg.Add(ts, 1)
@@ -168,7 +169,7 @@ func TestAggMetric(t *testing.T) {
 	cluster.Init("default", "test", time.Now(), "http", 6060)
 
 	ret := conf.MustParseRetentions("1s:1s:2min:5:true")
-	c := NewChecker(t, NewAggMetric(mockstore, &cache.MockCache{}, test.GetAMKey(42), ret, 0, 1, nil, false, 0))
+	c := NewChecker(t, NewAggMetric(mockstore, &cache.MockCache{}, test.GetAMKey(42), ret, 0, 1, chunk.FormatGoTszLongWithSpan, nil, false, 0))
 
 	// chunk t0's: 120, 240, 360, 480, 600, 720, 840, 960
 
@@ -246,7 +247,7 @@ func TestAggMetricWithReorderBuffer(t *testing.T) {
 		AggregationMethod: []conf.Method{conf.Avg},
 	}
 	ret := conf.MustParseRetentions("1s:1s:2min:5:true")
-	c := NewChecker(t, NewAggMetric(mockstore, &cache.MockCache{}, test.GetAMKey(42), ret, 10, 1, &agg, false, 0))
+	c := NewChecker(t, NewAggMetric(mockstore, &cache.MockCache{}, test.GetAMKey(42), ret, 10, 1, chunk.FormatGoTszLongWithSpan, &agg, false, 0))
 
 	// basic adds and verifies with test data
 	c.Add(121, 121)
@@ -284,7 +285,7 @@ func TestAggMetricDropFirstChunk(t *testing.T) {
 	cluster.Manager.SetPrimary(true)
 	mockstore.Reset()
 	rets := conf.MustParseRetentions("1s:1s:10s:5:true")
-	m := NewAggMetric(mockstore, &cache.MockCache{}, test.GetAMKey(42), rets, 0, 1, nil, true, 0)
+	m := NewAggMetric(mockstore, &cache.MockCache{}, test.GetAMKey(42), rets, 0, 1, chunk.FormatGoTszLongWithSpan, nil, true, 0)
 	m.Add(10, 10)
 	m.Add(11, 11)
 	m.Add(12, 12)
@@ -312,7 +313,7 @@ func TestAggMetricIngestFrom(t *testing.T) {
 	mockstore.Reset()
 	ingestFrom := int64(25)
 	ret := conf.MustParseRetentions("1s:1s:10s:5:true")
-	m := NewAggMetric(mockstore, &cache.MockCache{}, test.GetAMKey(42), ret, 0, 1, nil, false, ingestFrom)
+	m := NewAggMetric(mockstore, &cache.MockCache{}, test.GetAMKey(42), ret, 0, 1, chunk.FormatGoTszLongWithSpan, nil, false, ingestFrom)
 	m.Add(10, 10)
 	m.Add(11, 11)
 	m.Add(12, 12)
@@ -373,7 +374,7 @@ func TestGetAggregated(t *testing.T) {
 		AggregationMethod: []conf.Method{conf.Sum},
 	}
 
-	m := NewAggMetric(mockstore, &cache.MockCache{}, test.GetAMKey(42), ret, 0, 1, &agg, false, 0)
+	m := NewAggMetric(mockstore, &cache.MockCache{}, test.GetAMKey(42), ret, 0, 1, chunk.FormatGoTszLongWithSpan, &agg, false, 0)
 	m.Add(10, 10)
 	m.Add(11, 11)
 	m.Add(12, 12)
@@ -419,7 +420,7 @@ func TestGetAggregatedIngestFrom(t *testing.T) {
 		AggregationMethod: []conf.Method{conf.Sum},
 	}
 
-	m := NewAggMetric(mockstore, &cache.MockCache{}, test.GetAMKey(42), ret, 0, 1, &agg, false, ingestFrom)
+	m := NewAggMetric(mockstore, &cache.MockCache{}, test.GetAMKey(42), ret, 0, 1, chunk.FormatGoTszLongWithSpan, &agg, false, ingestFrom)
 	m.Add(10, 10)
 	m.Add(11, 11)
 	m.Add(12, 12)
@@ -448,6 +449,114 @@ func TestGetAggregatedIngestFrom(t *testing.T) {
 	assertPointsEqual(t, got, expected)
 }
 
+func TestAggMetricsReconfigure(t *testing.T) {
+	cluster.Init("default", "test", time.Now(), "http", 6060)
+	cluster.Manager.SetPrimary(true)
+	mockstore.Reset()
+	defer mockstore.Reset()
+	defer SetSingleSchema(conf.MustParseRetentions("1s:1d:1h:2"))
+
+	SetSingleSchema(conf.MustParseRetentions("10s:1d:10min:2"))
+	SetSingleAgg(conf.Avg)
+	ms := NewAggMetrics(mockstore, &cache.MockCache{}, false, nil, 0, 0, 0)
+	key := test.GetMKey(1)
+
+	m := ms.GetOrCreate(key, 0, 0, 10).(*AggMetric)
+	for ts := uint32(600); ts < 1200; ts += 10 {
+		m.Add(ts, float64(ts))
+	}
+
+	// reloading unchanged rules doesn't affect the AggMetric
+	ms.Reconfigure()
+	if got := ms.GetOrCreate(key, 0, 0, 10).(*AggMetric); got != m || got.next != nil {
+		t.Fatalf("expected the AggMetric to be unaffected by a reload of unchanged rules")
+	}
+
+	SetSingleSchema(conf.MustParseRetentions("10s:1d:30min:2"))
+	ms.Reconfigure()
+	reconfigured := metricsReconfigured.Peek()
+
+	// the new chunk of 1800s would start at 0, before our current chunk, so we keep writing to the old chunks.
+	for ts := uint32(1200); ts < 1800; ts += 10 {
+		got := ms.GetOrCreate(key, 0, 0, 10).(*AggMetric)
+		if got != m {
+			t.Fatalf("ts %d: expected the AggMetric not to be replaced yet", ts)
+		}
+		got.Add(ts, float64(ts))
+	}
+	if m.next == nil || m.switched {
+		t.Fatalf("expected the AggMetric to have new settings prepared, but not to have switched over")
+	}
+
+	// the new chunk starting at 1800 starts after our current chunk of 1200, so we switch over
+	m.Add(1800, 1800)
+	if !m.switched {
+		t.Fatalf("expected the AggMetric to have switched over")
+	}
+	if mockstore.Items() != 2 {
+		t.Fatalf("expected the chunks of 600 and 1200 to be persisted, got %d chunks", mockstore.Items())
+	}
+	next := ms.GetOrCreate(key, 0, 0, 10).(*AggMetric)
+	if next != m.next || next.chunkSpan != 1800 {
+		t.Fatalf("expected the AggMetric to be replaced by one with the new chunkspan")
+	}
+	if metricsReconfigured.Peek() != reconfigured+1 {
+		t.Fatalf("expected metricsReconfigured to be incremented")
+	}
+
+	next.Add(1810, 1810)
+	res, err := next.Get(0, 3600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertPointsEqual(t, itersToPoints(res.Iters), []schema.Point{{Val: 1800, Ts: 1800}, {Val: 1810, Ts: 1810}})
+}
+
+func TestAggMetricsReconfigureHandsOverAggregation(t *testing.T) {
+	cluster.Init("default", "test", time.Now(), "http", 6060)
+	cluster.Manager.SetPrimary(true)
+	mockstore.Reset()
+	defer mockstore.Reset()
+	defer SetSingleSchema(conf.MustParseRetentions("1s:1d:1h:2"))
+	defer SetSingleAgg(conf.Avg)
+
+	SetSingleSchema(conf.MustParseRetentions("10s:1d:10min:2,60s:2d:1h:2"))
+	SetSingleAgg(conf.Sum)
+	ms := NewAggMetrics(mockstore, &cache.MockCache{}, false, nil, 0, 0, 0)
+	key := test.GetMKey(1)
+
+	m := ms.GetOrCreate(key, 0, 0, 10).(*AggMetric)
+	for ts := uint32(3600); ts < 4800; ts += 10 {
+		m.Add(ts, 1)
+	}
+
+	// same rollup, but bigger raw chunks
+	SetSingleSchema(conf.MustParseRetentions("10s:1d:20min:2,60s:2d:1h:2"))
+	ms.Reconfigure()
+	ms.GetOrCreate(key, 0, 0, 10)
+
+	// the new raw chunk of 4800 starts after our current raw chunk of 4200,
+	// but the new rollup chunk would overwrite our current one of 3600
+	for ts := uint32(4800); ts < 7200; ts += 10 {
+		m.Add(ts, 1)
+	}
+	if m.switched {
+		t.Fatalf("expected the AggMetric not to have switched over before 7200")
+	}
+	m.Add(7200, 1)
+	if !m.switched {
+		t.Fatalf("expected the AggMetric to have switched over at 7200")
+	}
+	m.Add(7210, 1)
+
+	// the rollup of the new settings continued the in-progress aggregation of the bucket of 7200
+	res, err := m.GetAggregated(consolidation.Sum, 60, 7200, 7300)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertPointsEqual(t, itersToPoints(res.Iters), []schema.Point{{Val: 6, Ts: 7200}})
+}
+
 func BenchmarkAggMetricAdd(b *testing.B) {
 	mockstore.Reset()
 	mockstore.Drop = true
@@ -459,7 +568,7 @@ func BenchmarkAggMetricAdd(b *testing.B) {
 
 	// each chunk contains 180 points
 	rets := conf.MustParseRetentions("10s:1000000000s,30min:1")
-	metric := NewAggMetric(mockstore, &cache.MockCache{}, test.GetAMKey(0), rets, 0, 10, nil, false, 0)
+	metric := NewAggMetric(mockstore, &cache.MockCache{}, test.GetAMKey(0), rets, 0, 10, chunk.FormatGoTszLongWithSpan, nil, false, 0)
 
 	max := uint32(b.N*10 + 1)
 	for t := uint32(1); t < max; t += 10 {
@@ -1,11 +1,16 @@
 package mdata
 
 import (
+	"math"
+	"reflect"
 	"strconv"
 	"sync"
+	"sync/atomic"
 	"time"
 
+	"github.com/grafana/metrictank/conf"
 	"github.com/grafana/metrictank/mdata/cache"
+	"github.com/grafana/metrictank/mdata/chunk"
 	"github.com/grafana/metrictank/schema"
 	log "github.com/sirupsen/logrus"
 )
@@ -22,6 +27,7 @@ type AggMetrics struct {
 	chunkMaxStale  uint32
 	metricMaxStale uint32
 	gcInterval     time.Duration
+	gen            uint32 // generation of the rules, incremented when they are reloaded. accessed atomically
 
 	sync.RWMutex
 	Metrics map[uint32]map[schema.Key]*AggMetric
@@ -121,6 +127,26 @@ func (ms *AggMetrics) GC() {
 	}
 }
 
+// PersistedUntil returns the timestamp before which the points of all metrics have been persisted.
+// math.MaxUint32 means everything has been persisted.
+func (ms *AggMetrics) PersistedUntil() uint32 {
+	ms.RLock()
+	metrics := make([]*AggMetric, 0, len(ms.Metrics))
+	for _, m := range ms.Metrics {
+		for _, a := range m {
+			metrics = append(metrics, a)
+		}
+	}
+	ms.RUnlock()
+	until := uint32(math.MaxUint32)
+	for _, a := range metrics {
+		if u := a.PersistedUntil(); u < until {
+			until = u
+		}
+	}
+	return until
+}
+
 func (ms *AggMetrics) Get(key schema.MKey) (Metric, bool) {
 	var m *AggMetric
 	ms.RLock()
@@ -142,6 +168,9 @@ func (ms *AggMetrics) GetOrCreate(key schema.MKey, schemaId, aggId uint16, inter
 	}
 	ms.RUnlock()
 	if ok {
+		if atomic.LoadUint32(&m.gen) != atomic.LoadUint32(&ms.gen) {
+			return ms.reconfigure(m, key, schemaId, aggId, interval)
+		}
 		return m
 	}
 
@@ -149,8 +178,8 @@ func (ms *AggMetrics) GetOrCreate(key schema.MKey, schemaId, aggId uint16, inter
 		MKey: key,
 	}
 
-	agg := Aggregations.Get(aggId)
-	confSchema := Schemas.Get(schemaId)
+	gen := atomic.LoadUint32(&ms.gen)
+	settings := getAggMetricSettings(schemaId, aggId, interval)
 
 	// if it wasn't there, get the write lock and prepare to add it
 	// but first we need to check again if someone has added it in
@@ -164,8 +193,8 @@ func (ms *AggMetrics) GetOrCreate(key schema.MKey, schemaId, aggId uint16, inter
 		ms.Unlock()
 		return m
 	}
-	ingestFrom := ms.ingestFrom[key.Org]
-	m = NewAggMetric(ms.store, ms.cachePusher, k, confSchema.Retentions, confSchema.ReorderWindow, interval, &agg, ms.dropFirstChunk, ingestFrom)
+	m = ms.newAggMetric(k, settings, ms.dropFirstChunk, ms.ingestFrom[key.Org])
+	m.gen = gen
 	ms.Metrics[key.Org][key.Key] = m
 	active := len(ms.Metrics[key.Org])
 	ms.Unlock()
@@ -173,3 +202,97 @@ func (ms *AggMetrics) GetOrCreate(key schema.MKey, schemaId, aggId uint16, inter
 	promActiveMetrics.WithLabelValues(strconv.Itoa(int(key.Org))).Set(float64(active))
 	return m
 }
+
+// aggMetricSettings are the settings of an AggMetric, as derived from its schema, aggregation and interval
+type aggMetricSettings struct {
+	retentions    conf.Retentions
+	reorderWindow uint32
+	interval      uint32
+	chunkFormat   chunk.Format
+	aggMethods    []conf.Method // only set if there are rollups
+}
+
+func getAggMetricSettings(schemaId, aggId uint16, interval uint32) aggMetricSettings {
+	confSchema := Schemas().Get(schemaId)
+	settings := aggMetricSettings{
+		retentions:    confSchema.Retentions,
+		reorderWindow: confSchema.ReorderWindow,
+		interval:      interval,
+		chunkFormat:   chunk.FormatGoTszLongWithSpan,
+	}
+	if confSchema.DecimalChunks {
+		settings.chunkFormat = chunk.FormatGoTszDecWithSpan
+	}
+	if len(confSchema.Retentions.Rets) > 1 {
+		settings.aggMethods = Aggregations().Get(aggId).AggregationMethod
+	}
+	return settings
+}
+
+func (ms *AggMetrics) newAggMetric(key schema.AMKey, settings aggMetricSettings, dropFirstChunk bool, ingestFrom int64) *AggMetric {
+	agg := conf.Aggregation{
+		AggregationMethod: settings.aggMethods,
+	}
+	m := NewAggMetric(ms.store, ms.cachePusher, key, settings.retentions, settings.reorderWindow, settings.interval, settings.chunkFormat, &agg, dropFirstChunk, ingestFrom)
+	m.settings = settings
+	return m
+}
+
+// Reconfigure makes all AggMetrics adopt the settings of the current schemas and aggregations.
+// it must be called after they were replaced, and the ids of the archives updated.
+//
+// AggMetrics of which the settings changed don't switch over right away, as chunks of the new settings
+// could overwrite chunks of the current settings in the store. they keep ingesting data with their
+// current settings until the data goes into chunks, in the new settings, that start after all the chunks
+// they are writing to (including those of the rollups). at that point they close and persist their chunks,
+// and an AggMetric with the new settings takes over. See AggMetric.switchOver
+// The data in the chunks of the old settings is then read from the cache or the store.
+func (ms *AggMetrics) Reconfigure() {
+	atomic.AddUint32(&ms.gen, 1)
+}
+
+// reconfigure checks the settings of an AggMetric that was created, or last checked, before the rules were reloaded.
+// if its settings changed, it prepares an AggMetric with the new settings, which takes over once the
+// AggMetric switches over. once it has, it replaces the AggMetric.
+// it returns the AggMetric to add data to.
+func (ms *AggMetrics) reconfigure(m *AggMetric, key schema.MKey, schemaId, aggId uint16, interval uint32) *AggMetric {
+	gen := atomic.LoadUint32(&ms.gen)
+	m.Lock()
+	if m.switched {
+		next := m.next
+		points := m.numPoints()
+		m.Unlock()
+		ms.Lock()
+		if ms.Metrics[key.Org][key.Key] == m {
+			ms.Metrics[key.Org][key.Key] = next
+			totalPoints.DecUint64(uint64(points))
+			metricsReconfigured.Inc()
+		}
+		ms.Unlock()
+		if atomic.LoadUint32(&next.gen) != gen {
+			return ms.reconfigure(next, key, schemaId, aggId, interval)
+		}
+		return next
+	}
+
+	if m.next == nil || m.nextGen != gen {
+		settings := getAggMetricSettings(schemaId, aggId, interval)
+		switch {
+		case reflect.DeepEqual(settings, m.settings):
+			m.next = nil
+			atomic.StoreUint32(&m.gen, gen)
+		case m.next != nil && reflect.DeepEqual(settings, m.next.settings):
+			m.nextGen = gen
+		default:
+			k := schema.AMKey{
+				MKey: key,
+			}
+			// the first chunk of next starts at a chunk boundary, so it's complete and should be persisted
+			m.next = ms.newAggMetric(k, settings, false, 0)
+			m.next.gen = gen
+			m.nextGen = gen
+		}
+	}
+	m.Unlock()
+	return m
+}
@@ -3,6 +3,7 @@ package mdata
 import (
 	"github.com/grafana/metrictank/conf"
 	"github.com/grafana/metrictank/mdata/cache"
+	"github.com/grafana/metrictank/mdata/chunk"
 	"github.com/grafana/metrictank/schema"
 )
 
@@ -29,7 +30,7 @@ type Aggregator struct {
 	lstMetric       *AggMetric
 }
 
-func NewAggregator(store Store, cachePusher cache.CachePusher, key schema.AMKey, retOrig string, ret conf.Retention, agg conf.Aggregation, dropFirstChunk bool, ingestFrom int64) *Aggregator {
+func NewAggregator(store Store, cachePusher cache.CachePusher, key schema.AMKey, retOrig string, ret conf.Retention, chunkFormat chunk.Format, agg conf.Aggregation, dropFirstChunk bool, ingestFrom int64) *Aggregator {
 	if len(agg.AggregationMethod) == 0 {
 		panic("NewAggregator called without aggregations. this should never happen")
 	}
@@ -47,31 +48,31 @@ func NewAggregator(store Store, cachePusher cache.CachePusher, key schema.AMKey,
 		case conf.Avg:
 			if aggregator.sumMetric == nil {
 				key.Archive = schema.NewArchive(schema.Sum, span)
-				aggregator.sumMetric = NewAggMetric(store, cachePusher, key, retentions, 0, span, nil, dropFirstChunk, ingestFrom)
+				aggregator.sumMetric = NewAggMetric(store, cachePusher, key, retentions, 0, span, chunkFormat, nil, dropFirstChunk, ingestFrom)
 			}
 			if aggregator.cntMetric == nil {
 				key.Archive = schema.NewArchive(schema.Cnt, span)
-				aggregator.cntMetric = NewAggMetric(store, cachePusher, key, retentions, 0, span, nil, dropFirstChunk, ingestFrom)
+				aggregator.cntMetric = NewAggMetric(store, cachePusher, key, retentions, 0, span, chunkFormat, nil, dropFirstChunk, ingestFrom)
 			}
 		case conf.Sum:
 			if aggregator.sumMetric == nil {
 				key.Archive = schema.NewArchive(schema.Sum, span)
-				aggregator.sumMetric = NewAggMetric(store, cachePusher, key, retentions, 0, span, nil, dropFirstChunk, ingestFrom)
+				aggregator.sumMetric = NewAggMetric(store, cachePusher, key, retentions, 0, span, chunkFormat, nil, dropFirstChunk, ingestFrom)
 			}
 		case conf.Lst:
 			if aggregator.lstMetric == nil {
 				key.Archive = schema.NewArchive(schema.Lst, span)
-				aggregator.lstMetric = NewAggMetric(store, cachePusher, key, retentions, 0, span, nil, dropFirstChunk, ingestFrom)
+				aggregator.lstMetric = NewAggMetric(store, cachePusher, key, retentions, 0, span, chunkFormat, nil, dropFirstChunk, ingestFrom)
 			}
 		case conf.Max:
 			if aggregator.maxMetric == nil {
 				key.Archive = schema.NewArchive(schema.Max, span)
-				aggregator.maxMetric = NewAggMetric(store, cachePusher, key, retentions, 0, span, nil, dropFirstChunk, ingestFrom)
+				aggregator.maxMetric = NewAggMetric(store, cachePusher, key, retentions, 0, span, chunkFormat, nil, dropFirstChunk, ingestFrom)
 			}
 		case conf.Min:
 			if aggregator.minMetric == nil {
 				key.Archive = schema.NewArchive(schema.Min, span)
-				aggregator.minMetric = NewAggMetric(store, cachePusher, key, retentions, 0, span, nil, dropFirstChunk, ingestFrom)
+				aggregator.minMetric = NewAggMetric(store, cachePusher, key, retentions, 0, span, chunkFormat, nil, dropFirstChunk, ingestFrom)
 			}
 		}
 	}
@@ -127,6 +128,66 @@ func (agg *Aggregator) Add(ts uint32, val float64) {
 	}
 }
 
+// metrics returns the series of the aggregator
+func (agg *Aggregator) metrics() []*AggMetric {
+	var metrics []*AggMetric
+	for _, m := range []*AggMetric{agg.minMetric, agg.maxMetric, agg.sumMetric, agg.cntMetric, agg.lstMetric} {
+		if m != nil {
+			metrics = append(metrics, m)
+		}
+	}
+	return metrics
+}
+
+// canHandOver returns whether next, which has the same span, can take over as of the point with the given ts,
+// without any of its chunks starting at or before the chunks of our series that are being written to.
+func (agg *Aggregator) canHandOver(ts uint32, next *Aggregator) bool {
+	boundary := AggBoundary(ts, agg.span)
+	pairs := [][2]*AggMetric{
+		{agg.minMetric, next.minMetric},
+		{agg.maxMetric, next.maxMetric},
+		{agg.sumMetric, next.sumMetric},
+		{agg.cntMetric, next.cntMetric},
+		{agg.lstMetric, next.lstMetric},
+	}
+	for _, pair := range pairs {
+		cur, nxt := pair[0], pair[1]
+		if cur == nil || nxt == nil {
+			continue
+		}
+		t0 := cur.currentT0()
+		if agg.agg.Cnt != 0 && agg.currentBoundary < boundary {
+			// the in-progress aggregation will be flushed into our series
+			if pending := agg.currentBoundary - agg.currentBoundary%cur.chunkSpan; pending > t0 {
+				t0 = pending
+			}
+		}
+		if t0 != 0 && boundary-boundary%nxt.chunkSpan <= t0 {
+			return false
+		}
+	}
+	return true
+}
+
+// handOver prepares next, which has the same span or is nil, to take over as of the point with the given ts.
+// the in-progress aggregation is handed over to next if the point belongs to it, otherwise it is flushed.
+// the chunks being written to are closed and persisted.
+func (agg *Aggregator) handOver(ts uint32, next *Aggregator) {
+	if agg.agg.Cnt != 0 {
+		if next != nil && agg.currentBoundary == AggBoundary(ts, agg.span) {
+			*next.agg = *agg.agg
+			next.currentBoundary = agg.currentBoundary
+		} else {
+			agg.flush()
+		}
+	}
+	for _, m := range agg.metrics() {
+		m.Lock()
+		m.finishCurrentChunk()
+		m.Unlock()
+	}
+}
+
 // GC returns whether all of the associated series are stale and can be removed, and their combined pointcount if so
 func (agg *Aggregator) GC(now, chunkMinTs, metricMinTs, lastWriteTime uint32) (uint32, bool) {
 	var points uint32
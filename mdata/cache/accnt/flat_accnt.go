@@ -17,6 +17,8 @@ const (
 	// EvictTarget (24 bytes + 4 bytes) (AMKey, uint32) + 8 bytes (pointer from map[interface{}]*EvictTarget)
 	// + 40 bytes (Element in List)
 	lruItemSize = 76
+	// lruItemSize + k: 16 bytes + v: 8 bytes (map[interface{}]uint64)
+	slruItemSize = 100
 
 	// k: 4 bytes + v: 8 bytes (map[uint32]uint64)
 	famChunkSize = 12
@@ -35,10 +37,10 @@ const (
 var EventQSize = 100000
 
 // FlatAccnt implements Flat accounting.
-// Keeps track of the chunk cache size and in which order the contained
-// chunks have been used to last time. If it detects that the total cache
-// size is above the given limit, it feeds the least recently used
-// cache chunks into the evict queue, which will get consumed by the
+// Keeps track of the chunk cache size and, through its eviction policy,
+// in which order the contained chunks should be evicted. If it detects
+// that the total cache size is above the given limit, it feeds the chunks
+// chosen by the policy into the evict queue, which will get consumed by the
 // evict loop.
 type FlatAccnt struct {
 	// metric accounting per metric key
@@ -47,10 +49,13 @@ type FlatAccnt struct {
 	// the size limit, once this is reached we'll start evicting data
 	maxSize uint64
 
-	// a last-recently-used implementation that keeps track of all chunks
-	// and which hasn't been used for the longest time. the eviction
-	// function relies on this to know what to evict.
-	lru *LRU
+	// the eviction policy (e.g. a last-recently-used implementation) that
+	// keeps track of all chunks and decides which one should be evicted
+	// next. the eviction function relies on this to know what to evict.
+	policy Policy
+
+	// approximate memory used by the policy per chunk
+	policyItemSize uint64
 
 	// whenever a chunk gets evicted a job gets added to this queue. it is
 	// consumed by the chunk cache, which will evict whatever the jobs in
@@ -121,13 +126,20 @@ type GetTotalPayload struct {
 	res_chan chan uint64
 }
 
+// NewFlatAccnt creates a FlatAccnt that evicts the least recently used chunks
 func NewFlatAccnt(maxSize uint64) *FlatAccnt {
+	return NewFlatAccntWithPolicy(maxSize, NewLRU())
+}
+
+// NewFlatAccntWithPolicy creates a FlatAccnt that evicts chunks in the order decided by the given policy
+func NewFlatAccntWithPolicy(maxSize uint64, policy Policy) *FlatAccnt {
 	accnt := FlatAccnt{
-		metrics: make(map[schema.AMKey]*FlatAccntMet),
-		maxSize: maxSize,
-		lru:     NewLRU(),
-		evictQ:  make(chan *EvictTarget, evictQSize),
-		eventQ:  make(chan FlatAccntEvent, EventQSize),
+		metrics:        make(map[schema.AMKey]*FlatAccntMet),
+		maxSize:        maxSize,
+		policy:         policy,
+		policyItemSize: policy.itemSize(),
+		evictQ:         make(chan *EvictTarget, evictQSize),
+		eventQ:         make(chan FlatAccntEvent, EventQSize),
 	}
 	cacheSizeMax.SetUint64(maxSize)
 	accntEventQueueMax.SetUint64(uint64(EventQSize))
@@ -193,27 +205,29 @@ func (a *FlatAccnt) eventLoop() {
 				payload := event.pl.(*AddPayload)
 				a.add(payload.metric, payload.ts, payload.size)
 				cacheChunkAdd.Inc()
-				a.lru.touch(
+				a.policy.add(
 					EvictTarget{
 						Metric: payload.metric,
 						Ts:     payload.ts,
 					},
+					payload.size,
 				)
 			case evnt_add_chnks:
 				payload := event.pl.(*AddsPayload)
 				a.addRange(payload.metric, payload.chunks)
 				cacheChunkAdd.Add(len(payload.chunks))
 				for _, chunk := range payload.chunks {
-					a.lru.touch(
+					a.policy.add(
 						EvictTarget{
 							Metric: payload.metric,
 							Ts:     chunk.T0,
 						},
+						chunk.Size(),
 					)
 				}
 			case evnt_hit_chnk:
 				payload := event.pl.(*HitPayload)
-				a.lru.touch(
+				a.policy.hit(
 					EvictTarget{
 						Metric: payload.metric,
 						Ts:     payload.ts,
@@ -222,7 +236,7 @@ func (a *FlatAccnt) eventLoop() {
 			case evnt_hit_chnks:
 				payload := event.pl.(*HitsPayload)
 				for _, chunk := range payload.chunks {
-					a.lru.touch(
+					a.policy.hit(
 						EvictTarget{
 							Metric: payload.metric,
 							Ts:     chunk.T0,
@@ -239,7 +253,7 @@ func (a *FlatAccnt) eventLoop() {
 				return
 			case evnt_reset:
 				a.metrics = make(map[schema.AMKey]*FlatAccntMet)
-				a.lru.reset()
+				a.policy.reset()
 				cacheSizeUsed.SetUint64(0)
 				cacheOverheadChunk.SetUint64(0)
 				cacheOverheadFlat.SetUint64(0)
@@ -267,11 +281,11 @@ func (a *FlatAccnt) delMet(metric schema.AMKey) {
 	lenChunks := len(met.chunks)
 	cacheSizeUsed.DecUint64(met.total)
 	cacheOverheadFlat.DecUint64(uint64(lenChunks*famChunkSize + famSize))
-	cacheOverheadLru.DecUint64(uint64(lenChunks * lruItemSize))
+	cacheOverheadLru.DecUint64(uint64(lenChunks) * a.policyItemSize)
 	cacheOverheadChunk.DecUint64(uint64(lenChunks*ccmChunkSize + ccmSize))
 
 	for ts := range met.chunks {
-		a.lru.del(
+		a.policy.del(
 			EvictTarget{
 				Metric: metric,
 				Ts:     ts,
@@ -307,8 +321,8 @@ func (a *FlatAccnt) add(metric schema.AMKey, ts uint32, size uint64) {
 
 	totalFlat += famChunkSize
 	totalChunk += ccmChunkSize
-	// this func is called from the event loop so lru will be touched with new EvictTarget
-	totalLru += lruItemSize
+	// this func is called from the event loop so the policy will be given the new EvictTarget
+	totalLru += a.policyItemSize
 	met.total = met.total + size
 	cacheSizeUsed.AddUint64(size)
 	cacheOverheadFlat.AddUint64(totalFlat)
@@ -344,8 +358,8 @@ func (a *FlatAccnt) addRange(metric schema.AMKey, chunks []chunk.IterGen) {
 		met.chunks[chunk.T0] = size
 		totalFlat += famChunkSize
 		totalChunk += ccmChunkSize
-		// this func is called from the event loop so lru will be touched with new EvictTarget
-		totalLru += lruItemSize
+		// this func is called from the event loop so the policy will be given the new EvictTarget
+		totalLru += a.policyItemSize
 	}
 
 	met.total = met.total + sizeDiff
@@ -363,9 +377,9 @@ func (a *FlatAccnt) evict() {
 	var ok bool
 	var e interface{}
 	var target EvictTarget
-	var totalFlat, totalChunk uint64
+	var totalFlat, totalChunk, totalLru uint64
 
-	e = a.lru.pop()
+	e = a.policy.pop()
 
 	// got nothing to evict
 	if e == nil {
@@ -374,9 +388,9 @@ func (a *FlatAccnt) evict() {
 
 	// convert to EvictTarget otherwise
 	target = e.(EvictTarget)
-	// the item is already removed from the LRU and will not be re-added in this call path
+	// the item is already removed from the policy and will not be re-added in this call path
 	// so it is safe to decrement the stat
-	cacheOverheadLru.DecUint64(lruItemSize)
+	cacheOverheadLru.DecUint64(a.policyItemSize)
 
 	if met, ok = a.metrics[target.Metric]; !ok {
 		return
@@ -403,6 +417,13 @@ func (a *FlatAccnt) evict() {
 			Ts:     ts,
 		}
 		delete(met.chunks, ts)
+		if ts != target.Ts {
+			a.policy.del(EvictTarget{
+				Metric: target.Metric,
+				Ts:     ts,
+			})
+			totalLru += a.policyItemSize
+		}
 	}
 
 	// technically none of the *CCacheChunk or *CCacheMetric will be deleted until
@@ -424,7 +445,7 @@ func (a *FlatAccnt) evict() {
 
 	cacheOverheadChunk.DecUint64(totalChunk)
 	cacheOverheadFlat.DecUint64(totalFlat)
-
+	cacheOverheadLru.DecUint64(totalLru)
 }
 
 func (a *FlatAccnt) GetEvictQ() chan *EvictTarget {
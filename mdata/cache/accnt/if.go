@@ -7,7 +7,8 @@ import (
 
 // Accnt represents an instance of cache accounting.
 // Currently there is only one implementation called `FlatAccnt`,
-// but it could be replaced with alternative eviction algorithms
+// which supports different eviction algorithms through its Policy,
+// but it could be replaced with alternative accounting implementations
 // in the future if they just implement this interface.
 type Accnt interface {
 	GetEvictQ() chan *EvictTarget
@@ -50,3 +50,15 @@ func (l *LRU) reset() {
 		}
 	}
 }
+
+func (l *LRU) add(key interface{}, size uint64) {
+	l.touch(key)
+}
+
+func (l *LRU) hit(key interface{}) {
+	l.touch(key)
+}
+
+func (l *LRU) itemSize() uint64 {
+	return lruItemSize
+}
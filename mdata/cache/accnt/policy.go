@@ -0,0 +1,35 @@
+package accnt
+
+import "fmt"
+
+// Policy decides in which order the chunks in the cache get evicted.
+// all methods are called from the FlatAccnt event loop, so implementations don't need to be safe for concurrent use.
+type Policy interface {
+	// add is called when a chunk of the given size got added to the cache
+	add(key interface{}, size uint64)
+	// hit is called when a chunk got read from the cache
+	hit(key interface{})
+	// del is called when a chunk got removed from the cache, without having been returned by pop
+	del(key interface{})
+	// pop removes and returns the chunk that should be evicted next, or nil if there are none
+	pop() interface{}
+	// reset removes all chunks
+	reset()
+	// itemSize is an approximation of the memory used per tracked chunk
+	itemSize() uint64
+}
+
+// NewPolicy returns the eviction policy with the given name.
+// protectedRatio is the share of maxSize that the protected segment of the slru policy may use.
+func NewPolicy(name string, maxSize uint64, protectedRatio float64) (Policy, error) {
+	switch name {
+	case "lru":
+		return NewLRU(), nil
+	case "slru":
+		if protectedRatio < 0 || protectedRatio >= 1 {
+			return nil, fmt.Errorf("slru protected ratio must be >= 0 and < 1. got %f", protectedRatio)
+		}
+		return NewSLRU(uint64(float64(maxSize) * protectedRatio)), nil
+	}
+	return nil, fmt.Errorf("unknown eviction policy %q. valid options are lru and slru", name)
+}
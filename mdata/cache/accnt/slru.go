@@ -0,0 +1,99 @@
+package accnt
+
+// SLRU is a segmented LRU. new chunks enter the probation segment, and only
+// get promoted to the protected segment once they get hit. chunks are evicted
+// from the probation segment first, so a scan over a large amount of data that
+// is only read once can't push out the data that is read over and over again.
+// the protected segment is limited in size, when it grows beyond its limit its
+// least recently used chunks are demoted back into the probation segment.
+type SLRU struct {
+	probation *LRU
+	protected *LRU
+
+	// the sizes of all chunks that are tracked by the SLRU
+	sizes map[interface{}]uint64
+
+	protectedSize uint64
+	protectedMax  uint64
+}
+
+func NewSLRU(protectedMax uint64) *SLRU {
+	return &SLRU{
+		probation:    NewLRU(),
+		protected:    NewLRU(),
+		sizes:        make(map[interface{}]uint64),
+		protectedMax: protectedMax,
+	}
+}
+
+func (s *SLRU) add(key interface{}, size uint64) {
+	if _, ok := s.sizes[key]; ok {
+		s.hit(key)
+		return
+	}
+	s.sizes[key] = size
+	s.probation.touch(key)
+}
+
+func (s *SLRU) hit(key interface{}) {
+	if _, ok := s.protected.items[key]; ok {
+		s.protected.touch(key)
+		return
+	}
+	if _, ok := s.probation.items[key]; !ok {
+		// not (or no longer) tracked, e.g. because it just got evicted
+		return
+	}
+	s.probation.del(key)
+	s.protected.touch(key)
+	s.protectedSize += s.sizes[key]
+	cacheChunkPromote.Inc()
+
+	for s.protectedSize > s.protectedMax {
+		demoted := s.protected.pop()
+		if demoted == nil {
+			break
+		}
+		s.protectedSize -= s.sizes[demoted]
+		s.probation.touch(demoted)
+		cacheChunkDemote.Inc()
+	}
+	cacheSizeProtected.SetUint64(s.protectedSize)
+}
+
+func (s *SLRU) del(key interface{}) {
+	if _, ok := s.protected.items[key]; ok {
+		s.protected.del(key)
+		s.protectedSize -= s.sizes[key]
+		cacheSizeProtected.SetUint64(s.protectedSize)
+	} else {
+		s.probation.del(key)
+	}
+	delete(s.sizes, key)
+}
+
+func (s *SLRU) pop() interface{} {
+	key := s.probation.pop()
+	if key == nil {
+		key = s.protected.pop()
+		if key == nil {
+			return nil
+		}
+		s.protectedSize -= s.sizes[key]
+		cacheSizeProtected.SetUint64(s.protectedSize)
+	}
+	delete(s.sizes, key)
+	return key
+}
+
+func (s *SLRU) reset() {
+	s.probation.reset()
+	s.protected.reset()
+	s.sizes = make(map[interface{}]uint64)
+	s.protectedSize = 0
+	cacheSizeProtected.SetUint64(0)
+}
+
+func (s *SLRU) itemSize() uint64 {
+	return slruItemSize
+}
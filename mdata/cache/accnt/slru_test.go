@@ -0,0 +1,111 @@
+package accnt
+
+import (
+	"testing"
+
+	"github.com/grafana/metrictank/schema"
+	"github.com/grafana/metrictank/test"
+)
+
+func TestSLRU(t *testing.T) {
+	slru := NewSLRU(4)
+	slru.add("key1", 2)
+	slru.add("key2", 2)
+	slru.add("key3", 2)
+
+	// key1 and key2 get promoted, key3 stays on probation
+	slru.hit("key1")
+	slru.hit("key2")
+	if slru.protectedSize != 4 {
+		t.Fatalf("expected protected size 4, got %d", slru.protectedSize)
+	}
+
+	// promoting key3 demotes key1, the least recently used protected chunk
+	slru.hit("key3")
+	if slru.protectedSize != 4 {
+		t.Fatalf("expected protected size 4, got %d", slru.protectedSize)
+	}
+
+	for _, exp := range []string{"key1", "key2", "key3"} {
+		if val := slru.pop(); val != exp {
+			t.Fatalf("expected %s, got %v", exp, val)
+		}
+	}
+	if val := slru.pop(); val != nil {
+		t.Fatalf("expected nil, got %v", val)
+	}
+	if slru.protectedSize != 0 || len(slru.sizes) != 0 {
+		t.Fatalf("expected empty slru, got protected size %d and %d sizes", slru.protectedSize, len(slru.sizes))
+	}
+}
+
+func TestSLRUDelete(t *testing.T) {
+	slru := NewSLRU(10)
+	slru.add("key1", 2)
+	slru.add("key2", 2)
+	slru.hit("key1")
+
+	slru.del("key1")
+	slru.del("key2")
+	if slru.protectedSize != 0 || len(slru.sizes) != 0 {
+		t.Fatalf("expected empty slru, got protected size %d and %d sizes", slru.protectedSize, len(slru.sizes))
+	}
+	if val := slru.pop(); val != nil {
+		t.Fatalf("expected nil, got %v", val)
+	}
+}
+
+// TestSLRUScanResistance checks that a scan over chunks that are only read once
+// does not evict the chunks that are read repeatedly, contrary to the LRU policy
+func TestSLRUScanResistance(t *testing.T) {
+	hot := schema.GetAMKey(test.GetMKey(1), schema.Cnt, 600)
+	scan := schema.GetAMKey(test.GetMKey(2), schema.Cnt, 600)
+
+	run := func(policy Policy) uint32 {
+		resetCounters()
+		a := NewFlatAccntWithPolicy(10, policy)
+		evictQ := a.GetEvictQ()
+		for ts := uint32(1); ts <= 5; ts++ {
+			a.AddChunk(hot, ts, 1)
+			a.HitChunk(hot, ts)
+		}
+		var hotEvicted uint32
+		for ts := uint32(1); ts <= 20; ts++ {
+			a.AddChunk(scan, ts, 1)
+			a.GetTotal() // wait for the event to be processed
+			for len(evictQ) > 0 {
+				if et := <-evictQ; et.Metric == hot {
+					hotEvicted++
+				}
+			}
+		}
+		a.Stop()
+		return hotEvicted
+	}
+
+	if evicted := run(NewLRU()); evicted != 5 {
+		t.Fatalf("expected lru to evict all 5 hot chunks, got %d", evicted)
+	}
+	if evicted := run(NewSLRU(8)); evicted != 0 {
+		t.Fatalf("expected slru to evict no hot chunks, got %d", evicted)
+	}
+}
+
+func TestNewPolicy(t *testing.T) {
+	cases := []struct {
+		name  string
+		ratio float64
+		ok    bool
+	}{
+		{"lru", 0, true},
+		{"slru", 0.8, true},
+		{"slru", 1, false},
+		{"arc", 0.8, false},
+	}
+	for _, c := range cases {
+		_, err := NewPolicy(c.name, 100, c.ratio)
+		if (err == nil) != c.ok {
+			t.Fatalf("NewPolicy(%q, %f): expected ok %t, got err %v", c.name, c.ratio, c.ok, err)
+		}
+	}
+}
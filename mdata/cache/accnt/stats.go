@@ -31,19 +31,28 @@ var (
 	// metric cache.ops.chunk.evict is how many chunks were evicted from the cache
 	cacheChunkEvict = stats.NewCounter32("cache.ops.chunk.evict")
 
+	// metric cache.ops.chunk.promote is how many chunks were promoted to the protected segment of the cache. only used with the slru eviction policy
+	cacheChunkPromote = stats.NewCounter32("cache.ops.chunk.promote")
+
+	// metric cache.ops.chunk.demote is how many chunks were demoted out of the protected segment of the cache. only used with the slru eviction policy
+	cacheChunkDemote = stats.NewCounter32("cache.ops.chunk.demote")
+
 	// metric cache.size.max is the maximum size of the cache (overhead does not count towards this limit)
 	cacheSizeMax = stats.NewGauge64("cache.size.max")
 
 	// metric cache.size.used is how much of the cache is used (sum of the chunk data without overhead)
 	cacheSizeUsed = stats.NewGauge64("cache.size.used")
 
+	// metric cache.size.protected is how much of the used cache is in the protected segment. only used with the slru eviction policy
+	cacheSizeProtected = stats.NewGauge64("cache.size.protected")
+
 	// metric cache.overhead.chunk is an approximation of the overhead used to store chunks in the cache
 	cacheOverheadChunk = stats.NewGauge64("cache.overhead.chunk")
 
 	// metric cache.overhead.flat is an approximation of the overhead used by flat accounting
 	cacheOverheadFlat = stats.NewGauge64("cache.overhead.flat")
 
-	// metric cache.overhead.lru is an approximation of the overhead used by the LRU
+	// metric cache.overhead.lru is an approximation of the overhead used by the eviction policy
 	cacheOverheadLru = stats.NewGauge64("cache.overhead.lru")
 
 	accntEventAddDuration = stats.NewLatencyHistogram15s32("cache.accounting.queue.add")
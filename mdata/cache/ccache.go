@@ -17,18 +17,28 @@ import (
 )
 
 var (
-	maxSize         uint64
-	searchFwdBug    = stats.NewCounter32("recovered_errors.cache.metric.searchForwardBug")
-	ErrInvalidRange = errors.New("CCache: invalid range: from must be less than to")
+	maxSize            uint64
+	evictionPolicy     string
+	slruProtectedRatio float64
+	searchFwdBug       = stats.NewCounter32("recovered_errors.cache.metric.searchForwardBug")
+	ErrInvalidRange    = errors.New("CCache: invalid range: from must be less than to")
 )
 
 func init() {
 	flags := flag.NewFlagSet("chunk-cache", flag.ExitOnError)
 	// 512 MB = (1024 ^ 2) * 512 = 536870912
 	flags.Uint64Var(&maxSize, "max-size", 536870912, "Maximum size of chunk cache in bytes. 0 disables cache")
+	flags.StringVar(&evictionPolicy, "eviction-policy", "lru", "which chunks to evict when the cache is full. lru: least recently used. slru: segmented lru, which protects chunks that have been read more than once against one-off scans")
+	flags.Float64Var(&slruProtectedRatio, "slru-protected-ratio", 0.8, "share of max-size that may be used by chunks in the protected segment of the slru eviction policy")
 	globalconf.Register("chunk-cache", flags, flag.ExitOnError)
 }
 
+func ConfigProcess() {
+	if _, err := accnt.NewPolicy(evictionPolicy, maxSize, slruProtectedRatio); err != nil {
+		log.Fatalf("chunk-cache: Config validation error. %s", err)
+	}
+}
+
 type CCache struct {
 	sync.RWMutex
 
@@ -56,10 +66,15 @@ func NewCCache() *CCache {
 		return nil
 	}
 
+	policy, err := accnt.NewPolicy(evictionPolicy, maxSize, slruProtectedRatio)
+	if err != nil {
+		log.Fatalf("CCache: %s", err)
+	}
+
 	cc := &CCache{
 		metricCache:   make(map[schema.AMKey]*CCacheMetric),
 		metricRawKeys: make(map[schema.MKey]map[schema.Archive]struct{}),
-		accnt:         accnt.NewFlatAccnt(maxSize),
+		accnt:         accnt.NewFlatAccntWithPolicy(maxSize, policy),
 		stop:          make(chan interface{}),
 		tracer:        opentracing.NoopTracer{},
 	}
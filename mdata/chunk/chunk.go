@@ -35,6 +35,17 @@ func NewFirst(t0 uint32) *Chunk {
 	}
 }
 
+// NewFormat creates a chunk that will be encoded in the given format,
+// which must be FormatGoTszLongWithSpan or FormatGoTszDecWithSpan
+func NewFormat(t0 uint32, format Format) *Chunk {
+	if format == FormatGoTszDecWithSpan {
+		return &Chunk{
+			Series: *tsz.NewSeriesLongDec(t0),
+		}
+	}
+	return New(t0)
+}
+
 func (c *Chunk) String() string {
 	return fmt.Sprintf("<chunk T0=%d, LastTs=%d, NumPoints=%d, First=%t, Closed=%t>", c.Series.T0, c.Series.T, c.NumPoints, c.First, c.Series.Finished)
 }
@@ -59,5 +70,8 @@ func (c *Chunk) Finish() {
 // so for formats that encode it, it needs to be passed in.
 // the returned value contains no references to the chunk. data is copied.
 func (c *Chunk) Encode(span uint32) []byte {
+	if c.Series.Decimal() {
+		return encode(span, FormatGoTszDecWithSpan, c.Series.Bytes())
+	}
 	return encode(span, FormatGoTszLongWithSpan, c.Series.Bytes())
 }
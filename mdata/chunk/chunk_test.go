@@ -119,3 +119,42 @@ func TestChunkPush(t *testing.T) {
 
 	testPush(t, points, expected)
 }
+
+func TestChunkEncodeDecodeFormats(t *testing.T) {
+	var points []schema.Point
+	for ts := uint32(600); ts < 1200; ts += 10 {
+		points = append(points, schema.Point{Ts: ts, Val: float64(ts) / 4})
+	}
+	for _, format := range []Format{FormatGoTszLongWithSpan, FormatGoTszDecWithSpan} {
+		c := NewFormat(600, format)
+		for _, p := range points {
+			if err := c.Push(p.Ts, p.Val); err != nil {
+				t.Fatalf("%s: push failed: %s", format, err)
+			}
+		}
+		c.Finish()
+		data := c.Encode(600)
+		if Format(data[0]) != format {
+			t.Fatalf("expected chunk in format %s, got %s", format, Format(data[0]))
+		}
+		if span := ExtractChunkSpan(data); span != 600 {
+			t.Fatalf("%s: expected span 600, got %d", format, span)
+		}
+		ig, err := NewIterGen(600, 10, data)
+		if err != nil {
+			t.Fatalf("%s: could not create itergen: %s", format, err)
+		}
+		iter, err := ig.Get()
+		if err != nil {
+			t.Fatalf("%s: could not get iterator: %s", format, err)
+		}
+		var got []schema.Point
+		for iter.Next() {
+			ts, val := iter.Values()
+			got = append(got, schema.Point{Ts: ts, Val: val})
+		}
+		if !equal(points, got) {
+			t.Fatalf("%s: expected %v, got %v", format, points, got)
+		}
+	}
+}
@@ -12,4 +12,5 @@ const (
 	FormatStandardGoTsz Format = iota
 	FormatStandardGoTszWithSpan
 	FormatGoTszLongWithSpan // like FormatStandardGoTszWithSpan but using tsz.SeriesLong
+	FormatGoTszDecWithSpan  // like FormatGoTszLongWithSpan but using decimal value compression
 )
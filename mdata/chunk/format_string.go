@@ -11,11 +11,12 @@ func _() {
 	_ = x[FormatStandardGoTsz-0]
 	_ = x[FormatStandardGoTszWithSpan-1]
 	_ = x[FormatGoTszLongWithSpan-2]
+	_ = x[FormatGoTszDecWithSpan-3]
 }
 
-const _Format_name = "FormatStandardGoTszFormatStandardGoTszWithSpanFormatGoTszLongWithSpan"
+const _Format_name = "FormatStandardGoTszFormatStandardGoTszWithSpanFormatGoTszLongWithSpanFormatGoTszDecWithSpan"
 
-var _Format_index = [...]uint8{0, 19, 46, 69}
+var _Format_index = [...]uint8{0, 19, 46, 69, 91}
 
 func (i Format) String() string {
 	if i >= Format(len(_Format_index)-1) {
@@ -28,7 +28,7 @@ func NewIterGen(t0, intervalHint uint32, b []byte) (IterGen, error) {
 		if len(b) == 1 {
 			return IterGen{}, errShort
 		}
-	case FormatStandardGoTszWithSpan, FormatGoTszLongWithSpan:
+	case FormatStandardGoTszWithSpan, FormatGoTszLongWithSpan, FormatGoTszDecWithSpan:
 		if len(b) <= 2 {
 			return IterGen{}, errShort
 		}
@@ -64,6 +64,11 @@ func (ig *IterGen) Get() (tsz.Iter, error) {
 		dest := make([]byte, len(src))
 		copy(dest, src)
 		return tsz.NewIteratorLong(ig.T0, dest)
+	case FormatGoTszDecWithSpan:
+		src := ig.B[2:]
+		dest := make([]byte, len(src))
+		copy(dest, src)
+		return tsz.NewIteratorLongDec(ig.T0, dest)
 	}
 	return nil, errUnknownChunkFormat
 }
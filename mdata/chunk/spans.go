@@ -53,7 +53,9 @@ func ExtractChunkSpan(chunk []byte) uint32 {
 		return 0
 	}
 
-	if Format(chunk[0]) != FormatStandardGoTszWithSpan && Format(chunk[0]) != FormatGoTszLongWithSpan {
+	switch Format(chunk[0]) {
+	case FormatStandardGoTszWithSpan, FormatGoTszLongWithSpan, FormatGoTszDecWithSpan:
+	default:
 		return 0
 	}
 
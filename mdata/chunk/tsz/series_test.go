@@ -181,12 +181,19 @@ func TestSeriesEncodeDecode(t *testing.T) {
 // and validates that what comes out, matches what went in
 func TestSeriesLongEncodeDecodeRandom(t *testing.T) {
 	for i := 0; i < 10000; i++ {
-		testSeriesLongEncodeDecodeRandom(t)
+		testSeriesLongEncodeDecodeRandom(t, false)
+	}
+}
+
+// TestSeriesLongDecEncodeDecodeRandom is like TestSeriesLongEncodeDecodeRandom, but with decimal value compression
+func TestSeriesLongDecEncodeDecodeRandom(t *testing.T) {
+	for i := 0; i < 10000; i++ {
+		testSeriesLongEncodeDecodeRandom(t, true)
 	}
 }
 
 // explore as much of the problem space as possible:
-func testSeriesLongEncodeDecodeRandom(t *testing.T) {
+func testSeriesLongEncodeDecodeRandom(t *testing.T, dec bool) {
 	// choose any of the chunkspans we support
 	// and a random corresponding t0.
 	chunkspan := ChunkSpans[rand.Int()%32]
@@ -196,6 +203,9 @@ func testSeriesLongEncodeDecodeRandom(t *testing.T) {
 	prev := t0 - 1
 
 	series := NewSeriesLong(t0)
+	if dec {
+		series = NewSeriesLongDec(t0)
+	}
 
 	var in []schema.Point
 
@@ -233,7 +243,7 @@ func testSeriesLongEncodeDecodeRandom(t *testing.T) {
 		prev = ts
 
 		var v float64
-		switch rand.Intn(7) {
+		switch rand.Intn(9) {
 		case 0:
 			v = 0
 		case 1:
@@ -248,6 +258,10 @@ func testSeriesLongEncodeDecodeRandom(t *testing.T) {
 			v = math.SmallestNonzeroFloat64 * -1
 		case 6:
 			v = rand.NormFloat64()
+		case 7:
+			v = float64(rand.Int63n(1<<54) - 1<<53)
+		case 8:
+			v = float64(rand.Intn(2000000)-1000000) / 1000
 		}
 
 		series.Push(ts, v)
@@ -260,6 +274,9 @@ func testSeriesLongEncodeDecodeRandom(t *testing.T) {
 	// note typically the storage system stores and retrieves the t0 along with the chunk data
 
 	iter, err := NewIteratorLong(t0, bytes)
+	if dec {
+		iter, err = NewIteratorLongDec(t0, bytes)
+	}
 	if err != nil {
 		t.Errorf("could not get iterator\ninput %v\nchunk %b\nerror: %s", in, bytes, err)
 	}
@@ -278,6 +295,81 @@ func testSeriesLongEncodeDecodeRandom(t *testing.T) {
 	}
 }
 
+func TestSeriesLongDecEncodeDecode(t *testing.T) {
+	t0 := uint32(1540728000)
+	counter := makeVals(t0, t0+3600*6, 60, 0, -1)
+	var decimals, mixed []schema.Point
+	for i, p := range counter {
+		decimals = append(decimals, schema.Point{Val: 12.5 + float64(i)*0.01, Ts: p.Ts})
+		val := float64(i)
+		switch i % 7 {
+		case 1:
+			val = -val / 8
+		case 2:
+			val = math.Pi
+		case 3:
+			val = 1 << 60
+		case 4:
+			val = math.Inf(-1)
+		case 5:
+			val = 0.001
+		}
+		mixed = append(mixed, schema.Point{Val: val, Ts: p.Ts})
+	}
+	cases := []struct {
+		desc string
+		vals []schema.Point
+	}{
+		{"counter", counter},
+		{"fixed decimals", decimals},
+		{"mixed integers, decimals and floats", mixed},
+		{"a single point", []schema.Point{{Val: 3.25, Ts: t0 + 60}}},
+		{"negative zero", []schema.Point{{Val: 1, Ts: t0}, {Val: math.Copysign(0, -1), Ts: t0 + 60}, {Val: 0, Ts: t0 + 120}}},
+	}
+
+	for _, c := range cases {
+		series := NewSeriesLongDec(t0)
+		for _, p := range c.vals {
+			series.Push(p.Ts, p.Val)
+		}
+		series.Finish()
+		iter, err := NewIteratorLongDec(t0, series.Bytes())
+		if err != nil {
+			t.Fatalf("%s: could not get iterator: %s", c.desc, err)
+		}
+		var out []schema.Point
+		for iter.Next() {
+			ts, val := iter.Values()
+			out = append(out, schema.Point{Val: val, Ts: ts})
+		}
+		if iter.Err() != nil {
+			t.Fatalf("%s: iterator error: %s", c.desc, iter.Err())
+		}
+		if len(out) != len(c.vals) {
+			t.Fatalf("%s: expected %d points, got %d", c.desc, len(c.vals), len(out))
+		}
+		for i := range out {
+			// compare the bits, to catch the sign of zero
+			if out[i].Ts != c.vals[i].Ts || math.Float64bits(out[i].Val) != math.Float64bits(c.vals[i].Val) {
+				t.Fatalf("%s: point %d: expected %v, got %v", c.desc, i, c.vals[i], out[i])
+			}
+		}
+	}
+
+	// the values of a counter or a series with fixed decimals should compress much better than with XOR compression
+	for _, vals := range [][]schema.Point{counter, decimals} {
+		long := NewSeriesLong(t0)
+		dec := NewSeriesLongDec(t0)
+		for _, p := range vals {
+			long.Push(p.Ts, p.Val)
+			dec.Push(p.Ts, p.Val)
+		}
+		if len(dec.Bytes())*2 > len(long.Bytes()) {
+			t.Fatalf("expected decimal compression to be at least twice as small as XOR compression. got %dB vs %dB", len(dec.Bytes()), len(long.Bytes()))
+		}
+	}
+}
+
 // rand returns a number in the range [low,hi)
 // caller should assure that hi > low
 func randUint32Range(low, hi uint32) uint32 {
@@ -40,6 +40,22 @@ func BenchmarkPushSeriesLong(b *testing.B) {
 	b.Logf("SeriesLong size: %dB", len(s.Bytes()))
 }
 
+func BenchmarkPushSeriesLongDec(b *testing.B) {
+	s := NewSeriesLongDec(0)
+	N := uint32(b.N)
+	for i := uint32(1); i <= N; i++ {
+		if i%10 == 0 {
+			s.Push(i, 0)
+		} else if i%10 == 1 {
+			s.Push(i, 1)
+		} else {
+			s.Push(i, float64(i)+123.45)
+		}
+	}
+	s.Finish()
+	b.Logf("SeriesLongDec size: %dB", len(s.Bytes()))
+}
+
 func BenchmarkIterSeries4h(b *testing.B) {
 	s := NewSeries4h(0)
 	N := uint32(b.N)
@@ -0,0 +1,175 @@
+package tsz
+
+import (
+	"errors"
+	"math"
+)
+
+// decimal value compression, an alternative to the XOR float compression of the paper, used by SeriesLong when created via NewSeriesLongDec.
+// most series are counters and gauges with integer or fixed-precision decimal values, which XOR compression handles poorly.
+// such values are represented as an integer n at a decimal scale (value = n / 10^scale), and we store the
+// delta-of-delta of n as a zigzag encoded varint. any other value (including NaN and -0) is stored as a raw float64.
+// after the timestamp dod, each value is encoded as one of:
+//
+// 0                                  dod of n is 0
+// 10<varint zigzag dod>              any other dod of n
+// 110<4bit scale><varint zigzag n>   n in full, at a new scale. also used for the first decimal value after a raw value, and for the first point
+// 111<64bit float>                   raw value
+//
+// the scale only increases within a series (until a raw value is seen), as any value that can be represented at a scale,
+// can be represented at any higher scale as well.
+
+const (
+	maxDecScale = 15
+	maxDecExact = 1 << 53 // beyond this, not all integers can be represented by a float64
+)
+
+var (
+	errVarintOverflow = errors.New("varint overflows 64 bits")
+	errCorruptDecimal = errors.New("corrupt decimal value")
+)
+
+var pow10 [maxDecScale + 1]float64
+
+func init() {
+	pow10[0] = 1
+	for i := 1; i <= maxDecScale; i++ {
+		pow10[i] = pow10[i-1] * 10
+	}
+}
+
+// decState is the state of the decimal value compression, of both the encoder and the decoder
+type decState struct {
+	raw   bool // previous value was stored raw, or there is no previous value
+	scale uint8
+	n     int64
+	delta int64
+}
+
+func newDecState() *decState {
+	return &decState{raw: true}
+}
+
+// toDecimal returns the integer representation of v at the given scale, if it can be represented exactly
+func toDecimal(v float64, scale uint8) (int64, bool) {
+	f := math.Round(v * pow10[scale])
+	if !(f > -maxDecExact && f < maxDecExact) || f/pow10[scale] != v || (v == 0 && math.Signbit(v)) {
+		return 0, false
+	}
+	return int64(f), true
+}
+
+func zigzag(v int64) uint64 {
+	return uint64((v << 1) ^ (v >> 63))
+}
+
+func unzigzag(u uint64) int64 {
+	return int64(u>>1) ^ -int64(u&1)
+}
+
+func writeVarint(bw *bstream, u uint64) {
+	for u >= 0x80 {
+		bw.writeByte(byte(u) | 0x80)
+		u >>= 7
+	}
+	bw.writeByte(byte(u))
+}
+
+func readVarint(br *bstream) (uint64, error) {
+	var u uint64
+	for shift := uint(0); shift < 64; shift += 7 {
+		b, err := br.readByte()
+		if err != nil {
+			return 0, err
+		}
+		u |= uint64(b&0x7f) << shift
+		if b < 0x80 {
+			return u, nil
+		}
+	}
+	return 0, errVarintOverflow
+}
+
+func (d *decState) push(bw *bstream, v float64) {
+	if !d.raw {
+		if n, ok := toDecimal(v, d.scale); ok {
+			delta := n - d.n
+			dod := delta - d.delta
+			if dod == 0 {
+				bw.writeBit(zero)
+			} else {
+				bw.writeBits(0x02, 2) // '10'
+				writeVarint(bw, zigzag(dod))
+			}
+			d.n, d.delta = n, delta
+			return
+		}
+	}
+
+	// the value can't be represented at the current scale, so it can only be at a higher one
+	scale := uint8(0)
+	if !d.raw {
+		scale = d.scale + 1
+	}
+	for ; scale <= maxDecScale; scale++ {
+		if n, ok := toDecimal(v, scale); ok {
+			bw.writeBits(0x06, 3) // '110'
+			bw.writeBits(uint64(scale), 4)
+			writeVarint(bw, zigzag(n))
+			d.raw, d.scale, d.n, d.delta = false, scale, n, 0
+			return
+		}
+	}
+
+	bw.writeBits(0x07, 3) // '111'
+	bw.writeBits(math.Float64bits(v), 64)
+	d.raw = true
+}
+
+func (d *decState) read(br *bstream) (float64, error) {
+	var ctrl byte
+	for i := 0; i < 3; i++ {
+		ctrl <<= 1
+		bit, err := br.readBit()
+		if err != nil {
+			return 0, err
+		}
+		if bit == zero {
+			break
+		}
+		ctrl |= 1
+	}
+
+	switch ctrl {
+	case 0x00: // '0'
+		d.n += d.delta
+	case 0x02: // '10'
+		u, err := readVarint(br)
+		if err != nil {
+			return 0, err
+		}
+		d.delta += unzigzag(u)
+		d.n += d.delta
+	case 0x06: // '110'
+		scale, err := br.readBits(4)
+		if err != nil {
+			return 0, err
+		}
+		u, err := readVarint(br)
+		if err != nil {
+			return 0, err
+		}
+		d.raw, d.scale, d.n, d.delta = false, uint8(scale), unzigzag(u), 0
+	case 0x07: // '111'
+		bits, err := br.readBits(64)
+		if err != nil {
+			return 0, err
+		}
+		d.raw = true
+		return math.Float64frombits(bits), nil
+	}
+	if d.raw || d.scale > maxDecScale {
+		return 0, errCorruptDecimal
+	}
+	return float64(d.n) / pow10[d.scale], nil
+}
@@ -16,6 +16,7 @@ import (
 // * it doesn't store an initial delta. instead, it assumes a starting delta of 60 and uses delta-of-delta
 //   encoding from the get-go.
 // * it uses a more compact way to mark end-of-stream
+// * it optionally uses decimal value compression instead of XOR compression (see tszdec.go)
 type SeriesLong struct {
 	sync.Mutex
 
@@ -30,6 +31,8 @@ type SeriesLong struct {
 	Finished bool // exposed for caller convenience. do NOT set directly.
 
 	tDelta uint32
+
+	dec *decState // nil unless decimal value compression is used
 }
 
 // New series
@@ -43,6 +46,18 @@ func NewSeriesLong(t0 uint32) *SeriesLong {
 
 }
 
+// NewSeriesLongDec creates a series that uses decimal value compression
+func NewSeriesLongDec(t0 uint32) *SeriesLong {
+	s := NewSeriesLong(t0)
+	s.dec = newDecState()
+	return s
+}
+
+// Decimal returns whether the series uses decimal value compression
+func (s *SeriesLong) Decimal() bool {
+	return s.dec != nil
+}
+
 // Bytes value of the series stream
 func (s *SeriesLong) Bytes() []byte {
 	s.Lock()
@@ -94,6 +109,12 @@ func (s *SeriesLong) Push(t uint32, v float64) {
 	s.tDelta = tDelta
 	s.T = t
 
+	if s.dec != nil {
+		s.dec.push(&s.bw, v)
+		s.val = v
+		return
+	}
+
 	if first {
 		// first point; write full float value
 		s.bw.writeBits(math.Float64bits(v), 64)
@@ -147,6 +168,9 @@ func (s *SeriesLong) Iter() *IterLong {
 
 	finishV2(w)
 	iter, _ := bstreamIteratorLong(s.T0, w)
+	if s.dec != nil {
+		iter.dec = newDecState()
+	}
 	return iter
 }
 
@@ -165,6 +189,8 @@ type IterLong struct {
 
 	tDelta uint32
 	err    error
+
+	dec *decState // nil unless decimal value compression is used
 }
 
 func bstreamIteratorLong(t0 uint32, br *bstream) (*IterLong, error) {
@@ -183,6 +209,16 @@ func NewIteratorLong(t0 uint32, b []byte) (*IterLong, error) {
 	return bstreamIteratorLong(t0, newBReader(b))
 }
 
+// NewIteratorLongDec for the series that uses decimal value compression
+func NewIteratorLongDec(t0 uint32, b []byte) (*IterLong, error) {
+	it, err := bstreamIteratorLong(t0, newBReader(b))
+	if err != nil {
+		return nil, err
+	}
+	it.dec = newDecState()
+	return it, nil
+}
+
 func (it *IterLong) dod() (int32, bool) {
 	var d byte
 	for i := 0; i < 5; i++ {
@@ -259,6 +295,16 @@ func (it *IterLong) Next() bool {
 	it.tDelta += uint32(dod)
 	it.t = it.t + it.tDelta
 
+	if it.dec != nil {
+		v, err := it.dec.read(&it.br)
+		if err != nil {
+			it.err = err
+			return false
+		}
+		it.val = v
+		return true
+	}
+
 	if first {
 		// first point. read the float raw
 		v, err := it.br.readBits(64)
@@ -344,6 +390,13 @@ func (s *SeriesLong) MarshalBinary() ([]byte, error) {
 	em.write(s.tDelta)
 	em.write(s.trailing)
 	em.write(s.val)
+	em.write(s.dec != nil)
+	if s.dec != nil {
+		em.write(s.dec.raw)
+		em.write(s.dec.scale)
+		em.write(s.dec.n)
+		em.write(s.dec.delta)
+	}
 	bStream, err := s.bw.MarshalBinary()
 	if err != nil {
 		return nil, err
@@ -365,6 +418,15 @@ func (s *SeriesLong) UnmarshalBinary(b []byte) error {
 	em.read(&s.tDelta)
 	em.read(&s.trailing)
 	em.read(&s.val)
+	var dec bool
+	em.read(&dec)
+	if dec {
+		s.dec = &decState{}
+		em.read(&s.dec.raw)
+		em.read(&s.dec.scale)
+		em.read(&s.dec.n)
+		em.read(&s.dec.delta)
+	}
 	outBuf := make([]byte, buf.Len())
 	em.read(outBuf)
 	err := s.bw.UnmarshalBinary(outBuf)
@@ -13,6 +13,7 @@ import (
 type Metrics interface {
 	Get(key schema.MKey) (Metric, bool)
 	GetOrCreate(key schema.MKey, schemaId, aggId uint16, interval uint32) Metric
+	Reconfigure()
 }
 
 type Metric interface {
@@ -55,7 +55,8 @@ func NewArchiveRequest(w *whisper.Whisper, schemas conf.Schemas, file, name stri
 	}
 	res.MetricData.SetId()
 
-	_, selectedSchema := schemas.Match(res.MetricData.Name, int(w.Header.Archives[0].SecondsPerPoint))
+	// the org is only known once the writer receives the request, so only the schemas that are not scoped to an org apply
+	_, selectedSchema := schemas.Match(0, res.MetricData.Name, int(w.Header.Archives[0].SecondsPerPoint))
 	converter := newConverter(w.Header.Archives, points, method, from, until)
 	for retIdx, retention := range selectedSchema.Retentions.Rets {
 		convertedPoints := converter.getPoints(retIdx, uint32(retention.SecondsPerPoint), uint32(retention.NumberOfPoints))
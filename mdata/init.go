@@ -5,7 +5,9 @@ package mdata
 
 import (
 	"flag"
+	"fmt"
 	"io/ioutil"
+	"sync/atomic"
 
 	"github.com/grafana/globalconf"
 	"github.com/grafana/metrictank/conf"
@@ -69,6 +71,9 @@ var (
 	// metric tank.metrics_active is the number of currently known metrics (excl rollup series), measured every second
 	metricsActive = stats.NewGauge32("tank.metrics_active")
 
+	// metric tank.metrics_reconfigured is the number of metrics that switched over to new settings after the rules were reloaded
+	metricsReconfigured = stats.NewCounter32("tank.metrics_reconfigured")
+
 	// metric tank.gc_metric is the number of times the metrics GC is about to inspect a metric (series)
 	gcMetric = stats.NewCounter32("tank.gc_metric")
 
@@ -80,9 +85,11 @@ var (
 	// with an incorrect aggspan specified
 	badAggSpan = stats.NewCounter32("recovered_errors.aggmetric.getaggregated.bad-aggspan")
 
-	// set either via ConfigProcess or from the unit tests. other code should not touch
-	Aggregations conf.Aggregations
-	Schemas      conf.Schemas
+	// set either via ConfigProcess, when the rules are reloaded, or from the unit tests. other code should not touch.
+	// they hold a *conf.Schemas and *conf.Aggregations which are replaced as a whole, never modified,
+	// so that readers always see a consistent set of rules. see Schemas() and Aggregations()
+	currentSchemas      atomic.Value
+	currentAggregations atomic.Value
 
 	schemasFile = "/etc/metrictank/storage-schemas.conf"
 	aggFile     = "/etc/metrictank/storage-aggregation.conf"
@@ -100,6 +107,11 @@ var (
 	}, []string{"reason", "org"})
 )
 
+func init() {
+	currentSchemas.Store(&conf.Schemas{})
+	currentAggregations.Store(&conf.Aggregations{})
+}
+
 func ConfigSetup() {
 	retentionConf := flag.NewFlagSet("retention", flag.ExitOnError)
 	retentionConf.StringVar(&schemasFile, "schemas-file", "/etc/metrictank/storage-schemas.conf", "path to storage-schemas.conf file")
@@ -108,7 +120,16 @@ func ConfigSetup() {
 }
 
 func ConfigProcess() {
-	var err error
+	schemas, aggregations, err := ReadRules()
+	if err != nil {
+		log.Fatal(err.Error())
+	}
+	SetSchemas(schemas)
+	SetAggregations(aggregations)
+}
+
+// ReadRules reads and validates the configured storage-schemas.conf and storage-aggregation.conf files
+func ReadRules() (conf.Schemas, conf.Aggregations, error) {
 
 	// === read storage-schemas.conf ===
 
@@ -116,9 +137,9 @@ func ConfigProcess() {
 	// at the end, add a default schema of 7 days of minutely data.
 	// we are stricter and don't tolerate any errors, that seems in the user's best interest.
 
-	Schemas, err = conf.ReadSchemas(schemasFile)
+	schemas, err := conf.ReadSchemas(schemasFile)
 	if err != nil {
-		log.Fatalf("can't read schemas file %q: %s", schemasFile, err.Error())
+		return conf.Schemas{}, conf.Aggregations{}, fmt.Errorf("can't read schemas file %q: %s", schemasFile, err.Error())
 	}
 
 	// === read storage-aggregation.conf ===
@@ -130,13 +151,13 @@ func ConfigProcess() {
 
 	// since we can't distinguish errors reading vs parsing, we'll just try a read separately first
 	_, err = ioutil.ReadFile(aggFile)
-	if err == nil {
-		Aggregations, err = conf.ReadAggregations(aggFile)
-		if err != nil {
-			log.Fatalf("can't read storage-aggregation file %q: %s", aggFile, err.Error())
-		}
-	} else {
+	if err != nil {
 		log.Infof("Could not read %s: %s: using defaults", aggFile, err)
-		Aggregations = conf.NewAggregations()
+		return schemas, conf.NewAggregations(), nil
+	}
+	aggregations, err := conf.ReadAggregations(aggFile)
+	if err != nil {
+		return conf.Schemas{}, conf.Aggregations{}, fmt.Errorf("can't read storage-aggregation file %q: %s", aggFile, err.Error())
 	}
+	return schemas, aggregations, nil
 }
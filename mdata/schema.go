@@ -1,37 +1,81 @@
 package mdata
 
 import (
+	"fmt"
+
 	"github.com/grafana/metrictank/conf"
 )
 
+// Schemas returns the current storage schemas.
+// callers that do multiple lookups should call it once, so they all use the same set of schemas.
+func Schemas() *conf.Schemas {
+	return currentSchemas.Load().(*conf.Schemas)
+}
+
+// SetSchemas replaces the current storage schemas
+func SetSchemas(schemas conf.Schemas) {
+	currentSchemas.Store(&schemas)
+}
+
+// Aggregations returns the current storage aggregations.
+// callers that do multiple lookups should call it once, so they all use the same set of aggregations.
+func Aggregations() *conf.Aggregations {
+	return currentAggregations.Load().(*conf.Aggregations)
+}
+
+// SetAggregations replaces the current storage aggregations
+func SetAggregations(aggregations conf.Aggregations) {
+	currentAggregations.Store(&aggregations)
+}
+
 func MaxChunkSpan() uint32 {
-	return Schemas.MaxChunkSpan()
+	return Schemas().MaxChunkSpan()
 }
 
 // TTLs returns the full set of unique TTLs (in seconds) used by the current schema config.
 func TTLs() []uint32 {
-	return Schemas.TTLs()
+	return Schemas().TTLs()
+}
+
+// CheckReloadedSchemas returns an error if the given schemas, which are to replace the current ones,
+// can't be supported by the store, which was set up for the TTLs and max chunkspan of the current ones.
+func CheckReloadedSchemas(schemas conf.Schemas) error {
+	current := make(map[uint32]struct{})
+	for _, ttl := range TTLs() {
+		current[ttl] = struct{}{}
+	}
+	for _, ttl := range schemas.TTLs() {
+		if _, ok := current[ttl]; !ok {
+			return fmt.Errorf("ttl %d is not used by the current schemas. adding ttls requires a restart", ttl)
+		}
+	}
+	if schemas.MaxChunkSpan() > MaxChunkSpan() {
+		return fmt.Errorf("max chunkspan %d exceeds the max chunkspan %d of the current schemas. increasing it requires a restart", schemas.MaxChunkSpan(), MaxChunkSpan())
+	}
+	return nil
 }
 
-// MatchAgg returns the aggregation definition for the given metric key, and the index of it (to efficiently reference it)
+// MatchAgg returns the aggregation definition for the given metric key (name with tags) of the given org, and the index of it (to efficiently reference it)
 // it will always find the aggregation definition because Aggregations has a catchall default
-func MatchAgg(key string) (uint16, conf.Aggregation) {
-	return Aggregations.Match(key)
+func MatchAgg(orgId uint32, key string) (uint16, conf.Aggregation) {
+	return Aggregations().Match(orgId, key)
 }
 
-// MatchSchema returns the schema for the given metric key, and the index of the schema (to efficiently reference it)
+// MatchSchema returns the schema for the given metric key (name with tags) of the given org, and the index of the schema (to efficiently reference it)
 // it will always find the schema because Schemas has a catchall default
-func MatchSchema(key string, interval int) (uint16, conf.Schema) {
-	return Schemas.Match(key, interval)
+func MatchSchema(orgId uint32, key string, interval int) (uint16, conf.Schema) {
+	return Schemas().Match(orgId, key, interval)
 }
 
 func SetSingleSchema(ret conf.Retentions) {
-	Schemas = conf.NewSchemas(nil)
-	Schemas.DefaultSchema.Retentions = ret
-	Schemas.BuildIndex()
+	schemas := conf.NewSchemas(nil)
+	schemas.DefaultSchema.Retentions = ret
+	schemas.BuildIndex()
+	SetSchemas(schemas)
 }
 
 func SetSingleAgg(met ...conf.Method) {
-	Aggregations = conf.NewAggregations()
-	Aggregations.DefaultAggregation.AggregationMethod = met
+	aggregations := conf.NewAggregations()
+	aggregations.DefaultAggregation.AggregationMethod = met
+	SetAggregations(aggregations)
 }
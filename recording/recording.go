@@ -0,0 +1,233 @@
+// Package recording evaluates the recording rules defined in the recording rules file,
+// and ingests their results as new series
+package recording
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"math"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/grafana/globalconf"
+	"github.com/grafana/metrictank/api/models"
+	"github.com/grafana/metrictank/cluster"
+	"github.com/grafana/metrictank/conf"
+	"github.com/grafana/metrictank/expr"
+	"github.com/grafana/metrictank/input"
+	"github.com/grafana/metrictank/schema"
+	"github.com/grafana/metrictank/stats"
+	log "github.com/sirupsen/logrus"
+)
+
+var (
+	rulesFile string
+
+	rules []*rule
+
+	// metric recording.evaluations is the number of evaluations of recording rules
+	evaluations = stats.NewCounter32("recording.evaluations")
+
+	// metric recording.evaluation_failures is the number of evaluations of recording rules that failed
+	evaluationFailures = stats.NewCounter32("recording.evaluation_failures")
+
+	// metric recording.evaluation_duration is how long it takes to evaluate a recording rule
+	evaluationDuration = stats.NewLatencyHistogram15s32("recording.evaluation_duration")
+
+	// metric recording.points_written is the number of points written by recording rules
+	pointsWritten = stats.NewCounter32("recording.points_written")
+)
+
+func ConfigSetup() {
+	recordingCfg := flag.NewFlagSet("recording-rules", flag.ExitOnError)
+	recordingCfg.StringVar(&rulesFile, "file", "", "path to the file defining the recording rules. empty disables recording rules")
+	globalconf.Register("recording-rules", recordingCfg, flag.ExitOnError)
+}
+
+func ConfigProcess() {
+	if rulesFile == "" {
+		return
+	}
+	confRules, err := conf.ReadRecordingRules(rulesFile)
+	if err != nil {
+		log.Fatalf("recording-rules: can't read recording rules file %q: %s", rulesFile, err.Error())
+	}
+	for _, r := range confRules {
+		if _, err := expr.ParseMany([]string{r.Expr}); err != nil {
+			log.Fatalf("recording-rules: [%s]: failed to parse expr %q: %s", r.Name, r.Expr, err.Error())
+		}
+		rules = append(rules, &rule{RecordingRule: r})
+	}
+}
+
+// Executor executes the plan of a graphite expression, like a render request does
+type Executor interface {
+	ExecutePlan(ctx context.Context, orgId uint32, plan expr.Plan) ([]models.Series, error)
+}
+
+// Start starts evaluating the recording rules, using executor, and ingesting their results through handler.
+// every rule is evaluated by the ready primary nodes of its partition, at the end of every interval, after its delay.
+func Start(executor Executor, handler input.Handler) {
+	for _, r := range rules {
+		go r.run(executor, handler)
+	}
+}
+
+// Status returns the status of all recording rules
+func Status() []models.RecordingRuleStatus {
+	statuses := make([]models.RecordingRuleStatus, 0, len(rules))
+	for _, r := range rules {
+		statuses = append(statuses, r.status())
+	}
+	return statuses
+}
+
+type rule struct {
+	conf.RecordingRule
+
+	sync.Mutex
+	lastEvaluation time.Time
+	lastDuration   time.Duration
+	lastError      error
+	seriesWritten  int
+	pointsWritten  int
+}
+
+func (r *rule) status() models.RecordingRuleStatus {
+	status := models.RecordingRuleStatus{
+		Name:      r.Name,
+		Expr:      r.Expr,
+		Series:    r.Series,
+		OrgId:     r.OrgId,
+		Interval:  r.Interval,
+		Partition: r.Partition,
+		Active:    r.active(),
+	}
+	r.Lock()
+	status.LastEvaluation = r.lastEvaluation
+	status.LastDuration = r.lastDuration
+	if r.lastError != nil {
+		status.LastError = r.lastError.Error()
+	}
+	status.SeriesWritten = r.seriesWritten
+	status.PointsWritten = r.pointsWritten
+	r.Unlock()
+	return status
+}
+
+// active returns whether this node should evaluate the rule
+func (r *rule) active() bool {
+	if !cluster.Manager.IsPrimary() || !cluster.Manager.IsReady() {
+		return false
+	}
+	for _, p := range cluster.Manager.GetPartitions() {
+		if p == r.Partition {
+			return true
+		}
+	}
+	return false
+}
+
+// run evaluates the rule at the end of every interval, after the delay
+func (r *rule) run(executor Executor, handler input.Handler) {
+	for {
+		now := uint32(time.Now().Unix())
+		to := (now-r.Delay)/r.Interval*r.Interval + r.Interval
+		time.Sleep(time.Until(time.Unix(int64(to+r.Delay), 0)))
+		if !r.active() {
+			continue
+		}
+		r.evaluate(executor, handler, to)
+	}
+}
+
+// evaluate evaluates the rule for the interval ending at to, and ingests the results
+func (r *rule) evaluate(executor Executor, handler input.Handler, to uint32) {
+	pre := time.Now()
+	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(r.Interval)*time.Second)
+	series, points, err := r.record(ctx, executor, handler, to-r.Interval, to)
+	cancel()
+	duration := time.Since(pre)
+
+	evaluations.Inc()
+	evaluationDuration.Value(duration)
+	pointsWritten.Add(points)
+	if err != nil {
+		evaluationFailures.Inc()
+		log.Errorf("recording-rules: [%s]: evaluation failed: %s", r.Name, err.Error())
+	}
+
+	r.Lock()
+	r.lastEvaluation = pre
+	r.lastDuration = duration
+	r.lastError = err
+	r.seriesWritten = series
+	r.pointsWritten = points
+	r.Unlock()
+}
+
+// record evaluates the expression over the range from (inclusive) - to (exclusive), and ingests the points
+// of the results in that range. It returns the number of series and points written.
+// results that map to the same series as an earlier result are not written, and make record return an error.
+func (r *rule) record(ctx context.Context, executor Executor, handler input.Handler, from, to uint32) (int, int, error) {
+	exprs, err := expr.ParseMany([]string{r.Expr})
+	if err != nil {
+		return 0, 0, err
+	}
+	plan, err := expr.NewPlan(exprs, from, to, 0, true, nil)
+	if err != nil {
+		return 0, 0, err
+	}
+	defer plan.Clean()
+	out, err := executor.ExecutePlan(ctx, r.OrgId, plan)
+	if err != nil {
+		return 0, 0, err
+	}
+	if ctx.Err() != nil {
+		return 0, 0, ctx.Err()
+	}
+
+	var series, points int
+	seen := make(map[string]string)
+	for _, s := range out {
+		md := r.metricData(s)
+		if target, ok := seen[md.Id]; ok {
+			err = fmt.Errorf("results %q and %q both map to series %q", target, s.Target, md.Id)
+			continue
+		}
+		seen[md.Id] = s.Target
+		series++
+		for _, p := range s.Datapoints {
+			if p.Ts < from || p.Ts >= to || math.IsNaN(p.Val) {
+				continue
+			}
+			point := md
+			point.Time = int64(p.Ts)
+			point.Value = p.Val
+			handler.ProcessMetricData(&point, r.Partition)
+			points++
+		}
+	}
+	return series, points, err
+}
+
+// metricData returns the metric data, without time and value, of the series that the given result is written to
+func (r *rule) metricData(s models.Series) schema.MetricData {
+	md := schema.MetricData{
+		OrgId:    int(r.OrgId),
+		Name:     r.Series,
+		Interval: int(s.Interval),
+		Unit:     "unknown",
+		Mtype:    "gauge",
+	}
+	for key, val := range s.Tags {
+		if key != "name" {
+			md.Tags = append(md.Tags, key+"="+val)
+		}
+	}
+	sort.Strings(md.Tags)
+	md.SetId()
+	return md
+}
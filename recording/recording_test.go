@@ -0,0 +1,166 @@
+package recording
+
+import (
+	"context"
+	"errors"
+	"math"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/grafana/metrictank/api/models"
+	"github.com/grafana/metrictank/cluster"
+	"github.com/grafana/metrictank/conf"
+	"github.com/grafana/metrictank/expr"
+	"github.com/grafana/metrictank/schema"
+	"github.com/grafana/metrictank/schema/msg"
+)
+
+type mockExecutor struct {
+	out []models.Series
+	err error
+}
+
+func (e mockExecutor) ExecutePlan(ctx context.Context, orgId uint32, plan expr.Plan) ([]models.Series, error) {
+	return e.out, e.err
+}
+
+type ingested struct {
+	md        schema.MetricData
+	partition int32
+}
+
+type mockHandler struct {
+	ingested []ingested
+}
+
+func (h *mockHandler) ProcessMetricData(md *schema.MetricData, partition int32) {
+	h.ingested = append(h.ingested, ingested{*md, partition})
+}
+
+func (h *mockHandler) ProcessMetricPoint(point schema.MetricPoint, format msg.Format, partition int32) {
+}
+
+func newTestRule() *rule {
+	return &rule{
+		RecordingRule: conf.RecordingRule{
+			Name:      "test",
+			Expr:      "groupByTags(seriesByTag('name=requests'), 'sum', 'dc')",
+			Series:    "requests.by_dc",
+			OrgId:     2,
+			Interval:  60,
+			Partition: 3,
+		},
+	}
+}
+
+func TestRecord(t *testing.T) {
+	r := newTestRule()
+	executor := mockExecutor{
+		out: []models.Series{
+			{
+				Target:   "requests;dc=us",
+				Tags:     map[string]string{"name": "requests", "dc": "us"},
+				Interval: 10,
+				Datapoints: []schema.Point{
+					{Val: 1, Ts: 50},
+					{Val: 2, Ts: 60},
+					{Val: math.NaN(), Ts: 70},
+					{Val: 3, Ts: 110},
+					{Val: 4, Ts: 120},
+				},
+			},
+			{
+				Target:     "requests;dc=eu",
+				Tags:       map[string]string{"name": "requests", "dc": "eu"},
+				Interval:   10,
+				Datapoints: []schema.Point{{Val: 5, Ts: 60}},
+			},
+		},
+	}
+	handler := &mockHandler{}
+
+	series, points, err := r.record(context.Background(), executor, handler, 60, 120)
+	if err != nil {
+		t.Fatalf("expected no error, got %s", err)
+	}
+	if series != 2 || points != 3 {
+		t.Fatalf("expected 2 series and 3 points to be written, got %d and %d", series, points)
+	}
+
+	exp := []struct {
+		name  string
+		tags  []string
+		ts    int64
+		value float64
+	}{
+		{"requests.by_dc", []string{"dc=us"}, 60, 2},
+		{"requests.by_dc", []string{"dc=us"}, 110, 3},
+		{"requests.by_dc", []string{"dc=eu"}, 60, 5},
+	}
+	for i, e := range exp {
+		got := handler.ingested[i]
+		if got.md.Name != e.name || !reflect.DeepEqual(got.md.Tags, e.tags) || got.md.Time != e.ts || got.md.Value != e.value {
+			t.Fatalf("point %d: expected %s %v %d %f, got %s %v %d %f", i, e.name, e.tags, e.ts, e.value, got.md.Name, got.md.Tags, got.md.Time, got.md.Value)
+		}
+		if got.md.OrgId != 2 || got.md.Interval != 10 || got.partition != 3 {
+			t.Fatalf("point %d: expected org 2, interval 10 and partition 3, got %d, %d and %d", i, got.md.OrgId, got.md.Interval, got.partition)
+		}
+		if err := got.md.Validate(); err != nil {
+			t.Fatalf("point %d: expected a valid metric, got %s", i, err)
+		}
+	}
+}
+
+func TestRecordDuplicateSeries(t *testing.T) {
+	r := newTestRule()
+	executor := mockExecutor{
+		out: []models.Series{
+			{
+				Target:     "requests;dc=us;host=a",
+				Tags:       map[string]string{"name": "requests", "dc": "us"},
+				Interval:   10,
+				Datapoints: []schema.Point{{Val: 1, Ts: 60}},
+			},
+			{
+				Target:     "requests;dc=us;host=b",
+				Tags:       map[string]string{"name": "requests.other", "dc": "us"},
+				Interval:   10,
+				Datapoints: []schema.Point{{Val: 2, Ts: 60}},
+			},
+		},
+	}
+	handler := &mockHandler{}
+
+	series, points, err := r.record(context.Background(), executor, handler, 60, 120)
+	if err == nil {
+		t.Fatalf("expected an error for results mapping to the same series")
+	}
+	if series != 1 || points != 1 || handler.ingested[0].md.Value != 1 {
+		t.Fatalf("expected only the first result to be written, got %d series and %d points", series, points)
+	}
+}
+
+func TestEvaluateStatus(t *testing.T) {
+	cluster.Init("default", "test", time.Now(), "http", 6060)
+	cluster.Manager.SetPrimary(true)
+	cluster.Manager.SetPriority(0)
+	cluster.Manager.SetReady()
+	cluster.Manager.SetPartitions([]int32{3})
+
+	r := newTestRule()
+	r.evaluate(mockExecutor{err: errors.New("boom")}, &mockHandler{}, 120)
+
+	status := r.status()
+	if !status.Active {
+		t.Fatalf("expected the rule to be active on a ready primary of its partition")
+	}
+	if status.LastError != "boom" || status.LastEvaluation.IsZero() {
+		t.Fatalf("expected the status to report the last evaluation and its error, got %v", status)
+	}
+
+	cluster.Manager.SetPrimary(false)
+	if r.status().Active {
+		t.Fatalf("expected the rule not to be active on a secondary")
+	}
+}
@@ -61,6 +61,10 @@ Metrictank comes with an [example main config file](https://github.com/grafana/m
 a [storage-schemas.conf file](https://github.com/grafana/metrictank/blob/master/scripts/config/storage-schemas.conf) and
 a [storage-aggregation.conf file](https://github.com/grafana/metrictank/blob/master/scripts/config/storage-aggregation.conf)
 an [index-rules.conf file](https://github.com/grafana/metrictank/blob/master/scripts/config/index-rules.conf)
+an [api-keys.conf file](https://github.com/grafana/metrictank/blob/master/scripts/config/api-keys.conf)
+a [limits.conf file](https://github.com/grafana/metrictank/blob/master/scripts/config/limits.conf)
+a [recording-rules.conf file](https://github.com/grafana/metrictank/blob/master/scripts/config/recording-rules.conf)
+an [alerting-rules.conf file](https://github.com/grafana/metrictank/blob/master/scripts/config/alerting-rules.conf)
 
 The files themselves are well documented, but for your convenience, they are replicated below.  
 
@@ -98,6 +102,46 @@ cat scripts/config/index-rules.conf
 cat << EOF
 \`\`\`
 
+# api-keys.conf
+
+\`\`\`
+EOF
+
+cat scripts/config/api-keys.conf
+
+cat << EOF
+\`\`\`
+
+# limits.conf
+
+\`\`\`
+EOF
+
+cat scripts/config/limits.conf
+
+cat << EOF
+\`\`\`
+
+# recording-rules.conf
+
+\`\`\`
+EOF
+
+cat scripts/config/recording-rules.conf
+
+cat << EOF
+\`\`\`
+
+# alerting-rules.conf
+
+\`\`\`
+EOF
+
+cat scripts/config/alerting-rules.conf
+
+cat << EOF
+\`\`\`
+
 # storage-aggregation.conf
 
 \`\`\`
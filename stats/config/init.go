@@ -7,6 +7,7 @@ import (
 
 	"github.com/grafana/globalconf"
 	"github.com/grafana/metrictank/stats"
+	"github.com/prometheus/client_golang/prometheus"
 	log "github.com/sirupsen/logrus"
 )
 
@@ -16,6 +17,11 @@ var addr string
 var interval int
 var bufferSize int
 var timeout time.Duration
+var promEnabled bool
+var selfOrgId int
+var selfPartition int
+
+var self *stats.Self
 
 func ConfigSetup() {
 	inStats := flag.NewFlagSet("stats", flag.ExitOnError)
@@ -25,28 +31,56 @@ func ConfigSetup() {
 	inStats.IntVar(&interval, "interval", 1, "interval at which to send statistics")
 	inStats.DurationVar(&timeout, "timeout", time.Second*10, "timeout after which a write is considered not successful")
 	inStats.IntVar(&bufferSize, "buffer-size", 20000, "how many messages (holding all measurements from one interval. rule of thumb: a message is ~25kB) to buffer up in case graphite endpoint is unavailable. With the default of 20k you will use max about 500MB and bridge 5 hours of downtime when needed")
+	inStats.BoolVar(&promEnabled, "prometheus", false, "expose the stats in prometheus format on the /prometheus/metrics endpoint")
+	inStats.IntVar(&selfOrgId, "self-org-id", 0, "ingest the stats, with the prefix, into this org of metrictank itself, so that it can monitor itself without a separate graphite. only on nodes that ingest data. 0 to disable")
+	inStats.IntVar(&selfPartition, "self-partition", 0, "partition to ingest the stats under, when self-org-id is set. should be one of the partitions of the node")
 	globalconf.Register("stats", inStats, flag.ExitOnError)
 }
 
 func ConfigProcess(instance string) {
-	if !enabled {
-		return
+	if selfOrgId < 0 || selfPartition < 0 {
+		log.Fatal("stats: self-org-id and self-partition must not be negative")
+	}
+	if interval <= 0 {
+		log.Fatal("stats: interval must be positive")
 	}
 	// TODO validate tcp addr
 	prefix = strings.Replace(prefix, "$instance", instance, -1)
 }
 
 func Start() {
+	var outputs []stats.Output
 	if enabled {
+		outputs = append(outputs, stats.NewGraphite(prefix, addr, bufferSize, timeout))
+	}
+	if promEnabled {
+		out := stats.NewPrometheus()
+		prometheus.MustRegister(out)
+		outputs = append(outputs, out)
+	}
+	if selfOrgId != 0 {
+		self = stats.NewSelf(prefix, selfOrgId, interval, int32(selfPartition))
+		outputs = append(outputs, self)
+	}
+
+	if len(outputs) > 0 {
 		stats.NewMemoryReporter()
 
 		_, err := stats.NewProcessReporter()
 		if err != nil {
 			log.Fatalf("stats: could not initialize process reporter: %v", err)
 		}
-		stats.NewGraphite(prefix, addr, interval, bufferSize, timeout)
+		stats.NewReporter(interval, outputs...)
 	} else {
 		stats.NewDevnull()
 		log.Warn("running metrictank without instrumentation.")
 	}
 }
+
+// SetSelfHandler sets the handler through which the self output ingests the stats.
+// it does nothing if the self output is disabled.
+func SetSelfHandler(handler stats.MetricDataHandler) {
+	if self != nil {
+		self.SetHandler(handler)
+	}
+}
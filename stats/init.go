@@ -1,12 +1,13 @@
 // Package stats provides functionality for instrumenting metrics and reporting them
 //
 // The metrics can be user specified, or sourced from the runtime (reporters)
-// To use this package correctly, you must instantiate exactly 1 output.
-// If you use 0 outputs, certain metrics type will accumulate data unboundedly
+// To use this package correctly, you must instantiate exactly 1 reporter (NewReporter), or DevNull.
+// If you use neither, certain metrics type will accumulate data unboundedly
 // (e.g. histograms and meters) resulting in unreasonable memory usage.
 // (though you can ignore this for shortlived processes, unit tests, etc)
-// If you use >1 outputs, then each will only see a partial view of the stats.
-// Currently supported outputs are DevNull and Graphite
+// If you use more, then each will only see a partial view of the stats.
+// A reporter reports the stats to any number of outputs.
+// Currently supported outputs are Graphite, Prometheus and Self
 package stats
 
 var registry *Registry
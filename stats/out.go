@@ -0,0 +1,71 @@
+package stats
+
+import (
+	"bytes"
+	"strconv"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+var genDataDuration *Gauge32
+
+// Output is a destination the stats are reported to
+type Output interface {
+	// Report reports the stats of an interval, in graphite line format, without prefix.
+	// buf is reused for the next interval, so it must not be referenced after Report returns.
+	Report(buf []byte, now time.Time)
+}
+
+// NewReporter starts reporting the stats to all the given outputs, every interval seconds.
+// as reporting resets some of the stats (e.g. histograms and meters), it must be
+// the only reporter, so that all outputs see the same, full view of the stats.
+func NewReporter(interval int, outputs ...Output) {
+	// metric stats.generate_message is how long it takes to generate the stats
+	genDataDuration = NewGauge32("stats.generate_message.duration")
+
+	go func() {
+		ticker := tick(time.Duration(interval) * time.Second)
+		var buf []byte
+		var fullPrefix bytes.Buffer
+		for now := range ticker {
+			log.Debugf("stats flushing for %s to %d outputs", now, len(outputs))
+			pre := time.Now()
+
+			buf = buf[:0]
+			for name, metric := range registry.list() {
+				fullPrefix.Reset()
+				fullPrefix.WriteString(name)
+				fullPrefix.WriteRune('.')
+				buf = metric.ReportGraphite(fullPrefix.Bytes(), buf, now)
+			}
+			genDataDuration.Set(int(time.Since(pre).Nanoseconds()))
+
+			for _, out := range outputs {
+				out.Report(buf, now)
+			}
+		}
+	}()
+}
+
+// eachLine calls fn for every measurement of the graphite line format in buf.
+// name is only valid for the duration of the call
+func eachLine(buf []byte, fn func(name []byte, val float64)) {
+	for len(buf) > 0 {
+		var line []byte
+		if i := bytes.IndexByte(buf, '\n'); i >= 0 {
+			line, buf = buf[:i], buf[i+1:]
+		} else {
+			line, buf = buf, nil
+		}
+		fields := bytes.Fields(line)
+		if len(fields) != 3 {
+			continue
+		}
+		val, err := strconv.ParseFloat(string(fields[1]), 64)
+		if err != nil {
+			continue
+		}
+		fn(fields[0], val)
+	}
+}
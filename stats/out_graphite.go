@@ -11,11 +11,10 @@ import (
 )
 
 var (
-	queueItems      *Range32
-	genDataDuration *Gauge32
-	flushDuration   *LatencyHistogram15s32
-	messageSize     *Gauge32
-	connected       *Bool
+	queueItems    *Range32
+	flushDuration *LatencyHistogram15s32
+	messageSize   *Gauge32
+	connected     *Bool
 )
 
 type GraphiteMetric interface {
@@ -23,6 +22,7 @@ type GraphiteMetric interface {
 	ReportGraphite(prefix []byte, buf []byte, now time.Time) []byte
 }
 
+// Graphite is an output that sends the stats to a graphite endpoint
 type Graphite struct {
 	prefix []byte
 	addr   string
@@ -31,14 +31,12 @@ type Graphite struct {
 	toGraphite chan []byte
 }
 
-func NewGraphite(prefix, addr string, interval, bufferSize int, timeout time.Duration) {
+func NewGraphite(prefix, addr string, bufferSize int, timeout time.Duration) *Graphite {
 	if len(prefix) != 0 && prefix[len(prefix)-1] != '.' {
 		prefix = prefix + "."
 	}
 	NewGauge32("stats.graphite.write_queue.size").Set(bufferSize)
 	queueItems = NewRange32("stats.graphite.write_queue.items")
-	// metric stats.generate_message is how long it takes to generate the stats
-	genDataDuration = NewGauge32("stats.generate_message.duration")
 	flushDuration = NewLatencyHistogram15s32("stats.graphite.flush")
 	messageSize = NewGauge32("stats.message_size")
 	connected = NewBool("stats.graphite.connected")
@@ -50,37 +48,31 @@ func NewGraphite(prefix, addr string, interval, bufferSize int, timeout time.Dur
 		timeout:    timeout,
 	}
 	go g.writer()
-	go g.reporter(interval)
+	return g
 }
 
-func (g *Graphite) reporter(interval int) {
-	ticker := tick(time.Duration(interval) * time.Second)
-	for now := range ticker {
-		log.Debugf("stats flushing for %s to graphite", now)
-		queueItems.Value(len(g.toGraphite))
-		if cap(g.toGraphite) != 0 && len(g.toGraphite) == cap(g.toGraphite) {
-			// no space in buffer, no use in doing any work
-			continue
-		}
-
-		pre := time.Now()
-
-		buf := make([]byte, 0)
+// Report queues the stats, with our prefix, for writing to graphite
+func (g *Graphite) Report(buf []byte, now time.Time) {
+	queueItems.Value(len(g.toGraphite))
+	if cap(g.toGraphite) != 0 && len(g.toGraphite) == cap(g.toGraphite) {
+		// no space in buffer, no use in doing any work
+		return
+	}
 
-		var fullPrefix bytes.Buffer
-		for name, metric := range registry.list() {
-			fullPrefix.Reset()
-			fullPrefix.Write(g.prefix)
-			fullPrefix.WriteString(name)
-			fullPrefix.WriteRune('.')
-			buf = metric.ReportGraphite(fullPrefix.Bytes(), buf, now)
+	msg := make([]byte, 0, len(buf)+bytes.Count(buf, []byte{'\n'})*len(g.prefix))
+	for len(buf) > 0 {
+		i := bytes.IndexByte(buf, '\n') + 1
+		if i == 0 {
+			i = len(buf)
 		}
-
-		genDataDuration.Set(int(time.Since(pre).Nanoseconds()))
-		messageSize.Set(len(buf))
-		g.toGraphite <- buf
-		queueItems.Value(len(g.toGraphite))
+		msg = append(msg, g.prefix...)
+		msg = append(msg, buf[:i]...)
+		buf = buf[i:]
 	}
+
+	messageSize.Set(len(msg))
+	g.toGraphite <- msg
+	queueItems.Value(len(g.toGraphite))
 }
 
 // writer connects to graphite and submits all pending data to it
@@ -0,0 +1,182 @@
+package stats
+
+import (
+	"bytes"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// the summary statistics reported by histograms, meters and ranges, which become the "stat" label
+var promStats = map[string]struct{}{
+	"min":    {},
+	"mean":   {},
+	"median": {},
+	"p75":    {},
+	"p90":    {},
+	"max":    {},
+}
+
+// Prometheus is an output that exposes the stats as a prometheus.Collector,
+// which reports the stats of the last interval.
+// the dotted names are mapped to prometheus metrics as follows:
+//   - all names get the metrictank_ prefix, and dots become underscores
+//   - counter32 and counter64 become counters, with the _total suffix. all others are gauges
+//   - the rate32 of a CounterRate32 is dropped, as prometheus derives rates from the counter
+//   - summary statistics like latency.p90 become the stat label, e.g. metrictank_api_request_render_latency{stat="p90"}
+//   - numeric nodes become a label named after the node before it, e.g. api.request.render.status.200
+//     becomes metrictank_api_request_render_status_total{status="200"}
+type Prometheus struct {
+	sync.Mutex
+	metrics []prometheus.Metric
+}
+
+func NewPrometheus() *Prometheus {
+	return &Prometheus{}
+}
+
+// promMetric is a measurement, as mapped to prometheus
+type promMetric struct {
+	name        string
+	help        string
+	labelNames  []string
+	labelValues []string
+	counter     bool
+}
+
+// newPromMetric maps the graphite name of a measurement to prometheus
+func newPromMetric(graphiteName string) promMetric {
+	nodes := strings.Split(graphiteName, ".")
+	typ := nodes[len(nodes)-1]
+	nodes = nodes[:len(nodes)-1]
+	help := make([]string, len(nodes), len(nodes)+1)
+	copy(help, nodes)
+	help = append(help, typ)
+
+	var m promMetric
+	var nameNodes []string
+	for i, node := range nodes {
+		if i > 0 && isNumeric(node) {
+			key := sanitizePromName(nodes[i-1])
+			m.labelNames = append(m.labelNames, key)
+			m.labelValues = append(m.labelValues, node)
+			help[i] = "<" + key + ">"
+			continue
+		}
+		if _, ok := promStats[node]; ok && i == len(nodes)-1 {
+			m.labelNames = append(m.labelNames, "stat")
+			m.labelValues = append(m.labelValues, node)
+			help[i] = "<stat>"
+			continue
+		}
+		nameNodes = append(nameNodes, sanitizePromName(node))
+	}
+
+	switch typ {
+	case "counter32", "counter64":
+		m.counter = true
+		nameNodes = append(nameNodes, "total")
+	case "count32":
+		nameNodes = append(nameNodes, "count")
+	case "rate32":
+		nameNodes = append(nameNodes, "rate")
+	}
+	m.name = "metrictank_" + strings.Join(nameNodes, "_")
+	m.help = "metrictank stat " + strings.Join(help, ".")
+	return m
+}
+
+func isNumeric(s string) bool {
+	if s == "" {
+		return false
+	}
+	for _, c := range s {
+		if c < '0' || c > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+func sanitizePromName(s string) string {
+	return strings.Map(func(c rune) rune {
+		if (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || (c >= '0' && c <= '9') || c == '_' {
+			return c
+		}
+		return '_'
+	}, s)
+}
+
+// Report converts the stats to prometheus metrics, which replace those of the previous interval
+func (p *Prometheus) Report(buf []byte, now time.Time) {
+	type measurement struct {
+		name string
+		val  float64
+	}
+	var measurements []measurement
+	counters := make(map[string]struct{})
+	eachLine(buf, func(name []byte, val float64) {
+		measurements = append(measurements, measurement{string(name), val})
+		if bytes.HasSuffix(name, []byte(".counter32")) {
+			counters[string(name[:len(name)-len("counter32")])] = struct{}{}
+		}
+	})
+
+	// a scrape fails entirely if it has inconsistent metrics, so we only keep the metrics
+	// that are consistent with the first one of the same name, and are not duplicates of it.
+	type family struct {
+		help       string
+		labelNames string
+		series     map[string]struct{}
+	}
+	families := make(map[string]family)
+
+	metrics := make([]prometheus.Metric, 0, len(measurements))
+	for _, meas := range measurements {
+		if strings.HasSuffix(meas.name, ".rate32") {
+			if _, ok := counters[meas.name[:len(meas.name)-len("rate32")]]; ok {
+				continue
+			}
+		}
+		m := newPromMetric(meas.name)
+		f, ok := families[m.name]
+		if !ok {
+			f = family{m.help, strings.Join(m.labelNames, ","), make(map[string]struct{})}
+			families[m.name] = f
+		}
+		labelValues := strings.Join(m.labelValues, ",")
+		if _, ok := f.series[labelValues]; ok || f.help != m.help || f.labelNames != strings.Join(m.labelNames, ",") {
+			continue
+		}
+		f.series[labelValues] = struct{}{}
+		valueType := prometheus.GaugeValue
+		if m.counter {
+			valueType = prometheus.CounterValue
+		}
+		metric, err := prometheus.NewConstMetric(prometheus.NewDesc(m.name, m.help, m.labelNames, nil), valueType, meas.val, m.labelValues...)
+		if err != nil {
+			continue
+		}
+		metrics = append(metrics, metric)
+	}
+
+	p.Lock()
+	p.metrics = metrics
+	p.Unlock()
+}
+
+// Describe sends no descriptors, which makes this an unchecked collector,
+// as the stats that exist are only known once they are reported.
+func (p *Prometheus) Describe(ch chan<- *prometheus.Desc) {}
+
+// Collect sends the stats of the last interval
+func (p *Prometheus) Collect(ch chan<- prometheus.Metric) {
+	p.Lock()
+	metrics := p.metrics
+	p.Unlock()
+	for _, m := range metrics {
+		ch <- m
+	}
+}
@@ -0,0 +1,120 @@
+package stats
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+func TestNewPromMetric(t *testing.T) {
+	cases := []struct {
+		in  string
+		exp promMetric
+	}{
+		{
+			"cluster.self.partitions.gauge32",
+			promMetric{name: "metrictank_cluster_self_partitions", help: "metrictank stat cluster.self.partitions.gauge32"},
+		},
+		{
+			"tank.discarded.sample-out-of-order.counter32",
+			promMetric{name: "metrictank_tank_discarded_sample_out_of_order_total", help: "metrictank stat tank.discarded.sample-out-of-order.counter32", counter: true},
+		},
+		{
+			"api.request.render.status.200.counter32",
+			promMetric{
+				name:        "metrictank_api_request_render_status_total",
+				help:        "metrictank stat api.request.render.status.<status>.counter32",
+				labelNames:  []string{"status"},
+				labelValues: []string{"200"},
+				counter:     true,
+			},
+		},
+		{
+			"api.request.render.latency.p90.gauge32",
+			promMetric{
+				name:        "metrictank_api_request_render_latency",
+				help:        "metrictank stat api.request.render.latency.<stat>.gauge32",
+				labelNames:  []string{"stat"},
+				labelValues: []string{"p90"},
+			},
+		},
+		{
+			"api.request.render.values.count32",
+			promMetric{name: "metrictank_api_request_render_values_count", help: "metrictank stat api.request.render.values.count32"},
+		},
+		{
+			"store.cassandra.write_queue.3.items.max.gauge32",
+			promMetric{
+				name:        "metrictank_store_cassandra_write_queue_items",
+				help:        "metrictank stat store.cassandra.write_queue.<write_queue>.items.<stat>.gauge32",
+				labelNames:  []string{"write_queue", "stat"},
+				labelValues: []string{"3", "max"},
+			},
+		},
+	}
+	for _, c := range cases {
+		got := newPromMetric(c.in)
+		if !reflect.DeepEqual(got, c.exp) {
+			t.Fatalf("%s: expected %+v, got %+v", c.in, c.exp, got)
+		}
+	}
+}
+
+func TestPrometheusReport(t *testing.T) {
+	p := NewPrometheus()
+	now := time.Unix(10, 0)
+	var buf []byte
+	buf = WriteUint32(buf, []byte("foo.requests."), []byte("counter32"), 5, now)
+	buf = WriteFloat64(buf, []byte("foo.requests."), []byte("rate32"), 0.5, now)
+	buf = WriteUint32(buf, []byte("foo.latency."), []byte("values.count32"), 3, now)
+	buf = WriteFloat64(buf, []byte("foo.latency."), []byte("values.rate32"), 1.5, now)
+	p.Report(buf, now)
+
+	reg := prometheus.NewRegistry()
+	reg.MustRegister(p)
+	families, err := reg.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := make(map[string]float64)
+	for _, f := range families {
+		for _, m := range f.Metric {
+			if f.GetType() == dto.MetricType_COUNTER {
+				got[f.GetName()] = m.GetCounter().GetValue()
+			} else {
+				got[f.GetName()] = m.GetGauge().GetValue()
+			}
+		}
+	}
+	exp := map[string]float64{
+		"metrictank_foo_requests_total":       5,
+		"metrictank_foo_latency_values_count": 3,
+		"metrictank_foo_latency_values_rate":  1.5,
+	}
+	if !reflect.DeepEqual(got, exp) {
+		t.Fatalf("expected %v, got %v", exp, got)
+	}
+}
+
+func TestPrometheusReportInconsistent(t *testing.T) {
+	p := NewPrometheus()
+	now := time.Unix(10, 0)
+	var buf []byte
+	buf = WriteUint32(buf, []byte("foo.bar."), []byte("gauge32"), 1, now)
+	// maps to the same name, but with a label
+	buf = WriteUint32(buf, []byte("foo.1.bar."), []byte("gauge32"), 2, now)
+	p.Report(buf, now)
+
+	reg := prometheus.NewRegistry()
+	reg.MustRegister(p)
+	families, err := reg.Gather()
+	if err != nil {
+		t.Fatalf("expected the inconsistent metric to be dropped, got %s", err)
+	}
+	if len(families) != 1 || len(families[0].Metric) != 1 || families[0].Metric[0].GetGauge().GetValue() != 1 {
+		t.Fatalf("expected only the first metric, got %v", families)
+	}
+}
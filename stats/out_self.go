@@ -0,0 +1,74 @@
+package stats
+
+import (
+	"bytes"
+	"sync"
+	"time"
+
+	"github.com/grafana/metrictank/schema"
+)
+
+// MetricDataHandler processes ingested metrics. It is implemented by input.Handler
+type MetricDataHandler interface {
+	ProcessMetricData(md *schema.MetricData, partition int32)
+}
+
+// Self is an output that ingests the stats into metrictank itself, into the given org,
+// so that an instance can monitor itself without a separate graphite.
+// until its handler is set, e.g. because metrictank is still starting up, the stats are dropped.
+type Self struct {
+	prefix    string
+	orgId     int
+	interval  int
+	partition int32
+
+	sync.Mutex
+	handler MetricDataHandler
+}
+
+func NewSelf(prefix string, orgId, interval int, partition int32) *Self {
+	if len(prefix) != 0 && prefix[len(prefix)-1] != '.' {
+		prefix = prefix + "."
+	}
+	return &Self{
+		prefix:    prefix,
+		orgId:     orgId,
+		interval:  interval,
+		partition: partition,
+	}
+}
+
+// SetHandler sets the handler that ingests the stats
+func (s *Self) SetHandler(handler MetricDataHandler) {
+	s.Lock()
+	s.handler = handler
+	s.Unlock()
+}
+
+// Report ingests the stats, with our prefix, into our org
+func (s *Self) Report(buf []byte, now time.Time) {
+	s.Lock()
+	handler := s.handler
+	s.Unlock()
+	if handler == nil {
+		return
+	}
+
+	eachLine(buf, func(name []byte, val float64) {
+		mtype := "gauge"
+		if bytes.HasSuffix(name, []byte(".counter32")) || bytes.HasSuffix(name, []byte(".counter64")) {
+			mtype = "counter"
+		}
+		md := &schema.MetricData{
+			OrgId:    s.orgId,
+			Name:     s.prefix + string(name),
+			Interval: s.interval,
+			Value:    val,
+			Unit:     "unknown",
+			Time:     now.Unix(),
+			Mtype:    mtype,
+		}
+		md.SetId()
+		handler.ProcessMetricData(md, s.partition)
+	})
+}
@@ -0,0 +1,79 @@
+package disk
+
+import (
+	"errors"
+	"flag"
+	"log"
+	"time"
+
+	"github.com/grafana/globalconf"
+)
+
+type StoreConfig struct {
+	Enabled           bool
+	Path              string
+	WindowFactor      int
+	WriteQueueSize    int
+	WriteMaxFlushSize int
+	FlushInterval     time.Duration
+	ExpireInterval    time.Duration
+}
+
+func (cfg *StoreConfig) Validate() error {
+	if !cfg.Enabled {
+		return nil
+	}
+	if cfg.Path == "" {
+		return errors.New("path must be set")
+	}
+	if cfg.WindowFactor < 1 {
+		return errors.New("window-factor must be at least 1")
+	}
+	if cfg.WriteMaxFlushSize < 1 {
+		return errors.New("write-max-flush-size must be at least 1")
+	}
+	if cfg.WriteMaxFlushSize >= cfg.WriteQueueSize {
+		return errors.New("write-queue-size must be larger then write-max-flush-size")
+	}
+	if cfg.FlushInterval <= 0 {
+		return errors.New("flush-interval must be positive")
+	}
+	if cfg.ExpireInterval <= 0 {
+		return errors.New("expire-interval must be positive")
+	}
+	return nil
+}
+
+// return StoreConfig with default values set.
+func NewStoreConfig() *StoreConfig {
+	return &StoreConfig{
+		Enabled:           false,
+		Path:              "/var/lib/metrictank/chunks",
+		WindowFactor:      20,
+		WriteQueueSize:    100000,
+		WriteMaxFlushSize: 10000,
+		FlushInterval:     time.Second,
+		ExpireInterval:    time.Hour,
+	}
+}
+
+var CliConfig = NewStoreConfig()
+
+func ConfigSetup() {
+	diskStore := flag.NewFlagSet("disk-store", flag.ExitOnError)
+	diskStore.BoolVar(&CliConfig.Enabled, "enabled", CliConfig.Enabled, "enable the local disk backend store plugin")
+	diskStore.StringVar(&CliConfig.Path, "path", CliConfig.Path, "directory to store the chunk files in")
+	diskStore.IntVar(&CliConfig.WindowFactor, "window-factor", CliConfig.WindowFactor, "size of the time windows chunk files cover, relative to the TTL. like the cassandra store's window-factor")
+	diskStore.IntVar(&CliConfig.WriteQueueSize, "write-queue-size", CliConfig.WriteQueueSize, "Max number of chunks allowed to be unwritten to disk. Must be larger then write-max-flush-size")
+	diskStore.IntVar(&CliConfig.WriteMaxFlushSize, "write-max-flush-size", CliConfig.WriteMaxFlushSize, "Max number of chunks written to disk before they are synced")
+	diskStore.DurationVar(&CliConfig.FlushInterval, "flush-interval", CliConfig.FlushInterval, "max time chunks may wait before they are written and synced to disk")
+	diskStore.DurationVar(&CliConfig.ExpireInterval, "expire-interval", CliConfig.ExpireInterval, "how often to look for chunk files of which all chunks have expired, and delete them")
+
+	globalconf.Register("disk-store", diskStore, flag.ExitOnError)
+}
+
+func ConfigProcess() {
+	if err := CliConfig.Validate(); err != nil {
+		log.Fatalf("disk-store: Config validation error. %s", err)
+	}
+}
@@ -0,0 +1,500 @@
+// Package disk implements an mdata.Store that keeps chunks in files on local disk,
+// for deployments that don't want to operate cassandra or bigtable.
+package disk
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/grafana/metrictank/mdata"
+	"github.com/grafana/metrictank/mdata/chunk"
+	"github.com/grafana/metrictank/schema"
+	"github.com/grafana/metrictank/stats"
+	"github.com/grafana/metrictank/store/cassandra"
+	"github.com/jpillora/backoff"
+	opentracing "github.com/opentracing/opentracing-go"
+	log "github.com/sirupsen/logrus"
+)
+
+var (
+	errChunkTooSmall = errors.New("impossibly small chunk in disk store")
+	errInvalidRange  = errors.New("DiskStore: invalid range: from must be less than to")
+	errTableNotFound = errors.New("table not found")
+
+	// metric store.disk.get.exec is the duration of getting from the disk store
+	diskGetExecDuration = stats.NewLatencyHistogram15s32("store.disk.get.exec")
+	// metric store.disk.put.exec is the duration of writing and syncing a batch of chunks to disk
+	diskPutExecDuration = stats.NewLatencyHistogram15s32("store.disk.put.exec")
+	// metric store.disk.put.wait is the duration of a put in the wait queue
+	diskPutWaitDuration = stats.NewLatencyHistogram12h32("store.disk.put.wait")
+	// metric store.disk.write_queue.items is the number of chunks waiting to be written to disk
+	diskWriteQueueItems = stats.NewRange32("store.disk.write_queue.items")
+	// metric store.disk.chunks_per_response is how many chunks are retrieved per response in get queries
+	diskChunksPerResponse = stats.NewMeter32("store.disk.chunks_per_response", false)
+	// metric store.disk.segments is the number of segment files in the disk store
+	diskSegments = stats.NewGauge32("store.disk.segments")
+	// metric store.disk.segments_expired is the number of segment files deleted because all their chunks expired
+	diskSegmentsExpired = stats.NewCounter32("store.disk.segments_expired")
+
+	// metric store.disk.chunk_operations.save_ok is counter of successful saves
+	chunkSaveOk = stats.NewCounter32("store.disk.chunk_operations.save_ok")
+	// metric store.disk.chunk_operations.save_fail is counter of failed saves
+	chunkSaveFail = stats.NewCounter32("store.disk.chunk_operations.save_fail")
+	// metric store.disk.chunk_size.at_save is the sizes of chunks seen when saving them
+	chunkSizeAtSave = stats.NewMeter32("store.disk.chunk_size.at_save", true)
+	// metric store.disk.chunk_size.at_load is the sizes of chunks seen when loading them
+	chunkSizeAtLoad = stats.NewMeter32("store.disk.chunk_size.at_load", true)
+)
+
+// table holds the chunks of all TTLs that map to the same cassandra table name (see cassandra.GetTable)
+// they are split up into segment files by time window, so that we can delete them once they expired,
+// like cassandra's TimeWindowCompactionStrategy does.
+type table struct {
+	name       string
+	dir        string
+	windowSize uint32 // in seconds
+	segments   map[uint32]*segment
+	chunks     map[schema.AMKey][]chunkRef // sorted by t0
+}
+
+// ChunkInfo describes a chunk in the store, for inspection purposes
+type ChunkInfo struct {
+	T0      uint32
+	TTL     uint32
+	Size    uint32
+	Expires uint32
+}
+
+type Store struct {
+	sync.RWMutex
+	cfg        *StoreConfig
+	tables     map[string]*table
+	ttlTables  map[uint32]cassandra.Table
+	writeQueue chan *mdata.ChunkWriteRequest
+	shutdown   chan struct{}
+	wg         sync.WaitGroup
+	tracer     opentracing.Tracer
+}
+
+// NewStore creates a new disk store, using the provided retention ttl's in seconds.
+// It loads all chunks that are already on disk.
+func NewStore(cfg *StoreConfig, ttls []uint32) (*Store, error) {
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(cfg.Path, 0755); err != nil {
+		return nil, fmt.Errorf("diskStore: failed to create %s. %s", cfg.Path, err)
+	}
+	s := &Store{
+		cfg:        cfg,
+		tables:     make(map[string]*table),
+		ttlTables:  cassandra.GetTTLTables(ttls, cfg.WindowFactor, cassandra.Table_name_format),
+		writeQueue: make(chan *mdata.ChunkWriteRequest, cfg.WriteQueueSize-cfg.WriteMaxFlushSize),
+		shutdown:   make(chan struct{}),
+	}
+	if err := s.load(); err != nil {
+		return nil, err
+	}
+	s.wg.Add(1)
+	go s.processWriteQueue()
+	return s, nil
+}
+
+// load reads the index of all existing segment files
+func (s *Store) load() error {
+	pre := time.Now()
+	dirs, err := ioutil.ReadDir(s.cfg.Path)
+	if err != nil {
+		return fmt.Errorf("diskStore: failed to list %s. %s", s.cfg.Path, err)
+	}
+	now := uint32(time.Now().Unix())
+	var numChunks int
+	for _, dir := range dirs {
+		if !dir.IsDir() || !cassandra.IsStoreTable(dir.Name()) {
+			continue
+		}
+		tbl := s.getTable(dir.Name())
+		files, err := ioutil.ReadDir(tbl.dir)
+		if err != nil {
+			return fmt.Errorf("diskStore: failed to list %s. %s", tbl.dir, err)
+		}
+		for _, file := range files {
+			if !strings.HasSuffix(file.Name(), segmentExt) {
+				continue
+			}
+			start, err := strconv.ParseUint(strings.TrimSuffix(file.Name(), segmentExt), 10, 32)
+			if err != nil {
+				log.Warnf("diskStore: ignoring unexpected file %s", filepath.Join(tbl.dir, file.Name()))
+				continue
+			}
+			seg, err := openSegment(filepath.Join(tbl.dir, file.Name()), uint32(start), func(key schema.AMKey, ref chunkRef) {
+				if ref.expires > now {
+					tbl.addRef(key, ref)
+					numChunks++
+				}
+			})
+			if err != nil {
+				return fmt.Errorf("diskStore: failed to load %s. %s", filepath.Join(tbl.dir, file.Name()), err)
+			}
+			tbl.segments[seg.start] = seg
+			diskSegments.Inc()
+		}
+	}
+	log.Infof("diskStore: loaded %d chunks in %d tables in %s", numChunks, len(s.tables), time.Since(pre))
+	return nil
+}
+
+// getTable returns the table with the given name, creating it if needed.
+// caller must hold the write lock, if the store is in use
+func (s *Store) getTable(name string) *table {
+	tbl, ok := s.tables[name]
+	if ok {
+		return tbl
+	}
+	// the table name holds the largest power of 2 that's <= the ttl in hours. see cassandra.GetTable
+	preFactorWindow, _ := strconv.Atoi(strings.TrimPrefix(name, "metric_"))
+	tbl = &table{
+		name:       name,
+		dir:        filepath.Join(s.cfg.Path, name),
+		windowSize: uint32(preFactorWindow/s.cfg.WindowFactor+1) * 3600,
+		segments:   make(map[uint32]*segment),
+		chunks:     make(map[schema.AMKey][]chunkRef),
+	}
+	s.tables[name] = tbl
+	return tbl
+}
+
+func (s *Store) tableName(ttl uint32) string {
+	if table, ok := s.ttlTables[ttl]; ok {
+		return table.Name
+	}
+	return cassandra.GetTable(ttl, s.cfg.WindowFactor, cassandra.Table_name_format).Name
+}
+
+// addRef adds the chunk to the index, replacing any existing chunk of the series with the same t0
+func (t *table) addRef(key schema.AMKey, ref chunkRef) {
+	refs := t.chunks[key]
+	i := sort.Search(len(refs), func(i int) bool { return refs[i].t0 >= ref.t0 })
+	if i < len(refs) && refs[i].t0 == ref.t0 {
+		refs[i] = ref
+		return
+	}
+	refs = append(refs, chunkRef{})
+	copy(refs[i+1:], refs[i:])
+	refs[i] = ref
+	t.chunks[key] = refs
+}
+
+// getSegment returns the segment that covers t0, creating it if needed
+// caller must hold the write lock
+func (t *table) getSegment(t0 uint32) (*segment, error) {
+	start := t0 - t0%t.windowSize
+	seg, ok := t.segments[start]
+	if ok {
+		return seg, nil
+	}
+	if err := os.MkdirAll(t.dir, 0755); err != nil {
+		return nil, err
+	}
+	seg, err := openSegment(filepath.Join(t.dir, strconv.FormatUint(uint64(start), 10)+segmentExt), start, func(schema.AMKey, chunkRef) {})
+	if err != nil {
+		return nil, err
+	}
+	t.segments[start] = seg
+	diskSegments.Inc()
+	return seg, nil
+}
+
+func (s *Store) SetTracer(t opentracing.Tracer) {
+	s.tracer = t
+}
+
+func (s *Store) Add(cwr *mdata.ChunkWriteRequest) {
+	s.writeQueue <- cwr
+}
+
+// processWriteQueue writes chunks to disk in batches. the chunks of a batch are synced to disk
+// before their callbacks are called, so chunks are only marked as saved once they are durable.
+// expiry of old segments also happens here, so that all file modifications happen on this goroutine.
+func (s *Store) processWriteQueue() {
+	defer s.wg.Done()
+
+	flushTicker := time.NewTicker(s.cfg.FlushInterval)
+	defer flushTicker.Stop()
+	expireTicker := time.NewTicker(s.cfg.ExpireInterval)
+	defer expireTicker.Stop()
+
+	buf := make([]*mdata.ChunkWriteRequest, 0, s.cfg.WriteMaxFlushSize)
+	boff := &backoff.Backoff{
+		Min:    100 * time.Millisecond,
+		Max:    time.Minute,
+		Factor: 3,
+		Jitter: true,
+	}
+	flush := func() {
+		attempts := 0
+		for len(buf) > 0 {
+			err := s.write(buf)
+			if err == nil {
+				chunkSaveOk.Add(len(buf))
+				for _, cwr := range buf {
+					if cwr.Callback != nil {
+						cwr.Callback()
+					}
+					log.Debugf("diskStore: save complete. %s:%d %v", cwr.Key.String(), cwr.T0, cwr.Data)
+				}
+				buf = buf[:0]
+				boff.Reset()
+				return
+			}
+			chunkSaveFail.Add(len(buf))
+			if (attempts % 20) == 0 {
+				log.Warnf("diskStore: failed to write %d chunks to disk. they will be retried. %s", len(buf), err)
+			}
+			attempts++
+			select {
+			case <-time.After(boff.Duration()):
+			case <-s.shutdown:
+				return
+			}
+		}
+	}
+
+	for {
+		select {
+		case <-flushTicker.C:
+			diskWriteQueueItems.Value(len(s.writeQueue))
+			flush()
+		case <-expireTicker.C:
+			s.expire(uint32(time.Now().Unix()))
+		case cwr := <-s.writeQueue:
+			diskWriteQueueItems.Value(len(s.writeQueue))
+			diskPutWaitDuration.Value(time.Since(cwr.Timestamp))
+			buf = append(buf, cwr)
+			if len(buf) >= s.cfg.WriteMaxFlushSize {
+				flush()
+			}
+		case <-s.shutdown:
+			// we don't have to write the remaining chunks: they won't be marked as saved,
+			// so they will be saved again after a restart.
+			flush()
+			return
+		}
+	}
+}
+
+// write appends the chunks to their segments and syncs all modified segments.
+// chunks that were appended before a failure may be written again when we retry,
+// which is fine because they'll just replace their previous copy.
+func (s *Store) write(cwrs []*mdata.ChunkWriteRequest) error {
+	pre := time.Now()
+	now := uint32(pre.Unix())
+	s.Lock()
+	dirty := make(map[*segment]struct{})
+	for _, cwr := range cwrs {
+		tbl := s.getTable(s.tableName(cwr.TTL))
+		seg, err := tbl.getSegment(cwr.T0)
+		if err != nil {
+			s.Unlock()
+			return err
+		}
+		chunkSizeAtSave.Value(len(cwr.Data))
+		// like cassandra, the TTL starts counting when the chunk is written
+		expires := now + cwr.TTL
+		record := encodeRecord(cwr.Key, cwr.T0, cwr.TTL, expires, cwr.Data)
+		offset, err := seg.append(record)
+		if err != nil {
+			s.Unlock()
+			return err
+		}
+		if expires > seg.maxExpires {
+			seg.maxExpires = expires
+		}
+		tbl.addRef(cwr.Key, chunkRef{
+			seg:     seg,
+			offset:  offset + int64(len(record)-len(cwr.Data)),
+			size:    uint32(len(cwr.Data)),
+			t0:      cwr.T0,
+			ttl:     cwr.TTL,
+			expires: expires,
+		})
+		dirty[seg] = struct{}{}
+	}
+	s.Unlock()
+
+	// syncing doesn't modify the index, so readers don't need to wait for it
+	for seg := range dirty {
+		if err := seg.sync(); err != nil {
+			return err
+		}
+	}
+	diskPutExecDuration.Value(time.Since(pre))
+	return nil
+}
+
+// expire deletes the segments of which all chunks have expired
+func (s *Store) expire(now uint32) {
+	s.Lock()
+	defer s.Unlock()
+	for _, tbl := range s.tables {
+		for start, seg := range tbl.segments {
+			if seg.maxExpires > now {
+				continue
+			}
+			for key, refs := range tbl.chunks {
+				kept := refs[:0]
+				for _, ref := range refs {
+					if ref.seg != seg {
+						kept = append(kept, ref)
+					}
+				}
+				if len(kept) == 0 {
+					delete(tbl.chunks, key)
+				} else {
+					tbl.chunks[key] = kept
+				}
+			}
+			seg.file.Close()
+			if err := os.Remove(seg.path); err != nil {
+				log.Errorf("diskStore: failed to delete expired segment %s. %s", seg.path, err)
+			}
+			delete(tbl.segments, start)
+			diskSegments.Dec()
+			diskSegmentsExpired.Inc()
+		}
+	}
+}
+
+func (s *Store) Search(ctx context.Context, key schema.AMKey, ttl, start, end uint32) ([]chunk.IterGen, error) {
+	return s.SearchTable(ctx, key, s.tableName(ttl), start, end)
+}
+
+// SearchTable returns the chunks of the given series in the given table that are needed to cover start to end:
+// all chunks with a t0 < end, starting with the last chunk with a t0 <= start
+func (s *Store) SearchTable(ctx context.Context, key schema.AMKey, tableName string, start, end uint32) ([]chunk.IterGen, error) {
+	if start >= end {
+		return nil, errInvalidRange
+	}
+	select {
+	case <-ctx.Done():
+		// request has been canceled
+		return nil, nil
+	default:
+	}
+	pre := time.Now()
+	now := uint32(pre.Unix())
+
+	s.RLock()
+	defer s.RUnlock()
+	tbl, ok := s.tables[tableName]
+	if !ok {
+		// nothing was ever written for this ttl
+		return nil, nil
+	}
+	refs := tbl.chunks[key]
+	first := sort.Search(len(refs), func(i int) bool { return refs[i].t0 > start })
+	if first > 0 {
+		first--
+	}
+	intervalHint := key.Archive.Span()
+	var itgens []chunk.IterGen
+	for _, ref := range refs[first:] {
+		if ref.t0 >= end {
+			break
+		}
+		if ref.expires <= now {
+			continue
+		}
+		chunkSizeAtLoad.Value(int(ref.size))
+		if ref.size < 2 {
+			return nil, errChunkTooSmall
+		}
+		data := make([]byte, ref.size)
+		_, err := ref.seg.file.ReadAt(data, ref.offset)
+		if err != nil {
+			return nil, err
+		}
+		itgen, err := chunk.NewIterGen(ref.t0, intervalHint, data)
+		if err != nil {
+			return nil, err
+		}
+		itgens = append(itgens, itgen)
+	}
+	diskGetExecDuration.Value(time.Since(pre))
+	diskChunksPerResponse.Value(len(itgens))
+	return itgens, nil
+}
+
+// Tables returns the names of all tables in the store
+func (s *Store) Tables() []string {
+	s.RLock()
+	defer s.RUnlock()
+	names := make([]string, 0, len(s.tables))
+	for name := range s.tables {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Keys returns the keys of all series that have chunks in the given table
+func (s *Store) Keys(tableName string) ([]schema.AMKey, error) {
+	s.RLock()
+	defer s.RUnlock()
+	tbl, ok := s.tables[tableName]
+	if !ok {
+		return nil, errTableNotFound
+	}
+	keys := make([]schema.AMKey, 0, len(tbl.chunks))
+	for key := range tbl.chunks {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool { return keys[i].String() < keys[j].String() })
+	return keys, nil
+}
+
+// Chunks describes the chunks of the given series in the given table, in t0 order
+// this includes chunks that have expired but not been deleted yet
+func (s *Store) Chunks(tableName string, key schema.AMKey) ([]ChunkInfo, error) {
+	s.RLock()
+	defer s.RUnlock()
+	tbl, ok := s.tables[tableName]
+	if !ok {
+		return nil, errTableNotFound
+	}
+	refs := tbl.chunks[key]
+	infos := make([]ChunkInfo, len(refs))
+	for i, ref := range refs {
+		infos[i] = ChunkInfo{
+			T0:      ref.t0,
+			TTL:     ref.ttl,
+			Size:    ref.size,
+			Expires: ref.expires,
+		}
+	}
+	return infos, nil
+}
+
+func (s *Store) Stop() {
+	close(s.shutdown)
+	s.wg.Wait()
+	s.Lock()
+	defer s.Unlock()
+	for _, tbl := range s.tables {
+		for _, seg := range tbl.segments {
+			if err := seg.sync(); err != nil {
+				log.Errorf("diskStore: failed to sync %s. %s", seg.path, err)
+			}
+			seg.file.Close()
+		}
+	}
+}
@@ -0,0 +1,216 @@
+package disk
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/grafana/metrictank/mdata"
+	"github.com/grafana/metrictank/mdata/chunk"
+	"github.com/grafana/metrictank/schema"
+)
+
+func testConfig(t *testing.T) (*StoreConfig, func()) {
+	dir, err := ioutil.TempDir("", "disk-store")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg := NewStoreConfig()
+	cfg.Enabled = true
+	cfg.Path = dir
+	cfg.WriteQueueSize = 100
+	cfg.WriteMaxFlushSize = 10
+	cfg.FlushInterval = 10 * time.Millisecond
+	return cfg, func() { os.RemoveAll(dir) }
+}
+
+func testKey(t *testing.T, id string) schema.AMKey {
+	key, err := schema.AMKeyFromString(id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key
+}
+
+// newChunk returns a chunk with t0 and points at t0, t0+10, ... up to t0+span
+func newChunk(t0, span uint32) []byte {
+	c := chunk.New(t0)
+	for ts := t0; ts < t0+span; ts += 10 {
+		c.Push(ts, float64(ts))
+	}
+	c.Finish()
+	return c.Encode(span)
+}
+
+// save writes the chunks and waits until they are saved
+func save(s *Store, key schema.AMKey, ttl uint32, t0s ...uint32) {
+	done := make(chan struct{}, len(t0s))
+	for _, t0 := range t0s {
+		cwr := mdata.NewChunkWriteRequest(func() { done <- struct{}{} }, key, ttl, t0, newChunk(t0, 600), time.Now())
+		s.Add(&cwr)
+	}
+	for range t0s {
+		<-done
+	}
+}
+
+func t0s(itgens []chunk.IterGen) []uint32 {
+	var out []uint32
+	for _, itgen := range itgens {
+		out = append(out, itgen.T0)
+	}
+	return out
+}
+
+func equal(a, b []uint32) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func TestSearch(t *testing.T) {
+	cfg, cleanup := testConfig(t)
+	defer cleanup()
+	s, err := NewStore(cfg, []uint32{3600 * 24})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Stop()
+
+	key := testKey(t, "1.01234567890123456789012345678901")
+	other := testKey(t, "1.01234567890123456789012345678901_sum_600")
+	save(s, key, 3600*24, 1800, 600, 1200, 2400)
+	save(s, other, 3600*24, 600)
+
+	cases := []struct {
+		start, end uint32
+		exp        []uint32
+	}{
+		{1, 10000, []uint32{600, 1200, 1800, 2400}},
+		{1200, 1800, []uint32{1200}},
+		{1300, 1900, []uint32{1200, 1800}},
+		{3000, 4000, []uint32{2400}},
+		{1, 600, nil},
+	}
+	for i, c := range cases {
+		itgens, err := s.Search(context.Background(), key, 3600*24, c.start, c.end)
+		if err != nil {
+			t.Fatalf("case %d: unexpected error %s", i, err)
+		}
+		if got := t0s(itgens); !equal(got, c.exp) {
+			t.Fatalf("case %d: expected chunks %v, got %v", i, c.exp, got)
+		}
+	}
+
+	itgens, err := s.Search(context.Background(), key, 3600*24, 600, 1200)
+	if err != nil {
+		t.Fatal(err)
+	}
+	iter, err := itgens[0].Get()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var points int
+	for iter.Next() {
+		ts, val := iter.Values()
+		if float64(ts) != val {
+			t.Fatalf("expected value %d at ts %d, got %f", ts, ts, val)
+		}
+		points++
+	}
+	if points != 60 {
+		t.Fatalf("expected 60 points, got %d", points)
+	}
+
+	if _, err := s.Search(context.Background(), key, 3600*24, 10, 10); err != errInvalidRange {
+		t.Fatalf("expected errInvalidRange, got %v", err)
+	}
+}
+
+func TestReload(t *testing.T) {
+	cfg, cleanup := testConfig(t)
+	defer cleanup()
+	s, err := NewStore(cfg, []uint32{3600})
+	if err != nil {
+		t.Fatal(err)
+	}
+	key := testKey(t, "1.01234567890123456789012345678901")
+	save(s, key, 3600, 600, 1200)
+	// writing a chunk with the same t0 again replaces it
+	save(s, key, 3600, 1200)
+	s.Stop()
+
+	// simulate a crash while writing a record
+	file := filepath.Join(cfg.Path, "metric_1", "0"+segmentExt)
+	f, err := os.OpenFile(file, os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.Write([]byte{100, 0, 0, 0, 1, 2})
+	f.Close()
+
+	s, err = NewStore(cfg, []uint32{3600})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Stop()
+	if tables := s.Tables(); len(tables) != 1 || tables[0] != "metric_1" {
+		t.Fatalf("expected table metric_1, got %v", tables)
+	}
+	itgens, err := s.Search(context.Background(), key, 3600, 1, 10000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := t0s(itgens); !equal(got, []uint32{600, 1200}) {
+		t.Fatalf("expected chunks [600 1200] after reload, got %v", got)
+	}
+	// the corrupt tail must have been truncated, so new writes can be read back
+	save(s, key, 3600, 1800)
+	itgens, err = s.Search(context.Background(), key, 3600, 1, 10000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := t0s(itgens); !equal(got, []uint32{600, 1200, 1800}) {
+		t.Fatalf("expected chunks [600 1200 1800], got %v", got)
+	}
+}
+
+func TestExpire(t *testing.T) {
+	cfg, cleanup := testConfig(t)
+	defer cleanup()
+	s, err := NewStore(cfg, []uint32{3600})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Stop()
+	key := testKey(t, "1.01234567890123456789012345678901")
+	// 2 different windows, so 2 segments
+	save(s, key, 3600, 600, 7200)
+
+	s.expire(uint32(time.Now().Unix()))
+	if infos, _ := s.Chunks("metric_1", key); len(infos) != 2 {
+		t.Fatalf("expected 2 chunks before expiry, got %d", len(infos))
+	}
+
+	s.Lock()
+	s.tables["metric_1"].segments[0].maxExpires = 0
+	s.Unlock()
+	s.expire(uint32(time.Now().Unix()))
+
+	infos, _ := s.Chunks("metric_1", key)
+	if len(infos) != 1 || infos[0].T0 != 7200 {
+		t.Fatalf("expected only chunk 7200 after expiry, got %v", infos)
+	}
+	if _, err := os.Stat(filepath.Join(cfg.Path, "metric_1", "0"+segmentExt)); !os.IsNotExist(err) {
+		t.Fatalf("expected expired segment to be deleted, got %v", err)
+	}
+}
@@ -0,0 +1,180 @@
+package disk
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"os"
+
+	"github.com/grafana/metrictank/schema"
+)
+
+// chunks are stored in segment files, one per table and time window.
+// a segment file is a sequence of records, each of which holds one chunk:
+//
+// length  uint32 (length of the body)
+// crc     uint32 (IEEE crc32 of the body)
+// body:
+//   t0      uint32
+//   ttl     uint32
+//   expires uint32 (unix timestamp after which the chunk must no longer be returned)
+//   keylen  uint16
+//   key     string (the AMKey)
+//   data    []byte (the chunk, as stored by the cassandra store)
+//
+// records are only ever appended. once all records in a segment have expired, the whole file is deleted.
+
+const (
+	recordHeaderSize = 8
+	bodyHeaderSize   = 14
+	segmentExt       = ".seg"
+)
+
+var errCorruptRecord = errors.New("corrupt record")
+
+// chunkRef locates a chunk within a segment
+type chunkRef struct {
+	seg     *segment
+	offset  int64 // offset of the chunk data within the segment file
+	size    uint32
+	t0      uint32
+	ttl     uint32
+	expires uint32
+}
+
+type segment struct {
+	path       string
+	start      uint32 // start of the time window covered by the segment
+	file       *os.File
+	size       int64
+	maxExpires uint32 // time after which all chunks in the segment have expired
+	dirty      bool   // whether there are writes that haven't been synced yet
+}
+
+func encodeRecord(key schema.AMKey, t0, ttl, expires uint32, data []byte) []byte {
+	keyStr := key.String()
+	bodySize := bodyHeaderSize + len(keyStr) + len(data)
+	buf := make([]byte, recordHeaderSize+bodySize)
+	body := buf[recordHeaderSize:]
+	binary.LittleEndian.PutUint32(body[0:], t0)
+	binary.LittleEndian.PutUint32(body[4:], ttl)
+	binary.LittleEndian.PutUint32(body[8:], expires)
+	binary.LittleEndian.PutUint16(body[12:], uint16(len(keyStr)))
+	copy(body[bodyHeaderSize:], keyStr)
+	copy(body[bodyHeaderSize+len(keyStr):], data)
+	binary.LittleEndian.PutUint32(buf[0:], uint32(bodySize))
+	binary.LittleEndian.PutUint32(buf[4:], crc32.ChecksumIEEE(body))
+	return buf
+}
+
+// decodeBody decodes the body of a record, and returns the key, the chunkRef (without segment and offset)
+// and the offset of the chunk data within the body
+func decodeBody(body []byte) (schema.AMKey, chunkRef, int, error) {
+	if len(body) < bodyHeaderSize {
+		return schema.AMKey{}, chunkRef{}, 0, errCorruptRecord
+	}
+	ref := chunkRef{
+		t0:      binary.LittleEndian.Uint32(body[0:]),
+		ttl:     binary.LittleEndian.Uint32(body[4:]),
+		expires: binary.LittleEndian.Uint32(body[8:]),
+	}
+	keyLen := int(binary.LittleEndian.Uint16(body[12:]))
+	if len(body) < bodyHeaderSize+keyLen {
+		return schema.AMKey{}, chunkRef{}, 0, errCorruptRecord
+	}
+	key, err := schema.AMKeyFromString(string(body[bodyHeaderSize : bodyHeaderSize+keyLen]))
+	if err != nil {
+		return schema.AMKey{}, chunkRef{}, 0, err
+	}
+	ref.size = uint32(len(body) - bodyHeaderSize - keyLen)
+	return key, ref, bodyHeaderSize + keyLen, nil
+}
+
+// openSegment opens (or creates) the segment file at path, and calls fn for every valid record in it.
+// if the file ends with an incomplete or corrupt record (e.g. because we crashed while writing it),
+// the file is truncated to the last valid record.
+func openSegment(path string, start uint32, fn func(key schema.AMKey, ref chunkRef)) (*segment, error) {
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, err
+	}
+	seg := &segment{
+		path:  path,
+		start: start,
+		file:  file,
+	}
+
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	fileSize := info.Size()
+
+	var header [recordHeaderSize]byte
+	for seg.size+recordHeaderSize <= fileSize {
+		_, err := file.ReadAt(header[:], seg.size)
+		if err != nil {
+			file.Close()
+			return nil, err
+		}
+		bodySize := int64(binary.LittleEndian.Uint32(header[0:]))
+		if seg.size+recordHeaderSize+bodySize > fileSize {
+			break
+		}
+		body := make([]byte, bodySize)
+		_, err = file.ReadAt(body, seg.size+recordHeaderSize)
+		if err != nil {
+			file.Close()
+			return nil, err
+		}
+		if crc32.ChecksumIEEE(body) != binary.LittleEndian.Uint32(header[4:]) {
+			break
+		}
+		key, ref, dataOffset, err := decodeBody(body)
+		if err != nil {
+			break
+		}
+		ref.seg = seg
+		ref.offset = seg.size + recordHeaderSize + int64(dataOffset)
+		if ref.expires > seg.maxExpires {
+			seg.maxExpires = ref.expires
+		}
+		fn(key, ref)
+		seg.size += recordHeaderSize + bodySize
+	}
+
+	if seg.size != fileSize {
+		if err := file.Truncate(seg.size); err != nil {
+			file.Close()
+			return nil, fmt.Errorf("failed to truncate corrupt tail: %s", err)
+		}
+	}
+	return seg, nil
+}
+
+// append writes the record to the end of the segment and returns the offset of the record
+func (s *segment) append(record []byte) (int64, error) {
+	offset := s.size
+	_, err := s.file.WriteAt(record, offset)
+	if err != nil {
+		// get rid of any partially written data, so the next write starts at a clean offset
+		s.file.Truncate(offset)
+		return 0, err
+	}
+	s.size += int64(len(record))
+	s.dirty = true
+	return offset, nil
+}
+
+func (s *segment) sync() error {
+	if !s.dirty {
+		return nil
+	}
+	err := s.file.Sync()
+	if err == nil {
+		s.dirty = false
+	}
+	return err
+}
@@ -0,0 +1,223 @@
+package tiered
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/grafana/metrictank/schema"
+)
+
+// a block holds the chunks of many series that have the same ttl and a t0 within the same time window.
+// it consists of 2 objects:
+//
+// <prefix>/<ttl>/<window start>_<id>.chunks: the chunk data of all series, concatenated.
+//                                             the chunks of each series are contiguous and sorted by t0
+// <prefix>/<ttl>/<window start>_<id>.index:  describes where the chunks of each series are:
+//
+//   magic   uint32
+//   start   uint32 (start of the time window)
+//   ttl     uint32
+//   count   uint32 (number of series)
+//   per series:
+//     keylen  uint16
+//     key     string (the AMKey)
+//     nchunks uint32
+//     per chunk:
+//       t0     uint32
+//       offset uint64 (offset of the chunk within the chunks object)
+//       size   uint32
+//   crc     uint32 (IEEE crc32 of everything before it)
+//
+// the chunks object is always uploaded before the index, so all blocks that have an index are complete.
+
+const (
+	indexMagic = 0x4d544231 // "MTB1"
+	indexExt   = ".index"
+	chunksExt  = ".chunks"
+)
+
+var errCorruptIndex = errors.New("corrupt block index")
+
+// blockChunk locates a chunk within the chunks object of a block
+type blockChunk struct {
+	t0     uint32
+	offset uint64
+	size   uint32
+}
+
+type block struct {
+	name   string // object name without extension
+	start  uint32
+	ttl    uint32
+	size   uint64 // size of the chunks object
+	series map[schema.AMKey][]blockChunk
+}
+
+// blockName returns the name of the objects of a block, without extension
+func blockName(prefix string, ttl, start uint32, id int64) string {
+	return fmt.Sprintf("%s/%d/%d_%d", prefix, ttl, start, id)
+}
+
+// parseBlockName returns the ttl and window start of the block with the given index object name
+func parseBlockName(prefix, name string) (ttl, start uint32, ok bool) {
+	if !strings.HasPrefix(name, prefix+"/") || !strings.HasSuffix(name, indexExt) {
+		return 0, 0, false
+	}
+	parts := strings.Split(strings.TrimSuffix(strings.TrimPrefix(name, prefix+"/"), indexExt), "/")
+	if len(parts) != 2 {
+		return 0, 0, false
+	}
+	ttl64, err := strconv.ParseUint(parts[0], 10, 32)
+	if err != nil {
+		return 0, 0, false
+	}
+	pos := strings.IndexByte(parts[1], '_')
+	if pos < 0 {
+		return 0, 0, false
+	}
+	start64, err := strconv.ParseUint(parts[1][:pos], 10, 32)
+	if err != nil {
+		return 0, 0, false
+	}
+	return uint32(ttl64), uint32(start64), true
+}
+
+// record is a chunk to be compacted into a block
+type record struct {
+	key  schema.AMKey
+	ttl  uint32
+	t0   uint32
+	data []byte
+}
+
+// buildBlock returns the chunks and index objects of a block holding the given records, which must all have the same ttl.
+// when there are multiple records for the same chunk, the last one wins.
+func buildBlock(name string, start, ttl uint32, records []record) (*block, []byte, []byte) {
+	bySeries := make(map[schema.AMKey]map[uint32][]byte)
+	for _, r := range records {
+		chunks, ok := bySeries[r.key]
+		if !ok {
+			chunks = make(map[uint32][]byte)
+			bySeries[r.key] = chunks
+		}
+		chunks[r.t0] = r.data
+	}
+	keys := make([]schema.AMKey, 0, len(bySeries))
+	for key := range bySeries {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool { return keys[i].String() < keys[j].String() })
+
+	b := &block{
+		name:   name,
+		start:  start,
+		ttl:    ttl,
+		series: make(map[schema.AMKey][]blockChunk, len(keys)),
+	}
+	var data []byte
+	for _, key := range keys {
+		chunks := bySeries[key]
+		t0s := make([]uint32, 0, len(chunks))
+		for t0 := range chunks {
+			t0s = append(t0s, t0)
+		}
+		sort.Slice(t0s, func(i, j int) bool { return t0s[i] < t0s[j] })
+		refs := make([]blockChunk, len(t0s))
+		for i, t0 := range t0s {
+			refs[i] = blockChunk{t0: t0, offset: uint64(len(data)), size: uint32(len(chunks[t0]))}
+			data = append(data, chunks[t0]...)
+		}
+		b.series[key] = refs
+	}
+	b.size = uint64(len(data))
+	return b, data, encodeIndex(b, keys)
+}
+
+func encodeIndex(b *block, keys []schema.AMKey) []byte {
+	buf := make([]byte, 16, 16+len(keys)*64)
+	binary.LittleEndian.PutUint32(buf[0:], indexMagic)
+	binary.LittleEndian.PutUint32(buf[4:], b.start)
+	binary.LittleEndian.PutUint32(buf[8:], b.ttl)
+	binary.LittleEndian.PutUint32(buf[12:], uint32(len(keys)))
+	var tmp [16]byte
+	for _, key := range keys {
+		keyStr := key.String()
+		refs := b.series[key]
+		binary.LittleEndian.PutUint16(tmp[0:], uint16(len(keyStr)))
+		buf = append(buf, tmp[:2]...)
+		buf = append(buf, keyStr...)
+		binary.LittleEndian.PutUint32(tmp[0:], uint32(len(refs)))
+		buf = append(buf, tmp[:4]...)
+		for _, ref := range refs {
+			binary.LittleEndian.PutUint32(tmp[0:], ref.t0)
+			binary.LittleEndian.PutUint64(tmp[4:], ref.offset)
+			binary.LittleEndian.PutUint32(tmp[12:], ref.size)
+			buf = append(buf, tmp[:16]...)
+		}
+	}
+	binary.LittleEndian.PutUint32(tmp[0:], crc32.ChecksumIEEE(buf))
+	return append(buf, tmp[:4]...)
+}
+
+func decodeIndex(name string, buf []byte) (*block, error) {
+	if len(buf) < 20 {
+		return nil, errCorruptIndex
+	}
+	body := buf[:len(buf)-4]
+	if crc32.ChecksumIEEE(body) != binary.LittleEndian.Uint32(buf[len(buf)-4:]) {
+		return nil, errCorruptIndex
+	}
+	if binary.LittleEndian.Uint32(body[0:]) != indexMagic {
+		return nil, errCorruptIndex
+	}
+	b := &block{
+		name:   name,
+		start:  binary.LittleEndian.Uint32(body[4:]),
+		ttl:    binary.LittleEndian.Uint32(body[8:]),
+		series: make(map[schema.AMKey][]blockChunk),
+	}
+	count := int(binary.LittleEndian.Uint32(body[12:]))
+	pos := 16
+	for i := 0; i < count; i++ {
+		if len(body) < pos+2 {
+			return nil, errCorruptIndex
+		}
+		keyLen := int(binary.LittleEndian.Uint16(body[pos:]))
+		pos += 2
+		if len(body) < pos+keyLen+4 {
+			return nil, errCorruptIndex
+		}
+		key, err := schema.AMKeyFromString(string(body[pos : pos+keyLen]))
+		if err != nil {
+			return nil, err
+		}
+		pos += keyLen
+		nchunks := int(binary.LittleEndian.Uint32(body[pos:]))
+		pos += 4
+		if len(body) < pos+nchunks*16 {
+			return nil, errCorruptIndex
+		}
+		refs := make([]blockChunk, nchunks)
+		for j := range refs {
+			refs[j] = blockChunk{
+				t0:     binary.LittleEndian.Uint32(body[pos:]),
+				offset: binary.LittleEndian.Uint64(body[pos+4:]),
+				size:   binary.LittleEndian.Uint32(body[pos+12:]),
+			}
+			if end := refs[j].offset + uint64(refs[j].size); end > b.size {
+				b.size = end
+			}
+			pos += 16
+		}
+		b.series[key] = refs
+	}
+	if pos != len(body) {
+		return nil, errCorruptIndex
+	}
+	return b, nil
+}
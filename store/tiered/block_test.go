@@ -0,0 +1,80 @@
+package tiered
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/grafana/metrictank/schema"
+)
+
+func testKey(t *testing.T, id string) schema.AMKey {
+	key, err := schema.AMKeyFromString(id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key
+}
+
+func TestBlockIndex(t *testing.T) {
+	a := testKey(t, "1.01234567890123456789012345678901")
+	b := testKey(t, "1.01234567890123456789012345678901_sum_600")
+	records := []record{
+		{key: a, ttl: 3600, t0: 1200, data: []byte{1, 2, 3}},
+		{key: b, ttl: 3600, t0: 0, data: []byte{4, 5}},
+		{key: a, ttl: 3600, t0: 600, data: []byte{6}},
+		// replaces the first record
+		{key: a, ttl: 3600, t0: 1200, data: []byte{7, 8}},
+	}
+	blk, data, index := buildBlock("metrictank/3600/0_1", 0, 3600, records)
+	if !bytes.Equal(data, []byte{6, 7, 8, 4, 5}) {
+		t.Fatalf("unexpected chunks object %v", data)
+	}
+	exp := []blockChunk{{t0: 600, offset: 0, size: 1}, {t0: 1200, offset: 1, size: 2}}
+	if got := blk.series[a]; len(got) != 2 || got[0] != exp[0] || got[1] != exp[1] {
+		t.Fatalf("expected chunks %v, got %v", exp, got)
+	}
+
+	decoded, err := decodeIndex("metrictank/3600/0_1", index)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if decoded.start != 0 || decoded.ttl != 3600 || decoded.size != 5 || len(decoded.series) != 2 {
+		t.Fatalf("unexpected decoded block %+v", decoded)
+	}
+	for key, refs := range blk.series {
+		got := decoded.series[key]
+		if len(got) != len(refs) {
+			t.Fatalf("series %s: expected %v, got %v", key, refs, got)
+		}
+		for i := range refs {
+			if got[i] != refs[i] {
+				t.Fatalf("series %s: expected %v, got %v", key, refs, got)
+			}
+		}
+	}
+
+	index[20]++
+	if _, err := decodeIndex("metrictank/3600/0_1", index); err != errCorruptIndex {
+		t.Fatalf("expected errCorruptIndex, got %v", err)
+	}
+}
+
+func TestParseBlockName(t *testing.T) {
+	cases := []struct {
+		name       string
+		ok         bool
+		ttl, start uint32
+	}{
+		{"metrictank/3600/7200_123.index", true, 3600, 7200},
+		{"metrictank/3600/7200_123.chunks", false, 0, 0},
+		{"other/3600/7200_123.index", false, 0, 0},
+		{"metrictank/3600/foo/7200_123.index", false, 0, 0},
+		{"metrictank/3600/7200.index", false, 0, 0},
+	}
+	for _, c := range cases {
+		ttl, start, ok := parseBlockName("metrictank", c.name)
+		if ok != c.ok || ttl != c.ttl || start != c.start {
+			t.Fatalf("%s: expected %t %d %d, got %t %d %d", c.name, c.ok, c.ttl, c.start, ok, ttl, start)
+		}
+	}
+}
@@ -0,0 +1,127 @@
+package tiered
+
+import (
+	"context"
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// ErrNotFound is returned by buckets when an object doesn't exist
+var ErrNotFound = errors.New("object not found")
+
+// Bucket is an object store that holds immutable objects
+type Bucket interface {
+	// Put stores the object under the given name, overwriting any existing object
+	Put(ctx context.Context, name string, data []byte) error
+	// Get returns the entire object
+	Get(ctx context.Context, name string) ([]byte, error)
+	// GetRange returns length bytes of the object, starting at offset
+	GetRange(ctx context.Context, name string, offset, length int64) ([]byte, error)
+	// List returns the names of all objects that start with prefix, in lexical order
+	List(ctx context.Context, prefix string) ([]string, error)
+	// Delete removes the object. deleting an object that doesn't exist is not an error
+	Delete(ctx context.Context, name string) error
+}
+
+// FSBucket is a Bucket that stores objects as files in a directory.
+// object names containing slashes result in subdirectories.
+type FSBucket struct {
+	dir string
+}
+
+func NewFSBucket(dir string) (*FSBucket, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	return &FSBucket{dir: dir}, nil
+}
+
+func (b *FSBucket) path(name string) string {
+	return filepath.Join(b.dir, filepath.FromSlash(name))
+}
+
+// Put writes the object to a temporary file first, so that readers never see partial objects
+func (b *FSBucket) Put(ctx context.Context, name string, data []byte) error {
+	path := b.path(name)
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	f, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(data)
+	if err == nil {
+		err = f.Sync()
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+func (b *FSBucket) Get(ctx context.Context, name string) ([]byte, error) {
+	data, err := ioutil.ReadFile(b.path(name))
+	if os.IsNotExist(err) {
+		return nil, ErrNotFound
+	}
+	return data, err
+}
+
+func (b *FSBucket) GetRange(ctx context.Context, name string, offset, length int64) ([]byte, error) {
+	f, err := os.Open(b.path(name))
+	if os.IsNotExist(err) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	data := make([]byte, length)
+	_, err = f.ReadAt(data, offset)
+	if err == io.EOF {
+		err = io.ErrUnexpectedEOF
+	}
+	return data, err
+}
+
+func (b *FSBucket) List(ctx context.Context, prefix string) ([]string, error) {
+	var names []string
+	err := filepath.Walk(b.dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() || strings.HasSuffix(path, ".tmp") {
+			return nil
+		}
+		rel, err := filepath.Rel(b.dir, path)
+		if err != nil {
+			return err
+		}
+		name := filepath.ToSlash(rel)
+		if strings.HasPrefix(name, prefix) {
+			names = append(names, name)
+		}
+		return nil
+	})
+	sort.Strings(names)
+	return names, err
+}
+
+func (b *FSBucket) Delete(ctx context.Context, name string) error {
+	err := os.Remove(b.path(name))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	return err
+}
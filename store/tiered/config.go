@@ -0,0 +1,168 @@
+package tiered
+
+import (
+	"errors"
+	"flag"
+	"log"
+	"time"
+
+	"github.com/grafana/globalconf"
+)
+
+type StoreConfig struct {
+	Enabled         bool
+	Backend         string
+	FSPath          string
+	S3Endpoint      string
+	S3Bucket        string
+	S3Region        string
+	S3AccessKey     string
+	S3SecretKey     string
+	S3PathStyle     bool
+	S3Insecure      bool
+	S3Timeout       time.Duration
+	Prefix          string
+	StagingDir      string
+	Age             time.Duration
+	BlockSpan       time.Duration
+	CompactInterval time.Duration
+	PrimaryTTL      time.Duration
+	WriteQueueSize  int
+	FlushInterval   time.Duration
+}
+
+func (cfg *StoreConfig) Validate() error {
+	if !cfg.Enabled {
+		return nil
+	}
+	switch cfg.Backend {
+	case "filesystem":
+		if cfg.FSPath == "" {
+			return errors.New("fs-path must be set for the filesystem backend")
+		}
+	case "s3":
+		if cfg.S3Endpoint == "" || cfg.S3Bucket == "" {
+			return errors.New("s3-endpoint and s3-bucket must be set for the s3 backend")
+		}
+		if cfg.S3Timeout <= 0 {
+			return errors.New("s3-timeout must be positive")
+		}
+	default:
+		return errors.New("backend must be one of filesystem or s3")
+	}
+	if cfg.StagingDir == "" {
+		return errors.New("staging-dir must be set")
+	}
+	if cfg.Age < 0 {
+		return errors.New("age must not be negative")
+	}
+	if cfg.BlockSpan < time.Hour || cfg.BlockSpan%time.Hour != 0 {
+		return errors.New("block-span must be a whole number of hours")
+	}
+	if cfg.CompactInterval <= 0 {
+		return errors.New("compact-interval must be positive")
+	}
+	if cfg.PrimaryTTL < 0 {
+		return errors.New("primary-ttl must not be negative")
+	}
+	// chunks must stay in the primary store until they have been compacted into a block
+	if cfg.PrimaryTTL > 0 && cfg.PrimaryTTL < cfg.Age+cfg.BlockSpan+cfg.CompactInterval {
+		return errors.New("primary-ttl must be at least age + block-span + compact-interval")
+	}
+	if cfg.WriteQueueSize < 1 {
+		return errors.New("write-queue-size must be at least 1")
+	}
+	if cfg.FlushInterval <= 0 {
+		return errors.New("flush-interval must be positive")
+	}
+	return nil
+}
+
+// primaryTTL returns the ttl with which chunks are saved in the primary store
+func (cfg *StoreConfig) primaryTTL(ttl uint32) uint32 {
+	max := uint32(cfg.PrimaryTTL.Seconds())
+	if max > 0 && ttl > max {
+		return max
+	}
+	return ttl
+}
+
+// PrimaryTTLs returns the ttls that the primary store must support, given the ttls of the retentions
+func (cfg *StoreConfig) PrimaryTTLs(ttls []uint32) []uint32 {
+	seen := make(map[uint32]struct{})
+	var out []uint32
+	for _, ttl := range ttls {
+		ttl = cfg.primaryTTL(ttl)
+		if _, ok := seen[ttl]; !ok {
+			seen[ttl] = struct{}{}
+			out = append(out, ttl)
+		}
+	}
+	return out
+}
+
+// NewBucket returns the bucket for the configured backend
+func (cfg *StoreConfig) NewBucket() (Bucket, error) {
+	if cfg.Backend == "s3" {
+		return NewS3Bucket(cfg.S3Endpoint, cfg.S3Bucket, cfg.S3Region, cfg.S3AccessKey, cfg.S3SecretKey, cfg.S3PathStyle, cfg.S3Insecure, cfg.S3Timeout), nil
+	}
+	return NewFSBucket(cfg.FSPath)
+}
+
+// return StoreConfig with default values set.
+func NewStoreConfig() *StoreConfig {
+	return &StoreConfig{
+		Enabled:         false,
+		Backend:         "filesystem",
+		FSPath:          "/var/lib/metrictank/blocks",
+		S3Endpoint:      "s3.amazonaws.com",
+		S3Bucket:        "",
+		S3Region:        "us-east-1",
+		S3AccessKey:     "",
+		S3SecretKey:     "",
+		S3PathStyle:     false,
+		S3Insecure:      false,
+		S3Timeout:       time.Minute,
+		Prefix:          "metrictank",
+		StagingDir:      "/var/lib/metrictank/staging",
+		Age:             7 * 24 * time.Hour,
+		BlockSpan:       24 * time.Hour,
+		CompactInterval: 10 * time.Minute,
+		PrimaryTTL:      0,
+		WriteQueueSize:  100000,
+		FlushInterval:   time.Second,
+	}
+}
+
+var CliConfig = NewStoreConfig()
+
+func ConfigSetup() {
+	tieredStore := flag.NewFlagSet("tiered-store", flag.ExitOnError)
+	tieredStore.BoolVar(&CliConfig.Enabled, "enabled", CliConfig.Enabled, "enable compaction of old chunks into blocks in object storage. requires one of the other store plugins to be enabled as primary store")
+	tieredStore.StringVar(&CliConfig.Backend, "backend", CliConfig.Backend, "object storage backend to use: filesystem or s3")
+	tieredStore.StringVar(&CliConfig.FSPath, "fs-path", CliConfig.FSPath, "directory to store blocks in, for the filesystem backend")
+	tieredStore.StringVar(&CliConfig.S3Endpoint, "s3-endpoint", CliConfig.S3Endpoint, "host[:port] of the s3 compatible api")
+	tieredStore.StringVar(&CliConfig.S3Bucket, "s3-bucket", CliConfig.S3Bucket, "name of the bucket to store blocks in")
+	tieredStore.StringVar(&CliConfig.S3Region, "s3-region", CliConfig.S3Region, "region of the bucket, used for request signing")
+	tieredStore.StringVar(&CliConfig.S3AccessKey, "s3-access-key", CliConfig.S3AccessKey, "access key id")
+	tieredStore.StringVar(&CliConfig.S3SecretKey, "s3-secret-key", CliConfig.S3SecretKey, "secret access key")
+	tieredStore.BoolVar(&CliConfig.S3PathStyle, "s3-path-style", CliConfig.S3PathStyle, "address the bucket as <endpoint>/<bucket> rather than <bucket>.<endpoint>. needed for most self-hosted s3 compatible stores")
+	tieredStore.BoolVar(&CliConfig.S3Insecure, "s3-insecure", CliConfig.S3Insecure, "use http instead of https")
+	tieredStore.DurationVar(&CliConfig.S3Timeout, "s3-timeout", CliConfig.S3Timeout, "timeout of s3 requests")
+	tieredStore.StringVar(&CliConfig.Prefix, "prefix", CliConfig.Prefix, "prefix of the names of all block objects. allows sharing a bucket between clusters")
+	tieredStore.StringVar(&CliConfig.StagingDir, "staging-dir", CliConfig.StagingDir, "local directory in which chunks are kept until they are compacted into blocks")
+	tieredStore.DurationVar(&CliConfig.Age, "age", CliConfig.Age, "chunks are compacted into blocks once all chunks of their block are older than this")
+	tieredStore.DurationVar(&CliConfig.BlockSpan, "block-span", CliConfig.BlockSpan, "time range covered by each block. must be a whole number of hours")
+	tieredStore.DurationVar(&CliConfig.CompactInterval, "compact-interval", CliConfig.CompactInterval, "how often to look for chunks to compact, and blocks to expire")
+	tieredStore.DurationVar(&CliConfig.PrimaryTTL, "primary-ttl", CliConfig.PrimaryTTL, "max TTL of chunks in the primary store. older data is only served from blocks. 0 keeps chunks in the primary store for their full TTL. must be at least age + block-span + compact-interval")
+	tieredStore.IntVar(&CliConfig.WriteQueueSize, "write-queue-size", CliConfig.WriteQueueSize, "max number of chunks allowed to be waiting to be written to the staging dir")
+	tieredStore.DurationVar(&CliConfig.FlushInterval, "flush-interval", CliConfig.FlushInterval, "max time chunks may wait before they are synced to the staging dir and passed to the primary store")
+
+	globalconf.Register("tiered-store", tieredStore, flag.ExitOnError)
+}
+
+func ConfigProcess() {
+	if err := CliConfig.Validate(); err != nil {
+		log.Fatalf("tiered-store: Config validation error. %s", err)
+	}
+}
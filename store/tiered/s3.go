@@ -0,0 +1,270 @@
+package tiered
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+)
+
+const emptyPayloadHash = "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"
+
+// S3Bucket is a Bucket backed by an S3-compatible object store (AWS S3, minio, ceph, GCS in interoperability mode, ...).
+// requests are signed with AWS signature version 4.
+type S3Bucket struct {
+	endpoint  string // host[:port]
+	bucket    string
+	region    string
+	accessKey string
+	secretKey string
+	pathStyle bool // address the bucket as endpoint/bucket rather than bucket.endpoint
+	scheme    string
+	client    *http.Client
+}
+
+func NewS3Bucket(endpoint, bucket, region, accessKey, secretKey string, pathStyle, insecure bool, timeout time.Duration) *S3Bucket {
+	scheme := "https"
+	if insecure {
+		scheme = "http"
+	}
+	return &S3Bucket{
+		endpoint:  endpoint,
+		bucket:    bucket,
+		region:    region,
+		accessKey: accessKey,
+		secretKey: secretKey,
+		pathStyle: pathStyle,
+		scheme:    scheme,
+		client:    &http.Client{Timeout: timeout},
+	}
+}
+
+// url returns the url of the given object. an empty name addresses the bucket itself
+func (b *S3Bucket) url(name string, query url.Values) *url.URL {
+	u := &url.URL{
+		Scheme:   b.scheme,
+		Host:     b.bucket + "." + b.endpoint,
+		Path:     "/" + name,
+		RawQuery: canonicalQuery(query),
+	}
+	if b.pathStyle {
+		u.Host = b.endpoint
+		u.Path = "/" + b.bucket + "/" + name
+	}
+	return u
+}
+
+func (b *S3Bucket) do(ctx context.Context, method string, u *url.URL, body []byte, header http.Header) (*http.Response, error) {
+	req, err := http.NewRequest(method, u.String(), bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req = req.WithContext(ctx)
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	payloadHash := emptyPayloadHash
+	if len(body) > 0 {
+		sum := sha256.Sum256(body)
+		payloadHash = hex.EncodeToString(sum[:])
+	}
+	b.sign(req, payloadHash, time.Now().UTC())
+	return b.client.Do(req)
+}
+
+// s3Error turns an unsuccessful response into an error, and closes its body
+func s3Error(resp *http.Response) error {
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		return ErrNotFound
+	}
+	body, _ := ioutil.ReadAll(resp.Body)
+	var e struct {
+		Code    string
+		Message string
+	}
+	if xml.Unmarshal(body, &e) == nil && e.Code != "" {
+		return fmt.Errorf("s3: %s: %s: %s", resp.Status, e.Code, e.Message)
+	}
+	return fmt.Errorf("s3: %s", resp.Status)
+}
+
+func (b *S3Bucket) Put(ctx context.Context, name string, data []byte) error {
+	resp, err := b.do(ctx, "PUT", b.url(name, nil), data, nil)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return s3Error(resp)
+	}
+	resp.Body.Close()
+	return nil
+}
+
+func (b *S3Bucket) get(ctx context.Context, name string, header http.Header, expStatus int) ([]byte, error) {
+	resp, err := b.do(ctx, "GET", b.url(name, nil), nil, header)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != expStatus {
+		return nil, s3Error(resp)
+	}
+	defer resp.Body.Close()
+	return ioutil.ReadAll(resp.Body)
+}
+
+func (b *S3Bucket) Get(ctx context.Context, name string) ([]byte, error) {
+	return b.get(ctx, name, nil, http.StatusOK)
+}
+
+func (b *S3Bucket) GetRange(ctx context.Context, name string, offset, length int64) ([]byte, error) {
+	header := http.Header{}
+	header.Set("Range", fmt.Sprintf("bytes=%d-%d", offset, offset+length-1))
+	data, err := b.get(ctx, name, header, http.StatusPartialContent)
+	if err == nil && int64(len(data)) != length {
+		return nil, fmt.Errorf("s3: expected %d bytes of %s, got %d", length, name, len(data))
+	}
+	return data, err
+}
+
+type listBucketResult struct {
+	Contents []struct {
+		Key string
+	}
+	IsTruncated           bool
+	NextContinuationToken string
+}
+
+func (b *S3Bucket) List(ctx context.Context, prefix string) ([]string, error) {
+	var names []string
+	query := url.Values{}
+	query.Set("list-type", "2")
+	query.Set("prefix", prefix)
+	for {
+		resp, err := b.do(ctx, "GET", b.url("", query), nil, nil)
+		if err != nil {
+			return nil, err
+		}
+		if resp.StatusCode != http.StatusOK {
+			return nil, s3Error(resp)
+		}
+		var result listBucketResult
+		err = xml.NewDecoder(resp.Body).Decode(&result)
+		resp.Body.Close()
+		if err != nil {
+			return nil, fmt.Errorf("s3: failed to decode list response: %s", err)
+		}
+		for _, c := range result.Contents {
+			names = append(names, c.Key)
+		}
+		if !result.IsTruncated {
+			break
+		}
+		query.Set("continuation-token", result.NextContinuationToken)
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+func (b *S3Bucket) Delete(ctx context.Context, name string) error {
+	resp, err := b.do(ctx, "DELETE", b.url(name, nil), nil, nil)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusNoContent && resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusNotFound {
+		return s3Error(resp)
+	}
+	resp.Body.Close()
+	return nil
+}
+
+// sign adds the AWS signature version 4 authorization header to the request.
+// all headers present on the request are signed.
+// see https://docs.aws.amazon.com/AmazonS3/latest/API/sig-v4-header-based-auth.html
+func (b *S3Bucket) sign(req *http.Request, payloadHash string, now time.Time) {
+	amzDate := now.Format("20060102T150405Z")
+	date := now.Format("20060102")
+	req.Header.Set("x-amz-date", amzDate)
+	req.Header.Set("x-amz-content-sha256", payloadHash)
+
+	headers := map[string]string{"host": req.URL.Host}
+	for k, v := range req.Header {
+		headers[strings.ToLower(k)] = strings.TrimSpace(strings.Join(v, ","))
+	}
+	names := make([]string, 0, len(headers))
+	for k := range headers {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+	var canonicalHeaders strings.Builder
+	for _, k := range names {
+		canonicalHeaders.WriteString(k + ":" + headers[k] + "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		uriEncode(req.URL.Path, false),
+		req.URL.RawQuery,
+		canonicalHeaders.String(),
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+
+	scope := date + "/" + b.region + "/s3/aws4_request"
+	requestHash := sha256.Sum256([]byte(canonicalRequest))
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hex.EncodeToString(requestHash[:])
+
+	key := hmacSHA256([]byte("AWS4"+b.secretKey), date)
+	key = hmacSHA256(key, b.region)
+	key = hmacSHA256(key, "s3")
+	key = hmacSHA256(key, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s", b.accessKey, scope, signedHeaders, signature))
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(data))
+	return h.Sum(nil)
+}
+
+// canonicalQuery encodes the query with sorted keys, the way signature v4 requires it
+func canonicalQuery(query url.Values) string {
+	keys := make([]string, 0, len(query))
+	for k := range query {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	var parts []string
+	for _, k := range keys {
+		for _, v := range query[k] {
+			parts = append(parts, uriEncode(k, true)+"="+uriEncode(v, true))
+		}
+	}
+	return strings.Join(parts, "&")
+}
+
+// uriEncode encodes everything but the unreserved characters. slashes are only encoded if encodeSlash is set
+func uriEncode(s string, encodeSlash bool) string {
+	var buf strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if (c >= 'A' && c <= 'Z') || (c >= 'a' && c <= 'z') || (c >= '0' && c <= '9') || c == '-' || c == '_' || c == '.' || c == '~' || (c == '/' && !encodeSlash) {
+			buf.WriteByte(c)
+		} else {
+			fmt.Fprintf(&buf, "%%%02X", c)
+		}
+	}
+	return buf.String()
+}
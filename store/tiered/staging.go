@@ -0,0 +1,221 @@
+package tiered
+
+import (
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/grafana/metrictank/schema"
+)
+
+// chunks wait in the staging dir until they are old enough to be compacted into a block.
+// there is a staging file per block time window, which is a sequence of records:
+//
+// length  uint32 (length of the body)
+// crc     uint32 (IEEE crc32 of the body)
+// body:
+//   ttl     uint32
+//   t0      uint32
+//   keylen  uint16
+//   key     string (the AMKey)
+//   data    []byte (the chunk)
+//
+// before a staging file is compacted, it is sealed: it is renamed from <window start>.staging
+// to <window start>_<id>.sealed, so that chunks for the same window that arrive later go to a new file.
+
+const (
+	stagingHeaderSize = 8
+	stagingBodySize   = 10
+	stagingExt        = ".staging"
+	sealedExt         = ".sealed"
+)
+
+type staging struct {
+	sync.Mutex
+	dir   string
+	span  uint32 // of the time windows
+	files map[uint32]*os.File
+}
+
+// newStaging opens the staging dir, and seals all files left behind by a previous run,
+// so that we never append to a file that may end with a partially written record.
+func newStaging(dir string, span uint32) (*staging, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	s := &staging{
+		dir:   dir,
+		span:  span,
+		files: make(map[uint32]*os.File),
+	}
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	for _, file := range files {
+		if !strings.HasSuffix(file.Name(), stagingExt) {
+			continue
+		}
+		start, err := strconv.ParseUint(strings.TrimSuffix(file.Name(), stagingExt), 10, 32)
+		if err != nil {
+			continue
+		}
+		if err := s.sealFile(uint32(start)); err != nil {
+			return nil, err
+		}
+	}
+	return s, nil
+}
+
+func encodeStagingRecord(key schema.AMKey, ttl, t0 uint32, data []byte) []byte {
+	keyStr := key.String()
+	bodySize := stagingBodySize + len(keyStr) + len(data)
+	buf := make([]byte, stagingHeaderSize+bodySize)
+	body := buf[stagingHeaderSize:]
+	binary.LittleEndian.PutUint32(body[0:], ttl)
+	binary.LittleEndian.PutUint32(body[4:], t0)
+	binary.LittleEndian.PutUint16(body[8:], uint16(len(keyStr)))
+	copy(body[stagingBodySize:], keyStr)
+	copy(body[stagingBodySize+len(keyStr):], data)
+	binary.LittleEndian.PutUint32(buf[0:], uint32(bodySize))
+	binary.LittleEndian.PutUint32(buf[4:], crc32.ChecksumIEEE(body))
+	return buf
+}
+
+// readStagingFile returns all valid records in the file.
+// reading stops at the first incomplete or corrupt record, which can only be the result of a crash while writing it.
+func readStagingFile(path string) ([]record, error) {
+	buf, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var records []record
+	for len(buf) >= stagingHeaderSize {
+		bodySize := int(binary.LittleEndian.Uint32(buf[0:]))
+		if len(buf) < stagingHeaderSize+bodySize || bodySize < stagingBodySize {
+			break
+		}
+		body := buf[stagingHeaderSize : stagingHeaderSize+bodySize]
+		if crc32.ChecksumIEEE(body) != binary.LittleEndian.Uint32(buf[4:]) {
+			break
+		}
+		keyLen := int(binary.LittleEndian.Uint16(body[8:]))
+		if bodySize < stagingBodySize+keyLen {
+			break
+		}
+		key, err := schema.AMKeyFromString(string(body[stagingBodySize : stagingBodySize+keyLen]))
+		if err != nil {
+			break
+		}
+		records = append(records, record{
+			key:  key,
+			ttl:  binary.LittleEndian.Uint32(body[0:]),
+			t0:   binary.LittleEndian.Uint32(body[4:]),
+			data: body[stagingBodySize+keyLen:],
+		})
+		buf = buf[stagingHeaderSize+bodySize:]
+	}
+	return records, nil
+}
+
+// windowStart returns the start of the time window that t0 belongs to
+func (s *staging) windowStart(t0 uint32) uint32 {
+	return t0 - t0%s.span
+}
+
+// write appends the records to their staging files and syncs them
+func (s *staging) write(records []record) error {
+	s.Lock()
+	defer s.Unlock()
+	dirty := make(map[*os.File]struct{})
+	for _, r := range records {
+		start := s.windowStart(r.t0)
+		f, ok := s.files[start]
+		if !ok {
+			var err error
+			f, err = os.OpenFile(filepath.Join(s.dir, strconv.FormatUint(uint64(start), 10)+stagingExt), os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+			if err != nil {
+				return err
+			}
+			s.files[start] = f
+		}
+		if _, err := f.Write(encodeStagingRecord(r.key, r.ttl, r.t0, r.data)); err != nil {
+			return err
+		}
+		dirty[f] = struct{}{}
+	}
+	for f := range dirty {
+		if err := f.Sync(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// sealFile closes the staging file of the given window, if open, and renames it to a sealed file.
+// caller must hold the lock, if the staging dir is in use
+func (s *staging) sealFile(start uint32) error {
+	if f, ok := s.files[start]; ok {
+		f.Close()
+		delete(s.files, start)
+	}
+	name := strconv.FormatUint(uint64(start), 10)
+	sealed := fmt.Sprintf("%s_%d%s", name, time.Now().UnixNano(), sealedExt)
+	return os.Rename(filepath.Join(s.dir, name+stagingExt), filepath.Join(s.dir, sealed))
+}
+
+// seal seals the staging files of all windows that start before the cutoff,
+// and returns the paths of all sealed files of those windows, by window start
+func (s *staging) seal(cutoff uint32) (map[uint32][]string, error) {
+	s.Lock()
+	for start := range s.files {
+		if start < cutoff {
+			if err := s.sealFile(start); err != nil {
+				s.Unlock()
+				return nil, err
+			}
+		}
+	}
+	s.Unlock()
+
+	files, err := ioutil.ReadDir(s.dir)
+	if err != nil {
+		return nil, err
+	}
+	sealed := make(map[uint32][]string)
+	for _, file := range files {
+		if !strings.HasSuffix(file.Name(), sealedExt) {
+			continue
+		}
+		pos := strings.IndexByte(file.Name(), '_')
+		if pos < 0 {
+			continue
+		}
+		start, err := strconv.ParseUint(file.Name()[:pos], 10, 32)
+		if err != nil || uint32(start) >= cutoff {
+			continue
+		}
+		sealed[uint32(start)] = append(sealed[uint32(start)], filepath.Join(s.dir, file.Name()))
+	}
+	for _, paths := range sealed {
+		sort.Strings(paths)
+	}
+	return sealed, nil
+}
+
+func (s *staging) close() {
+	s.Lock()
+	defer s.Unlock()
+	for start, f := range s.files {
+		f.Close()
+		delete(s.files, start)
+	}
+}
@@ -0,0 +1,433 @@
+// Package tiered implements an mdata.Store that wraps another store, and compacts chunks that are older
+// than a configurable age into immutable block objects in (S3 compatible) object storage.
+// This allows keeping long retentions without keeping all chunks in the primary store.
+package tiered
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/grafana/metrictank/mdata"
+	"github.com/grafana/metrictank/mdata/chunk"
+	"github.com/grafana/metrictank/schema"
+	"github.com/grafana/metrictank/stats"
+	"github.com/jpillora/backoff"
+	opentracing "github.com/opentracing/opentracing-go"
+	log "github.com/sirupsen/logrus"
+)
+
+const maxFlushSize = 10000
+
+var (
+	errInvalidRange = errors.New("TieredStore: invalid range: from must be less than to")
+
+	// metric store.tiered.get.exec is the duration of getting chunks from blocks
+	tieredGetExecDuration = stats.NewLatencyHistogram15s32("store.tiered.get.exec")
+	// metric store.tiered.chunks_per_response is how many chunks are retrieved from blocks per response in get queries
+	tieredChunksPerResponse = stats.NewMeter32("store.tiered.chunks_per_response", false)
+	// metric store.tiered.write_queue.items is the number of chunks waiting to be written to the staging dir
+	tieredWriteQueueItems = stats.NewRange32("store.tiered.write_queue.items")
+	// metric store.tiered.staging.fail is counter of failed writes of chunks to the staging dir
+	tieredStagingFail = stats.NewCounter32("store.tiered.staging.fail")
+	// metric store.tiered.blocks is the number of blocks in object storage
+	tieredBlocks = stats.NewGauge32("store.tiered.blocks")
+	// metric store.tiered.compaction.ok is counter of blocks that were successfully created
+	tieredCompactionOk = stats.NewCounter32("store.tiered.compaction.ok")
+	// metric store.tiered.compaction.fail is counter of failed attempts to create a block. they will be retried
+	tieredCompactionFail = stats.NewCounter32("store.tiered.compaction.fail")
+	// metric store.tiered.compaction.exec is the duration of compacting a time window into blocks
+	tieredCompactionExec = stats.NewLatencyHistogram12h32("store.tiered.compaction.exec")
+	// metric store.tiered.blocks_expired is the number of blocks deleted because their chunks expired
+	tieredBlocksExpired = stats.NewCounter32("store.tiered.blocks_expired")
+)
+
+type Store struct {
+	sync.RWMutex
+	cfg        *StoreConfig
+	primary    mdata.Store
+	bucket     Bucket
+	staging    *staging
+	blocks     map[uint32][]*block // by ttl, sorted by start
+	writeQueue chan *mdata.ChunkWriteRequest
+	shutdown   chan struct{}
+	wg         sync.WaitGroup
+	tracer     opentracing.Tracer
+}
+
+// NewStore creates a store that saves chunks in the primary store, as well as in blocks in the bucket.
+// the primary store must support the ttls returned by cfg.PrimaryTTLs.
+// It loads the index of all existing blocks.
+func NewStore(cfg *StoreConfig, primary mdata.Store, bucket Bucket) (*Store, error) {
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	stg, err := newStaging(cfg.StagingDir, uint32(cfg.BlockSpan.Seconds()))
+	if err != nil {
+		return nil, fmt.Errorf("tieredStore: failed to open staging dir %s. %s", cfg.StagingDir, err)
+	}
+	s := &Store{
+		cfg:        cfg,
+		primary:    primary,
+		bucket:     bucket,
+		staging:    stg,
+		blocks:     make(map[uint32][]*block),
+		writeQueue: make(chan *mdata.ChunkWriteRequest, cfg.WriteQueueSize),
+		shutdown:   make(chan struct{}),
+	}
+	if err := s.load(); err != nil {
+		stg.close()
+		return nil, err
+	}
+	s.wg.Add(2)
+	go s.processWriteQueue()
+	go s.compactLoop()
+	return s, nil
+}
+
+// load reads the index objects of all blocks
+func (s *Store) load() error {
+	pre := time.Now()
+	names, err := s.bucket.List(context.Background(), s.cfg.Prefix+"/")
+	if err != nil {
+		return fmt.Errorf("tieredStore: failed to list blocks. %s", err)
+	}
+	var numBlocks int
+	for _, name := range names {
+		if _, _, ok := parseBlockName(s.cfg.Prefix, name); !ok {
+			continue
+		}
+		buf, err := s.bucket.Get(context.Background(), name)
+		if err != nil {
+			return fmt.Errorf("tieredStore: failed to get block index %s. %s", name, err)
+		}
+		b, err := decodeIndex(name[:len(name)-len(indexExt)], buf)
+		if err != nil {
+			return fmt.Errorf("tieredStore: failed to decode block index %s. %s", name, err)
+		}
+		s.addBlock(b)
+		numBlocks++
+	}
+	log.Infof("tieredStore: loaded %d blocks in %s", numBlocks, time.Since(pre))
+	return nil
+}
+
+// addBlock adds the block to the in-memory index of blocks
+func (s *Store) addBlock(b *block) {
+	s.Lock()
+	blocks := append(s.blocks[b.ttl], b)
+	sort.SliceStable(blocks, func(i, j int) bool { return blocks[i].start < blocks[j].start })
+	s.blocks[b.ttl] = blocks
+	s.Unlock()
+	tieredBlocks.Inc()
+}
+
+func (s *Store) SetTracer(t opentracing.Tracer) {
+	s.tracer = t
+	s.primary.SetTracer(t)
+}
+
+func (s *Store) Add(cwr *mdata.ChunkWriteRequest) {
+	s.writeQueue <- cwr
+}
+
+// processWriteQueue writes chunks to the staging dir in batches. once a batch is synced,
+// the chunks are passed on to the primary store, which calls their callbacks once they're saved there too.
+func (s *Store) processWriteQueue() {
+	defer s.wg.Done()
+
+	ticker := time.NewTicker(s.cfg.FlushInterval)
+	defer ticker.Stop()
+
+	buf := make([]*mdata.ChunkWriteRequest, 0, maxFlushSize)
+	boff := &backoff.Backoff{
+		Min:    100 * time.Millisecond,
+		Max:    time.Minute,
+		Factor: 3,
+		Jitter: true,
+	}
+	flush := func() {
+		records := make([]record, len(buf))
+		for i, cwr := range buf {
+			records[i] = record{key: cwr.Key, ttl: cwr.TTL, t0: cwr.T0, data: cwr.Data}
+		}
+		attempts := 0
+		for len(buf) > 0 {
+			err := s.staging.write(records)
+			if err == nil {
+				for _, cwr := range buf {
+					primaryCwr := *cwr
+					primaryCwr.TTL = s.cfg.primaryTTL(cwr.TTL)
+					s.primary.Add(&primaryCwr)
+				}
+				buf = buf[:0]
+				boff.Reset()
+				return
+			}
+			tieredStagingFail.Add(len(buf))
+			if (attempts % 20) == 0 {
+				log.Warnf("tieredStore: failed to write %d chunks to staging dir. they will be retried. %s", len(buf), err)
+			}
+			attempts++
+			select {
+			case <-time.After(boff.Duration()):
+			case <-s.shutdown:
+				return
+			}
+		}
+	}
+
+	for {
+		select {
+		case <-ticker.C:
+			tieredWriteQueueItems.Value(len(s.writeQueue))
+			flush()
+		case cwr := <-s.writeQueue:
+			tieredWriteQueueItems.Value(len(s.writeQueue))
+			buf = append(buf, cwr)
+			if len(buf) >= maxFlushSize {
+				flush()
+			}
+		case <-s.shutdown:
+			// chunks that we don't flush won't be marked as saved, so they will be saved again after a restart.
+			flush()
+			return
+		}
+	}
+}
+
+func (s *Store) compactLoop() {
+	defer s.wg.Done()
+	ticker := time.NewTicker(s.cfg.CompactInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			now := uint32(time.Now().Unix())
+			s.compact(now)
+			s.expire(now)
+		case <-s.shutdown:
+			return
+		}
+	}
+}
+
+// compact turns the staged chunks of all time windows that are older than the configured age into blocks.
+// staged chunks are only deleted once all their blocks have been uploaded. if that fails, the window is retried
+// next time, which may result in duplicate blocks. that's fine, because searches dedupe chunks.
+func (s *Store) compact(now uint32) {
+	age := uint32(s.cfg.Age.Seconds())
+	span := s.staging.span
+	if now < age+span {
+		return
+	}
+	// windows that start before the cutoff have ended at least age ago
+	cutoff := now - age - span + 1
+	sealed, err := s.staging.seal(cutoff)
+	if err != nil {
+		log.Errorf("tieredStore: failed to seal staging files. %s", err)
+		return
+	}
+	for start, paths := range sealed {
+		pre := time.Now()
+		if err := s.compactWindow(start, paths); err != nil {
+			tieredCompactionFail.Inc()
+			log.Errorf("tieredStore: failed to compact window %d. will retry. %s", start, err)
+			continue
+		}
+		for _, path := range paths {
+			if err := os.Remove(path); err != nil {
+				log.Errorf("tieredStore: failed to delete compacted staging file %s. %s", path, err)
+			}
+		}
+		tieredCompactionExec.Value(time.Since(pre))
+	}
+}
+
+// compactWindow creates a block per ttl out of the chunks in the given staging files
+func (s *Store) compactWindow(start uint32, paths []string) error {
+	var records []record
+	for _, path := range paths {
+		r, err := readStagingFile(path)
+		if err != nil {
+			return err
+		}
+		records = append(records, r...)
+	}
+	byTTL := make(map[uint32][]record)
+	for _, r := range records {
+		byTTL[r.ttl] = append(byTTL[r.ttl], r)
+	}
+	for ttl, records := range byTTL {
+		name := blockName(s.cfg.Prefix, ttl, start, time.Now().UnixNano())
+		b, data, index := buildBlock(name, start, ttl, records)
+		ctx, cancel := context.WithTimeout(context.Background(), s.cfg.CompactInterval)
+		err := s.bucket.Put(ctx, name+chunksExt, data)
+		if err == nil {
+			err = s.bucket.Put(ctx, name+indexExt, index)
+		}
+		cancel()
+		if err != nil {
+			return err
+		}
+		s.addBlock(b)
+		tieredCompactionOk.Inc()
+		log.Infof("tieredStore: created block %s with %d series and %d bytes of chunks", name, len(b.series), len(data))
+	}
+	return nil
+}
+
+// expire deletes the blocks of which all chunks have expired
+func (s *Store) expire(now uint32) {
+	span := s.staging.span
+	var expired []*block
+	s.Lock()
+	for ttl, blocks := range s.blocks {
+		kept := blocks[:0]
+		for _, b := range blocks {
+			if b.start+span+ttl < now {
+				expired = append(expired, b)
+			} else {
+				kept = append(kept, b)
+			}
+		}
+		if len(kept) == 0 {
+			delete(s.blocks, ttl)
+		} else {
+			s.blocks[ttl] = kept
+		}
+	}
+	s.Unlock()
+
+	for _, b := range expired {
+		// delete the index first, so that we never have an index without its chunks
+		err := s.bucket.Delete(context.Background(), b.name+indexExt)
+		if err == nil {
+			err = s.bucket.Delete(context.Background(), b.name+chunksExt)
+		}
+		if err != nil {
+			log.Errorf("tieredStore: failed to delete expired block %s. %s", b.name, err)
+		}
+		tieredBlocks.Dec()
+		tieredBlocksExpired.Inc()
+	}
+}
+
+// chunkSource is a chunk that we may need to fetch from a block
+type chunkSource struct {
+	blockChunk
+	block *block
+}
+
+// Search returns the chunks that are needed to cover start to end: all chunks with a t0 < end,
+// starting with the last chunk with a t0 <= start. Chunks are taken from the primary store
+// where possible, and from blocks otherwise.
+func (s *Store) Search(ctx context.Context, key schema.AMKey, ttl, start, end uint32) ([]chunk.IterGen, error) {
+	if start >= end {
+		return nil, errInvalidRange
+	}
+	var itgens []chunk.IterGen
+	// if the entire range is older than the primary store keeps chunks, we don't need to ask it
+	primaryTTL := uint32(s.cfg.PrimaryTTL.Seconds())
+	if primaryTTL == 0 || uint32(time.Now().Unix()) < end+primaryTTL {
+		var err error
+		itgens, err = s.primary.Search(ctx, key, s.cfg.primaryTTL(ttl), start, end)
+		if err != nil {
+			return nil, err
+		}
+	}
+	inPrimary := make(map[uint32]struct{}, len(itgens))
+	for _, itgen := range itgens {
+		inPrimary[itgen.T0] = struct{}{}
+	}
+
+	pre := time.Now()
+	var sources []chunkSource
+	s.RLock()
+	for _, b := range s.blocks[ttl] {
+		if b.start >= end {
+			break
+		}
+		for _, c := range b.series[key] {
+			if c.t0 < end {
+				sources = append(sources, chunkSource{c, b})
+			}
+		}
+	}
+	s.RUnlock()
+	if len(sources) == 0 {
+		return itgens, nil
+	}
+
+	// when a chunk is in multiple blocks, use the one of the newest block
+	sort.SliceStable(sources, func(i, j int) bool { return sources[i].t0 < sources[j].t0 })
+	deduped := sources[:0]
+	for _, src := range sources {
+		if len(deduped) > 0 && deduped[len(deduped)-1].t0 == src.t0 {
+			deduped[len(deduped)-1] = src
+			continue
+		}
+		deduped = append(deduped, src)
+	}
+	sources = deduped
+
+	// we only need the chunks from the last one with t0 <= start onwards
+	// the primary may have a later chunk that satisfies that condition, which is taken care of after merging
+	first := sort.Search(len(sources), func(i int) bool { return sources[i].t0 > start })
+	if first > 0 {
+		first--
+	}
+	byBlock := make(map[*block][]chunkSource)
+	for _, src := range sources[first:] {
+		if _, ok := inPrimary[src.t0]; !ok {
+			byBlock[src.block] = append(byBlock[src.block], src)
+		}
+	}
+
+	intervalHint := key.Archive.Span()
+	var fromBlocks int
+	for b, srcs := range byBlock {
+		select {
+		case <-ctx.Done():
+			// request has been canceled
+			return nil, nil
+		default:
+		}
+		// the chunks of a series are contiguous within a block, so we can get them all with 1 request
+		offset := srcs[0].offset
+		last := srcs[len(srcs)-1]
+		data, err := s.bucket.GetRange(ctx, b.name+chunksExt, int64(offset), int64(last.offset+uint64(last.size)-offset))
+		if err != nil {
+			return nil, fmt.Errorf("tieredStore: failed to get chunks from block %s. %s", b.name, err)
+		}
+		for _, src := range srcs {
+			pos := src.offset - offset
+			itgen, err := chunk.NewIterGen(src.t0, intervalHint, data[pos:pos+uint64(src.size)])
+			if err != nil {
+				return nil, err
+			}
+			itgens = append(itgens, itgen)
+			fromBlocks++
+		}
+	}
+	tieredGetExecDuration.Value(time.Since(pre))
+	tieredChunksPerResponse.Value(fromBlocks)
+
+	sort.Slice(itgens, func(i, j int) bool { return itgens[i].T0 < itgens[j].T0 })
+	first = sort.Search(len(itgens), func(i int) bool { return itgens[i].T0 > start })
+	if first > 0 {
+		first--
+	}
+	return itgens[first:], nil
+}
+
+func (s *Store) Stop() {
+	close(s.shutdown)
+	s.wg.Wait()
+	s.staging.close()
+	s.primary.Stop()
+}
@@ -0,0 +1,208 @@
+package tiered
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/grafana/metrictank/mdata"
+	"github.com/grafana/metrictank/mdata/chunk"
+	"github.com/grafana/metrictank/schema"
+	"github.com/grafana/metrictank/store/disk"
+)
+
+const testTTL = 3600 * 24 * 30
+
+type testEnv struct {
+	dir    string
+	cfg    *StoreConfig
+	bucket *FSBucket
+}
+
+func newTestEnv(t *testing.T) *testEnv {
+	dir, err := ioutil.TempDir("", "tiered-store")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg := NewStoreConfig()
+	cfg.Enabled = true
+	cfg.FSPath = filepath.Join(dir, "bucket")
+	cfg.StagingDir = filepath.Join(dir, "staging")
+	cfg.Age = 0
+	cfg.BlockSpan = time.Hour
+	cfg.CompactInterval = time.Hour
+	cfg.WriteQueueSize = 100
+	cfg.FlushInterval = 10 * time.Millisecond
+	bucket, err := NewFSBucket(cfg.FSPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &testEnv{dir: dir, cfg: cfg, bucket: bucket}
+}
+
+// newStore returns a tiered store with an empty disk store as primary
+func (e *testEnv) newStore(t *testing.T, primaryName string) *Store {
+	pcfg := disk.NewStoreConfig()
+	pcfg.Enabled = true
+	pcfg.Path = filepath.Join(e.dir, primaryName)
+	pcfg.WriteQueueSize = 100
+	pcfg.WriteMaxFlushSize = 10
+	pcfg.FlushInterval = 10 * time.Millisecond
+	primary, err := disk.NewStore(pcfg, e.cfg.PrimaryTTLs([]uint32{testTTL}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, err := NewStore(e.cfg, primary, e.bucket)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+func newChunk(t0, span uint32) []byte {
+	c := chunk.New(t0)
+	for ts := t0; ts < t0+span; ts += 10 {
+		c.Push(ts, float64(ts))
+	}
+	c.Finish()
+	return c.Encode(span)
+}
+
+// save writes the chunks and waits until they are saved
+func save(s *Store, key schema.AMKey, t0s ...uint32) {
+	done := make(chan struct{}, len(t0s))
+	for _, t0 := range t0s {
+		cwr := mdata.NewChunkWriteRequest(func() { done <- struct{}{} }, key, testTTL, t0, newChunk(t0, 600), time.Now())
+		s.Add(&cwr)
+	}
+	for range t0s {
+		<-done
+	}
+}
+
+func search(t *testing.T, s *Store, key schema.AMKey, start, end uint32) []uint32 {
+	itgens, err := s.Search(context.Background(), key, testTTL, start, end)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var out []uint32
+	for i, itgen := range itgens {
+		out = append(out, itgen.T0)
+		iter, err := itgen.Get()
+		if err != nil {
+			t.Fatalf("chunk %d: %s", i, err)
+		}
+		var points int
+		for iter.Next() {
+			ts, val := iter.Values()
+			if float64(ts) != val {
+				t.Fatalf("chunk %d: expected value %d at ts %d, got %f", i, ts, ts, val)
+			}
+			points++
+		}
+		if points != 60 {
+			t.Fatalf("chunk %d: expected 60 points, got %d", i, points)
+		}
+	}
+	return out
+}
+
+func equal(a, b []uint32) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func TestCompactAndSearch(t *testing.T) {
+	env := newTestEnv(t)
+	defer os.RemoveAll(env.dir)
+
+	now := uint32(time.Now().Unix())
+	current := now - now%3600
+	base := current - 48*3600
+	key := testKey(t, "1.01234567890123456789012345678901")
+	other := testKey(t, "1.01234567890123456789012345678902")
+
+	s := env.newStore(t, "primary1")
+	save(s, key, base, base+600, base+1200, base+3600, current)
+	save(s, other, base)
+	s.compact(now)
+
+	// the 2 old windows have been compacted, the current one hasn't
+	names, err := env.bucket.List(context.Background(), env.cfg.Prefix+"/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(names) != 4 {
+		t.Fatalf("expected 2 blocks with 2 objects each, got %v", names)
+	}
+	files, _ := ioutil.ReadDir(env.cfg.StagingDir)
+	if len(files) != 1 || files[0].Name() != itoa(current)+stagingExt {
+		t.Fatalf("expected only the staging file of the current window to remain, got %v", files)
+	}
+
+	// chunks are in both the primary store and the blocks, but must only be returned once
+	if got, exp := search(t, s, key, base, current+1), []uint32{base, base + 600, base + 1200, base + 3600, current}; !equal(got, exp) {
+		t.Fatalf("expected chunks %v, got %v", exp, got)
+	}
+	s.Stop()
+
+	// with an empty primary store, old chunks must come from the blocks, which are loaded from the bucket
+	s = env.newStore(t, "primary2")
+	defer s.Stop()
+	cases := []struct {
+		start, end uint32
+		exp        []uint32
+	}{
+		{base, current + 1, []uint32{base, base + 600, base + 1200, base + 3600}},
+		{base + 700, base + 1300, []uint32{base + 600, base + 1200}},
+		{base + 5000, base + 6000, []uint32{base + 3600}},
+		{base - 600, base, nil},
+	}
+	for i, c := range cases {
+		if got := search(t, s, key, c.start, c.end); !equal(got, c.exp) {
+			t.Fatalf("case %d: expected chunks %v, got %v", i, c.exp, got)
+		}
+	}
+
+	// chunks from the primary store and the blocks are merged
+	save(s, key, base+1800)
+	if got, exp := search(t, s, key, base+700, base+3700), []uint32{base + 600, base + 1200, base + 1800, base + 3600}; !equal(got, exp) {
+		t.Fatalf("expected chunks %v, got %v", exp, got)
+	}
+
+	// the staging file left behind by the previous store was sealed, and is compacted along with
+	// the late chunk, which results in an additional block for its window
+	s.compact(current + 3600)
+	if names, _ := env.bucket.List(context.Background(), env.cfg.Prefix+"/"); len(names) != 8 {
+		t.Fatalf("expected 4 blocks, got %v", names)
+	}
+
+	s.expire(now + testTTL)
+	if names, _ := env.bucket.List(context.Background(), env.cfg.Prefix+"/"); len(names) != 2 {
+		t.Fatalf("expected only the block of the current window to remain after expiry, got %v", names)
+	}
+}
+
+func TestPrimaryTTLs(t *testing.T) {
+	cfg := NewStoreConfig()
+	cfg.PrimaryTTL = 10 * 24 * time.Hour
+	got := cfg.PrimaryTTLs([]uint32{3600, 864000, 8640000, 86400000})
+	if exp := []uint32{3600, 864000}; !equal(got, exp) {
+		t.Fatalf("expected %v, got %v", exp, got)
+	}
+}
+
+func itoa(i uint32) string {
+	return strconv.FormatUint(uint64(i), 10)
+}